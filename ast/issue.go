@@ -0,0 +1,21 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Issue describes a construct that could not be represented exactly when
+// converting a document from or into another format. Line and Column are the
+// position of the construct in the source, they are zero when it's not known.
+type Issue struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s", i.Line, i.Column, i.Message)
+	}
+	return i.Message
+}
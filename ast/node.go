@@ -3,6 +3,7 @@ package ast
 import (
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/xiam/s-expr/lexer"
 )
@@ -13,7 +14,10 @@ type Node struct {
 
 	nt  NodeType
 	tok *lexer.Token
+	end *lexer.Token
 	v   interface{}
+
+	tail *Node
 }
 
 func newNode(nt NodeType, tok *lexer.Token, v interface{}) *Node {
@@ -34,6 +38,16 @@ func NewExpression(tok *lexer.Token) *Node {
 	return newNode(NodeTypeExpression, tok, []*Node{})
 }
 
+// NewPair creates and returns a dotted expression that holds a single child
+// and a tail, like (a . b), the equivalent of a Lisp cons cell.
+func NewPair(tok *lexer.Token, head *Node, tail *Node) *Node {
+	n := NewExpression(tok)
+	head.p = n
+	n.v = []*Node{head}
+	n.SetTail(tail)
+	return n
+}
+
 // NewMap creates and returns a node of type "map"
 func NewMap(tok *lexer.Token) *Node {
 	return newNode(NodeTypeMap, tok, []*Node{})
@@ -44,6 +58,17 @@ func NewList(tok *lexer.Token) *Node {
 	return newNode(NodeTypeList, tok, []*Node{})
 }
 
+// NewSet creates and returns a node of type "set"
+func NewSet(tok *lexer.Token) *Node {
+	return newNode(NodeTypeSet, tok, []*Node{})
+}
+
+// NewTagged creates and returns a node of type "tagged", a tagged node holds
+// two children: the tag, as a symbol, and the tagged value.
+func NewTagged(tok *lexer.Token) *Node {
+	return newNode(NodeTypeTagged, tok, []*Node{})
+}
+
 // PushValue appends a new value to the node
 func (n *Node) PushValue(tok *lexer.Token, v Valuer) (*Node, error) {
 	node := NewNode(tok, v)
@@ -85,6 +110,57 @@ func (n Node) Token() *lexer.Token {
 	return n.tok
 }
 
+// EndToken returns the token that closes the node, only vector nodes that
+// were closed explicitly have one
+func (n Node) EndToken() *lexer.Token {
+	return n.end
+}
+
+// SetToken sets the token associated to the node
+func (n *Node) SetToken(tok *lexer.Token) {
+	n.tok = tok
+}
+
+// SetEndToken sets the token that closes the node
+func (n *Node) SetEndToken(tok *lexer.Token) {
+	n.end = tok
+}
+
+// Tail returns the node that follows the dot of a dotted expression, like c
+// in (a b . c), or nil if the expression is a proper one
+func (n Node) Tail() *Node {
+	return n.tail
+}
+
+// SetTail sets the node that follows the dot of a dotted expression
+func (n *Node) SetTail(tail *Node) {
+	if tail != nil {
+		tail.p = n
+	}
+	n.tail = tail
+}
+
+// Copy returns an orphaned deep copy of the node, values and tokens are
+// shared with the original node.
+func (n *Node) Copy() *Node {
+	c := newNode(n.nt, n.tok, n.v)
+	c.end = n.end
+	if n.IsVector() {
+		children := n.List()
+		list := make([]*Node, 0, len(children))
+		for _, child := range children {
+			child = child.Copy()
+			child.p = c
+			list = append(list, child)
+		}
+		c.v = list
+	}
+	if n.tail != nil {
+		c.SetTail(n.tail.Copy())
+	}
+	return c
+}
+
 // Type returns the type of the node
 func (n Node) Type() NodeType {
 	return n.nt
@@ -112,20 +188,60 @@ func (n Node) Encode() string {
 	return ""
 }
 
+// Int returns the value of an int node, ok is false for nodes of any other
+// type and for ints that don't fit in an int64, see BigInt
+func (n Node) Int() (v int64, ok bool) {
+	v, ok = n.Value().(int64)
+	return v, ok && n.nt == NodeTypeInt
+}
+
+// BigInt returns the value of an int node of any size, ok is false for nodes
+// of any other type
+func (n Node) BigInt() (v *big.Int, ok bool) {
+	if n.nt != NodeTypeInt {
+		return nil, false
+	}
+	switch i := n.Value().(type) {
+	case int64:
+		return big.NewInt(i), true
+	case *big.Int:
+		return new(big.Int).Set(i), true
+	}
+	return nil, false
+}
+
+// Float returns the value of a float node, ok is false for nodes of any other
+// type
+func (n Node) Float() (v float64, ok bool) {
+	v, ok = n.Value().(float64)
+	return v, ok && n.nt == NodeTypeFloat
+}
+
+// Bool returns the value of a bool node, ok is false for nodes of any other
+// type
+func (n Node) Bool() (v bool, ok bool) {
+	v, ok = n.Value().(bool)
+	return v, ok && n.nt == NodeTypeBool
+}
+
+// IsNil returns true if the node is of type nil
+func (n Node) IsNil() bool {
+	return n.nt == NodeTypeNil
+}
+
 // List returns all the children elements of the node
 func (n *Node) List() []*Node {
 	return n.v.([]*Node)
 }
 
 func (n Node) String() string {
-	switch n.nt {
-	case NodeTypeExpression, NodeTypeList, NodeTypeMap:
+	if n.IsVector() {
 		return fmt.Sprintf("(%v)[%d]", nodeTypeName[n.nt], len(n.List()))
 	}
 	return fmt.Sprintf("(%v): %v", nodeTypeName[n.nt], n.Value())
 }
 
-// Push appends a child node to a parent node of type "expression", "map" or "list".
+// Push appends a child node to a parent node of type vector.
 func (n *Node) Push(node *Node) error {
 	if n.IsVector() {
 		n.v = append(n.v.([]*Node), node)
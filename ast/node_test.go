@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,3 +52,124 @@ func TestNodeExpression(t *testing.T) {
 	_, err := list.PushValue(token, value)
 	assert.NoError(t, err)
 }
+
+func TestEDNNodes(t *testing.T) {
+	set := NewSet(nil)
+	_, err := set.PushValue(nil, NewBoolValue(true))
+	assert.NoError(t, err)
+	_, err = set.PushValue(nil, NewNilValue())
+	assert.NoError(t, err)
+	_, err = set.PushValue(nil, NewCharValue('\t'))
+	assert.NoError(t, err)
+	_, err = set.PushValue(nil, NewCharValue('\x7f'))
+	assert.NoError(t, err)
+
+	tagged := NewTagged(nil)
+	_, err = tagged.PushValue(nil, NewSymbolValue("inst"))
+	assert.NoError(t, err)
+	_, err = tagged.PushValue(nil, NewStringValue("2020-01-01"))
+	assert.NoError(t, err)
+	assert.NoError(t, set.Push(tagged))
+
+	assert.Equal(t, `#{true nil \tab \u007f #inst "2020-01-01"}`, string(Encode(set)))
+	assert.Equal(t, "(set)[5]", set.String())
+	assert.Equal(t, "(nil): <nil>", set.List()[1].String())
+	assert.True(t, tagged.IsVector())
+}
+
+func TestTypedAccessors(t *testing.T) {
+	i := NewNode(nil, NewIntValue(42))
+	f := NewNode(nil, NewFloatValue(1.5))
+	b := NewNode(nil, NewBoolValue(false))
+	n := NewNode(nil, NewNilValue())
+	s := NewNode(nil, NewSymbolValue("true"))
+
+	v, ok := i.Int()
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), v)
+
+	_, ok = f.Int()
+	assert.False(t, ok)
+
+	fv, ok := f.Float()
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, fv)
+
+	bv, ok := b.Bool()
+	assert.True(t, ok)
+	assert.False(t, bv)
+
+	_, ok = s.Bool()
+	assert.False(t, ok)
+
+	_, ok = NewList(nil).Bool()
+	assert.False(t, ok)
+
+	bi, ok := i.BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, "42", bi.String())
+
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	h := NewNode(nil, NewBigIntValue(huge))
+	_, ok = h.Int()
+	assert.False(t, ok)
+	bi, ok = h.BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, 0, huge.Cmp(bi))
+	assert.Equal(t, "123456789012345678901234567890", h.Encode())
+
+	_, ok = f.BigInt()
+	assert.False(t, ok)
+
+	assert.True(t, n.IsNil())
+	assert.False(t, s.IsNil())
+	assert.Equal(t, "false", b.Encode())
+	assert.Equal(t, "nil", n.Encode())
+}
+
+func TestDottedNodes(t *testing.T) {
+	pair := NewPair(nil, NewNode(nil, NewSymbolValue("a")), NewNode(nil, NewIntValue(1)))
+	assert.Equal(t, "(a . 1)", string(Encode(pair)))
+	assert.Equal(t, pair, pair.List()[0].Parent())
+	assert.Equal(t, pair, pair.Tail().Parent())
+
+	improper := NewExpression(nil)
+	_, err := improper.PushValue(nil, NewSymbolValue("b"))
+	assert.NoError(t, err)
+	improper.SetTail(pair)
+	assert.Equal(t, "(b . (a . 1))", string(Encode(improper)))
+
+	visited := []string{}
+	Walk(improper, func(n *Node) bool {
+		visited = append(visited, n.String())
+		return true
+	})
+	assert.Equal(t, []string{
+		"(expression)[1]",
+		"(symbol): b",
+		"(expression)[1]",
+		"(symbol): a",
+		"(int): 1",
+	}, visited)
+
+	count := 0
+	Walk(improper, func(n *Node) bool {
+		count++
+		return n != pair
+	})
+	assert.Equal(t, 3, count)
+	copied := improper.Copy()
+	assert.Nil(t, copied.Parent())
+	assert.Equal(t, string(Encode(improper)), string(Encode(copied)))
+	assert.Equal(t, copied, copied.Tail().Parent())
+	assert.NotSame(t, pair, copied.Tail())
+}
+
+func TestIsAtomName(t *testing.T) {
+	for _, name := range []string{"a", "max-conns", "snake_case", "_x1"} {
+		assert.True(t, IsAtomName(name), name)
+	}
+	for _, name := range []string{"", "1a", "-a", "with space", "a.b", "é"} {
+		assert.False(t, IsAtomName(name), name)
+	}
+}
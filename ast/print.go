@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -17,8 +18,8 @@ func printLevel(n *Node, level int) {
 	}
 	indent := strings.Repeat("    ", level)
 	fmt.Printf("%s(:%s ", indent, n.Type())
-	switch n.Type() {
-	case NodeTypeExpression, NodeTypeList, NodeTypeMap:
+	switch {
+	case n.IsVector():
 		tok := n.Token()
 		if tok == nil {
 			fmt.Printf("()\n")
@@ -29,6 +30,10 @@ func printLevel(n *Node, level int) {
 		for i := range list {
 			printLevel(list[i], level+1)
 		}
+		if tail := n.Tail(); tail != nil {
+			fmt.Printf("%s    .\n", indent)
+			printLevel(tail, level+1)
+		}
 		fmt.Printf("%s)\n", indent)
 
 	default:
@@ -36,44 +41,103 @@ func printLevel(n *Node, level int) {
 	}
 }
 
-// Encode transform a node into text representation
+// ErrNotEncodable is returned by Marshal and MarshalDocument for the values
+// that the parser can't read back
+var ErrNotEncodable = errors.New("value can't be encoded")
+
+// Encode transforms a node into its text representation, vector nodes are
+// always enclosed by their delimiters. The output of Encode can be read back
+// by the parser and results in an equivalent node; bools, nils, chars, sets
+// and tagged values are written with the EDN syntax and need the EDN options
+// of the parser. Values that can't be read back, infinities, NaN and symbols
+// with names like "a b", are written after a backslash, like \+Inf, so that
+// parsing the output fails instead of returning a different tree; use
+// Marshal to get an error instead.
 func Encode(n *Node) []byte {
-	return encodeNodeLevel(n, 0)
+	return []byte(encodeNode(n))
 }
 
-func encodeNodeLevel(n *Node, level int) []byte {
-	if n == nil {
-		return []byte("()")
+// EncodeDocument transforms the root node of a document (like the one
+// returned by the parser) into its text representation. The children of the
+// root are encoded one after another, separated by a space, without the
+// delimiters of the root node.
+func EncodeDocument(root *Node) []byte {
+	if root == nil {
+		return []byte{}
 	}
-	switch n.Type() {
-	case NodeTypeMap:
-		nodes := []string{}
-		for i := range n.List() {
-			nodes = append(nodes, string(encodeNodeLevel(n.List()[i], level+1)))
-		}
-		return []byte(fmt.Sprintf("{%s}", strings.Join(nodes, " ")))
+	if !root.IsVector() {
+		return Encode(root)
+	}
+	return []byte(encodeList(root.List()))
+}
 
-	case NodeTypeList:
-		nodes := []string{}
-		for i := range n.List() {
-			nodes = append(nodes, string(encodeNodeLevel(n.List()[i], level+1)))
+// Marshal is like Encode, but returns an error that wraps ErrNotEncodable if
+// the node holds a value that can't be read back
+func Marshal(n *Node) ([]byte, error) {
+	if err := checkEncodable(n); err != nil {
+		return nil, err
+	}
+	return Encode(n), nil
+}
+
+// MarshalDocument is like EncodeDocument, but returns an error that wraps
+// ErrNotEncodable if the tree holds a value that can't be read back
+func MarshalDocument(root *Node) ([]byte, error) {
+	if err := checkEncodable(root); err != nil {
+		return nil, err
+	}
+	return EncodeDocument(root), nil
+}
+
+func checkEncodable(root *Node) error {
+	var err error
+	Walk(root, func(n *Node) bool {
+		if err != nil {
+			return false
 		}
-		if level == 0 {
-			return []byte(strings.Join(nodes, " "))
+		switch n.Type() {
+		case NodeTypeFloat:
+			if f := n.Value().(float64); !isFinite(f) {
+				err = fmt.Errorf("%w: float %v", ErrNotEncodable, f)
+			}
+		case NodeTypeSymbol:
+			if name := n.Value().(string); !isSymbolName(name) {
+				err = fmt.Errorf("%w: symbol %q", ErrNotEncodable, name)
+			}
 		}
-		return []byte(fmt.Sprintf("[%s]", strings.Join(nodes, " ")))
+		return err == nil
+	})
+	return err
+}
+
+func encodeList(nodes []*Node) string {
+	values := make([]string, 0, len(nodes))
+	for i := range nodes {
+		values = append(values, encodeNode(nodes[i]))
+	}
+	return strings.Join(values, " ")
+}
+
+func encodeNode(n *Node) string {
+	if n == nil {
+		return "()"
+	}
 
+	switch n.Type() {
+	case NodeTypeMap:
+		return "{" + encodeList(n.List()) + "}"
+	case NodeTypeList:
+		return "[" + encodeList(n.List()) + "]"
 	case NodeTypeExpression:
-		nodes := []string{}
-		for i := range n.List() {
-			nodes = append(nodes, string(encodeNodeLevel(n.List()[i], level+1)))
-		}
-		if level == 0 {
-			return []byte(strings.Join(nodes, " "))
+		if tail := n.Tail(); tail != nil {
+			return "(" + encodeList(n.List()) + " . " + encodeNode(tail) + ")"
 		}
-		return []byte(fmt.Sprintf("(%s)", strings.Join(nodes, " ")))
-
+		return "(" + encodeList(n.List()) + ")"
+	case NodeTypeSet:
+		return "#{" + encodeList(n.List()) + "}"
+	case NodeTypeTagged:
+		return "#" + encodeList(n.List())
 	default:
-		return []byte(n.Encode())
+		return n.Encode()
 	}
 }
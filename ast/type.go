@@ -6,17 +6,23 @@ type NodeType uint32
 // Node types
 const (
 	nodeTypeValue  NodeType = 1 << 16
-	nodeTypeVector          = 1 << 15
+	nodeTypeVector NodeType = 1 << 15
 
-	NodeTypeInt    = nodeTypeValue | 1<<0
-	NodeTypeFloat  = nodeTypeValue | 1<<1
-	NodeTypeSymbol = nodeTypeValue | 1<<2
-	NodeTypeAtom   = nodeTypeValue | 1<<3
-	NodeTypeString = nodeTypeValue | 1<<4
+	NodeTypeInt       = nodeTypeValue | 1<<0
+	NodeTypeFloat     = nodeTypeValue | 1<<1
+	NodeTypeSymbol    = nodeTypeValue | 1<<2
+	NodeTypeAtom      = nodeTypeValue | 1<<3
+	NodeTypeString    = nodeTypeValue | 1<<4
+	NodeTypeBool      = nodeTypeValue | 1<<5
+	NodeTypeNil       = nodeTypeValue | 1<<6
+	NodeTypeChar      = nodeTypeValue | 1<<7
+	NodeTypeBitVector = nodeTypeValue | 1<<8
 
 	NodeTypeList       = nodeTypeVector | 1<<0
 	NodeTypeMap        = nodeTypeVector | 1<<1
 	NodeTypeExpression = nodeTypeVector | 1<<2
+	NodeTypeSet        = nodeTypeVector | 1<<3
+	NodeTypeTagged     = nodeTypeVector | 1<<4
 )
 
 func (nt NodeType) String() string {
@@ -33,7 +39,13 @@ var nodeTypeName = map[NodeType]string{
 	NodeTypeSymbol:     "symbol",
 	NodeTypeAtom:       "atom",
 	NodeTypeString:     "string",
+	NodeTypeBool:       "bool",
+	NodeTypeNil:        "nil",
+	NodeTypeChar:       "char",
+	NodeTypeBitVector:  "bitvector",
 	NodeTypeList:       "list",
 	NodeTypeMap:        "map",
 	NodeTypeExpression: "expression",
+	NodeTypeSet:        "set",
+	NodeTypeTagged:     "tagged",
 }
@@ -2,6 +2,11 @@ package ast
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 // Valuer represents a value interface
@@ -36,18 +41,100 @@ func (n *nodeValue) Encode() string {
 	case NodeTypeInt:
 		return fmt.Sprintf("%d", n.v)
 	case NodeTypeFloat:
-		return fmt.Sprintf("%v", n.v)
+		return encodeFloat(n.v.(float64))
 	case NodeTypeSymbol:
-		return fmt.Sprintf("%s", n.v)
+		return encodeSymbol(n.v.(string))
 	case NodeTypeAtom:
 		return fmt.Sprintf("%s", n.v)
 	case NodeTypeString:
 		return fmt.Sprintf("%q", n.v)
+	case NodeTypeBool:
+		return strconv.FormatBool(n.v.(bool))
+	case NodeTypeNil:
+		return "nil"
+	case NodeTypeChar:
+		return encodeChar(n.v.(rune))
+	case NodeTypeBitVector:
+		return encodeBitVector(n.v.(string))
 	}
 
 	panic("unreachable")
 }
 
+// encodeSymbol returns the name of a symbol, the names that the parser can't
+// read back as a symbol are quoted after \symbol, like \symbol"a b", which
+// the parser rejects.
+func encodeSymbol(name string) string {
+	if !isSymbolName(name) {
+		return `\symbol` + strconv.Quote(name)
+	}
+	return name
+}
+
+// isSymbolName returns true if the parser reads the name back as a symbol
+// with the same name
+func isSymbolName(name string) bool {
+	return name != "" && name[0] != '#' && strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`()[]{}"\|`, r)
+	}) < 0
+}
+
+// encodeFloat returns a representation of the float that the parser reads
+// back as the same float: no exponent and always with a decimal point.
+// Infinities and NaN can't be read back, they are written after a backslash,
+// like \+Inf, which the parser rejects.
+func encodeFloat(f float64) string {
+	if !isFinite(f) {
+		return fmt.Sprintf(`\%+v`, f)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s = s + ".0"
+	}
+	return s
+}
+
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// charNames maps the characters that are written by name
+var charNames = map[rune]string{
+	'\n': "newline",
+	'\r': "return",
+	' ':  "space",
+	'\t': "tab",
+	'\f': "formfeed",
+	'\b': "backspace",
+}
+
+// encodeChar returns the EDN representation of a character: a backslash
+// followed by the character, its name or its unicode code point.
+func encodeChar(r rune) string {
+	if name, ok := charNames[r]; ok {
+		return `\` + name
+	}
+	if !unicode.IsPrint(r) && r <= 0xffff {
+		return fmt.Sprintf(`\u%04x`, r)
+	}
+	return `\` + string(r)
+}
+
+// encodeBitVector returns the SMT-LIB representation of a bit vector, in
+// hexadecimal when the number of bits is a multiple of four.
+func encodeBitVector(bits string) string {
+	if len(bits) == 0 || len(bits)%4 != 0 {
+		return "#b" + bits
+	}
+	var buf strings.Builder
+	buf.WriteString("#x")
+	for i := 0; i < len(bits); i += 4 {
+		v, _ := strconv.ParseUint(bits[i:i+4], 2, 8)
+		buf.WriteString(strconv.FormatUint(v, 16))
+	}
+	return buf.String()
+}
+
 // NewStringValue creates a node of type string and sets it to the given value
 func NewStringValue(v string) Valuer {
 	return newNodeValue(NodeTypeString, v)
@@ -63,14 +150,62 @@ func NewIntValue(v int64) Valuer {
 	return newNodeValue(NodeTypeInt, v)
 }
 
+// NewBigIntValue creates a node of type int for an integer of any size, the
+// value of the node is an int64 when the integer fits in one and a *big.Int
+// otherwise
+func NewBigIntValue(v *big.Int) Valuer {
+	if v.IsInt64() {
+		return NewIntValue(v.Int64())
+	}
+	return newNodeValue(NodeTypeInt, new(big.Int).Set(v))
+}
+
 // NewAtomValue creates a node of type atom and sets it to the given value
 func NewAtomValue(v string) Valuer {
 	return newNodeValue(NodeTypeAtom, v)
 }
 
+// IsAtomName returns true if the given name would be read back as an atom
+// after being prefixed by a colon, decoders use it to turn keys into atoms.
+func IsAtomName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // NewSymbolValue creates a node of type symbol and sets it to the given value
 func NewSymbolValue(v string) Valuer {
 	return newNodeValue(NodeTypeSymbol, v)
 }
 
+// NewBoolValue creates a node of type bool and sets it to the given value
+func NewBoolValue(v bool) Valuer {
+	return newNodeValue(NodeTypeBool, v)
+}
+
+// NewNilValue creates a node of type nil
+func NewNilValue() Valuer {
+	return newNodeValue(NodeTypeNil, nil)
+}
+
+// NewCharValue creates a node of type char and sets it to the given value
+func NewCharValue(v rune) Valuer {
+	return newNodeValue(NodeTypeChar, v)
+}
+
+// NewBitVectorValue creates a node of type bitvector, the value is the string
+// of binary digits of the vector, like "0101"
+func NewBitVectorValue(bits string) Valuer {
+	return newNodeValue(NodeTypeBitVector, bits)
+}
+
 var _ = Valuer(&nodeValue{})
@@ -0,0 +1,19 @@
+package ast
+
+// Walk traverses a tree in depth-first order: fn is called with a node and,
+// if it returns true, with each one of its children and then with the tail of
+// the node, if it is a dotted expression.
+func Walk(n *Node, fn func(n *Node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+	if !n.IsVector() {
+		return
+	}
+	for _, child := range n.List() {
+		Walk(child, fn)
+	}
+	if tail := n.Tail(); tail != nil {
+		Walk(tail, fn)
+	}
+}
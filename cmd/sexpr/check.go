@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runCheck(args []string) error {
+	fs := newFlagSet("check", "check [flags] [path ...]")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	err = readInputs(fs.Args(), func(name string, src []byte) error {
+		if _, err := parse(src, options); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			invalid++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return exitError(1)
+	}
+	return nil
+}
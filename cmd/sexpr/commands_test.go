@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "write the output of the commands into the golden files")
+
+// runCommand runs a command and returns what it writes to the standard output
+// and error, followed by the error it returns
+func runCommand(t *testing.T, args []string) string {
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+	}()
+
+	var err error
+	os.Stdout, err = ioutil.TempFile("", "stdout")
+	assert.NoError(t, err)
+	defer os.Remove(os.Stdout.Name())
+	os.Stderr, err = ioutil.TempFile("", "stderr")
+	assert.NoError(t, err)
+	defer os.Remove(os.Stderr.Name())
+
+	runErr := commands[args[0]].run(args[1:])
+
+	var out strings.Builder
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		data, err := ioutil.ReadFile(f.Name())
+		assert.NoError(t, err)
+		if f == os.Stderr && len(data) > 0 {
+			out.WriteString("-- stderr --\n")
+		}
+		out.Write(data)
+		f.Close()
+	}
+	if runErr != nil {
+		out.WriteString("-- error --\n" + runErr.Error() + "\n")
+	}
+	return out.String()
+}
+
+func TestCommands(t *testing.T) {
+	testCases := []struct {
+		Golden string
+		Args   []string
+	}{
+		{"query", []string{"query", "**/listen/*/port", "testdata/config.sexp"}},
+		{"query-index", []string{"query", "server/tags[0][-1]", "testdata/config.sexp", "testdata/nodes.sexp"}},
+		{"query-edn", []string{"query", "-dialect", "edn", "*[2]", "testdata/edn.sexp"}},
+		{"query-none", []string{"query", "client/name", "testdata/config.sexp"}},
+		{"query-selector", []string{"query", "server/[x]", "testdata/config.sexp"}},
+		{"query-invalid", []string{"query", "server", "testdata/invalid.sexp"}},
+
+		{"convert-json", []string{"convert", "-to", "json", "testdata/config.sexp"}},
+		{"convert-xml", []string{"convert", "-to", "xml", "testdata/page.sexp"}},
+		{"convert-yaml", []string{"convert", "-to", "yaml", "testdata/config.sexp"}},
+		{"convert-yaml-error", []string{"convert", "-to", "yaml", "-dialect", "edn", "testdata/edn.sexp"}},
+		{"convert-format", []string{"convert", "-to", "toml", "testdata/config.sexp"}},
+		{"convert-dialect", []string{"convert", "-to", "json", "-dialect", "go", "testdata/config.sexp"}},
+		{"convert-invalid", []string{"convert", "-to", "json", "testdata/invalid.sexp"}},
+		{"convert-xml-error", []string{"convert", "-to", "xml", "-dialect", "edn", "testdata/edn.sexp"}},
+		{"convert-missing", []string{"convert", "-to", "json", "testdata/missing.sexp"}},
+
+		{"tokens", []string{"tokens", "testdata/nodes.sexp"}},
+		{"tokens-edn", []string{"tokens", "-dialect", "edn", "testdata/edn.sexp"}},
+		{"tokens-invalid", []string{"tokens", "testdata/invalid.sexp"}},
+
+		{"tree", []string{"tree", "testdata/nodes.sexp"}},
+		{"tree-edn", []string{"tree", "-dialect", "edn", "testdata/edn.sexp"}},
+		{"tree-invalid", []string{"tree", "testdata/invalid.sexp"}},
+	}
+
+	for _, tc := range testCases {
+		out := runCommand(t, tc.Args)
+
+		golden := filepath.Join("testdata", tc.Golden+".golden")
+		if *update {
+			assert.NoError(t, ioutil.WriteFile(golden, []byte(out), 0644))
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if assert.NoError(t, err, golden) {
+			assert.Equal(t, string(expected), out, strings.Join(tc.Args, " "))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xiam/s-expr/sjson"
+	"github.com/xiam/s-expr/sxml"
+	"github.com/xiam/s-expr/syaml"
+)
+
+func runConvert(args []string) error {
+	fs := newFlagSet("convert", "convert -to json|xml|yaml [flags] [path ...]")
+	to := fs.String("to", "", "output format: json, xml or yaml")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+	if *to != "json" && *to != "xml" && *to != "yaml" {
+		return fmt.Errorf("unknown output format %q, expecting json, xml or yaml", *to)
+	}
+
+	return readInputs(fs.Args(), func(name string, src []byte) error {
+		root, err := parse(src, options)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		switch *to {
+		case "json":
+			// each top-level form is an element of the array
+			out, err := sjson.Encode(root)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fmt.Printf("%s\n", out)
+
+		case "xml":
+			for _, n := range root.List() {
+				if err := sxml.Encode(os.Stdout, n); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				fmt.Println()
+			}
+
+		case "yaml":
+			out, issues, err := syaml.Encode(root)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			for _, issue := range issues {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, issue)
+			}
+			if _, err := os.Stdout.Write(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
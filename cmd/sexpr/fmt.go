@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/xiam/s-expr/format"
+)
+
+func runFmt(args []string) error {
+	fs := newFlagSet("fmt", "fmt [flags] [path ...]")
+	list := fs.Bool("l", false, "list files whose formatting differs instead of printing them")
+	write := fs.Bool("w", false, "overwrite files whose formatting differs instead of printing them")
+	width := fs.Int("width", format.DefaultOptions.Width, "column limit of the formatted forms")
+	_ = fs.Parse(args)
+
+	if *write && fs.NArg() == 0 {
+		return errors.New("cannot use -w with standard input")
+	}
+
+	options := format.Options{Width: *width, Rules: format.DefaultRules}
+	return readInputs(fs.Args(), func(name string, src []byte) error {
+		res, err := options.Source(src)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if !*list && !*write {
+			_, err := os.Stdout.Write(res)
+			return err
+		}
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		if *list {
+			fmt.Println(name)
+		}
+		if *write {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(name, res, info.Mode().Perm())
+		}
+		return nil
+	})
+}
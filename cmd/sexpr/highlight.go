@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/xiam/s-expr/highlight"
+)
+
+func runHighlight(args []string) error {
+	fs := newFlagSet("highlight", "highlight [-format ansi|html] [flags] [path ...]")
+	format := fs.String("format", "ansi", "output format: ansi or html")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	options := highlight.DefaultOptions
+	var err error
+	if options.Parser, err = parserOptions(*dialect); err != nil {
+		return err
+	}
+
+	var render func(src []byte) error
+	switch *format {
+	case "ansi":
+		render = func(src []byte) error {
+			return options.ANSI(os.Stdout, src)
+		}
+	case "html":
+		render = func(src []byte) error {
+			// the output can be pasted into a page as it is
+			if _, err := fmt.Fprint(os.Stdout, `<pre class="sexpr">`); err != nil {
+				return err
+			}
+			if err := options.HTML(os.Stdout, src); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(os.Stdout, `</pre>`)
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, expecting ansi or html", *format)
+	}
+
+	return readInputs(fs.Args(), func(name string, src []byte) error {
+		return render(src)
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+	"github.com/xiam/s-expr/parser"
+)
+
+const (
+	fileExt = ".sexp"
+	stdin   = "<standard input>"
+)
+
+// exitError is returned by commands that report their own errors and only
+// need to set the exit status
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// dialects maps the values of the -dialect flag to the options of the parser
+var dialects = map[string]func() parser.ParserOptions{
+	"default":    func() parser.ParserOptions { return parser.ParserOptions{} },
+	"scheme":     parser.SchemeOptions,
+	"commonlisp": parser.CommonLispOptions,
+	"edn":        parser.EDNOptions,
+	"smtlib":     parser.SMTLIBOptions,
+}
+
+func dialectNames() string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// dialectFlag adds the -dialect flag to a flag set
+func dialectFlag(fs *flag.FlagSet) *string {
+	return fs.String("dialect", "default", "syntax of the input: "+dialectNames())
+}
+
+func parserOptions(dialect string) (parser.ParserOptions, error) {
+	options, ok := dialects[dialect]
+	if !ok {
+		return parser.ParserOptions{}, fmt.Errorf("unknown dialect %q, expecting one of %s", dialect, dialectNames())
+	}
+	return options(), nil
+}
+
+// lexerConfig returns the configuration the parser gives to the lexer
+func lexerConfig(options parser.ParserOptions) lexer.Config {
+	if options.Lexer != nil {
+		return *options.Lexer
+	}
+	return lexer.DefaultConfig()
+}
+
+func parse(src []byte, options parser.ParserOptions) (*ast.Node, error) {
+	p := parser.NewParser(bytes.NewReader(src))
+	p.SetOptions(options)
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return p.RootNode(), nil
+}
+
+// readInputs calls fn with the name and contents of each file, the .sexp
+// files of directories are read recursively. The standard input is read when
+// no file is given.
+func readInputs(paths []string, fn func(name string, src []byte) error) error {
+	if len(paths) == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return fn(stdin, src)
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := readFile(path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || strings.HasPrefix(info.Name(), ".") || filepath.Ext(path) != fileExt {
+				return nil
+			}
+			return readFile(path, fn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, fn func(name string, src []byte) error) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return fn(path, src)
+}
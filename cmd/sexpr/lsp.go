@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"github.com/xiam/s-expr/format"
+	"github.com/xiam/s-expr/lsp"
+)
+
+func runLSP(args []string) error {
+	fs := newFlagSet("lsp", "lsp [flags]")
+	dialect := dialectFlag(fs)
+	width := fs.Int("width", format.DefaultOptions.Width, "column limit of formatted documents")
+	_ = fs.Parse(args)
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+
+	server := lsp.Options{
+		Parser: options,
+		Format: format.Options{Width: *width, Rules: format.DefaultRules},
+	}
+	return server.Serve(os.Stdin, os.Stdout)
+}
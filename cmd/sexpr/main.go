@@ -0,0 +1,150 @@
+// Command sexpr is a set of tools for S-expression sources.
+//
+// Usage:
+//
+//	sexpr <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	check
+//		Parse files and report their syntax errors, the exit status is 1
+//		if any file is invalid.
+//	convert -to json|xml|yaml
+//		Convert files into JSON, XML (following the SXML conventions) or
+//		YAML.
+//	fmt
+//		Format files, like the sexprfmt command does.
+//	highlight -format ansi|html
+//		Print files with syntax highlighting, with the escape sequences of
+//		terminals or as HTML with CSS classes.
+//	lsp
+//		Run a Language Server Protocol server over the standard input and
+//		output, for editors.
+//	query selector
+//		Print the nodes of files that match a selector, like
+//		server/listen/*/port, one per line. The exit status is 1 if no
+//		node matches. See the query package for the selector syntax.
+//	repl
+//		Read forms from the standard input, evaluate them and print their
+//		values. Forms can span several lines, a line that leaves a form
+//		open is followed by a continuation prompt.
+//	tokens
+//		Print the tokens of files, as read by the lexer.
+//	tree
+//		Print the syntax tree of files.
+//	validate -schema file
+//		Validate files against a schema and report every violation, the
+//		exit status is 1 if any file is invalid. See the schema package
+//		for the schema language.
+//
+// Commands that take files read the standard input when none is given, the
+// .sexp files of directories are read recursively. The -dialect flag sets the
+// syntax of the files: default, scheme, commonlisp, edn or smtlib.
+//
+// Run "sexpr <command> -h" to see the flags of a command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	short string
+	run   func(args []string) error
+}
+
+var commands = map[string]*command{
+	"check": {
+		short: "report syntax errors",
+		run:   runCheck,
+	},
+	"convert": {
+		short: "convert into JSON, XML or YAML",
+		run:   runConvert,
+	},
+	"fmt": {
+		short: "format sources",
+		run:   runFmt,
+	},
+	"highlight": {
+		short: "print sources with syntax highlighting",
+		run:   runHighlight,
+	},
+	"lsp": {
+		short: "run a language server for editors",
+		run:   runLSP,
+	},
+	"query": {
+		short: "print the nodes that match a selector",
+		run:   runQuery,
+	},
+	"repl": {
+		short: "evaluate forms interactively",
+		run:   runRepl,
+	},
+	"tokens": {
+		short: "print the tokens of sources",
+		run:   runTokens,
+	},
+	"tree": {
+		short: "print the syntax tree of sources",
+		run:   runTree,
+	},
+	"validate": {
+		short: "validate sources against a schema",
+		run:   runValidate,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sexpr <command> [flags] [arguments]\n\nThe commands are:\n\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"sexpr <command> -h\" to see the flags of a command.\n")
+}
+
+// newFlagSet returns the flag set of a command
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexpr %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sexpr: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		var status exitError
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		fmt.Fprintf(os.Stderr, "sexpr %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
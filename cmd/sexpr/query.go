@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/query"
+)
+
+func runQuery(args []string) error {
+	fs := newFlagSet("query", "query [flags] selector [path ...]")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitError(2)
+	}
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+	selector, err := query.Compile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	err = readInputs(fs.Args()[1:], func(name string, src []byte) error {
+		root, err := parse(src, options)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, n := range selector.Select(root) {
+			fmt.Printf("%s\n", ast.Encode(n))
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		// like grep, nothing selected is not an error but a different status
+		return exitError(1)
+	}
+	return nil
+}
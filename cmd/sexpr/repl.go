@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/eval"
+	"github.com/xiam/s-expr/format"
+	"github.com/xiam/s-expr/parser"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+
+	// maxHistory is the number of entries kept in the history file
+	maxHistory = 1000
+)
+
+const replHelp = `Forms are evaluated as soon as they are complete, a line that leaves a
+form open is followed by a continuation prompt.
+
+Commands:
+
+	:help           show this help
+	:history        list the previous entries
+	!!              evaluate the previous entry again
+	!n              evaluate the entry n of the history again
+	:quit           exit, like an end of file does
+`
+
+func runRepl(args []string) error {
+	fs := newFlagSet("repl", "repl [flags]")
+	historyFile := fs.String("history", "", "file that keeps the history between sessions (default ~/.sexpr_history when the input is a terminal)")
+	width := fs.Int("width", format.DefaultOptions.Width, "column limit of the printed values")
+	_ = fs.Parse(args)
+
+	r := &repl{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		interactive: isTerminal(os.Stdin),
+		env:         eval.DefaultEnv(),
+		format:      format.Options{Width: *width, Rules: format.DefaultRules},
+		historyFile: *historyFile,
+	}
+
+	// scripts piped into the REPL don't add to the history unless a file is
+	// given
+	history := false
+	fs.Visit(func(f *flag.Flag) {
+		history = history || f.Name == "history"
+	})
+	if !history && r.interactive {
+		r.historyFile = defaultHistoryFile()
+	}
+	if err := r.loadHistory(); err != nil {
+		return err
+	}
+	return r.run()
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sexpr_history")
+}
+
+// isTerminal returns true if the file is a character device, prompts are only
+// printed for terminals
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type repl struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+
+	env    *eval.Env
+	format format.Options
+
+	history     []string
+	historyFile string
+}
+
+func (r *repl) prompt(s string) {
+	if r.interactive {
+		fmt.Fprint(r.out, s)
+	}
+}
+
+func (r *repl) run() error {
+	if r.interactive {
+		fmt.Fprintln(r.out, `Type :help for help, :quit or an end of file to exit.`)
+	}
+
+	var input bytes.Buffer
+	for {
+		if input.Len() == 0 {
+			r.prompt(prompt)
+		} else {
+			r.prompt(continuationPrompt)
+		}
+
+		line, readErr := r.in.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if readErr == io.EOF && line == "" {
+			if input.Len() > 0 {
+				// the input ended within a form
+				r.eval(input.String())
+			}
+			if r.interactive {
+				fmt.Fprintln(r.out)
+			}
+			return nil
+		}
+
+		if input.Len() == 0 {
+			handled, quit := r.command(strings.TrimSpace(line))
+			if quit {
+				return nil
+			}
+			if handled {
+				continue
+			}
+		}
+
+		input.WriteString(line)
+		if readErr == nil && incomplete(input.Bytes()) {
+			continue
+		}
+
+		src := input.String()
+		input.Reset()
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+		r.addHistory(src)
+		r.eval(src)
+	}
+}
+
+// incomplete returns true if the source ends within a form
+func incomplete(src []byte) bool {
+	p := parser.NewParser(bytes.NewReader(src))
+	p.SetOptions(eval.ParserOptions())
+	return parser.IsIncomplete(p.Parse())
+}
+
+// historyEntry matches the commands that evaluate an entry of the history
+var historyEntry = regexp.MustCompile(`^!(!|[0-9]+)$`)
+
+// command runs a REPL command, lines that are not commands, like an atom, are
+// not handled
+func (r *repl) command(line string) (handled bool, quit bool) {
+	switch {
+	case line == ":quit" || line == ":q":
+		return true, true
+
+	case line == ":help":
+		fmt.Fprint(r.out, replHelp)
+
+	case line == ":history":
+		for i, entry := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, strings.Replace(strings.TrimRight(entry, "\n"), "\n", "\n      ", -1))
+		}
+
+	case historyEntry.MatchString(line):
+		i := len(r.history)
+		if line != "!!" {
+			i, _ = strconv.Atoi(line[1:])
+		}
+		if i < 1 || i > len(r.history) {
+			fmt.Fprintf(r.out, "error: no history entry %d\n", i)
+			return true, false
+		}
+		src := r.history[i-1]
+		fmt.Fprint(r.out, src)
+		if !strings.HasSuffix(src, "\n") {
+			fmt.Fprintln(r.out)
+		}
+		r.addHistory(src)
+		r.eval(src)
+
+	default:
+		return false, false
+	}
+	return true, false
+}
+
+// eval evaluates the forms of a source one by one and prints their values,
+// define forms are not printed
+func (r *repl) eval(src string) {
+	p := parser.NewParser(strings.NewReader(src))
+	p.SetOptions(eval.ParserOptions())
+	if err := p.Parse(); err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+
+	for _, n := range p.RootNode().List() {
+		v, err := eval.Eval(n, r.env)
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+			return
+		}
+		if isDefinition(n) {
+			continue
+		}
+		r.print(v)
+	}
+}
+
+func isDefinition(n *ast.Node) bool {
+	if n.Type() != ast.NodeTypeExpression || len(n.List()) == 0 {
+		return false
+	}
+	head := n.List()[0]
+	return head.Type() == ast.NodeTypeSymbol && (head.Value() == "define" || head.Value() == "define-syntax")
+}
+
+// print pretty-prints a value with the formatter
+func (r *repl) print(v eval.Value) {
+	n, ok := v.(*ast.Node)
+	if !ok {
+		fmt.Fprintf(r.out, "%v\n", v)
+		return
+	}
+	root := ast.NewList(nil)
+	if err := root.Push(n.Copy()); err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	r.out.Write(r.format.Node(root))
+}
+
+// loadHistory reads the entries of the history file, each entry is written
+// in a line as a quoted Go string
+func (r *repl) loadHistory() error {
+	if r.historyFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(r.historyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, err := strconv.Unquote(line); err == nil {
+			r.history = append(r.history, entry)
+		}
+	}
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+	}
+	return nil
+}
+
+func (r *repl) addHistory(src string) {
+	r.history = append(r.history, src)
+	if r.historyFile == "" {
+		return
+	}
+
+	if len(r.history) > 2*maxHistory {
+		// rewrite the file from time to time so it doesn't grow forever
+		r.history = r.history[len(r.history)-maxHistory:]
+		var buf bytes.Buffer
+		for _, entry := range r.history {
+			fmt.Fprintln(&buf, strconv.Quote(entry))
+		}
+		if err := ioutil.WriteFile(r.historyFile, buf.Bytes(), 0600); err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+		return
+	}
+
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strconv.Quote(src))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/eval"
+	"github.com/xiam/s-expr/format"
+)
+
+func newTestRepl(in string, out *bytes.Buffer) *repl {
+	return &repl{
+		in:     bufio.NewReader(strings.NewReader(in)),
+		out:    out,
+		env:    eval.DefaultEnv(),
+		format: format.Options{Width: format.DefaultOptions.Width, Rules: format.DefaultRules},
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	testCases := []struct {
+		In         string
+		Incomplete bool
+	}{
+		{"", false},
+		{"(+ 1 2)", false},
+		{"(+ 1", true},
+		{"(define (f x)\n  (* x", true},
+		{"[1 {:a", true},
+		{`"abc`, true},
+		{"(+ 1 2) (", true},
+		{"; (", false},
+
+		// invalid input is evaluated to report the error
+		{")", false},
+		{"(a ]", false},
+		{"(+ 1 2))", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Incomplete, incomplete([]byte(tc.In)), tc.In)
+	}
+}
+
+func TestRepl(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{"(+ 1 2)\n", "3\n"},
+		{"(+ 1 2) (* 2 3)\n\n", "3\n6\n"},
+		{"(define x 5)\nx", "5\n"},
+
+		// forms spanning several lines are evaluated once they are complete
+		{"(define (f x)\n  (* x\n     2))\n(f\n 21)\n", "42\n"},
+		{"\"a\nb\"\n", "\"a\nb\"\n"},
+		{"(+ 1\n", "error: syntax error: unexpected EOF (around (line: 2) (column 1))\n"},
+
+		// invalid input
+		{")\n(+ 1 2)\n", "error: syntax error: unexpected token \")\" (around (line 1) (column 1))\n3\n"},
+		{"(foo 1)\n", "error: eval: line 1, column 2: unbound symbol: foo\n"},
+
+		// commands
+		{":quit\n(+ 1 2)\n", ""},
+		{"(+ 1 2)\n!!\n", "3\n(+ 1 2)\n3\n"},
+		{"(+ 1 2)\n(* 2\n 3)\n!1\n!2\n", "3\n6\n(+ 1 2)\n3\n(* 2\n 3)\n6\n"},
+		{"!!\n", "error: no history entry 0\n"},
+		{"1\n!2\n", "1\nerror: no history entry 2\n"},
+		{"1\n(list\n 2)\n!1\n:history\n", "1\n[2]\n1\n1\n   1  1\n   2  (list\n       2)\n   3  1\n"},
+		{":help\n", replHelp},
+	}
+
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		r := newTestRepl(tc.In, &out)
+		assert.NoError(t, r.run(), tc.In)
+		assert.Equal(t, tc.Out, out.String(), tc.In)
+	}
+}
+
+func TestReplHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sexpr")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "history")
+
+	var out bytes.Buffer
+	r := newTestRepl("(+ 1 2)\n(list\n 1)\n!1\n:history\n", &out)
+	r.historyFile = file
+	assert.NoError(t, r.loadHistory())
+	assert.NoError(t, r.run())
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "\"(+ 1 2)\\n\"\n\"(list\\n 1)\\n\"\n\"(+ 1 2)\\n\"\n", string(data))
+
+	// the history is kept between sessions
+	out.Reset()
+	r = newTestRepl("!2\n", &out)
+	r.historyFile = file
+	assert.NoError(t, r.loadHistory())
+	assert.Equal(t, 3, len(r.history))
+	assert.NoError(t, r.run())
+	assert.Equal(t, "(list\n 1)\n[1]\n", out.String())
+
+	// the file is trimmed when it has twice the number of entries kept
+	var buf bytes.Buffer
+	for i := 0; i < 2*maxHistory; i++ {
+		fmt.Fprintln(&buf, strconv.Quote(strconv.Itoa(i)))
+	}
+	assert.NoError(t, ioutil.WriteFile(file, buf.Bytes(), 0600))
+
+	r = newTestRepl("", &out)
+	r.historyFile = file
+	assert.NoError(t, r.loadHistory())
+	assert.Equal(t, maxHistory, len(r.history))
+	assert.Equal(t, strconv.Itoa(maxHistory), r.history[0])
+
+	for i := 0; i < maxHistory; i++ {
+		r.addHistory("x")
+	}
+	data, err = ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*maxHistory, strings.Count(string(data), "\n"))
+
+	r.addHistory("last")
+	assert.Equal(t, maxHistory, len(r.history))
+	data, err = ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, maxHistory, strings.Count(string(data), "\n"))
+	assert.True(t, strings.HasSuffix(string(data), "\"x\"\n\"last\"\n"))
+}
+
+func TestReplHistoryNotInteractive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sexpr")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", dir)
+
+	in := filepath.Join(dir, "in.sexp")
+	assert.NoError(t, ioutil.WriteFile(in, []byte("(+ 1 2)\n"), 0600))
+
+	runRepl := func(args ...string) string {
+		stdin := os.Stdin
+		defer func() {
+			os.Stdin = stdin
+		}()
+		os.Stdin, err = os.Open(in)
+		assert.NoError(t, err)
+		defer os.Stdin.Close()
+		return runCommand(t, append([]string{"repl"}, args...))
+	}
+
+	// input that is not a terminal doesn't write the default history file
+	assert.Equal(t, "3\n", runRepl())
+	_, err = os.Stat(filepath.Join(dir, ".sexpr_history"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	// unless a file is given
+	file := filepath.Join(dir, "history")
+	assert.Equal(t, "3\n", runRepl("-history", file))
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "\"(+ 1 2)\\n\"\n", string(data))
+}
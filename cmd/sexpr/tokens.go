@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/lexer"
+)
+
+func runTokens(args []string) error {
+	fs := newFlagSet("tokens", "tokens [flags] [path ...]")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+
+	return readInputs(fs.Args(), func(name string, src []byte) error {
+		tokens, err := lexer.TokenizeWithConfig(src, lexerConfig(options))
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, tok := range tokens {
+			pos := tok.Pos()
+			fmt.Printf("%s:%d:%d\t%v\t%q\n", name, pos.Line, pos.Column, tok.Type(), tok.Text())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+func runTree(args []string) error {
+	fs := newFlagSet("tree", "tree [flags] [path ...]")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+
+	return readInputs(fs.Args(), func(name string, src []byte) error {
+		root, err := parse(src, options)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ast.Print(root)
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/xiam/s-expr/schema"
+)
+
+func runValidate(args []string) error {
+	fs := newFlagSet("validate", "validate -schema file [flags] [path ...]")
+	schemaPath := fs.String("schema", "", "path of the schema")
+	dialect := dialectFlag(fs)
+	_ = fs.Parse(args)
+
+	if *schemaPath == "" {
+		return errors.New("missing -schema flag")
+	}
+	options, err := parserOptions(*dialect)
+	if err != nil {
+		return err
+	}
+
+	src, err := ioutil.ReadFile(*schemaPath)
+	if err != nil {
+		return err
+	}
+	s, err := schema.Compile(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *schemaPath, err)
+	}
+
+	invalid := 0
+	err = readInputs(fs.Args(), func(name string, src []byte) error {
+		root, err := parse(src, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			invalid++
+			return nil
+		}
+		violations := s.Validate(root)
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, v)
+		}
+		if len(violations) > 0 {
+			invalid++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return exitError(1)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Command sexprfmt formats S-expression files.
+//
+// Without an explicit path, it processes the standard input. Given a file, it
+// operates on that file; given a directory, it operates on all .sexp files in
+// that directory, recursively.
+//
+// Usage:
+//
+//	sexprfmt [flags] [path ...]
+//
+// The flags are:
+//
+//	-d
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different than sexprfmt's, print diffs
+//		to standard output.
+//	-l
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from sexprfmt's, print its name
+//		to standard output.
+//	-w
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from sexprfmt's, overwrite it
+//		with sexprfmt's version.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xiam/s-expr/format"
+)
+
+var (
+	list   = flag.Bool("l", false, "list files whose formatting differs from sexprfmt's")
+	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+)
+
+const fileExt = ".sexp"
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sexprfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			report(fmt.Errorf("error: cannot use -w with standard input"))
+			os.Exit(exitCode)
+		}
+		if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for _, path := range flag.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			report(err)
+			continue
+		}
+		if info.IsDir() {
+			walkDir(path)
+			continue
+		}
+		if err := processFile(path, nil, os.Stdout); err != nil {
+			report(err)
+		}
+	}
+
+	os.Exit(exitCode)
+}
+
+func walkDir(path string) {
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			report(err)
+			return nil
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || filepath.Ext(path) != fileExt {
+			return nil
+		}
+		if err := processFile(path, nil, os.Stdout); err != nil {
+			report(err)
+		}
+		return nil
+	})
+	if err != nil {
+		report(err)
+	}
+}
+
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	res, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if !*list && !*write && !*doDiff {
+		_, err = out.Write(res)
+		return err
+	}
+
+	if bytes.Equal(src, res) {
+		return nil
+	}
+
+	if *list {
+		fmt.Fprintln(out, filename)
+	}
+	if *write {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, res, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if *doDiff {
+		fmt.Fprintf(out, "diff -u %s.orig %s\n", filename, filename)
+		if _, err := out.Write(diff(filename+".orig", filename, src, res)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
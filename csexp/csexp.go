@@ -0,0 +1,113 @@
+// Package csexp implements Rivest's canonical S-expressions, a binary-safe
+// representation where two equal trees always produce the same bytes, which
+// makes it suitable for hashing and signing.
+//
+// The format has three forms: the canonical form, where every octet string is
+// prefixed by its length (3:abc) and lists are written without spaces, the
+// transport form, which is the base64 encoding of the canonical form enclosed
+// in braces, and the advanced form, which is meant to be read by humans and
+// allows tokens, quoted strings, hexadecimal and base64 strings. Any octet
+// string can be preceded by a display hint enclosed in brackets:
+// [10:text/plain]5:hello.
+//
+// Canonical S-expressions only have octet strings and lists, so the type of
+// each node is kept by using display hints:
+//
+//	(fn_a "b" 1 [2.5 :c])
+//
+// is encoded as:
+//
+//	([6:symbol]4:fn_a1:b[3:int]1:1([6:vector]4:list[5:float]3:2.5[4:atom]2::c))
+//
+// Strings have no hint, ints, floats, symbols, atoms, bools, nils and chars
+// are hinted with their type, expressions become plain lists and lists, maps,
+// sets and tagged values become lists that begin with a vector marker. Dotted
+// expressions begin with the dotted marker and their tail is the last element
+// of the list. Display hints that are not type names are represented as
+// (*hint* "text/plain" "hello") expressions.
+package csexp
+
+import (
+	"errors"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Hint is the head of the expressions that represent a hinted octet string
+const Hint = "*hint*"
+
+// Display hints used to keep the type of the nodes
+const (
+	HintInt       = "int"
+	HintFloat     = "float"
+	HintSymbol    = "symbol"
+	HintAtom      = "atom"
+	HintBool      = "bool"
+	HintNil       = "nil"
+	HintChar      = "char"
+	HintBitVector = "bitvector"
+	HintVector    = "vector"
+)
+
+// Error messages
+var (
+	ErrUnexpectedEOF  = errors.New("unexpected EOF")
+	ErrUnexpectedChar = errors.New("unexpected character")
+	ErrInvalidLength  = errors.New("invalid length")
+	ErrInvalidString  = errors.New("invalid string")
+	ErrInvalidValue   = errors.New("invalid typed value")
+	ErrTrailingData   = errors.New("trailing data after expression")
+)
+
+// Options represents the settings of the encoder and the decoder
+type Options struct {
+	// Plain disables the type hints: ints, floats, symbols and atoms are
+	// written as plain octet strings and every vector is written as a list,
+	// this is what most consumers of canonical S-expressions expect. When
+	// decoding, every display hint is represented as a *hint* expression.
+	Plain bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Encode returns the canonical form of the given node using DefaultOptions
+func Encode(n *ast.Node) []byte {
+	return DefaultOptions.Encode(n)
+}
+
+// EncodeTransport returns the transport form of the given node using
+// DefaultOptions
+func EncodeTransport(n *ast.Node) []byte {
+	return DefaultOptions.EncodeTransport(n)
+}
+
+// EncodeAdvanced returns the advanced form of the given node using
+// DefaultOptions
+func EncodeAdvanced(n *ast.Node) []byte {
+	return DefaultOptions.EncodeAdvanced(n)
+}
+
+// Decode reads a single expression written in any of the three forms using
+// DefaultOptions
+func Decode(data []byte) (*ast.Node, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// element is an octet string, optionally hinted, or a list
+type element struct {
+	isList bool
+	list   []*element
+
+	hinted bool
+	hint   string
+	data   string
+}
+
+func octets(s string) *element {
+	return &element{data: s}
+}
+
+func hinted(hint, s string) *element {
+	return &element{hinted: true, hint: hint, data: s}
+}
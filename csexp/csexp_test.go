@@ -0,0 +1,215 @@
+package csexp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func parseNode(t *testing.T, in string) *ast.Node {
+	root, err := parser.Parse([]byte(in))
+	assert.NoError(t, err)
+	return root.List()[0]
+}
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		in        string
+		canonical string
+		advanced  string
+	}{
+		{
+			`"abc"`,
+			`3:abc`,
+			`abc`,
+		},
+		{
+			`(fn_a "b" 1 [2.5 :c])`,
+			`([6:symbol]4:fn_a1:b[3:int]1:1([6:vector]4:list[5:float]3:2.5[4:atom]2::c))`,
+			`([symbol]fn_a b [int]"1" ([vector]list [float]"2.5" [atom]:c))`,
+		},
+		{
+			`{:a -1 "key" ()}`,
+			`([6:vector]3:map[4:atom]2::a[3:int]2:-13:key())`,
+			`([vector]map [atom]:a [int]-1 key ())`,
+		},
+		{
+			`["" "two words" "a\"b\n" "\x00\xff"]`,
+			`([6:vector]4:list0:9:two words4:a"b` + "\n" + `2:` + "\x00\xff" + `)`,
+			`([vector]list "" "two words" "a\"b\n" |AP8=|)`,
+		},
+		{
+			`(*hint* "text/plain" "hello")`,
+			`[10:text/plain]5:hello`,
+			`[text/plain]hello`,
+		},
+	}
+
+	for _, tc := range testCases {
+		n := parseNode(t, tc.in)
+		assert.Equal(t, tc.canonical, string(Encode(n)), tc.in)
+		assert.Equal(t, tc.advanced, string(EncodeAdvanced(n)), tc.in)
+
+		for _, out := range [][]byte{Encode(n), EncodeTransport(n), EncodeAdvanced(n)} {
+			back, err := Decode(out)
+			if assert.NoError(t, err, string(out)) {
+				assert.Equal(t, string(ast.Encode(n)), string(ast.Encode(back)), string(out))
+			}
+		}
+	}
+}
+
+func TestEncodeNil(t *testing.T) {
+	assert.Equal(t, "()", string(Encode(nil)))
+}
+
+func TestEncodeTransport(t *testing.T) {
+	n := parseNode(t, `"abc"`)
+	assert.Equal(t, "{MzphYmM=}", string(EncodeTransport(n)))
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	// equal trees produce the same bytes, no matter how they were written
+	a := parseNode(t, `(fn [1   2.50 "\x41"]  :key)`)
+	b := parseNode(t, "(fn\n  [1 2.5 \"A\"]\n  :key)")
+	assert.Equal(t, Encode(a), Encode(b))
+
+	c := parseNode(t, `(fn [1 2.5 "A"] "key")`)
+	assert.NotEqual(t, Encode(a), Encode(c))
+}
+
+func TestPlain(t *testing.T) {
+	opts := Options{Plain: true}
+
+	n := parseNode(t, `(cert (issuer "alice") [1 2] {:a b})`)
+	assert.Equal(t, `(4:cert(6:issuer5:alice)(1:11:2)(2::a1:b))`, string(opts.Encode(n)))
+	assert.Equal(t, `(cert (issuer alice) ("1" "2") (:a b))`, string(opts.EncodeAdvanced(n)))
+
+	back, err := opts.Decode([]byte(`(4:cert[10:text/plain]5:hello[3:int]1:1)`))
+	assert.NoError(t, err)
+	assert.Equal(t, `("cert" (*hint* "text/plain" "hello") (*hint* "int" "1"))`, string(ast.Encode(back)))
+}
+
+func TestEDNTypes(t *testing.T) {
+	p := parser.NewParser(strings.NewReader(`[nil true \λ #{1} #inst "2020-01-01"]`))
+	p.SetOptions(parser.EDNOptions())
+	assert.NoError(t, p.Parse())
+	n := p.RootNode().List()[0]
+
+	out := Encode(n)
+	assert.Equal(t, `([6:vector]4:list[3:nil]0:[4:bool]4:true[4:char]2:λ([6:vector]3:set[3:int]1:1)([6:vector]6:tagged[6:symbol]4:inst10:2020-01-01))`, string(out))
+
+	back, err := Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.Encode(n)), string(ast.Encode(back)))
+
+	bv := ast.NewNode(nil, ast.NewBitVectorValue("0101"))
+	out = Encode(bv)
+	assert.Equal(t, `[9:bitvector]4:0101`, string(out))
+	back, err = Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "#x5", back.Encode())
+
+	for _, in := range []string{`[bool]yes`, `[nil]x`, `[char]ab`, `[char]""`, `[bitvector]"012"`, `[bitvector]""`} {
+		_, err := Decode([]byte(in))
+		assert.True(t, errors.Is(err, ErrInvalidValue), in)
+	}
+}
+
+func TestDottedExpressions(t *testing.T) {
+	n := ast.NewPair(nil, ast.NewNode(nil, ast.NewSymbolValue("a")), ast.NewNode(nil, ast.NewIntValue(1)))
+
+	out := Encode(n)
+	assert.Equal(t, `([6:vector]6:dotted[6:symbol]1:a[3:int]1:1)`, string(out))
+
+	back, err := Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `(a . 1)`, string(ast.Encode(back)))
+
+	out = Options{Plain: true}.Encode(n)
+	assert.Equal(t, `(1:a1:1)`, string(out))
+
+	_, err = Decode([]byte(`([vector]dotted 1:a)`))
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+}
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out string
+	}{
+		{`3:abc`, `"abc"`},
+		{`(3:abc(3:def))`, `("abc" ("def"))`},
+		{` ( abc  "d e f"
+		  #616263# |YWJj| 3"abc" 3#616263# 3|YWJj|)  `, `("abc" "d e f" "abc" "abc" "abc" "abc" "abc")`},
+		{`"\b\t\v\n\f\r\"\'\\\x41\101\` + "\n" + `z"`, `"\b\t\v\n\f\r\"'\\AAz"`},
+		{`(a {MzphYmM=} b)`, `("a" "abc" "b")`},
+		{`{KDM6YWJjKQ==}`, `("abc")`},
+		{`[ text/plain ] "hello"`, `(*hint* "text/plain" "hello")`},
+		{`[int]"42"`, `42`},
+		{`[atom]:key`, `:key`},
+		{`()`, `()`},
+		{`0:`, `""`},
+	}
+
+	for _, tc := range testCases {
+		n, err := Decode([]byte(tc.in))
+		if assert.NoError(t, err, tc.in) {
+			assert.Equal(t, tc.out, string(ast.Encode(n)), tc.in)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		in  string
+		err error
+		msg string
+	}{
+		{``, ErrUnexpectedEOF, "csexp: unexpected EOF at offset 0"},
+		{`(3:abc`, ErrUnexpectedEOF, "csexp: unexpected EOF at offset 6"},
+		{`5:abc`, ErrUnexpectedEOF, "csexp: unexpected EOF at offset 5"},
+		{`)`, ErrUnexpectedChar, "csexp: unexpected character ')' at offset 0"},
+		{`3abc`, ErrUnexpectedChar, "csexp: unexpected character 'a' at offset 1"},
+		{`03:abc`, ErrInvalidLength, "csexp: invalid length 03: leading zeros at offset 2"},
+		{`4"abc"`, ErrInvalidLength, "csexp: invalid length: expecting 4 bytes, got 3 at offset 6"},
+		{`#6g#`, ErrInvalidString, "csexp: invalid string: encoding/hex: invalid byte: U+0067 'g' at offset 4"},
+		{`"\q"`, ErrInvalidString, `csexp: invalid string: invalid escape \q at offset 3`},
+		{`[3:abc 3:def`, ErrUnexpectedChar, "csexp: unexpected character '3', expecting ']' at offset 8"},
+		{`3:abc 3:def`, ErrTrailingData, "csexp: trailing data after expression at offset 6"},
+		{`[int]abc`, ErrInvalidValue, `csexp: invalid typed value: int "abc" at offset 8`},
+		{`[atom]abc`, ErrInvalidValue, `csexp: invalid typed value: atom "abc" at offset 9`},
+		{`[vector]list`, ErrInvalidValue, "csexp: invalid typed value: vector marker outside of a list at offset 12"},
+		{`([vector]bag)`, ErrInvalidValue, `csexp: invalid typed value: unknown vector "bag" at offset 13`},
+	}
+
+	for _, tc := range testCases {
+		_, err := Decode([]byte(tc.in))
+		if assert.Error(t, err, tc.in) {
+			assert.True(t, errors.Is(err, tc.err), tc.in)
+			assert.Equal(t, tc.msg, err.Error(), tc.in)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`3:abc([3:int]1:1) {Mzphc2Q=}` + "\n"))
+
+	out := []string{}
+	for {
+		n, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		out = append(out, string(ast.Encode(n)))
+	}
+	assert.Equal(t, []string{`"abc"`, `(1)`, `"asd"`}, out)
+}
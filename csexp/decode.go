@@ -0,0 +1,550 @@
+package csexp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// maxPrealloc limits the memory that is allocated up front when reading a
+// verbatim string, longer strings grow as their bytes are read.
+const maxPrealloc = 1 << 16
+
+// Decoder reads expressions from a stream
+type Decoder struct {
+	Options
+
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewDecoder creates a decoder that reads from r using DefaultOptions
+func NewDecoder(r io.Reader) *Decoder {
+	return DefaultOptions.NewDecoder(r)
+}
+
+// NewDecoder creates a decoder that reads from r
+func (o Options) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{Options: o, r: bufio.NewReader(r)}
+}
+
+// Decode reads a single expression written in any of the three forms, only
+// whitespace is allowed after the expression.
+func (o Options) Decode(data []byte) (*ast.Node, error) {
+	d := o.NewDecoder(bytes.NewReader(data))
+
+	n, err := d.Decode()
+	if err != nil {
+		if err == io.EOF {
+			return nil, d.errorf(ErrUnexpectedEOF)
+		}
+		return nil, err
+	}
+
+	d.skipSpace()
+	if _, err := d.r.Peek(1); err != io.EOF {
+		return nil, d.errorf(ErrTrailingData)
+	}
+	return n, nil
+}
+
+// Decode reads the next expression from the stream, it returns io.EOF when
+// there are no more expressions.
+func (d *Decoder) Decode() (*ast.Node, error) {
+	d.skipSpace()
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+
+	e, err := d.element()
+	if err != nil {
+		return nil, err
+	}
+	return d.fromElement(e)
+}
+
+func (d *Decoder) errorf(err error) error {
+	return fmt.Errorf("csexp: %w at offset %d", err, d.offset)
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return 0, d.errorf(ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	d.offset++
+	return c, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	buf, err := d.r.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return 0, d.errorf(ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\v', '\f', '\r', '\n':
+		return true
+	}
+	return false
+}
+
+func (d *Decoder) skipSpace() {
+	for {
+		buf, err := d.r.Peek(1)
+		if err != nil || !isSpace(buf[0]) {
+			return
+		}
+		d.readByte()
+	}
+}
+
+func (d *Decoder) expect(c byte) error {
+	d.skipSpace()
+	got, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if got != c {
+		return d.errorf(fmt.Errorf("%w %q, expecting %q", ErrUnexpectedChar, got, c))
+	}
+	return nil
+}
+
+func (d *Decoder) element() (*element, error) {
+	d.skipSpace()
+
+	c, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case '(':
+		d.readByte()
+		e := &element{isList: true}
+		for {
+			d.skipSpace()
+			c, err := d.peekByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ')' {
+				d.readByte()
+				return e, nil
+			}
+			item, err := d.element()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, item)
+		}
+
+	case '[':
+		d.readByte()
+		d.skipSpace()
+		hint, err := d.simple()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.expect(']'); err != nil {
+			return nil, err
+		}
+		d.skipSpace()
+		data, err := d.simple()
+		if err != nil {
+			return nil, err
+		}
+		return hinted(hint, data), nil
+
+	case '{':
+		d.readByte()
+		data, err := d.delimited('}')
+		if err != nil {
+			return nil, err
+		}
+		canonical, err := decodeBase64(data)
+		if err != nil {
+			return nil, d.errorf(fmt.Errorf("%w: %v", ErrInvalidString, err))
+		}
+		inner := &Decoder{Options: d.Options, r: bufio.NewReader(bytes.NewReader(canonical))}
+		e, err := inner.element()
+		if err != nil {
+			return nil, err
+		}
+		inner.skipSpace()
+		if _, err := inner.r.Peek(1); err != io.EOF {
+			return nil, inner.errorf(ErrTrailingData)
+		}
+		return e, nil
+	}
+
+	s, err := d.simple()
+	if err != nil {
+		return nil, err
+	}
+	return octets(s), nil
+}
+
+// delimited reads everything up to the given delimiter, which is consumed
+func (d *Decoder) delimited(delim byte) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return "", err
+		}
+		if c == delim {
+			return b.String(), nil
+		}
+		b.WriteByte(c)
+	}
+}
+
+func removeSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && isSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(removeSpace(s), "=")
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// simple reads an octet string
+func (d *Decoder) simple() (string, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return "", err
+	}
+
+	length := -1
+	if isDigit(c) {
+		if length, err = d.length(); err != nil {
+			return "", err
+		}
+		if c, err = d.peekByte(); err != nil {
+			return "", err
+		}
+		if c == ':' {
+			d.readByte()
+			return d.verbatim(length)
+		}
+	}
+
+	var s string
+	switch {
+	case c == '"':
+		d.readByte()
+		s, err = d.quoted()
+	case c == '#':
+		d.readByte()
+		var data string
+		if data, err = d.delimited('#'); err == nil {
+			var b []byte
+			if b, err = hex.DecodeString(removeSpace(data)); err != nil {
+				err = d.errorf(fmt.Errorf("%w: %v", ErrInvalidString, err))
+			}
+			s = string(b)
+		}
+	case c == '|':
+		d.readByte()
+		var data string
+		if data, err = d.delimited('|'); err == nil {
+			var b []byte
+			if b, err = decodeBase64(data); err != nil {
+				err = d.errorf(fmt.Errorf("%w: %v", ErrInvalidString, err))
+			}
+			s = string(b)
+		}
+	case length < 0 && isTokenChar(c):
+		s = d.token()
+	default:
+		return "", d.errorf(fmt.Errorf("%w %q", ErrUnexpectedChar, c))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if length >= 0 && len(s) != length {
+		return "", d.errorf(fmt.Errorf("%w: expecting %d bytes, got %d", ErrInvalidLength, length, len(s)))
+	}
+	return s, nil
+}
+
+func (d *Decoder) length() (int, error) {
+	var digits []byte
+	for {
+		c, err := d.peekByte()
+		if err != nil {
+			return 0, err
+		}
+		if !isDigit(c) {
+			break
+		}
+		d.readByte()
+		digits = append(digits, c)
+	}
+	if len(digits) > 1 && digits[0] == '0' {
+		return 0, d.errorf(fmt.Errorf("%w %s: leading zeros", ErrInvalidLength, digits))
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil || n > math.MaxInt32 {
+		return 0, d.errorf(fmt.Errorf("%w %s", ErrInvalidLength, digits))
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) verbatim(length int) (string, error) {
+	var b bytes.Buffer
+	if length < maxPrealloc {
+		b.Grow(length)
+	} else {
+		b.Grow(maxPrealloc)
+	}
+	n, err := io.CopyN(&b, d.r, int64(length))
+	d.offset += n
+	if err != nil {
+		if err == io.EOF {
+			return "", d.errorf(ErrUnexpectedEOF)
+		}
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (d *Decoder) token() string {
+	var b strings.Builder
+	for {
+		buf, err := d.r.Peek(1)
+		if err != nil || !isTokenChar(buf[0]) {
+			return b.String()
+		}
+		d.readByte()
+		b.WriteByte(buf[0])
+	}
+}
+
+func (d *Decoder) quoted() (string, error) {
+	var b strings.Builder
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if err := d.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func isOctalDigit(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+func (d *Decoder) escape(b *strings.Builder) error {
+	c, err := d.readByte()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\'', '\\':
+		b.WriteByte(c)
+	case '\n', '\r':
+		// line continuation, \r\n and \n\r are a single line break
+		if next, err := d.r.Peek(1); err == nil && (next[0] == '\n' || next[0] == '\r') && next[0] != c {
+			d.readByte()
+		}
+	case 'x':
+		digits := make([]byte, 2)
+		for i := range digits {
+			if digits[i], err = d.readByte(); err != nil {
+				return err
+			}
+		}
+		v, err := strconv.ParseUint(string(digits), 16, 8)
+		if err != nil {
+			return d.errorf(fmt.Errorf("%w: invalid escape \\x%s", ErrInvalidString, digits))
+		}
+		b.WriteByte(byte(v))
+	default:
+		if !isOctalDigit(c) {
+			return d.errorf(fmt.Errorf("%w: invalid escape \\%c", ErrInvalidString, c))
+		}
+		digits := []byte{c, 0, 0}
+		for i := 1; i < 3; i++ {
+			if digits[i], err = d.readByte(); err != nil {
+				return err
+			}
+		}
+		v, err := strconv.ParseUint(string(digits), 8, 8)
+		if err != nil {
+			return d.errorf(fmt.Errorf("%w: invalid escape \\%s", ErrInvalidString, digits))
+		}
+		b.WriteByte(byte(v))
+	}
+	return nil
+}
+
+func hintNode(hint, data string) *ast.Node {
+	n := ast.NewExpression(nil)
+	n.PushValue(nil, ast.NewSymbolValue(Hint))
+	n.PushValue(nil, ast.NewStringValue(hint))
+	n.PushValue(nil, ast.NewStringValue(data))
+	return n
+}
+
+func (d *Decoder) fromElement(e *element) (*ast.Node, error) {
+	if !e.isList {
+		if !e.hinted {
+			return ast.NewNode(nil, ast.NewStringValue(e.data)), nil
+		}
+		if d.Plain {
+			return hintNode(e.hint, e.data), nil
+		}
+		return d.fromTyped(e)
+	}
+
+	items := e.list
+	n := ast.NewExpression(nil)
+	dotted := false
+	if !d.Plain && len(items) > 0 && !items[0].isList && items[0].hinted && items[0].hint == HintVector {
+		switch items[0].data {
+		case "list":
+			n = ast.NewList(nil)
+		case "map":
+			n = ast.NewMap(nil)
+		case "set":
+			n = ast.NewSet(nil)
+		case "tagged":
+			n = ast.NewTagged(nil)
+		case "dotted":
+			if len(items) < 3 {
+				return nil, d.errorf(fmt.Errorf("%w: dotted expression without a tail", ErrInvalidValue))
+			}
+			dotted = true
+		default:
+			return nil, d.errorf(fmt.Errorf("%w: unknown vector %q", ErrInvalidValue, items[0].data))
+		}
+		items = items[1:]
+	}
+
+	for i, item := range items {
+		child, err := d.fromElement(item)
+		if err != nil {
+			return nil, err
+		}
+		if dotted && i == len(items)-1 {
+			n.SetTail(child)
+			break
+		}
+		if err := n.Push(child); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (d *Decoder) fromTyped(e *element) (*ast.Node, error) {
+	switch e.hint {
+	case HintInt:
+		v, ok := new(big.Int).SetString(e.data, 10)
+		if !ok {
+			return nil, d.errorf(fmt.Errorf("%w: int %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewBigIntValue(v)), nil
+
+	case HintFloat:
+		v, err := strconv.ParseFloat(e.data, 64)
+		if err != nil {
+			return nil, d.errorf(fmt.Errorf("%w: float %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewFloatValue(v)), nil
+
+	case HintSymbol:
+		if e.data == "" {
+			return nil, d.errorf(fmt.Errorf("%w: empty symbol", ErrInvalidValue))
+		}
+		return ast.NewNode(nil, ast.NewSymbolValue(e.data)), nil
+
+	case HintAtom:
+		if len(e.data) < 2 || e.data[0] != ':' {
+			return nil, d.errorf(fmt.Errorf("%w: atom %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewAtomValue(e.data)), nil
+
+	case HintBool:
+		v, err := strconv.ParseBool(e.data)
+		if err != nil || (e.data != "true" && e.data != "false") {
+			return nil, d.errorf(fmt.Errorf("%w: bool %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewBoolValue(v)), nil
+
+	case HintNil:
+		if e.data != "" {
+			return nil, d.errorf(fmt.Errorf("%w: nil %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewNilValue()), nil
+
+	case HintChar:
+		r, size := utf8.DecodeRuneInString(e.data)
+		if size == 0 || size != len(e.data) || (r == utf8.RuneError && size == 1) {
+			return nil, d.errorf(fmt.Errorf("%w: char %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewCharValue(r)), nil
+
+	case HintBitVector:
+		if e.data == "" || strings.Trim(e.data, "01") != "" {
+			return nil, d.errorf(fmt.Errorf("%w: bitvector %q", ErrInvalidValue, e.data))
+		}
+		return ast.NewNode(nil, ast.NewBitVectorValue(e.data)), nil
+
+	case HintVector:
+		return nil, d.errorf(fmt.Errorf("%w: vector marker outside of a list", ErrInvalidValue))
+	}
+
+	return hintNode(e.hint, e.data), nil
+}
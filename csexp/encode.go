@@ -0,0 +1,228 @@
+package csexp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Encode returns the canonical form of the given node, a nil node is encoded
+// as an empty list.
+func (o Options) Encode(n *ast.Node) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, o.toElement(n))
+	return buf.Bytes()
+}
+
+// EncodeTransport returns the transport form of the given node: the base64
+// encoding of its canonical form enclosed in braces.
+func (o Options) EncodeTransport(n *ast.Node) []byte {
+	canonical := o.Encode(n)
+
+	buf := make([]byte, base64.StdEncoding.EncodedLen(len(canonical))+2)
+	buf[0] = '{'
+	base64.StdEncoding.Encode(buf[1:], canonical)
+	buf[len(buf)-1] = '}'
+	return buf
+}
+
+// EncodeAdvanced returns the advanced form of the given node, octet strings
+// are written as tokens when possible, as quoted strings when they're
+// printable and as base64 otherwise.
+func (o Options) EncodeAdvanced(n *ast.Node) []byte {
+	var buf bytes.Buffer
+	writeAdvanced(&buf, o.toElement(n))
+	return buf.Bytes()
+}
+
+// hintExpression returns the hint and the data of a (*hint* "hint" "data")
+// expression
+func hintExpression(n *ast.Node) (string, string, bool) {
+	if n.Type() != ast.NodeTypeExpression {
+		return "", "", false
+	}
+	children := n.List()
+	if len(children) != 3 {
+		return "", "", false
+	}
+	head, hint, data := children[0], children[1], children[2]
+	if head.Type() != ast.NodeTypeSymbol || head.Value().(string) != Hint {
+		return "", "", false
+	}
+	if hint.Type() != ast.NodeTypeString || data.Type() != ast.NodeTypeString {
+		return "", "", false
+	}
+	return hint.Value().(string), data.Value().(string), true
+}
+
+func (o Options) typed(hint, s string) *element {
+	if o.Plain {
+		return octets(s)
+	}
+	return hinted(hint, s)
+}
+
+func (o Options) toElement(n *ast.Node) *element {
+	if n == nil {
+		return &element{isList: true}
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeString:
+		return octets(n.Value().(string))
+	case ast.NodeTypeInt:
+		return o.typed(HintInt, n.Encode())
+	case ast.NodeTypeFloat:
+		return o.typed(HintFloat, strconv.FormatFloat(n.Value().(float64), 'g', -1, 64))
+	case ast.NodeTypeSymbol:
+		return o.typed(HintSymbol, n.Value().(string))
+	case ast.NodeTypeAtom:
+		return o.typed(HintAtom, n.Value().(string))
+	case ast.NodeTypeBool:
+		return o.typed(HintBool, strconv.FormatBool(n.Value().(bool)))
+	case ast.NodeTypeNil:
+		return o.typed(HintNil, "")
+	case ast.NodeTypeChar:
+		return o.typed(HintChar, string(n.Value().(rune)))
+	case ast.NodeTypeBitVector:
+		return o.typed(HintBitVector, n.Value().(string))
+	}
+
+	if hint, data, ok := hintExpression(n); ok {
+		return hinted(hint, data)
+	}
+
+	e := &element{isList: true}
+	if !o.Plain {
+		switch n.Type() {
+		case ast.NodeTypeList:
+			e.list = append(e.list, hinted(HintVector, "list"))
+		case ast.NodeTypeMap:
+			e.list = append(e.list, hinted(HintVector, "map"))
+		case ast.NodeTypeSet:
+			e.list = append(e.list, hinted(HintVector, "set"))
+		case ast.NodeTypeTagged:
+			e.list = append(e.list, hinted(HintVector, "tagged"))
+		case ast.NodeTypeExpression:
+			if n.Tail() != nil {
+				e.list = append(e.list, hinted(HintVector, "dotted"))
+			}
+		}
+	}
+	for _, child := range n.List() {
+		e.list = append(e.list, o.toElement(child))
+	}
+	if tail := n.Tail(); tail != nil {
+		e.list = append(e.list, o.toElement(tail))
+	}
+	return e
+}
+
+func writeVerbatim(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+func writeCanonical(buf *bytes.Buffer, e *element) {
+	if e.isList {
+		buf.WriteByte('(')
+		for _, item := range e.list {
+			writeCanonical(buf, item)
+		}
+		buf.WriteByte(')')
+		return
+	}
+	if e.hinted {
+		buf.WriteByte('[')
+		writeVerbatim(buf, e.hint)
+		buf.WriteByte(']')
+	}
+	writeVerbatim(buf, e.data)
+}
+
+func writeAdvanced(buf *bytes.Buffer, e *element) {
+	if e.isList {
+		buf.WriteByte('(')
+		for i, item := range e.list {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeAdvanced(buf, item)
+		}
+		buf.WriteByte(')')
+		return
+	}
+	if e.hinted {
+		buf.WriteByte('[')
+		writeSimple(buf, e.hint)
+		buf.WriteByte(']')
+	}
+	writeSimple(buf, e.data)
+}
+
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-./_:*+=", c) >= 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isToken(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeSimple(buf *bytes.Buffer, s string) {
+	switch {
+	case isToken(s):
+		buf.WriteString(s)
+	case isPrintable(s):
+		buf.WriteByte('"')
+		for _, r := range s {
+			switch r {
+			case '"', '\\':
+				buf.WriteByte('\\')
+				buf.WriteRune(r)
+			case '\t':
+				buf.WriteString(`\t`)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			default:
+				buf.WriteRune(r)
+			}
+		}
+		buf.WriteByte('"')
+	default:
+		buf.WriteByte('|')
+		buf.WriteString(base64.StdEncoding.EncodeToString([]byte(s)))
+		buf.WriteByte('|')
+	}
+}
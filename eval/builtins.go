@@ -0,0 +1,296 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Builtins are the functions defined by DefaultEnv: arithmetic (+ - * /),
+// comparison (= < > <= >=), not and list. Arithmetic on ints returns ints,
+// unless a division is not exact, and any float argument makes the result a
+// float. Ints are 64 bits, a result that doesn't fit returns
+// ErrInvalidArgument instead of wrapping around.
+var Builtins = map[string]Func{
+	"+":    add,
+	"-":    sub,
+	"*":    mul,
+	"/":    div,
+	"=":    equals,
+	"<":    compare(func(c int) bool { return c < 0 }),
+	">":    compare(func(c int) bool { return c > 0 }),
+	"<=":   compare(func(c int) bool { return c <= 0 }),
+	">=":   compare(func(c int) bool { return c >= 0 }),
+	"not":  not,
+	"list": list,
+}
+
+// pure are the builtins that Compile can call at compile time
+var pure = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true,
+	"=": true, "<": true, ">": true, "<=": true, ">=": true,
+	"not": true,
+}
+
+func newNil() *ast.Node {
+	return ast.NewNode(nil, ast.NewNilValue())
+}
+
+func newBool(b bool) *ast.Node {
+	return ast.NewNode(nil, ast.NewBoolValue(b))
+}
+
+// newList returns a list node with copies of the given values
+func newList(values []Value) (*ast.Node, error) {
+	n := ast.NewList(nil)
+	if err := pushValues(n, values); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// pushValues appends copies of the given values to a vector node, procedures
+// can't be stored in vectors
+func pushValues(n *ast.Node, values []Value) error {
+	for _, v := range values {
+		child, ok := v.(*ast.Node)
+		if !ok {
+			return fmt.Errorf("%w: can't store %v in a %v", ErrInvalidArgument, v, n.Type())
+		}
+		if err := n.Push(child.Copy()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func arity(args []Value, min, max int) error {
+	switch {
+	case len(args) < min:
+		return fmt.Errorf("%w: expecting at least %d, got %d", ErrArity, min, len(args))
+	case max >= 0 && len(args) > max:
+		return fmt.Errorf("%w: expecting at most %d, got %d", ErrArity, max, len(args))
+	}
+	return nil
+}
+
+// number is an int or float argument
+type number struct {
+	i       int64
+	f       float64
+	isFloat bool
+}
+
+func toNumber(v Value) (number, error) {
+	if x, ok := asNumber(v); ok {
+		return x, nil
+	}
+	if n, ok := v.(*ast.Node); ok {
+		if i, ok := n.BigInt(); ok {
+			return number{}, fmt.Errorf("%w: %v doesn't fit in 64 bits", ErrInvalidArgument, i)
+		}
+	}
+	return number{}, fmt.Errorf("%w: expecting a number, got %s", ErrInvalidArgument, describe(v))
+}
+
+func asNumber(v Value) (number, bool) {
+	if n, ok := v.(*ast.Node); ok {
+		if i, ok := n.Int(); ok {
+			return number{i: i, f: float64(i)}, true
+		}
+		if f, ok := n.Float(); ok {
+			return number{f: f, isFloat: true}, true
+		}
+	}
+	return number{}, false
+}
+
+func (n number) node() *ast.Node {
+	if n.isFloat {
+		return ast.NewNode(nil, ast.NewFloatValue(n.f))
+	}
+	return ast.NewNode(nil, ast.NewIntValue(n.i))
+}
+
+func describe(v Value) string {
+	if n, ok := v.(*ast.Node); ok {
+		return n.Type().String()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// errOverflow is returned when the result of an int operation doesn't fit
+var errOverflow = fmt.Errorf("%w: int overflow", ErrInvalidArgument)
+
+// arithmetic folds the arguments with the given int and float operations, ok
+// is false when the int operation overflows
+func arithmetic(args []Value, acc number, ints func(a, b int64) (c int64, ok bool), floats func(a, b float64) float64) (Value, error) {
+	for _, arg := range args {
+		x, err := toNumber(arg)
+		if err != nil {
+			return nil, err
+		}
+		if acc.isFloat || x.isFloat {
+			acc = number{f: floats(acc.f, x.f), isFloat: true}
+			continue
+		}
+		i, ok := ints(acc.i, x.i)
+		if !ok {
+			return nil, errOverflow
+		}
+		acc.i, acc.f = i, float64(i)
+	}
+	return acc.node(), nil
+}
+
+func add(args []Value) (Value, error) {
+	return arithmetic(args, number{},
+		func(a, b int64) (int64, bool) {
+			c := a + b
+			return c, (c > a) == (b > 0)
+		},
+		func(a, b float64) float64 { return a + b })
+}
+
+func mul(args []Value) (Value, error) {
+	return arithmetic(args, number{i: 1, f: 1},
+		func(a, b int64) (int64, bool) {
+			if a == 0 || b == 0 {
+				return 0, true
+			}
+			c := a * b
+			return c, c/b == a && (a != -1 || b != math.MinInt64) && (b != -1 || a != math.MinInt64)
+		},
+		func(a, b float64) float64 { return a * b })
+}
+
+func sub(args []Value) (Value, error) {
+	if err := arity(args, 1, -1); err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		args = append([]Value{ast.NewNode(nil, ast.NewIntValue(0))}, args...)
+	}
+	first, err := toNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return arithmetic(args[1:], first,
+		func(a, b int64) (int64, bool) {
+			c := a - b
+			return c, (c < a) == (b > 0)
+		},
+		func(a, b float64) float64 { return a - b })
+}
+
+func div(args []Value) (Value, error) {
+	if err := arity(args, 1, -1); err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		args = append([]Value{ast.NewNode(nil, ast.NewIntValue(1))}, args...)
+	}
+	acc, err := toNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		x, err := toNumber(arg)
+		if err != nil {
+			return nil, err
+		}
+		if x.f == 0 {
+			return nil, ErrDivisionByZero
+		}
+		if !acc.isFloat && !x.isFloat && acc.i%x.i == 0 {
+			if acc.i == math.MinInt64 && x.i == -1 {
+				return nil, errOverflow
+			}
+			acc.i = acc.i / x.i
+			acc.f = float64(acc.i)
+			continue
+		}
+		acc = number{f: acc.f / x.f, isFloat: true}
+	}
+	return acc.node(), nil
+}
+
+// cmp returns -1, 0 or 1 when a is less than, equal to or greater than b
+func (a number) cmp(b number) int {
+	switch {
+	case !a.isFloat && !b.isFloat && a.i < b.i, (a.isFloat || b.isFloat) && a.f < b.f:
+		return -1
+	case !a.isFloat && !b.isFloat && a.i > b.i, (a.isFloat || b.isFloat) && a.f > b.f:
+		return 1
+	}
+	return 0
+}
+
+// equal compares two values: numbers are compared by value, other nodes by
+// type and representation and procedures by identity
+func equal(a, b Value) bool {
+	x, okX := asNumber(a)
+	y, okY := asNumber(b)
+	if okX && okY {
+		return x.cmp(y) == 0
+	}
+
+	n, okA := a.(*ast.Node)
+	m, okB := b.(*ast.Node)
+	if !okA || !okB {
+		return a == b
+	}
+	if n.Type() != m.Type() {
+		return false
+	}
+	switch n.Type() {
+	case ast.NodeTypeString, ast.NodeTypeSymbol, ast.NodeTypeAtom, ast.NodeTypeBool, ast.NodeTypeChar, ast.NodeTypeNil:
+		return n.Value() == m.Value()
+	}
+	return string(ast.Encode(n)) == string(ast.Encode(m))
+}
+
+func equals(args []Value) (Value, error) {
+	if err := arity(args, 1, -1); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		if !equal(args[i-1], args[i]) {
+			return newBool(false), nil
+		}
+	}
+	return newBool(true), nil
+}
+
+func compare(fn func(c int) bool) Func {
+	return func(args []Value) (Value, error) {
+		if err := arity(args, 1, -1); err != nil {
+			return nil, err
+		}
+		result := true
+		var prev number
+		for i, arg := range args {
+			x, err := toNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 && !fn(prev.cmp(x)) {
+				result = false
+			}
+			prev = x
+		}
+		return newBool(result), nil
+	}
+}
+
+func not(args []Value) (Value, error) {
+	if err := arity(args, 1, 1); err != nil {
+		return nil, err
+	}
+	return newBool(!Truthy(args[0])), nil
+}
+
+func list(args []Value) (Value, error) {
+	return newList(args)
+}
@@ -0,0 +1,665 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Program is a compiled tree, it holds the bytecode that evaluates the tree
+// in the environment it was compiled for. Programs are not modified by
+// running them, so a Program can be run many times and from different
+// goroutines, as long as the procedures it calls allow it.
+//
+// Running a program gives the same values and errors as evaluating the tree
+// it was compiled from, with some exceptions:
+//
+//   - Calls to the arithmetic and comparison builtins of DefaultEnv with
+//     constant arguments are folded at compile time, unless the program
+//     defines a symbol with the same name. Options.Allow is still checked
+//     for them when the program runs.
+//   - Steps count the instructions that were run, they are not the same as
+//     the steps of Eval.
+//   - MaxDepth limits the nested calls instead of the nested evaluations.
+//     The limits trip at a different point than in Eval, so their errors
+//     can report another position: an expression that exceeds the depth
+//     is reported at the call that entered it and not at the innermost
+//     subexpression that Eval was evaluating.
+//   - Closures created by a program and called with Apply run with the
+//     limits of the run that created them.
+type Program struct {
+	code *code
+	env  *Env
+}
+
+// Compile compiles a node for the given environment using DefaultOptions
+func Compile(n *ast.Node, env *Env) (*Program, error) {
+	return DefaultOptions.Compile(context.Background(), n, env)
+}
+
+// CompileDocument compiles a document root using DefaultOptions
+func CompileDocument(root *ast.Node, env *Env) (*Program, error) {
+	return DefaultOptions.CompileDocument(context.Background(), root, env)
+}
+
+// CompileSource compiles a source using DefaultOptions
+func CompileSource(src []byte, env *Env) (*Program, error) {
+	return DefaultOptions.CompileSource(context.Background(), src, env)
+}
+
+// Compile compiles a node for the given environment. The macros of the node
+// are expanded with the limits of the options and stop when the context is
+// done, the limits of a run are given to Run.
+func (o Options) Compile(ctx context.Context, n *ast.Node, env *Env) (*Program, error) {
+	n, err := env.Macros().ExpandContext(ctx, n, o.evaluator(ctx).expanded)
+	if err != nil {
+		return nil, err
+	}
+	return compile([]*ast.Node{n}, n, env)
+}
+
+// CompileDocument compiles the children of a document root, running the
+// program returns the value of the last one, or nil if the root has no
+// children.
+func (o Options) CompileDocument(ctx context.Context, root *ast.Node, env *Env) (*Program, error) {
+	root, err := env.Macros().ExpandContext(ctx, root, o.evaluator(ctx).expanded)
+	if err != nil {
+		return nil, err
+	}
+	return compile(root.List(), root, env)
+}
+
+// CompileSource parses a source with ParserOptions and compiles it with
+// CompileDocument.
+func (o Options) CompileSource(ctx context.Context, src []byte, env *Env) (*Program, error) {
+	p := parser.NewParser(bytes.NewReader(src))
+	p.SetOptions(ParserOptions())
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return o.CompileDocument(ctx, p.RootNode(), env)
+}
+
+// Run runs the program using DefaultOptions
+func (p *Program) Run() (Value, error) {
+	return DefaultOptions.Run(context.Background(), p)
+}
+
+// String returns a listing of the bytecode of the program
+func (p *Program) String() string {
+	var buf strings.Builder
+	p.code.disassemble(&buf)
+	return buf.String()
+}
+
+// Run runs a program, the run stops when the context is done.
+func (o Options) Run(ctx context.Context, p *Program) (Value, error) {
+	return o.machine(ctx).run(p.code, p.env)
+}
+
+func compile(nodes []*ast.Node, n *ast.Node, env *Env) (*Program, error) {
+	c := &compiler{env: env, defined: map[string]bool{}}
+	for _, child := range nodes {
+		ast.Walk(child, func(n *ast.Node) bool {
+			if name, ok := definedName(n); ok {
+				c.defined[name] = true
+			}
+			return true
+		})
+	}
+
+	c.fn = &function{code: &code{name: "program"}}
+	if err := c.body(nodes, n, true); err != nil {
+		return nil, err
+	}
+	// a document root has no position, the return takes the one of the last
+	// form so the limits can tell where the run stopped
+	end := n
+	if len(nodes) > 0 {
+		end = nodes[len(nodes)-1]
+	}
+	c.emit(opReturn, 0, end)
+	return &Program{code: c.fn.code, env: env}, nil
+}
+
+// definedName returns the name defined by a define form
+func definedName(n *ast.Node) (string, bool) {
+	if name, ok := headSymbol(n); !ok || name != "define" || len(n.List()) < 2 {
+		return "", false
+	}
+	target := n.List()[1]
+	if target.Type() == ast.NodeTypeExpression && len(target.List()) > 0 {
+		target = target.List()[0]
+	}
+	if target.Type() != ast.NodeTypeSymbol {
+		return "", false
+	}
+	return target.Value().(string), true
+}
+
+// headSymbol returns the name of the symbol at the head of an expression
+func headSymbol(n *ast.Node) (string, bool) {
+	if n.Type() != ast.NodeTypeExpression || len(n.List()) == 0 {
+		return "", false
+	}
+	head := n.List()[0]
+	if head.Type() != ast.NodeTypeSymbol {
+		return "", false
+	}
+	return head.Value().(string), true
+}
+
+type compiler struct {
+	env *Env
+	fn  *function
+
+	// defined holds the symbols defined anywhere in the program, calls to
+	// them are never folded
+	defined map[string]bool
+}
+
+// function is the state of the procedure being compiled
+type function struct {
+	parent *function
+	code   *code
+
+	// scope is nil for the top level of a program, where symbols are
+	// global
+	scope *scope
+
+	captures map[capture]int
+}
+
+// scope maps the symbols bound by a lambda or a let to local slots
+type scope struct {
+	parent *scope
+	vars   map[string]int
+
+	// defs holds the symbols that are only bound by the define forms of the
+	// scope, their slots are empty until the define runs
+	defs map[string]bool
+}
+
+func (fn *function) declare(name string) int {
+	if slot, ok := fn.scope.vars[name]; ok {
+		return slot
+	}
+	slot := fn.code.slots
+	fn.code.slots++
+	fn.scope.vars[name] = slot
+	return slot
+}
+
+// ref is a local slot or a captured variable
+type ref struct {
+	local bool
+	index int
+}
+
+// resolve finds the local slots and captured variables a symbol can refer
+// to, from the innermost one out. A symbol defined by a body refers to the
+// bindings around it until its define runs, so every slot of a define is
+// followed by the next binding. bound is false when the last one is a define
+// too, or when no binding was found, then the symbol falls back to the
+// global one.
+func (fn *function) resolve(name string) (refs []ref, bound bool) {
+	for s := fn.scope; s != nil; s = s.parent {
+		if slot, ok := s.vars[name]; ok {
+			refs = append(refs, ref{local: true, index: slot})
+			if !s.defs[name] {
+				return refs, true
+			}
+		}
+	}
+	if fn.parent == nil {
+		return refs, false
+	}
+
+	outer, bound := fn.parent.resolve(name)
+	for _, r := range outer {
+		c := capture(r)
+		i, ok := fn.captures[c]
+		if !ok {
+			i = len(fn.code.captures)
+			fn.code.captures = append(fn.code.captures, c)
+			fn.captures[c] = i
+		}
+		refs = append(refs, ref{index: i})
+	}
+	return refs, bound
+}
+
+func (c *compiler) emit(op opcode, arg int, n *ast.Node) int {
+	code := c.fn.code
+	code.instrs = append(code.instrs, newInstr(op, arg))
+	code.nodes = append(code.nodes, n)
+	return len(code.instrs) - 1
+}
+
+// patch sets the target of a jump to the next instruction
+func (c *compiler) patch(jump int) {
+	code := c.fn.code
+	code.instrs[jump] = newInstr(code.instrs[jump].op(), len(code.instrs))
+}
+
+func (c *compiler) constant(v Value, n *ast.Node) {
+	code := c.fn.code
+	code.consts = append(code.consts, v)
+	c.emit(opConst, len(code.consts)-1, n)
+}
+
+func (c *compiler) name(name string) int {
+	code := c.fn.code
+	for i := range code.names {
+		if code.names[i] == name {
+			return i
+		}
+	}
+	code.names = append(code.names, name)
+	return len(code.names) - 1
+}
+
+// body compiles a sequence of expressions that leaves the value of the last
+// one on the stack, the symbols defined by the sequence are declared before
+// compiling it so procedures can refer to the ones defined after them.
+func (c *compiler) body(nodes []*ast.Node, n *ast.Node, tail bool) error {
+	if c.fn.scope != nil {
+		c.declareDefinitions(nodes)
+	}
+	if len(nodes) == 0 {
+		c.constant(newNil(), n)
+		return nil
+	}
+	for i, child := range nodes {
+		last := i == len(nodes)-1
+		if err := c.compile(child, tail && last); err != nil {
+			return err
+		}
+		if !last {
+			c.emit(opPop, 0, child)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) declareDefinitions(nodes []*ast.Node) {
+	s := c.fn.scope
+	for _, n := range nodes {
+		if name, ok := headSymbol(n); ok && name == "begin" {
+			c.declareDefinitions(n.List()[1:])
+			continue
+		}
+		name, ok := definedName(n)
+		if !ok {
+			continue
+		}
+		if _, ok := s.vars[name]; ok {
+			continue
+		}
+		c.fn.declare(name)
+		if s.defs == nil {
+			s.defs = map[string]bool{}
+		}
+		s.defs[name] = true
+	}
+}
+
+func (c *compiler) compile(n *ast.Node, tail bool) error {
+	if v, calls, ok := c.fold(n); ok {
+		c.checkCalls(calls)
+		c.constant(v, n)
+		return nil
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeSymbol:
+		c.symbol(n)
+		return nil
+	case ast.NodeTypeList, ast.NodeTypeMap, ast.NodeTypeSet:
+		for _, child := range n.List() {
+			if err := c.compile(child, false); err != nil {
+				return err
+			}
+		}
+		code := c.fn.code
+		code.consts = append(code.consts, n)
+		c.emit(opVector, len(code.consts)-1, n)
+		return nil
+	case ast.NodeTypeExpression:
+	default:
+		c.constant(n, n)
+		return nil
+	}
+
+	if n.Tail() != nil {
+		return errorAt(n, fmt.Errorf("%w: can't evaluate a dotted expression", ErrInvalidForm))
+	}
+	children := n.List()
+	if len(children) == 0 {
+		return errorAt(n, fmt.Errorf("%w: empty expression", ErrInvalidForm))
+	}
+
+	head, args := children[0], children[1:]
+	if head.Type() == ast.NodeTypeSymbol {
+		switch head.Value().(string) {
+		case "quote":
+			if len(args) != 1 {
+				return errorAt(n, fmt.Errorf("%w: expecting (quote x)", ErrInvalidForm))
+			}
+			c.constant(args[0], n)
+			return nil
+
+		case "if":
+			if len(args) != 2 && len(args) != 3 {
+				return errorAt(n, fmt.Errorf("%w: expecting (if test then else)", ErrInvalidForm))
+			}
+			if test, calls, ok := c.fold(args[0]); ok {
+				c.checkCalls(calls)
+				switch {
+				case Truthy(test):
+					return c.compile(args[1], tail)
+				case len(args) == 3:
+					return c.compile(args[2], tail)
+				}
+				c.constant(newNil(), n)
+				return nil
+			}
+			if err := c.compile(args[0], false); err != nil {
+				return err
+			}
+			otherwise := c.emit(opJumpIfFalse, 0, n)
+			if err := c.compile(args[1], tail); err != nil {
+				return err
+			}
+			end := c.emit(opJump, 0, n)
+			c.patch(otherwise)
+			if len(args) == 3 {
+				if err := c.compile(args[2], tail); err != nil {
+					return err
+				}
+			} else {
+				c.constant(newNil(), n)
+			}
+			c.patch(end)
+			return nil
+
+		case "define":
+			return c.define(n, args)
+
+		case "define-syntax":
+			if err := c.env.Macros().Define(n); err != nil {
+				return err
+			}
+			c.constant(newNil(), n)
+			return nil
+
+		case "lambda":
+			if len(args) < 2 {
+				return errorAt(n, fmt.Errorf("%w: expecting (lambda (params...) body...)", ErrInvalidForm))
+			}
+			switch params := args[0]; params.Type() {
+			case ast.NodeTypeSymbol:
+				return c.lambda(n, "", nil, params, args[1:])
+			case ast.NodeTypeExpression, ast.NodeTypeList:
+				return c.lambda(n, "", params.List(), params.Tail(), args[1:])
+			}
+			return errorAt(args[0], fmt.Errorf("%w: expecting a parameter list", ErrInvalidForm))
+
+		case "let":
+			if len(args) < 2 {
+				return errorAt(n, fmt.Errorf("%w: expecting (let ((name value)...) body...)", ErrInvalidForm))
+			}
+			return c.let(n, args[0], args[1:], tail)
+
+		case "begin":
+			return c.body(args, n, tail)
+		}
+	}
+
+	for _, child := range children {
+		if err := c.compile(child, false); err != nil {
+			return err
+		}
+	}
+	if tail {
+		c.emit(opTailCall, len(args), n)
+	} else {
+		c.emit(opCall, len(args), n)
+	}
+	return nil
+}
+
+// foldedCall is a call that was folded at compile time
+type foldedCall struct {
+	name string
+	n    *ast.Node
+}
+
+// fold returns the value of a node that doesn't depend on the environment it
+// is evaluated in: values, quoted forms, vectors and calls to pure builtins
+// with constant arguments. It also returns the calls it folded, in the order
+// they would run, so the program can check that they are allowed.
+func (c *compiler) fold(n *ast.Node) (Value, []foldedCall, bool) {
+	switch n.Type() {
+	case ast.NodeTypeSymbol:
+		return nil, nil, false
+	case ast.NodeTypeList, ast.NodeTypeMap, ast.NodeTypeSet:
+		values, calls, ok := c.foldAll(n.List())
+		if !ok {
+			return nil, nil, false
+		}
+		vector := newVector(n)
+		if err := pushValues(vector, values); err != nil {
+			return nil, nil, false
+		}
+		return vector, calls, true
+	case ast.NodeTypeExpression:
+	default:
+		return n, nil, true
+	}
+
+	name, ok := headSymbol(n)
+	if !ok || n.Tail() != nil {
+		return nil, nil, false
+	}
+	args := n.List()[1:]
+	if name == "quote" && len(args) == 1 {
+		return args[0], nil, true
+	}
+
+	if c.defined[name] {
+		return nil, nil, false
+	}
+	if refs, _ := c.fn.resolve(name); len(refs) > 0 {
+		return nil, nil, false
+	}
+	v, _ := c.env.Lookup(name)
+	p, ok := v.(*Procedure)
+	if !ok || !p.pure {
+		return nil, nil, false
+	}
+	values, calls, ok := c.foldAll(args)
+	if !ok {
+		return nil, nil, false
+	}
+	// errors are left for the program to report
+	v, err := p.fn(values)
+	if err != nil {
+		return nil, nil, false
+	}
+	return v, append(calls, foldedCall{name: p.Name, n: n}), true
+}
+
+func (c *compiler) foldAll(nodes []*ast.Node) ([]Value, []foldedCall, bool) {
+	values := make([]Value, 0, len(nodes))
+	var calls []foldedCall
+	for _, n := range nodes {
+		v, nodeCalls, ok := c.fold(n)
+		if !ok {
+			return nil, nil, false
+		}
+		values = append(values, v)
+		calls = append(calls, nodeCalls...)
+	}
+	return values, calls, true
+}
+
+// checkCalls emits the instructions that check that the folded calls are
+// allowed by the options of the run
+func (c *compiler) checkCalls(calls []foldedCall) {
+	for _, call := range calls {
+		c.emit(opAllowed, c.name(call.name), call.n)
+	}
+}
+
+// symbol compiles a reference to a variable
+func (c *compiler) symbol(n *ast.Node) {
+	name := n.Value().(string)
+	refs, bound := c.fn.resolve(name)
+	switch {
+	case len(refs) == 0:
+		c.emit(opGlobal, c.name(name), n)
+	case len(refs) == 1 && bound && refs[0].local:
+		c.emit(opLocal, refs[0].index, n)
+	case len(refs) == 1 && bound:
+		c.emit(opFree, refs[0].index, n)
+	default:
+		code := c.fn.code
+		code.lookups = append(code.lookups, lookup{name: name, refs: refs, global: !bound})
+		c.emit(opLookup, len(code.lookups)-1, n)
+	}
+}
+
+func (c *compiler) define(n *ast.Node, args []*ast.Node) error {
+	if len(args) < 2 {
+		return errorAt(n, fmt.Errorf("%w: expecting (define name value)", ErrInvalidForm))
+	}
+
+	var name string
+	switch target := args[0]; target.Type() {
+	case ast.NodeTypeSymbol:
+		if len(args) != 2 {
+			return errorAt(n, fmt.Errorf("%w: expecting (define name value)", ErrInvalidForm))
+		}
+		name = target.Value().(string)
+		if err := c.compile(args[1], false); err != nil {
+			return err
+		}
+
+	case ast.NodeTypeExpression:
+		children := target.List()
+		if len(children) == 0 || children[0].Type() != ast.NodeTypeSymbol {
+			return errorAt(n, fmt.Errorf("%w: expecting (define (name params...) body...)", ErrInvalidForm))
+		}
+		name = children[0].Value().(string)
+		if err := c.lambda(n, name, children[1:], target.Tail(), args[1:]); err != nil {
+			return err
+		}
+
+	default:
+		return errorAt(target, fmt.Errorf("%w: can't define %s", ErrInvalidForm, ast.Encode(target)))
+	}
+
+	if c.fn.scope == nil {
+		c.emit(opDefine, c.name(name), n)
+	} else {
+		c.emit(opDefineLocal, c.fn.declare(name), n)
+	}
+	c.constant(newNil(), n)
+	return nil
+}
+
+// lambda compiles the body of a procedure into a new code block and emits
+// the instruction that creates a closure of it
+func (c *compiler) lambda(n *ast.Node, name string, params []*ast.Node, rest *ast.Node, body []*ast.Node) error {
+	fn := &function{
+		parent:   c.fn,
+		code:     &code{name: name},
+		scope:    &scope{vars: map[string]int{}},
+		captures: map[capture]int{},
+	}
+
+	for _, param := range params {
+		if param.Type() != ast.NodeTypeSymbol {
+			return errorAt(param, fmt.Errorf("%w: expecting a parameter name, got %s", ErrInvalidForm, ast.Encode(param)))
+		}
+		fn.declare(param.Value().(string))
+		fn.code.params++
+	}
+	if rest != nil {
+		if rest.Type() != ast.NodeTypeSymbol {
+			return errorAt(rest, fmt.Errorf("%w: expecting a parameter name, got %s", ErrInvalidForm, ast.Encode(rest)))
+		}
+		fn.declare(rest.Value().(string))
+		fn.code.rest = true
+	}
+
+	c.fn = fn
+	err := c.body(body, n, true)
+	c.emit(opReturn, 0, n)
+	c.fn = fn.parent
+	if err != nil {
+		return err
+	}
+
+	code := c.fn.code
+	code.codes = append(code.codes, fn.code)
+	c.emit(opClosure, len(code.codes)-1, n)
+	return nil
+}
+
+// let compiles the values of the bindings in the enclosing scope and stores
+// them in the slots of a new one
+func (c *compiler) let(n *ast.Node, bindings *ast.Node, body []*ast.Node, tail bool) error {
+	if bindings.Type() != ast.NodeTypeExpression && bindings.Type() != ast.NodeTypeList {
+		return errorAt(bindings, fmt.Errorf("%w: expecting a list of bindings", ErrInvalidForm))
+	}
+
+	names := make([]string, 0, len(bindings.List()))
+	for _, binding := range bindings.List() {
+		pair := []*ast.Node{}
+		if binding.IsVector() {
+			pair = binding.List()
+		}
+		if len(pair) != 2 || pair[0].Type() != ast.NodeTypeSymbol {
+			return errorAt(binding, fmt.Errorf("%w: expecting (name value)", ErrInvalidForm))
+		}
+		if err := c.compile(pair[1], false); err != nil {
+			return err
+		}
+		names = append(names, pair[0].Value().(string))
+	}
+
+	outer := c.fn.scope
+	c.fn.scope = &scope{parent: outer, vars: map[string]int{}}
+	defer func() { c.fn.scope = outer }()
+
+	// let slots are never shared, so a let can't overwrite the variables
+	// that closures captured from another one
+	slots := make([]int, len(names))
+	for i, name := range names {
+		slots[i] = c.fn.code.slots
+		c.fn.code.slots++
+		c.fn.scope.vars[name] = slots[i]
+	}
+	for i := len(slots) - 1; i >= 0; i-- {
+		c.emit(opInit, slots[i], n)
+	}
+	return c.body(body, n, tail)
+}
+
+// newVector returns an empty node of the same type as the given list, map or
+// set
+func newVector(n *ast.Node) *ast.Node {
+	switch n.Type() {
+	case ast.NodeTypeList:
+		return ast.NewList(n.Token())
+	case ast.NodeTypeMap:
+		return ast.NewMap(n.Token())
+	}
+	return ast.NewSet(n.Token())
+}
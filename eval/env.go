@@ -0,0 +1,145 @@
+package eval
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/macro"
+)
+
+// Value is the result of evaluating an expression: data is represented by
+// *ast.Node values and procedures by *Procedure values.
+type Value interface{}
+
+// Func is a procedure written in Go, it receives the evaluated arguments of
+// the call. Errors returned by a Func are reported at the position of the
+// call.
+type Func func(args []Value) (Value, error)
+
+// Procedure is a value that can be called, either a Func or a closure created
+// by lambda.
+type Procedure struct {
+	Name string
+
+	fn Func
+
+	// pure functions have no side effects, calls to them with constant
+	// arguments are folded by Compile
+	pure bool
+
+	params []string
+	rest   string
+	body   []*ast.Node
+	env    *Env
+	ev     *evaluator
+
+	// closures created by a Program
+	code *code
+	free []*cell
+	vm   *machine
+}
+
+// NewFunc returns a procedure that calls the given Func
+func NewFunc(name string, fn Func) *Procedure {
+	return &Procedure{Name: name, fn: fn}
+}
+
+func (p *Procedure) String() string {
+	if p.Name == "" {
+		return "#<procedure>"
+	}
+	return fmt.Sprintf("#<procedure %s>", p.Name)
+}
+
+// bind returns a new environment that maps the parameters of a closure to the
+// given arguments
+func (p *Procedure) bind(args []Value) (*Env, error) {
+	if len(args) < len(p.params) || (p.rest == "" && len(args) > len(p.params)) {
+		return nil, arityError(p, len(args))
+	}
+
+	env := NewEnv(p.env)
+	for i, name := range p.params {
+		env.Define(name, args[i])
+	}
+	if p.rest != "" {
+		rest, err := newList(args[len(p.params):])
+		if err != nil {
+			return nil, err
+		}
+		env.Define(p.rest, rest)
+	}
+	return env, nil
+}
+
+func arityError(p *Procedure, got int) error {
+	if p.rest != "" {
+		return fmt.Errorf("%w: %v expects at least %d arguments, got %d", ErrArity, p, len(p.params), got)
+	}
+	return fmt.Errorf("%w: %v expects %d arguments, got %d", ErrArity, p, len(p.params), got)
+}
+
+// Env maps symbols to values, environments are nested: a symbol that is not
+// defined in an environment is looked up in its parent.
+type Env struct {
+	parent *Env
+	vars   map[string]Value
+	macros *macro.Expander
+}
+
+// NewEnv returns an empty environment within the given parent, which can be
+// nil.
+func NewEnv(parent *Env) *Env {
+	return &Env{
+		parent: parent,
+		vars:   map[string]Value{},
+	}
+}
+
+// DefaultEnv returns a new environment with the nil, true and false constants
+// and the functions in Builtins.
+func DefaultEnv() *Env {
+	env := NewEnv(nil)
+	env.Define("nil", ast.NewNode(nil, ast.NewNilValue()))
+	env.Define("true", ast.NewNode(nil, ast.NewBoolValue(true)))
+	env.Define("false", ast.NewNode(nil, ast.NewBoolValue(false)))
+	for name, fn := range Builtins {
+		env.Define(name, &Procedure{Name: name, fn: fn, pure: pure[name]})
+	}
+	return env
+}
+
+// Define binds a symbol to a value in the environment
+func (e *Env) Define(name string, v Value) {
+	e.vars[name] = v
+}
+
+// DefineFunc binds a symbol to a procedure that calls the given Func
+func (e *Env) DefineFunc(name string, fn Func) {
+	e.Define(name, NewFunc(name, fn))
+}
+
+// Lookup returns the value of a symbol, looking it up in the enclosing
+// environments when it is not defined in this one.
+func (e *Env) Lookup(name string) (Value, bool) {
+	for env := e; env != nil; env = env.parent {
+		if v, ok := env.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Macros returns the expander that holds the macros defined with
+// define-syntax, macros are global: every environment returns the expander of
+// the outermost one.
+func (e *Env) Macros() *macro.Expander {
+	root := e
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root.macros == nil {
+		root.macros = macro.NewExpander()
+	}
+	return root.macros
+}
@@ -0,0 +1,606 @@
+// Package eval implements an evaluator for S-expression trees, the base for
+// embedding a small Lisp in a Go program.
+//
+// Expressions are evaluated against an environment (see Env) that holds the
+// values of the symbols, including the procedures written in Go. Values
+// evaluate to themselves, symbols evaluate to the value they are bound to,
+// lists, maps and sets evaluate to new vectors with their children evaluated
+// and expressions are procedure calls, unless their head is one of the
+// special forms:
+//
+//	(quote x)                     returns x without evaluating it
+//	(if test then else)           else is optional and defaults to nil
+//	(define name value)           binds name in the current environment
+//	(define (name params...) body...)
+//	(lambda (params...) body...)  params can be a list or a dotted
+//	                              expression, like (a b . rest)
+//	(let ((name value)...) body...)
+//	(begin body...)
+//
+// Macros can be defined with define-syntax and syntax-rules, they are
+// expanded before evaluating, see the macro package.
+//
+// Scoping is lexical and calls in tail position don't grow the Go stack, so
+// loops can be written as recursive procedures. The nil and false values are
+// false, any other value is true.
+//
+// Trees that are evaluated many times can be compiled into a Program, which
+// runs the same code on a stack machine, see Compile.
+//
+// Code that is not trusted can be evaluated with limits on the number of
+// steps, allocations and nested evaluations, a deadline and a list of the Go
+// functions it can call, see Options.
+package eval
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Error messages
+var (
+	ErrUnboundSymbol   = errors.New("unbound symbol")
+	ErrNotProcedure    = errors.New("not a procedure")
+	ErrArity           = errors.New("wrong number of arguments")
+	ErrInvalidForm     = errors.New("invalid form")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrDivisionByZero  = errors.New("division by zero")
+
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+	ErrDepthLimit      = errors.New("depth limit exceeded")
+	ErrNotAllowed      = errors.New("function not allowed")
+)
+
+// Error is an error found while evaluating an expression, it holds the
+// position of the expression that failed. Line and Column are zero for
+// expressions that don't come from a source.
+type Error struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("eval: %v", e.Err)
+	}
+	return fmt.Sprintf("eval: line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorAt returns an *Error with the position of the given node, errors that
+// already have a position are returned as they are.
+func errorAt(n *ast.Node, err error) error {
+	var evalErr *Error
+	if errors.As(err, &evalErr) {
+		return err
+	}
+	evalErr = &Error{Err: err}
+	if tok := n.Token(); tok != nil {
+		evalErr.Line, evalErr.Column = tok.Pos().Line, tok.Pos().Column
+	}
+	return evalErr
+}
+
+// ParserOptions returns the options EvalSource reads sources with: the ones
+// of parser.SchemeOptions.
+func ParserOptions() parser.ParserOptions {
+	return parser.SchemeOptions()
+}
+
+// Options represents the limits of an evaluation, they are meant for running
+// code that is not trusted. A zero limit means no limit. When a limit is
+// exceeded the evaluation stops with an *Error that wraps ErrStepLimit,
+// ErrAllocationLimit, ErrDepthLimit, ErrNotAllowed or the error of the
+// context and holds the position of the expression that was being evaluated.
+//
+// Closures keep the limits and the context of the evaluation that created
+// them, also when they are called from Go with Apply. A closure created by an
+// evaluation without limits runs without limits when a Go function called by
+// a limited evaluation applies it, so Go functions given to code that is not
+// trusted should only apply the procedures they receive as arguments.
+type Options struct {
+	// MaxSteps is the number of expressions that can be evaluated, including
+	// the values and symbols within them, and of macro expansions.
+	MaxSteps int
+
+	// MaxAllocations is the number of nodes, environments and closures that
+	// can be created. The nodes returned by Go functions and the nodes
+	// created by macro expansions are counted too.
+	MaxAllocations int
+
+	// MaxDepth is the number of nested evaluations, calls in tail position
+	// don't count.
+	MaxDepth int
+
+	// Allow holds the names of the Go functions that can be called, any
+	// function is allowed when nil. Closures can always be called.
+	Allow []string
+}
+
+// DefaultOptions are the options used by the package level functions, they
+// only limit the depth, so deep recursion returns an error instead of
+// overflowing the stack of the goroutine.
+var DefaultOptions = Options{MaxDepth: 10000}
+
+// Eval evaluates a node in the given environment using DefaultOptions
+func Eval(n *ast.Node, env *Env) (Value, error) {
+	return DefaultOptions.Eval(context.Background(), n, env)
+}
+
+// EvalDocument evaluates a document root using DefaultOptions
+func EvalDocument(root *ast.Node, env *Env) (Value, error) {
+	return DefaultOptions.EvalDocument(context.Background(), root, env)
+}
+
+// EvalSource evaluates a source using DefaultOptions
+func EvalSource(src []byte, env *Env) (Value, error) {
+	return DefaultOptions.EvalSource(context.Background(), src, env)
+}
+
+// Eval evaluates a node in the given environment, the evaluation stops when
+// the context is done.
+func (o Options) Eval(ctx context.Context, n *ast.Node, env *Env) (Value, error) {
+	ev := o.evaluator(ctx)
+	n, err := env.Macros().ExpandContext(ctx, n, ev.expanded)
+	if err != nil {
+		return nil, err
+	}
+	return ev.eval(n, env)
+}
+
+// EvalDocument evaluates each one of the children of a document root in order
+// and returns the value of the last one, or nil if the root has no children.
+func (o Options) EvalDocument(ctx context.Context, root *ast.Node, env *Env) (Value, error) {
+	ev := o.evaluator(ctx)
+	root, err := env.Macros().ExpandContext(ctx, root, ev.expanded)
+	if err != nil {
+		return nil, err
+	}
+	return ev.body(root.List(), env)
+}
+
+// EvalSource parses a source with ParserOptions and evaluates it with
+// EvalDocument.
+func (o Options) EvalSource(ctx context.Context, src []byte, env *Env) (Value, error) {
+	p := parser.NewParser(bytes.NewReader(src))
+	p.SetOptions(ParserOptions())
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return o.EvalDocument(ctx, p.RootNode(), env)
+}
+
+func (o Options) evaluator(ctx context.Context) *evaluator {
+	ev := &evaluator{Options: o, ctx: ctx}
+	if o.Allow != nil {
+		ev.allowed = make(map[string]bool, len(o.Allow))
+		for _, name := range o.Allow {
+			ev.allowed[name] = true
+		}
+	}
+	return ev
+}
+
+// Apply calls a procedure with the given arguments, it is meant to be used by
+// Go functions that receive procedures, like a map function would. Closures
+// are evaluated with the limits and the context of the evaluation that
+// created them, not of the evaluation that called the Go function, see
+// Options.
+func Apply(p *Procedure, args []Value) (Value, error) {
+	if p.fn != nil {
+		return p.fn(args)
+	}
+	if p.code != nil {
+		return p.vm.apply(p, args)
+	}
+	env, err := p.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return p.ev.body(p.body, env)
+}
+
+// Truthy returns false for the nil and false values and true for any other
+// value.
+func Truthy(v Value) bool {
+	n, ok := v.(*ast.Node)
+	if !ok {
+		return true
+	}
+	if b, ok := n.Bool(); ok {
+		return b
+	}
+	return !n.IsNil()
+}
+
+type evaluator struct {
+	Options
+
+	ctx     context.Context
+	allowed map[string]bool
+
+	steps       int
+	allocations int
+	depth       int
+}
+
+// step counts the evaluation of a node and checks the limits that depend on
+// the number of steps
+func (ev *evaluator) step(n *ast.Node) error {
+	ev.steps++
+	if ev.MaxSteps > 0 && ev.steps > ev.MaxSteps {
+		return errorAt(n, fmt.Errorf("%w: %d steps", ErrStepLimit, ev.MaxSteps))
+	}
+	if ev.ctx != nil {
+		select {
+		case <-ev.ctx.Done():
+			return errorAt(n, ev.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// allocate counts the creation of nodes, environments or closures
+func (ev *evaluator) allocate(n *ast.Node, count int) error {
+	ev.allocations += count
+	if ev.MaxAllocations > 0 && ev.allocations > ev.MaxAllocations {
+		return errorAt(n, fmt.Errorf("%w: %d allocations", ErrAllocationLimit, ev.MaxAllocations))
+	}
+	return nil
+}
+
+// expanded counts a macro expansion as a step and the nodes it created as
+// allocations, so macros can't be used to get around the limits
+func (ev *evaluator) expanded(use *ast.Node, size int) error {
+	if err := ev.step(use); err != nil {
+		return err
+	}
+	return ev.allocate(use, size)
+}
+
+// size returns the number of nodes of a value
+func size(v Value) int {
+	count := 0
+	if n, ok := v.(*ast.Node); ok {
+		ast.Walk(n, func(*ast.Node) bool {
+			count++
+			return true
+		})
+	}
+	return count
+}
+
+// body evaluates a sequence of expressions and returns the value of the last
+// one
+func (ev *evaluator) body(nodes []*ast.Node, env *Env) (Value, error) {
+	if len(nodes) == 0 {
+		return newNil(), nil
+	}
+	if err := ev.sequence(nodes[:len(nodes)-1], env); err != nil {
+		return nil, err
+	}
+	return ev.eval(nodes[len(nodes)-1], env)
+}
+
+// sequence evaluates a sequence of expressions for their side effects
+func (ev *evaluator) sequence(nodes []*ast.Node, env *Env) error {
+	for _, n := range nodes {
+		if _, err := ev.eval(n, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ev *evaluator) eval(n *ast.Node, env *Env) (Value, error) {
+	ev.depth++
+	defer func() { ev.depth-- }()
+	if ev.MaxDepth > 0 && ev.depth > ev.MaxDepth {
+		return nil, errorAt(n, fmt.Errorf("%w: %d nested evaluations", ErrDepthLimit, ev.MaxDepth))
+	}
+
+	// the loop replaces the recursive call on expressions in tail position
+	for {
+		if err := ev.step(n); err != nil {
+			return nil, err
+		}
+
+		switch n.Type() {
+		case ast.NodeTypeSymbol:
+			name := n.Value().(string)
+			v, ok := env.Lookup(name)
+			if !ok {
+				return nil, errorAt(n, fmt.Errorf("%w: %s", ErrUnboundSymbol, name))
+			}
+			return v, nil
+		case ast.NodeTypeList, ast.NodeTypeMap, ast.NodeTypeSet:
+			return ev.vector(n, env)
+		case ast.NodeTypeExpression:
+		default:
+			return n, nil
+		}
+
+		if n.Tail() != nil {
+			return nil, errorAt(n, fmt.Errorf("%w: can't evaluate a dotted expression", ErrInvalidForm))
+		}
+		children := n.List()
+		if len(children) == 0 {
+			return nil, errorAt(n, fmt.Errorf("%w: empty expression", ErrInvalidForm))
+		}
+
+		head, args := children[0], children[1:]
+		if head.Type() == ast.NodeTypeSymbol {
+			switch head.Value().(string) {
+			case "quote":
+				if len(args) != 1 {
+					return nil, errorAt(n, fmt.Errorf("%w: expecting (quote x)", ErrInvalidForm))
+				}
+				return args[0], nil
+
+			case "if":
+				if len(args) != 2 && len(args) != 3 {
+					return nil, errorAt(n, fmt.Errorf("%w: expecting (if test then else)", ErrInvalidForm))
+				}
+				test, err := ev.eval(args[0], env)
+				if err != nil {
+					return nil, err
+				}
+				switch {
+				case Truthy(test):
+					n = args[1]
+				case len(args) == 3:
+					n = args[2]
+				default:
+					return newNil(), nil
+				}
+				continue
+
+			case "define":
+				return ev.define(n, args, env)
+
+			case "define-syntax":
+				// define-syntax forms within a tree are removed by the
+				// expander, this is only reached by a form evaluated alone
+				if err := env.Macros().Define(n); err != nil {
+					return nil, err
+				}
+				return newNil(), nil
+
+			case "lambda":
+				if len(args) < 2 {
+					return nil, errorAt(n, fmt.Errorf("%w: expecting (lambda (params...) body...)", ErrInvalidForm))
+				}
+				switch params := args[0]; params.Type() {
+				case ast.NodeTypeSymbol:
+					return ev.lambda(n, "", nil, params, args[1:], env)
+				case ast.NodeTypeExpression, ast.NodeTypeList:
+					return ev.lambda(n, "", params.List(), params.Tail(), args[1:], env)
+				}
+				return nil, errorAt(args[0], fmt.Errorf("%w: expecting a parameter list", ErrInvalidForm))
+
+			case "let":
+				if len(args) < 2 {
+					return nil, errorAt(n, fmt.Errorf("%w: expecting (let ((name value)...) body...)", ErrInvalidForm))
+				}
+				scope, err := ev.let(args[0], env)
+				if err != nil {
+					return nil, err
+				}
+				if err := ev.allocate(n, 1); err != nil {
+					return nil, err
+				}
+				if err := ev.sequence(args[1:len(args)-1], scope); err != nil {
+					return nil, err
+				}
+				n, env = args[len(args)-1], scope
+				continue
+
+			case "begin":
+				if len(args) == 0 {
+					return newNil(), nil
+				}
+				if err := ev.sequence(args[:len(args)-1], env); err != nil {
+					return nil, err
+				}
+				n = args[len(args)-1]
+				continue
+			}
+		}
+
+		f, err := ev.eval(head, env)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := f.(*Procedure)
+		if !ok {
+			return nil, errorAt(head, fmt.Errorf("%w: %s", ErrNotProcedure, ast.Encode(head)))
+		}
+
+		values := make([]Value, 0, len(args))
+		for _, arg := range args {
+			v, err := ev.eval(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+
+		if p.fn != nil {
+			if ev.allowed != nil && !ev.allowed[p.Name] {
+				return nil, errorAt(head, fmt.Errorf("%w: %s", ErrNotAllowed, p.Name))
+			}
+			v, err := p.fn(values)
+			if err != nil {
+				var evalErr *Error
+				if errors.As(err, &evalErr) {
+					return nil, err
+				}
+				return nil, errorAt(n, fmt.Errorf("%s: %w", p.Name, err))
+			}
+			if ev.MaxAllocations > 0 {
+				if err := ev.allocate(n, size(v)); err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		}
+
+		if p.code != nil {
+			v, err := p.vm.apply(p, values)
+			if err != nil {
+				return nil, errorAt(n, err)
+			}
+			return v, nil
+		}
+
+		scope, err := p.bind(values)
+		if err != nil {
+			return nil, errorAt(n, err)
+		}
+		count := 1
+		if p.rest != "" {
+			count += 1 + len(values) - len(p.params)
+		}
+		if err := ev.allocate(n, count); err != nil {
+			return nil, err
+		}
+		if err := ev.sequence(p.body[:len(p.body)-1], scope); err != nil {
+			return nil, err
+		}
+		n, env = p.body[len(p.body)-1], scope
+	}
+}
+
+// vector evaluates the children of a list, map or set into a new node of the
+// same type
+func (ev *evaluator) vector(n *ast.Node, env *Env) (Value, error) {
+	values := make([]Value, 0, len(n.List()))
+	for _, child := range n.List() {
+		v, err := ev.eval(child, env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	var vector *ast.Node
+	switch n.Type() {
+	case ast.NodeTypeList:
+		vector = ast.NewList(n.Token())
+	case ast.NodeTypeMap:
+		vector = ast.NewMap(n.Token())
+	default:
+		vector = ast.NewSet(n.Token())
+	}
+	if err := pushValues(vector, values); err != nil {
+		return nil, errorAt(n, err)
+	}
+	if ev.MaxAllocations > 0 {
+		if err := ev.allocate(n, size(vector)); err != nil {
+			return nil, err
+		}
+	}
+	return vector, nil
+}
+
+// define reads the (define name value) and (define (name params...) body...)
+// forms
+func (ev *evaluator) define(n *ast.Node, args []*ast.Node, env *Env) (Value, error) {
+	if len(args) < 2 {
+		return nil, errorAt(n, fmt.Errorf("%w: expecting (define name value)", ErrInvalidForm))
+	}
+
+	target := args[0]
+	switch target.Type() {
+	case ast.NodeTypeSymbol:
+		if len(args) != 2 {
+			return nil, errorAt(n, fmt.Errorf("%w: expecting (define name value)", ErrInvalidForm))
+		}
+		v, err := ev.eval(args[1], env)
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := v.(*Procedure); ok && p.Name == "" {
+			p.Name = target.Value().(string)
+		}
+		env.Define(target.Value().(string), v)
+
+	case ast.NodeTypeExpression:
+		children := target.List()
+		if len(children) == 0 || children[0].Type() != ast.NodeTypeSymbol {
+			return nil, errorAt(n, fmt.Errorf("%w: expecting (define (name params...) body...)", ErrInvalidForm))
+		}
+		name := children[0].Value().(string)
+		v, err := ev.lambda(n, name, children[1:], target.Tail(), args[1:], env)
+		if err != nil {
+			return nil, err
+		}
+		env.Define(name, v)
+
+	default:
+		return nil, errorAt(target, fmt.Errorf("%w: can't define %s", ErrInvalidForm, ast.Encode(target)))
+	}
+
+	return newNil(), nil
+}
+
+// lambda returns a closure over the given environment, rest is the symbol
+// that takes the remaining arguments, if any
+func (ev *evaluator) lambda(n *ast.Node, name string, params []*ast.Node, rest *ast.Node, body []*ast.Node, env *Env) (Value, error) {
+	p := &Procedure{Name: name, body: body, env: env, ev: ev}
+
+	for _, param := range params {
+		if param.Type() != ast.NodeTypeSymbol {
+			return nil, errorAt(param, fmt.Errorf("%w: expecting a parameter name, got %s", ErrInvalidForm, ast.Encode(param)))
+		}
+		p.params = append(p.params, param.Value().(string))
+	}
+	if rest != nil {
+		if rest.Type() != ast.NodeTypeSymbol {
+			return nil, errorAt(rest, fmt.Errorf("%w: expecting a parameter name, got %s", ErrInvalidForm, ast.Encode(rest)))
+		}
+		p.rest = rest.Value().(string)
+	}
+
+	if err := ev.allocate(n, 1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// let returns a new environment with the bindings of a let form, the values
+// are evaluated in the enclosing environment
+func (ev *evaluator) let(bindings *ast.Node, env *Env) (*Env, error) {
+	if bindings.Type() != ast.NodeTypeExpression && bindings.Type() != ast.NodeTypeList {
+		return nil, errorAt(bindings, fmt.Errorf("%w: expecting a list of bindings", ErrInvalidForm))
+	}
+
+	scope := NewEnv(env)
+	for _, binding := range bindings.List() {
+		pair := []*ast.Node{}
+		if binding.IsVector() {
+			pair = binding.List()
+		}
+		if len(pair) != 2 || pair[0].Type() != ast.NodeTypeSymbol {
+			return nil, errorAt(binding, fmt.Errorf("%w: expecting (name value)", ErrInvalidForm))
+		}
+		v, err := ev.eval(pair[1], env)
+		if err != nil {
+			return nil, err
+		}
+		scope.Define(pair[0].Value().(string), v)
+	}
+	return scope, nil
+}
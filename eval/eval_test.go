@@ -0,0 +1,422 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/macro"
+	"github.com/xiam/s-expr/parser"
+)
+
+func encode(v Value) string {
+	if n, ok := v.(*ast.Node); ok {
+		return string(ast.Encode(n))
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// evalTests are shared with the tests of compiled programs
+var evalTests = []struct {
+	In  string
+	Out string
+}{
+	{`1`, `1`},
+	{`"hello" :atom`, `:atom`},
+	{`(+ 1 2 3)`, `6`},
+	{`(+ 1 2.5)`, `3.5`},
+	{`(- 10 1 2) (- 5)`, `-5`},
+	{`(* 2 3 4)`, `24`},
+	{`(/ 10 2)`, `5`},
+	{`(/ 1 4)`, `0.25`},
+	{`(< 1 2 3)`, `true`},
+	{`(<= 1 1 0)`, `false`},
+	{`(= 1 1.0)`, `true`},
+	{`(= "a" "a" "b")`, `false`},
+	{`(not nil)`, `true`},
+	{`(quote (a b))`, `(a b)`},
+	{`'sym`, `sym`},
+	{`(if true 1 2)`, `1`},
+	{`(if false 1)`, `nil`},
+	{`(if nil 1 #f)`, `false`},
+	{`(if 0 "zero is true" "no")`, `"zero is true"`},
+	{`(define x 40) (+ x 2)`, `42`},
+	{`(define (sq x) (* x x)) (sq 9)`, `81`},
+	{`((lambda (a b) (- a b)) 5 3)`, `2`},
+	{`((lambda [a] a) 1)`, `1`},
+	{`((lambda (a . rest) rest) 1 2 3)`, `[2 3]`},
+	{`((lambda args args))`, `[]`},
+	{`(let ((a 1) (b 2)) (+ a b))`, `3`},
+	{`(define a 1) (let ((a 2) (b a)) b)`, `1`},
+	{`(begin 1 2 3)`, `3`},
+	{`(begin)`, `nil`},
+	{`[1 (+ 1 1) 'x]`, `[1 2 x]`},
+	{`{:a (* 2 2)}`, `{:a 4}`},
+	{`(list 1 "b" (list))`, `[1 "b" []]`},
+	{`
+			(define (make-counter n)
+			  (lambda (step) (+ n step)))
+			(define from-ten (make-counter 10))
+			(from-ten 5)
+		`, `15`},
+	{`
+			(define (fact n)
+			  (if (<= n 1)
+			    1
+			    (* n (fact (- n 1)))))
+			(fact 20)
+		`, `2432902008176640000`},
+	{`
+			(define x 1)
+			(define (get-x) x)
+			(let ((x 2)) (get-x))
+		`, `1`},
+	// a define binds its name from the point it runs
+	{`(define y 2) (let ((a 1)) (define x y) (define y 3) x)`, `2`},
+	{`(define y 2) (define (f) (define x y) (define y 3) (list x y)) (f)`, `[2 3]`},
+	{`(define y 2) (define (f) (lambda () (define x y) (define y 3) x)) ((f))`, `2`},
+}
+
+func TestEval(t *testing.T) {
+	for _, tc := range evalTests {
+		v, err := EvalSource([]byte(tc.In), DefaultEnv())
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, encode(v), tc.In)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	src := `
+		(define (loop n acc)
+		  (if (= n 0)
+		    acc
+		    (loop (- n 1) (+ acc 1))))
+		(define (even? n) (if (= n 0) true (odd? (- n 1))))
+		(define (odd? n) (if (= n 0) false (even? (- n 1))))
+		(list (loop 100000 0) (even? 100001))
+	`
+	v, err := EvalSource([]byte(src), DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, `[100000 false]`, encode(v))
+}
+
+func TestMacros(t *testing.T) {
+	src := `
+		(define-syntax my-or
+		  (syntax-rules ()
+		    ((_) #f)
+		    ((_ e) e)
+		    ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))
+		(define t 5)
+		(my-or false t)
+	`
+	v, err := EvalSource([]byte(src), DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, `5`, encode(v))
+
+	// macros are kept in the environment between evaluations
+	env := DefaultEnv()
+	_, err = EvalSource([]byte(`(define-syntax inc! (syntax-rules () ((_ x) (define x (+ x 1)))))`), env)
+	assert.NoError(t, err)
+	v, err = EvalSource([]byte(`(define n 1) (inc! n) (inc! n) n`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `3`, encode(v))
+
+	// free symbols of a template refer to the bindings where the macro is
+	// defined
+	v, err = EvalSource([]byte(`
+		(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+		(let ((+ -)) (inc 10))
+	`), DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, `11`, encode(v))
+
+	_, err = EvalSource([]byte("(define-syntax m (syntax-rules () ((_ x) x)))\n(m)"), DefaultEnv())
+	assert.True(t, errors.Is(err, macro.ErrNoMatch))
+	assert.EqualError(t, err, "macro: line 2, column 1: no syntax rule matches: (m)")
+}
+
+func TestGoFunctions(t *testing.T) {
+	env := DefaultEnv()
+	env.DefineFunc("upcase", func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, ErrArity
+		}
+		s, ok := args[0].(*ast.Node)
+		if !ok || s.Type() != ast.NodeTypeString {
+			return nil, ErrInvalidArgument
+		}
+		return ast.NewNode(nil, ast.NewStringValue(strings.ToUpper(s.Value().(string)))), nil
+	})
+	env.DefineFunc("map", func(args []Value) (Value, error) {
+		p, ok := args[0].(*Procedure)
+		if !ok {
+			return nil, ErrNotProcedure
+		}
+		values := []Value{}
+		for _, item := range args[1].(*ast.Node).List() {
+			v, err := Apply(p, []Value{item})
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return newList(values)
+	})
+
+	v, err := EvalSource([]byte(`(map upcase ["a" "b"]) (map (lambda (x) (* x x)) [1 2 3])`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `[1 4 9]`, encode(v))
+
+	v, err = EvalSource([]byte(`(map upcase ["a" "b"])`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `["A" "B"]`, encode(v))
+
+	v, err = EvalSource([]byte(`upcase (define (f) 1) f`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `#<procedure f>`, encode(v))
+
+	_, err = EvalSource([]byte(`(map (lambda (x) (/ x 0)) [1])`), env)
+	assert.True(t, errors.Is(err, ErrDivisionByZero))
+	assert.Equal(t, "eval: line 1, column 18: /: division by zero", err.Error())
+}
+
+var errorTests = []struct {
+	In  string
+	Err error
+	Msg string
+}{
+	{`(+ 1 foo)`, ErrUnboundSymbol, "eval: line 1, column 6: unbound symbol: foo"},
+	{"(define x 1)\n  (x 2)", ErrNotProcedure, "eval: line 2, column 4: not a procedure: x"},
+	{`(+ 1 "a")`, ErrInvalidArgument, "eval: line 1, column 1: +: invalid argument: expecting a number, got string"},
+	{"(define (f a) a)\n(f)", ErrArity, "eval: line 2, column 1: wrong number of arguments: #<procedure f> expects 1 arguments, got 0"},
+	{`((lambda (a . b) a))`, ErrArity, "eval: line 1, column 1: wrong number of arguments: #<procedure> expects at least 1 arguments, got 0"},
+	{`(not)`, ErrArity, "eval: line 1, column 1: not: wrong number of arguments: expecting at least 1, got 0"},
+	{`(/ 1 0)`, ErrDivisionByZero, "eval: line 1, column 1: /: division by zero"},
+	{`(* 9223372036854775807 2)`, ErrInvalidArgument, "eval: line 1, column 1: *: invalid argument: int overflow"},
+	{`(+ 9223372036854775807 1)`, ErrInvalidArgument, "eval: line 1, column 1: +: invalid argument: int overflow"},
+	{`(- -9223372036854775807 2)`, ErrInvalidArgument, "eval: line 1, column 1: -: invalid argument: int overflow"},
+	{`(- (- -9223372036854775807 1))`, ErrInvalidArgument, "eval: line 1, column 1: -: invalid argument: int overflow"},
+	{`(* (- -9223372036854775807 1) -1)`, ErrInvalidArgument, "eval: line 1, column 1: *: invalid argument: int overflow"},
+	{`(/ (- -9223372036854775807 1) -1)`, ErrInvalidArgument, "eval: line 1, column 1: /: invalid argument: int overflow"},
+	{`()`, ErrInvalidForm, "eval: line 1, column 1: invalid form: empty expression"},
+	{`(a . b)`, ErrInvalidForm, "eval: line 1, column 1: invalid form: can't evaluate a dotted expression"},
+	{`(if)`, ErrInvalidForm, "eval: line 1, column 1: invalid form: expecting (if test then else)"},
+	{`(quote)`, ErrInvalidForm, "eval: line 1, column 1: invalid form: expecting (quote x)"},
+	{`(lambda (1) 1)`, ErrInvalidForm, "eval: line 1, column 10: invalid form: expecting a parameter name, got 1"},
+	{`(let (a) a)`, ErrInvalidForm, "eval: line 1, column 7: invalid form: expecting (name value)"},
+	{`(define 1 2)`, ErrInvalidForm, "eval: line 1, column 9: invalid form: can't define 1"},
+	{`[not]`, ErrInvalidArgument, "eval: line 1, column 1: invalid argument: can't store #<procedure not> in a list"},
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range errorTests {
+		_, err := EvalSource([]byte(tc.In), DefaultEnv())
+		assert.True(t, errors.Is(err, tc.Err), "%q: %v", tc.In, err)
+		if assert.Error(t, err, tc.In) {
+			assert.Equal(t, tc.Msg, err.Error(), tc.In)
+		}
+	}
+
+	// nodes built in Go don't have a position
+	expr := ast.NewExpression(nil)
+	_, _ = expr.PushValue(nil, ast.NewSymbolValue("nope"))
+	_, err := Eval(expr, DefaultEnv())
+	assert.Equal(t, "eval: unbound symbol: nope", err.Error())
+
+	// ints that don't fit in 64 bits are only parsed with the BigInts option
+	p := parser.NewParser(strings.NewReader(`(+ 9223372036854775808 1)`))
+	opts := ParserOptions()
+	opts.BigInts = true
+	p.SetOptions(opts)
+	assert.NoError(t, p.Parse())
+	_, err = EvalDocument(p.RootNode(), DefaultEnv())
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	assert.Equal(t, "eval: line 1, column 1: +: invalid argument: 9223372036854775808 doesn't fit in 64 bits", err.Error())
+}
+
+func TestScopes(t *testing.T) {
+	global := DefaultEnv()
+	global.Define("limit", ast.NewNode(nil, ast.NewIntValue(10)))
+
+	local := NewEnv(global)
+	_, err := EvalSource([]byte(`(define limit 5) (define other 1)`), local)
+	assert.NoError(t, err)
+
+	v, ok := local.Lookup("limit")
+	assert.True(t, ok)
+	assert.Equal(t, "5", encode(v))
+
+	v, ok = global.Lookup("limit")
+	assert.True(t, ok)
+	assert.Equal(t, "10", encode(v))
+
+	_, ok = global.Lookup("other")
+	assert.False(t, ok)
+
+	assert.False(t, Truthy(newNil()))
+	assert.False(t, Truthy(newBool(false)))
+	assert.True(t, Truthy(NewFunc("f", nil)))
+}
+
+func TestLimits(t *testing.T) {
+	loop := `
+		(define (loop n)
+		  (if (= n 0) 0 (loop (- n 1))))
+		(loop 1000)
+	`
+	deep := `
+		(define (count n)
+		  (if (= n 0) 0 (+ 1 (count (- n 1)))))
+		(count 1000)
+	`
+	lists := `
+		(define (grow n acc)
+		  (if (= n 0) acc (grow (- n 1) (list acc acc))))
+		(grow 20 1)
+	`
+
+	testCases := []struct {
+		Options Options
+		In      string
+		Err     error
+		Msg     string
+	}{
+		{Options{MaxSteps: 1000}, loop, ErrStepLimit, "eval: line 3, column 20: step limit exceeded: 1000 steps"},
+		{Options{MaxSteps: 100000}, loop, nil, ""},
+		{Options{MaxDepth: 100}, deep, ErrDepthLimit, "eval: line 3, column 32: depth limit exceeded: 100 nested evaluations"},
+		{Options{MaxDepth: 100}, loop, nil, ""},
+		{Options{MaxAllocations: 10000}, lists, ErrAllocationLimit, "eval: line 3, column 35: allocation limit exceeded: 10000 allocations"},
+		{Options{MaxAllocations: 1000}, `(define (f) [1 2 3]) (f) (f)`, nil, ""},
+		{Options{MaxAllocations: 1}, `(let ((a 1)) (lambda () a))`, ErrAllocationLimit, "eval: line 1, column 14: allocation limit exceeded: 1 allocations"},
+		{Options{Allow: []string{"+"}}, `(+ 1 (* 2 3))`, ErrNotAllowed, "eval: line 1, column 7: function not allowed: *"},
+		{Options{Allow: []string{"+"}}, `(define (f x) (+ x 1)) (f 1)`, nil, ""},
+	}
+
+	for _, tc := range testCases {
+		_, err := tc.Options.EvalSource(context.Background(), []byte(tc.In), DefaultEnv())
+		if tc.Err == nil {
+			assert.NoError(t, err, tc.In)
+			continue
+		}
+		assert.True(t, errors.Is(err, tc.Err), "%q: %v", tc.In, err)
+		if assert.Error(t, err) {
+			assert.Equal(t, tc.Msg, err.Error())
+		}
+	}
+
+	// deep recursion is stopped by default
+	_, err := EvalSource([]byte(`(define (f n) (if (= n 0) 0 (+ 1 (f (- n 1))))) (f 10000000)`), DefaultEnv())
+	assert.True(t, errors.Is(err, ErrDepthLimit), "%v", err)
+
+	// the same limit trips at the same place every time
+	for i := 0; i < 3; i++ {
+		_, err := Options{MaxSteps: 1000}.EvalSource(context.Background(), []byte(loop), DefaultEnv())
+		assert.Equal(t, "eval: line 3, column 20: step limit exceeded: 1000 steps", err.Error())
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	forever := `
+		(define (forever) (forever))
+		(forever)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := Options{}.EvalSource(ctx, []byte(forever), DefaultEnv())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	var evalErr *Error
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, 2, evalErr.Line)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = Options{}.EvalSource(ctx, []byte(`1`), DefaultEnv())
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestMacroLimits(t *testing.T) {
+	// each use of d doubles its argument before it's expanded, 22 levels
+	// expand into millions of nodes
+	src := "(define-syntax d (syntax-rules () ((_ x) (list x x))))\n" +
+		strings.Repeat("(d ", 22) + "1" + strings.Repeat(")", 22)
+
+	testCases := []struct {
+		Options Options
+		Err     error
+		Msg     string
+	}{
+		{Options{MaxSteps: 1000}, ErrStepLimit, "eval: line 2, column 64: step limit exceeded: 1000 steps"},
+		{Options{MaxAllocations: 1000}, ErrAllocationLimit, "eval: line 2, column 61: allocation limit exceeded: 1000 allocations"},
+	}
+
+	for _, tc := range testCases {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := tc.Options.EvalSource(ctx, []byte(src), DefaultEnv())
+		assert.True(t, errors.Is(err, tc.Err), "%v", err)
+		if assert.Error(t, err) {
+			assert.Equal(t, tc.Msg, err.Error())
+		}
+
+		_, err = tc.Options.CompileSource(ctx, []byte(src), DefaultEnv())
+		assert.True(t, errors.Is(err, tc.Err), "%v", err)
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := Options{}.EvalSource(ctx, []byte(src), DefaultEnv())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestSandboxedCallbacks(t *testing.T) {
+	env := DefaultEnv()
+	env.DefineFunc("call", func(args []Value) (Value, error) {
+		return Apply(args[0].(*Procedure), nil)
+	})
+
+	// closures created by a limited evaluation keep its limits when they are
+	// called from Go
+	_, err := Options{MaxDepth: 50}.EvalSource(context.Background(), []byte(`
+		(define (count n)
+		  (if (= n 0) 0 (+ 1 (count (- n 1)))))
+		(call (lambda () (count 100)))
+	`), env)
+	assert.True(t, errors.Is(err, ErrDepthLimit))
+
+	_, err = Options{Allow: []string{"call"}}.EvalSource(context.Background(), []byte(`(call (lambda () (list 1)))`), env)
+	assert.True(t, errors.Is(err, ErrNotAllowed))
+
+	// a closure created by an evaluation without limits keeps running without
+	// limits when a limited evaluation calls it through Go
+	loop, err := Options{}.EvalSource(context.Background(), []byte(`
+		(define (loop n)
+		  (if (= n 0) 0 (loop (- n 1))))
+		(lambda () (loop 10000))
+	`), env)
+	assert.NoError(t, err)
+	env.DefineFunc("call-loop", func(args []Value) (Value, error) {
+		return Apply(loop.(*Procedure), nil)
+	})
+	v, err := Options{MaxSteps: 10}.EvalSource(context.Background(), []byte(`(call-loop)`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `0`, encode(v))
+
+	p, err := CompileSource([]byte(`(lambda () (define (loop n) (if (= n 0) 0 (loop (- n 1)))) (loop 10000))`), env)
+	assert.NoError(t, err)
+	loop, err = p.Run()
+	assert.NoError(t, err)
+	v, err = Options{MaxSteps: 10}.EvalSource(context.Background(), []byte(`(call-loop)`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `0`, encode(v))
+}
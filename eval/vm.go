@@ -0,0 +1,567 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+type opcode uint8
+
+const (
+	opConst       opcode = iota // push consts[arg]
+	opGlobal                    // push the global names[arg]
+	opLocal                     // push the local slot arg
+	opFree                      // push the captured variable arg
+	opLookup                    // push the first variable of lookups[arg] that is set
+	opInit                      // pop into the local slot arg
+	opDefine                    // pop into the global names[arg]
+	opDefineLocal               // pop into the local slot arg, or its cell
+	opPop                       // drop the top of the stack
+	opJump                      // jump to arg
+	opJumpIfFalse               // pop and jump to arg if false
+	opCall                      // call with arg arguments
+	opTailCall                  // call with arg arguments, replacing the frame
+	opReturn                    // return the top of the stack
+	opClosure                   // push a closure of codes[arg]
+	opVector                    // pop the children of the vector consts[arg]
+	opAllowed                   // check that the folded call to names[arg] is allowed
+)
+
+var opcodeNames = [...]string{
+	opConst:       "const",
+	opGlobal:      "global",
+	opLocal:       "local",
+	opFree:        "free",
+	opLookup:      "lookup",
+	opInit:        "init",
+	opDefine:      "define",
+	opDefineLocal: "define-local",
+	opPop:         "pop",
+	opJump:        "jump",
+	opJumpIfFalse: "jump-if-false",
+	opCall:        "call",
+	opTailCall:    "tail-call",
+	opReturn:      "return",
+	opClosure:     "closure",
+	opVector:      "vector",
+	opAllowed:     "allowed",
+}
+
+func (op opcode) String() string {
+	return opcodeNames[op]
+}
+
+// instr is an instruction: the opcode takes the lowest byte and the argument
+// the others.
+type instr uint32
+
+func newInstr(op opcode, arg int) instr {
+	return instr(op) | instr(arg)<<8
+}
+
+func (in instr) op() opcode {
+	return opcode(in & 0xff)
+}
+
+func (in instr) arg() int {
+	return int(in >> 8)
+}
+
+// code is a compiled procedure, or the top level of a program
+type code struct {
+	name   string
+	params int
+	rest   bool
+
+	// slots is the number of local variables, including the parameters
+	slots int
+
+	instrs []instr
+	// nodes holds the node each instruction was compiled from, they give the
+	// position of errors
+	nodes  []*ast.Node
+	consts []Value
+	names  []string
+	codes  []*code
+
+	captures []capture
+	lookups  []lookup
+}
+
+// lookup holds the variables a symbol defined by a body can refer to, the
+// slot of the define comes first and is empty until the define runs
+type lookup struct {
+	name string
+	refs []ref
+
+	// global is true when the symbol falls back to the global one
+	global bool
+}
+
+// capture tells where a closure takes a variable from when it is created:
+// a local slot or a captured variable of the enclosing procedure
+type capture struct {
+	local bool
+	index int
+}
+
+// cell holds a variable captured by a closure, the slot of a captured
+// variable is replaced with its cell so the procedure and the closure share
+// it
+type cell struct {
+	v Value
+}
+
+func (c *code) disassemble(w io.Writer) {
+	name := c.name
+	if name == "" {
+		name = "lambda"
+	}
+	fmt.Fprintf(w, "%s:\n", name)
+	for i, in := range c.instrs {
+		fmt.Fprintf(w, "  %3d %s", i, in.op())
+		switch in.op() {
+		case opConst:
+			fmt.Fprintf(w, " %s", describeValue(c.consts[in.arg()]))
+		case opGlobal, opDefine, opAllowed:
+			fmt.Fprintf(w, " %s", c.names[in.arg()])
+		case opLookup:
+			fmt.Fprintf(w, " %s", c.lookups[in.arg()].name)
+		case opPop, opReturn:
+		case opVector:
+			fmt.Fprintf(w, " %d", len(c.consts[in.arg()].(*ast.Node).List()))
+		default:
+			fmt.Fprintf(w, " %d", in.arg())
+		}
+		fmt.Fprintln(w)
+	}
+	for _, code := range c.codes {
+		code.disassemble(w)
+	}
+}
+
+func describeValue(v Value) string {
+	if n, ok := v.(*ast.Node); ok {
+		return string(ast.Encode(n))
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// frame is a running procedure, its arguments and local variables start at
+// bp and the procedure is right below them.
+type frame struct {
+	code *code
+	proc *Procedure
+	env  *Env
+	pc   int
+	bp   int
+}
+
+// machine runs compiled code, it holds the limits of the run and the stack
+// shared by every procedure called within it.
+type machine struct {
+	Options
+
+	ctx     context.Context
+	allowed map[string]bool
+
+	// limited is false when no limit is set, so the limits are not checked
+	limited bool
+
+	steps       int
+	allocations int
+
+	stack  []Value
+	frames []frame
+}
+
+func (o Options) machine(ctx context.Context) *machine {
+	m := &machine{
+		Options: o,
+		ctx:     ctx,
+		stack:   make([]Value, 0, 256),
+	}
+	if o.Allow != nil {
+		m.allowed = make(map[string]bool, len(o.Allow))
+		for _, name := range o.Allow {
+			m.allowed[name] = true
+		}
+	}
+	if ctx != nil && ctx.Done() == nil {
+		m.ctx = nil
+	}
+	m.limited = o.MaxSteps > 0 || o.MaxAllocations > 0 || m.ctx != nil
+	return m
+}
+
+// run runs the top level of a program
+func (m *machine) run(c *code, env *Env) (Value, error) {
+	m.stack = append(m.stack, nil)
+	base := len(m.frames)
+	m.frames = append(m.frames, frame{code: c, env: env, bp: len(m.stack)})
+	m.stack = append(m.stack, make([]Value, c.slots)...)
+	return m.loop(base)
+}
+
+// apply calls a closure from Go
+func (m *machine) apply(p *Procedure, args []Value) (Value, error) {
+	m.stack = append(m.stack, p)
+	m.stack = append(m.stack, args...)
+	base := len(m.frames)
+	if err := m.enter(p, len(args), false); err != nil {
+		m.stack = m.stack[:len(m.stack)-len(args)-1]
+		return nil, err
+	}
+	return m.loop(base)
+}
+
+// step counts the run of an instruction and checks the limits that depend on
+// the number of steps
+func (m *machine) step(n *ast.Node) error {
+	m.steps++
+	if m.MaxSteps > 0 && m.steps > m.MaxSteps {
+		return errorAt(n, fmt.Errorf("%w: %d steps", ErrStepLimit, m.MaxSteps))
+	}
+	if m.ctx != nil {
+		select {
+		case <-m.ctx.Done():
+			return errorAt(n, m.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// allocate counts the creation of nodes, frames or closures
+func (m *machine) allocate(count int) error {
+	m.allocations += count
+	if m.MaxAllocations > 0 && m.allocations > m.MaxAllocations {
+		return fmt.Errorf("%w: %d allocations", ErrAllocationLimit, m.MaxAllocations)
+	}
+	return nil
+}
+
+func (m *machine) push(v Value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *machine) pop() Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// loop runs instructions until the frame at base returns
+func (m *machine) loop(base int) (Value, error) {
+	v, err := m.exec(base)
+	if err != nil {
+		// drop the frames of the failed run, so the machine can be used
+		// again by the closures it created
+		m.stack = m.stack[:m.frames[base].bp-1]
+		m.frames = m.frames[:base]
+	}
+	return v, err
+}
+
+func (m *machine) exec(base int) (Value, error) {
+	f := &m.frames[len(m.frames)-1]
+	for {
+		in := f.code.instrs[f.pc]
+		f.pc++
+
+		if m.limited {
+			if err := m.step(f.code.nodes[f.pc-1]); err != nil {
+				return nil, err
+			}
+		}
+
+		switch in.op() {
+		case opConst:
+			m.push(f.code.consts[in.arg()])
+
+		case opGlobal:
+			name := f.code.names[in.arg()]
+			v, ok := f.env.Lookup(name)
+			if !ok {
+				return nil, errorAt(f.code.nodes[f.pc-1], fmt.Errorf("%w: %s", ErrUnboundSymbol, name))
+			}
+			m.push(v)
+
+		case opLocal:
+			v := m.stack[f.bp+in.arg()]
+			if c, ok := v.(*cell); ok {
+				v = c.v
+			}
+			if v == nil {
+				return nil, m.unbound(f)
+			}
+			m.push(v)
+
+		case opFree:
+			v := f.proc.free[in.arg()].v
+			if v == nil {
+				return nil, m.unbound(f)
+			}
+			m.push(v)
+
+		case opLookup:
+			v, err := m.lookup(f, &f.code.lookups[in.arg()])
+			if err != nil {
+				return nil, err
+			}
+			m.push(v)
+
+		case opInit:
+			m.stack[f.bp+in.arg()] = m.pop()
+
+		case opDefine:
+			v := m.pop()
+			name := f.code.names[in.arg()]
+			nameProcedure(v, name)
+			f.env.Define(name, v)
+
+		case opDefineLocal:
+			v := m.pop()
+			nameProcedure(v, f.code.nodes[f.pc-1])
+			slot := f.bp + in.arg()
+			if c, ok := m.stack[slot].(*cell); ok {
+				c.v = v
+			} else {
+				m.stack[slot] = v
+			}
+
+		case opPop:
+			m.stack = m.stack[:len(m.stack)-1]
+
+		case opJump:
+			f.pc = in.arg()
+
+		case opJumpIfFalse:
+			if !Truthy(m.pop()) {
+				f.pc = in.arg()
+			}
+
+		case opCall, opTailCall:
+			n := in.arg()
+			call := f.code.nodes[f.pc-1]
+			p, ok := m.stack[len(m.stack)-n-1].(*Procedure)
+			if !ok {
+				head := call.List()[0]
+				return nil, errorAt(head, fmt.Errorf("%w: %s", ErrNotProcedure, ast.Encode(head)))
+			}
+			if p.code == nil {
+				v, err := m.callFunc(p, n, call)
+				if err != nil {
+					return nil, err
+				}
+				m.push(v)
+				if in.op() == opTailCall {
+					if v, done := m.ret(base); done {
+						return v, nil
+					}
+				}
+				// Go functions can run closures on the same machine
+				f = &m.frames[len(m.frames)-1]
+				continue
+			}
+			if err := m.enter(p, n, in.op() == opTailCall); err != nil {
+				return nil, errorAt(call, err)
+			}
+			f = &m.frames[len(m.frames)-1]
+
+		case opReturn:
+			if v, done := m.ret(base); done {
+				return v, nil
+			}
+			f = &m.frames[len(m.frames)-1]
+
+		case opClosure:
+			c := f.code.codes[in.arg()]
+			p := &Procedure{Name: c.name, code: c, env: f.env, vm: m}
+			p.free = make([]*cell, len(c.captures))
+			for i, capture := range c.captures {
+				if !capture.local {
+					p.free[i] = f.proc.free[capture.index]
+					continue
+				}
+				slot := f.bp + capture.index
+				v, ok := m.stack[slot].(*cell)
+				if !ok {
+					v = &cell{v: m.stack[slot]}
+					m.stack[slot] = v
+				}
+				p.free[i] = v
+			}
+			if m.limited {
+				if err := m.allocate(1); err != nil {
+					return nil, errorAt(f.code.nodes[f.pc-1], err)
+				}
+			}
+			m.push(p)
+
+		case opAllowed:
+			if name := f.code.names[in.arg()]; m.allowed != nil && !m.allowed[name] {
+				call := f.code.nodes[f.pc-1]
+				return nil, errorAt(call.List()[0], fmt.Errorf("%w: %s", ErrNotAllowed, name))
+			}
+
+		case opVector:
+			n := f.code.consts[in.arg()].(*ast.Node)
+			count := len(n.List())
+			values := m.stack[len(m.stack)-count:]
+			vector := newVector(n)
+			if err := pushValues(vector, values); err != nil {
+				return nil, errorAt(n, err)
+			}
+			m.stack = m.stack[:len(m.stack)-count]
+			if m.MaxAllocations > 0 {
+				if err := m.allocate(size(vector)); err != nil {
+					return nil, errorAt(n, err)
+				}
+			}
+			m.push(vector)
+		}
+	}
+}
+
+func (m *machine) lookup(f *frame, l *lookup) (Value, error) {
+	for _, r := range l.refs {
+		var v Value
+		if r.local {
+			v = m.stack[f.bp+r.index]
+			if c, ok := v.(*cell); ok {
+				v = c.v
+			}
+		} else {
+			v = f.proc.free[r.index].v
+		}
+		if v != nil {
+			return v, nil
+		}
+	}
+	if l.global {
+		if v, ok := f.env.Lookup(l.name); ok {
+			return v, nil
+		}
+	}
+	return nil, m.unbound(f)
+}
+
+func (m *machine) unbound(f *frame) error {
+	n := f.code.nodes[f.pc-1]
+	return errorAt(n, fmt.Errorf("%w: %s", ErrUnboundSymbol, n.Value().(string)))
+}
+
+// nameProcedure gives a name to the anonymous procedures bound by define
+func nameProcedure(v Value, name interface{}) {
+	p, ok := v.(*Procedure)
+	if !ok || p.Name != "" {
+		return
+	}
+	switch name := name.(type) {
+	case string:
+		p.Name = name
+	case *ast.Node:
+		if target, ok := definedName(name); ok {
+			p.Name = target
+		}
+	}
+}
+
+// ret returns from the current frame, it pushes the result of the procedure
+// in place of it, or returns it when the frame is the one at base
+func (m *machine) ret(base int) (Value, bool) {
+	f := &m.frames[len(m.frames)-1]
+	v := m.pop()
+	m.stack = m.stack[:f.bp-1]
+	m.frames = m.frames[:len(m.frames)-1]
+	if len(m.frames) == base {
+		return v, true
+	}
+	m.push(v)
+	return nil, false
+}
+
+// callFunc calls a procedure that was not compiled with the n values on top
+// of the stack and removes the procedure and its arguments from the stack
+func (m *machine) callFunc(p *Procedure, n int, call *ast.Node) (Value, error) {
+	args := make([]Value, n)
+	copy(args, m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:len(m.stack)-n-1]
+
+	if p.fn == nil {
+		v, err := Apply(p, args)
+		if err != nil {
+			return nil, errorAt(call, err)
+		}
+		return v, nil
+	}
+
+	if m.allowed != nil && !m.allowed[p.Name] {
+		return nil, errorAt(call.List()[0], fmt.Errorf("%w: %s", ErrNotAllowed, p.Name))
+	}
+	v, err := p.fn(args)
+	if err != nil {
+		var evalErr *Error
+		if errors.As(err, &evalErr) {
+			return nil, err
+		}
+		return nil, errorAt(call, fmt.Errorf("%s: %w", p.Name, err))
+	}
+	if m.MaxAllocations > 0 {
+		if err := m.allocate(size(v)); err != nil {
+			return nil, errorAt(call, err)
+		}
+	}
+	return v, nil
+}
+
+// enter starts running a compiled procedure with the n values on top of the
+// stack as arguments, a tail call replaces the current frame
+func (m *machine) enter(p *Procedure, n int, tail bool) error {
+	c := p.code
+	if n < c.params || (!c.rest && n > c.params) {
+		if c.rest {
+			return fmt.Errorf("%w: %v expects at least %d arguments, got %d", ErrArity, p, c.params, n)
+		}
+		return fmt.Errorf("%w: %v expects %d arguments, got %d", ErrArity, p, c.params, n)
+	}
+
+	count := 1
+	if c.rest {
+		rest, err := newList(m.stack[len(m.stack)-n+c.params:])
+		if err != nil {
+			return err
+		}
+		m.stack = m.stack[:len(m.stack)-n+c.params]
+		m.push(rest)
+		n = c.params + 1
+		count += len(rest.List()) + 1
+	}
+	if m.limited {
+		if err := m.allocate(count); err != nil {
+			return err
+		}
+	}
+
+	bp := len(m.stack) - n
+	if tail {
+		f := &m.frames[len(m.frames)-1]
+		copy(m.stack[f.bp-1:], m.stack[bp-1:])
+		m.stack = m.stack[:f.bp+n]
+		bp = f.bp
+		m.frames = m.frames[:len(m.frames)-1]
+	} else if m.MaxDepth > 0 && len(m.frames) >= m.MaxDepth {
+		return fmt.Errorf("%w: %d nested calls", ErrDepthLimit, m.MaxDepth)
+	}
+
+	for i := n; i < c.slots; i++ {
+		m.push(nil)
+	}
+	m.frames = append(m.frames, frame{code: c, proc: p, env: p.env, bp: bp})
+	return nil
+}
@@ -0,0 +1,304 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func run(src string, env *Env) (Value, error) {
+	p, err := CompileSource([]byte(src), env)
+	if err != nil {
+		return nil, err
+	}
+	return p.Run()
+}
+
+func TestCompile(t *testing.T) {
+	for _, tc := range evalTests {
+		v, err := run(tc.In, DefaultEnv())
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, encode(v), tc.In)
+		}
+	}
+
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		// procedures defined in a body can refer to the ones after them
+		{`
+			(define (parity n)
+			  (define (even? n) (if (= n 0) true (odd? (- n 1))))
+			  (define (odd? n) (if (= n 0) false (even? (- n 1))))
+			  (if (even? n) :even :odd))
+			(list (parity 10) (parity 7))
+		`, `[:even :odd]`},
+		// closures share the variables they capture
+		{`
+			(define (make)
+			  (define n 1)
+			  (define (get) n)
+			  (define n 2)
+			  (get))
+			(make)
+		`, `2`},
+		{`
+			(define (adder a)
+			  (lambda (b) (lambda (c) (+ a b c))))
+			(((adder 1) 10) 100)
+		`, `111`},
+		{`(let ((a 1) (a 2)) a)`, `2`},
+		{`(let ((f (lambda (x) x))) (let ((g (lambda () (f 3)))) (g)))`, `3`},
+		{`(define x 1) (let ((x 2)) (define x 3) x)`, `3`},
+		{`(define (f . args) args) (f 1 2 3)`, `[1 2 3]`},
+		{`(define f (lambda (x) x)) f`, `#<procedure f>`},
+	}
+	for _, tc := range testCases {
+		v, err := run(tc.In, DefaultEnv())
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, encode(v), tc.In)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range errorTests {
+		_, err := run(tc.In, DefaultEnv())
+		assert.True(t, errors.Is(err, tc.Err), "%q: %v", tc.In, err)
+		if assert.Error(t, err, tc.In) {
+			assert.Equal(t, tc.Msg, err.Error(), tc.In)
+		}
+	}
+
+	_, err := run("(define (f) (g))\n(f)", DefaultEnv())
+	assert.Equal(t, "eval: line 1, column 14: unbound symbol: g", err.Error())
+
+	_, err = run("(define (f) (define a b) (define b 1) a)\n(f)", DefaultEnv())
+	assert.Equal(t, "eval: line 1, column 23: unbound symbol: b", err.Error())
+}
+
+func TestConstantFolding(t *testing.T) {
+	p, err := CompileSource([]byte(`(if (< 1 2) (+ 1 (* 2 3)) (f))`), DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, "program:\n    0 allowed <\n    1 allowed *\n    2 allowed +\n    3 const 7\n    4 return\n", p.String())
+
+	// folded calls are checked against the allowed functions when the
+	// program runs
+	src := []byte(`(+ 1 (* 2 3))`)
+	_, err = Options{Allow: []string{"+"}}.EvalSource(context.Background(), src, DefaultEnv())
+	assert.Equal(t, "eval: line 1, column 7: function not allowed: *", err.Error())
+	p, err = CompileSource(src, DefaultEnv())
+	assert.NoError(t, err)
+	_, err = Options{Allow: []string{"+"}}.Run(context.Background(), p)
+	assert.True(t, errors.Is(err, ErrNotAllowed))
+	assert.Equal(t, "eval: line 1, column 7: function not allowed: *", err.Error())
+	v, err := Options{Allow: []string{"+", "*"}}.Run(context.Background(), p)
+	assert.NoError(t, err)
+	assert.Equal(t, `7`, encode(v))
+
+	// limits keep the position of folded forms
+	for steps := 1; steps <= 3; steps++ {
+		_, err = Options{MaxSteps: steps}.Run(context.Background(), p)
+		assert.True(t, errors.Is(err, ErrStepLimit))
+		assert.Regexp(t, `^eval: line 1, column \d+: step limit exceeded`, err.Error())
+	}
+
+	// errors are reported when the program runs
+	p, err = CompileSource([]byte(`(/ 1 0)`), DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, "program:\n    0 global /\n    1 const 1\n    2 const 0\n    3 tail-call 2\n    4 return\n", p.String())
+
+	// symbols bound by the program are not folded
+	v, err = run(`(define (+ a b) (- a b)) (+ 5 3)`, DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, `2`, encode(v))
+
+	v, err = run(`(let ((* -)) (* 5 3))`, DefaultEnv())
+	assert.NoError(t, err)
+	assert.Equal(t, `2`, encode(v))
+}
+
+func TestCompiledProcedures(t *testing.T) {
+	env := DefaultEnv()
+	env.DefineFunc("map", func(args []Value) (Value, error) {
+		p := args[0].(*Procedure)
+		values := []Value{}
+		for _, item := range args[1].(*ast.Node).List() {
+			v, err := Apply(p, []Value{item})
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return newList(values)
+	})
+
+	v, err := run(`(define k 10) (map (lambda (x) (map (lambda (y) (+ x y k)) [1 2])) [1 2])`, env)
+	assert.NoError(t, err)
+	assert.Equal(t, `[[12 13] [13 14]]`, encode(v))
+
+	// rules can be compiled once and applied many times
+	v, err = run(`(lambda (age) (if (>= age 18) :adult :minor))`, env)
+	assert.NoError(t, err)
+	rule := v.(*Procedure)
+	for age, want := range map[int64]string{10: ":minor", 30: ":adult"} {
+		v, err := Apply(rule, []Value{ast.NewNode(nil, ast.NewIntValue(age))})
+		assert.NoError(t, err)
+		assert.Equal(t, want, encode(v))
+	}
+	_, err = Apply(rule, nil)
+	assert.True(t, errors.Is(err, ErrArity))
+
+	// compiled procedures can be called by Eval and the other way around
+	_, err = EvalSource([]byte(`(define (twice x) (* 2 x))`), env)
+	assert.NoError(t, err)
+	v, err = run(`(define (inc x) (+ x 1)) (twice 4)`, env)
+	assert.NoError(t, err)
+	assert.Equal(t, `8`, encode(v))
+	v, err = EvalSource([]byte(`(inc (twice 2))`), env)
+	assert.NoError(t, err)
+	assert.Equal(t, `5`, encode(v))
+
+	// the machine can be used after an error
+	_, err = run(`(map (lambda (x) (/ x 0)) [1])`, env)
+	assert.Equal(t, "eval: line 1, column 18: /: division by zero", err.Error())
+	v, err = Apply(rule, []Value{ast.NewNode(nil, ast.NewIntValue(20))})
+	assert.NoError(t, err)
+	assert.Equal(t, `:adult`, encode(v))
+}
+
+func TestCompiledTailCalls(t *testing.T) {
+	src := `
+		(define (loop n acc)
+		  (if (= n 0)
+		    acc
+		    (loop (- n 1) (+ acc 1))))
+		(define (even? n) (if (= n 0) true (odd? (- n 1))))
+		(define (odd? n) (if (= n 0) false (even? (- n 1))))
+		(list (loop 100000 0) (even? 100001))
+	`
+	p, err := CompileSource([]byte(src), DefaultEnv())
+	assert.NoError(t, err)
+	v, err := Options{MaxDepth: 10}.Run(context.Background(), p)
+	assert.NoError(t, err)
+	assert.Equal(t, `[100000 false]`, encode(v))
+}
+
+func TestCompiledLimits(t *testing.T) {
+	deep := `
+		(define (count n)
+		  (if (= n 0) 0 (+ 1 (count (- n 1)))))
+		(count 1000)
+	`
+	testCases := []struct {
+		Options Options
+		In      string
+		Err     error
+		Msg     string
+	}{
+		{Options{MaxSteps: 100}, deep, ErrStepLimit, "eval: line 3, column 14: step limit exceeded: 100 steps"},
+		{Options{MaxDepth: 100}, deep, ErrDepthLimit, "eval: line 3, column 24: depth limit exceeded: 100 nested calls"},
+		{Options{MaxAllocations: 10}, deep, ErrAllocationLimit, "eval: line 3, column 24: allocation limit exceeded: 10 allocations"},
+		{Options{Allow: []string{"+"}}, deep, ErrNotAllowed, "eval: line 3, column 10: function not allowed: ="},
+	}
+
+	for _, tc := range testCases {
+		p, err := CompileSource([]byte(tc.In), DefaultEnv())
+		assert.NoError(t, err)
+		_, err = tc.Options.Run(context.Background(), p)
+		assert.True(t, errors.Is(err, tc.Err), "%v: %v", tc.Options, err)
+		if assert.Error(t, err) {
+			assert.Equal(t, tc.Msg, err.Error())
+		}
+	}
+
+	p, err := CompileSource([]byte(`(define (loop) (loop)) (loop)`), DefaultEnv())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = DefaultOptions.Run(ctx, p)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+const benchmarkSource = `
+	(define (fib n)
+	  (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+	(fib 20)
+`
+
+const ruleSource = `
+	(lambda (age score country)
+	  (if (>= age 18)
+	    (if (= country "NL") (> score (* 10 (+ 5 2))) (> score 50))
+	    false))
+`
+
+func BenchmarkEvalFib(b *testing.B) {
+	root := parse(b, benchmarkSource)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := EvalDocument(root, DefaultEnv()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRunFib(b *testing.B) {
+	p, err := CompileDocument(parse(b, benchmarkSource), DefaultEnv())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkApply(b *testing.B, rule Value) {
+	args := []Value{
+		ast.NewNode(nil, ast.NewIntValue(30)),
+		ast.NewNode(nil, ast.NewIntValue(80)),
+		ast.NewNode(nil, ast.NewStringValue("NL")),
+	}
+	p := rule.(*Procedure)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Apply(p, args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvalRule(b *testing.B) {
+	v, err := EvalSource([]byte(ruleSource), DefaultEnv())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkApply(b, v)
+}
+
+func BenchmarkRunRule(b *testing.B) {
+	v, err := run(ruleSource, DefaultEnv())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkApply(b, v)
+}
+
+func parse(tb testing.TB, src string) *ast.Node {
+	p := parser.NewParser(bytes.NewReader([]byte(src)))
+	p.SetOptions(ParserOptions())
+	if err := p.Parse(); err != nil {
+		tb.Fatal(err)
+	}
+	return p.RootNode()
+}
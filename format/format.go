@@ -0,0 +1,467 @@
+// Package format implements canonical formatting of S-expression sources.
+//
+// The formatter reads the input with the parser, keeps track of the comments
+// found along the way and prints the resulting tree following a fixed set of
+// rules: forms that fit in a line are printed flat, forms that don't are
+// broken and indented according to their head symbol, comments are kept next
+// to the node they were written along and consecutive blank lines are
+// collapsed into one.
+package format
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+	"github.com/xiam/s-expr/parser"
+)
+
+// IndentWidth is the number of spaces used to indent the body of a form
+const IndentWidth = 2
+
+// Options represents the settings of the formatter
+type Options struct {
+	// Width is the column limit after which forms are broken into
+	// several lines.
+	Width int
+
+	// Rules maps the head symbol of an expression to the number of arguments
+	// that are kept in the same line as the head when the expression is
+	// broken, the rest of the arguments (the body) are indented by
+	// IndentWidth. Expressions with a head that is not in this map are
+	// considered function calls and their arguments are aligned with the
+	// first one.
+	Rules map[string]int
+}
+
+// DefaultRules holds the indentation rules for common forms
+var DefaultRules = map[string]int{
+	"begin":         0,
+	"case":          1,
+	"cond":          0,
+	"def":           1,
+	"define":        1,
+	"define-syntax": 1,
+	"defmacro":      2,
+	"defn":          2,
+	"do":            0,
+	"fn":            1,
+	"for":           1,
+	"if":            1,
+	"lambda":        1,
+	"let":           1,
+	"let*":          1,
+	"letrec":        1,
+	"loop":          0,
+	"progn":         0,
+	"set":           1,
+	"syntax-rules":  1,
+	"unless":        1,
+	"when":          1,
+	"while":         1,
+}
+
+// DefaultOptions are the options used by Source and Node
+var DefaultOptions = Options{
+	Width: 80,
+	Rules: DefaultRules,
+}
+
+// Source formats the given source using DefaultOptions
+func Source(src []byte) ([]byte, error) {
+	return DefaultOptions.Source(src)
+}
+
+// Node formats a tree using DefaultOptions
+func Node(root *ast.Node) []byte {
+	return DefaultOptions.Node(root)
+}
+
+// Source parses and formats the given source, comments are preserved. The
+// result always ends with a newline, unless the input has no forms nor
+// comments.
+func (o Options) Source(src []byte) ([]byte, error) {
+	p := parser.NewParser(bytes.NewReader(src))
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+
+	pr := newPrinter(o)
+	pr.assignComments(p.RootNode(), p.Comments())
+
+	return pr.document(p.RootNode()), nil
+}
+
+// Node formats a tree that may not come from a source file, the given node is
+// considered the root of the document.
+func (o Options) Node(root *ast.Node) []byte {
+	pr := newPrinter(o)
+	return pr.document(root)
+}
+
+type item struct {
+	node    *ast.Node
+	comment *lexer.Token
+}
+
+func (it item) text() string {
+	if it.comment != nil {
+		return strings.TrimRight(it.comment.Text(), " \t\r")
+	}
+	return leafText(it.node)
+}
+
+func (it item) start() (int, int) {
+	if it.comment != nil {
+		pos := it.comment.Pos()
+		return pos.Line, pos.Column
+	}
+	return nodeStart(it.node)
+}
+
+func (it item) endLine() int {
+	if it.comment != nil {
+		return it.comment.Pos().Line
+	}
+	return nodeEndLine(it.node)
+}
+
+type printer struct {
+	Options
+
+	buf bytes.Buffer
+	col int
+
+	comments map[*ast.Node][]*lexer.Token
+}
+
+func newPrinter(o Options) *printer {
+	if o.Width <= 0 {
+		o.Width = DefaultOptions.Width
+	}
+	if o.Rules == nil {
+		o.Rules = DefaultRules
+	}
+	return &printer{
+		Options:  o,
+		comments: map[*ast.Node][]*lexer.Token{},
+	}
+}
+
+// assignComments attaches each comment to the innermost vector node that
+// contains it.
+func (pr *printer) assignComments(n *ast.Node, comments []*lexer.Token) {
+	if len(comments) == 0 {
+		return
+	}
+	for _, child := range n.List() {
+		if !child.IsVector() || child.Token() == nil || child.EndToken() == nil {
+			continue
+		}
+		inner, outer := []*lexer.Token{}, []*lexer.Token{}
+		for _, c := range comments {
+			if contains(child, c) {
+				inner = append(inner, c)
+			} else {
+				outer = append(outer, c)
+			}
+		}
+		pr.assignComments(child, inner)
+		comments = outer
+	}
+	pr.comments[n] = comments
+}
+
+// items returns the children of the node interleaved with its comments
+func (pr *printer) items(n *ast.Node) []item {
+	comments := pr.comments[n]
+	children := n.List()
+
+	items := make([]item, 0, len(children)+len(comments))
+	for _, child := range children {
+		for len(comments) > 0 && child.Token() != nil && before(comments[0], child) {
+			items = append(items, item{comment: comments[0]})
+			comments = comments[1:]
+		}
+		items = append(items, item{node: child})
+	}
+	for _, c := range comments {
+		items = append(items, item{comment: c})
+	}
+	return items
+}
+
+func (pr *printer) write(s string) {
+	pr.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		pr.col = utf8.RuneCountInString(s[i+1:])
+		return
+	}
+	pr.col += utf8.RuneCountInString(s)
+}
+
+func (pr *printer) newline(blank bool, indent int) {
+	if blank {
+		pr.buf.WriteByte('\n')
+	}
+	pr.write("\n" + strings.Repeat(" ", indent))
+}
+
+func (pr *printer) document(root *ast.Node) []byte {
+	if root == nil {
+		return nil
+	}
+
+	items := pr.items(root)
+	for i, it := range items {
+		if i > 0 {
+			prev := items[i-1]
+			line, _ := it.start()
+			switch {
+			case line > 0 && line == prev.endLine():
+				pr.write(" ")
+			default:
+				pr.newline(blankBetween(prev, it), 0)
+			}
+		}
+		pr.item(it)
+	}
+	if len(items) > 0 {
+		pr.write("\n")
+	}
+
+	return pr.buf.Bytes()
+}
+
+func (pr *printer) item(it item) {
+	if it.comment != nil {
+		pr.write(it.text())
+		return
+	}
+	pr.node(it.node)
+}
+
+func (pr *printer) node(n *ast.Node) {
+	if !n.IsVector() {
+		pr.write(leafText(n))
+		return
+	}
+
+	if w := pr.flatWidth(n); w >= 0 && pr.col+w <= pr.Width {
+		pr.write(pr.flat(n))
+		return
+	}
+
+	pr.broken(n)
+}
+
+// broken prints a vector node in multiple lines
+func (pr *printer) broken(n *ast.Node) {
+	open, close := delimiters(n)
+
+	start := pr.col
+	pr.write(open)
+
+	items := pr.items(n)
+
+	headCount, bodyIndent := 1, start+1
+	if n.Type() == ast.NodeTypeExpression && len(items) > 0 && items[0].node != nil && items[0].node.Type() == ast.NodeTypeSymbol {
+		head := items[0].text()
+		if args, ok := pr.Rules[head]; ok {
+			headCount, bodyIndent = 1+args, start+IndentWidth
+		} else {
+			headCount, bodyIndent = 2, start+1+utf8.RuneCountInString(head)+1
+		}
+	}
+
+	values := 0
+	for i, it := range items {
+		if i > 0 {
+			prev := items[i-1]
+			line, _ := it.start()
+
+			sameLine := false
+			switch {
+			case it.comment != nil:
+				sameLine = prev.comment == nil && line == prev.endLine()
+			case prev.comment != nil:
+				sameLine = false
+			case n.Type() == ast.NodeTypeMap:
+				sameLine = values%2 == 1
+			case n.Type() == ast.NodeTypeTagged:
+				sameLine = true
+			default:
+				sameLine = i < headCount
+			}
+
+			if sameLine {
+				pr.write(" ")
+			} else {
+				pr.newline(blankBetween(prev, it), bodyIndent)
+			}
+		}
+		pr.item(it)
+		if it.node != nil {
+			values++
+		}
+	}
+
+	// the tail of a dotted expression follows the last child
+	if tail := n.Tail(); tail != nil {
+		if len(items) > 0 && items[len(items)-1].comment != nil {
+			pr.newline(false, bodyIndent)
+		} else {
+			pr.write(" ")
+		}
+		pr.write(". ")
+		pr.node(tail)
+	}
+
+	if len(items) > 0 && items[len(items)-1].comment != nil && n.Tail() == nil {
+		pr.newline(false, start)
+	}
+	pr.write(close)
+}
+
+// flatWidth returns the width of the node when printed in a single line, or
+// -1 if the node can't be printed in a single line.
+func (pr *printer) flatWidth(n *ast.Node) int {
+	if !n.IsVector() {
+		text := leafText(n)
+		if strings.ContainsRune(text, '\n') {
+			return -1
+		}
+		return utf8.RuneCountInString(text)
+	}
+
+	if len(pr.comments[n]) > 0 {
+		return -1
+	}
+
+	open, close := delimiters(n)
+	children := n.List()
+	w := len(open) + len(close)
+	for i, child := range children {
+		cw := pr.flatWidth(child)
+		if cw < 0 {
+			return -1
+		}
+		if i > 0 {
+			w++
+		}
+		w += cw
+	}
+	if tail := n.Tail(); tail != nil {
+		tw := pr.flatWidth(tail)
+		if tw < 0 {
+			return -1
+		}
+		w += len(" . ") + tw
+	}
+	return w
+}
+
+func (pr *printer) flat(n *ast.Node) string {
+	if !n.IsVector() {
+		return leafText(n)
+	}
+
+	open, close := delimiters(n)
+	children := n.List()
+	values := make([]string, 0, len(children))
+	for _, child := range children {
+		values = append(values, pr.flat(child))
+	}
+	if tail := n.Tail(); tail != nil {
+		values = append(values, ".", pr.flat(tail))
+	}
+	return open + strings.Join(values, " ") + close
+}
+
+func delimiters(n *ast.Node) (string, string) {
+	switch n.Type() {
+	case ast.NodeTypeList:
+		return "[", "]"
+	case ast.NodeTypeMap:
+		return "{", "}"
+	case ast.NodeTypeSet:
+		return "#{", "}"
+	case ast.NodeTypeTagged:
+		return "#", ""
+	}
+	return "(", ")"
+}
+
+// leafText returns the text of a value node, as it was written in the source
+// when possible.
+func leafText(n *ast.Node) string {
+	tok := n.Token()
+	if tok == nil {
+		return string(ast.Encode(n))
+	}
+	if n.Type() == ast.NodeTypeString {
+		return `"` + tok.Text() + `"`
+	}
+	return tok.Text()
+}
+
+func nodeStart(n *ast.Node) (int, int) {
+	tok := n.Token()
+	if tok == nil {
+		return 0, 0
+	}
+	pos := tok.Pos()
+	return pos.Line, pos.Column
+}
+
+func nodeEndLine(n *ast.Node) int {
+	if n.IsVector() {
+		if end := n.EndToken(); end != nil {
+			return end.Pos().Line
+		}
+		line, _ := nodeStart(n)
+		return line
+	}
+	line, _ := nodeStart(n)
+	if line == 0 {
+		return 0
+	}
+	return line + strings.Count(leafText(n), "\n")
+}
+
+func blankBetween(prev, next item) bool {
+	line, _ := next.start()
+	end := prev.endLine()
+	if line == 0 || end == 0 {
+		return false
+	}
+	return line-end > 1
+}
+
+func comparePos(line1, col1, line2, col2 int) int {
+	switch {
+	case line1 < line2:
+		return -1
+	case line1 > line2:
+		return 1
+	case col1 < col2:
+		return -1
+	case col1 > col2:
+		return 1
+	}
+	return 0
+}
+
+func before(c *lexer.Token, n *ast.Node) bool {
+	line, col := nodeStart(n)
+	pos := c.Pos()
+	return comparePos(pos.Line, pos.Column, line, col) < 0
+}
+
+func contains(n *ast.Node, c *lexer.Token) bool {
+	start, end, pos := n.Token().Pos(), n.EndToken().Pos(), c.Pos()
+	return comparePos(start.Line, start.Column, pos.Line, pos.Column) < 0 &&
+		comparePos(pos.Line, pos.Column, end.Line, end.Column) < 0
+}
@@ -0,0 +1,138 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func TestSource(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			In:  ``,
+			Out: ``,
+		},
+		{
+			In:  "(a   b\n\n c)",
+			Out: "(a b c)\n",
+		},
+		{
+			In:  "\n\n(a)\n\n\n\n(b)\n\n",
+			Out: "(a)\n\n(b)\n",
+		},
+		{
+			In:  `set {:foo 1 :bar 2} (get :foo)`,
+			Out: "set {:foo 1 :bar 2} (get :foo)\n",
+		},
+		{
+			In:  "[1 2   +3.5 -4 \"hello\tworld\"]",
+			Out: "[1 2 +3.5 -4 \"hello\tworld\"]\n",
+		},
+		{
+			In: "(fn_a # comment\n (fn_b [89 :A :B [67 3.27]])\n\n\n (fn_c 66 3 53 \"Hello world!\"))",
+			Out: "(fn_a # comment\n" +
+				"      (fn_b [89 :A :B [67 3.27]])\n" +
+				"\n" +
+				"      (fn_c 66 3 53 \"Hello world!\"))\n",
+		},
+		{
+			In: "(defn sum [alpha beta gamma] (let [total (+ alpha beta gamma delta epsilon)] (print total)))",
+			Out: "(defn sum [alpha beta gamma]\n" +
+				"  (let [total (+ alpha beta gamma delta epsilon)] (print total)))\n",
+		},
+		{
+			In: "{:alpha \"a long string value\" :beta \"another quite long string value\" :gamma [1 2 3]}",
+			Out: "{:alpha \"a long string value\"\n" +
+				" :beta \"another quite long string value\"\n" +
+				" :gamma [1 2 3]}\n",
+		},
+		{
+			In: "[# first\n1 2 # second\n3]",
+			Out: "[# first\n" +
+				" 1\n" +
+				" 2 # second\n" +
+				" 3]\n",
+		},
+		{
+			In: "(print a # trailing\n)",
+			Out: "(print a # trailing\n" +
+				")\n",
+		},
+		{
+			In:  "# header   \n\n\n(a) # after a\n# before b\n(b)",
+			Out: "# header\n\n(a) # after a\n# before b\n(b)\n",
+		},
+	}
+
+	for i := range testCases {
+		out, err := Source([]byte(testCases[i].In))
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, string(out))
+
+		again, err := Source(out)
+		assert.NoError(t, err)
+		assert.Equal(t, string(out), string(again), "formatting must be idempotent")
+	}
+}
+
+func TestSourceWidth(t *testing.T) {
+	opts := Options{
+		Width: 20,
+		Rules: map[string]int{"when": 1},
+	}
+
+	out, err := opts.Source([]byte(`(when (ready system) (start system) (wait system))`))
+	assert.NoError(t, err)
+	assert.Equal(t, "(when (ready system)\n  (start system)\n  (wait system))\n", string(out))
+
+	out, err = opts.Source([]byte(`(call first second third)`))
+	assert.NoError(t, err)
+	assert.Equal(t, "(call first\n      second\n      third)\n", string(out))
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source([]byte(`(1 2`))
+	assert.Error(t, err)
+
+	_, err = Source([]byte(`(1 2]`))
+	assert.Error(t, err)
+}
+
+func TestNode(t *testing.T) {
+	root, err := parser.Parse([]byte(`(a [1 2] {:b "c"}) (d)`))
+	assert.NoError(t, err)
+
+	out := Node(root)
+	assert.Equal(t, "(a [1 2] {:b \"c\"}) (d)\n", string(out))
+
+	root = ast.NewList(nil)
+	expr, err := root.PushExpression(nil)
+	assert.NoError(t, err)
+	_, err = expr.PushValue(nil, ast.NewSymbolValue("print"))
+	assert.NoError(t, err)
+	_, err = expr.PushValue(nil, ast.NewStringValue(strings.Repeat("x", 10)))
+	assert.NoError(t, err)
+	_, err = root.PushList(nil)
+	assert.NoError(t, err)
+
+	out = Node(root)
+	assert.Equal(t, "(print \"xxxxxxxxxx\")\n[]\n", string(out))
+	tail := ast.NewList(nil)
+	_, _ = tail.PushValue(nil, ast.NewIntValue(1))
+	_, _ = tail.PushValue(nil, ast.NewIntValue(2))
+	pair := ast.NewPair(nil, ast.NewNode(nil, ast.NewSymbolValue("key")), tail)
+	root = ast.NewList(nil)
+	assert.NoError(t, root.Push(pair))
+
+	out = Node(root)
+	assert.Equal(t, "(key . [1 2])\n", string(out))
+
+	out = Options{Width: 8}.Node(root)
+	assert.Equal(t, "(key . [1\n        2])\n", string(out))
+}
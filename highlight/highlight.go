@@ -0,0 +1,326 @@
+// Package highlight classifies the text of S-expression sources for syntax
+// highlighting and renders it for terminals, HTML pages and language servers.
+//
+// The source is read with the parser, the role of each piece of text is taken
+// from the resulting tree: symbols at the head of expressions are told apart
+// from other symbols and brackets carry the depth at which they are nested.
+// Sources that end within a form are highlighted as if the form was closed,
+// the tokens that make a source invalid are classified as ClassInvalid.
+package highlight
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Class is the role of a piece of text
+type Class uint8
+
+// List of classes
+const (
+	ClassText    Class = iota // Whitespace and the text that has no other class
+	ClassComment              // Comments: "; text", "#| text |#"
+	ClassString               // Strings, including their quotes: "\"text\""
+	ClassChar                 // Characters: "#\a", "\a"
+	ClassNumber               // Integers, floats and bit vectors: "12", "1.5", "#x1f"
+	ClassLiteral              // Booleans and nil: "#t", "true", "nil"
+	ClassAtom                 // Atoms: ":name"
+	ClassSymbol               // Symbols: "name"
+	ClassHead                 // Symbols at the head of an expression: "(name ...)"
+	ClassTag                  // Tags of tagged elements: "#inst"
+	ClassQuote                // Quote reader macros: "'", "`", ",", ",@"
+	ClassBracket              // Delimiters of vectors: "(", "#{", "]"
+	ClassInvalid              // Tokens that make the source invalid
+)
+
+var classNames = map[Class]string{
+	ClassText:    "text",
+	ClassComment: "comment",
+	ClassString:  "string",
+	ClassChar:    "char",
+	ClassNumber:  "number",
+	ClassLiteral: "literal",
+	ClassAtom:    "atom",
+	ClassSymbol:  "symbol",
+	ClassHead:    "head",
+	ClassTag:     "tag",
+	ClassQuote:   "quote",
+	ClassBracket: "bracket",
+	ClassInvalid: "invalid",
+}
+
+func (c Class) String() string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return classNames[ClassText]
+}
+
+// valueClasses maps the types of value nodes to their classes
+var valueClasses = map[ast.NodeType]Class{
+	ast.NodeTypeString:    ClassString,
+	ast.NodeTypeChar:      ClassChar,
+	ast.NodeTypeInt:       ClassNumber,
+	ast.NodeTypeFloat:     ClassNumber,
+	ast.NodeTypeBitVector: ClassNumber,
+	ast.NodeTypeBool:      ClassLiteral,
+	ast.NodeTypeNil:       ClassLiteral,
+	ast.NodeTypeAtom:      ClassAtom,
+	ast.NodeTypeSymbol:    ClassSymbol,
+}
+
+// Span is a piece of text with a class, from its Start to its End byte
+// offsets
+type Span struct {
+	Start int
+	End   int
+	Class Class
+
+	// Depth is the number of vectors that contain a bracket, the brackets of
+	// top-level forms have a depth of 0
+	Depth int
+}
+
+// Options represents the settings of the highlighter and its renderers
+type Options struct {
+	// Parser holds the options used to read the source
+	Parser parser.ParserOptions
+
+	// Colors maps classes to the SGR parameters ANSI uses to render them,
+	// like "32" for green or "1;34" for bold blue, text of classes that are
+	// not in the map is written as it is
+	Colors map[Class]string
+
+	// BracketColors holds the SGR parameters of brackets, ANSI renders a
+	// bracket of depth d with BracketColors[d%len(BracketColors)]
+	BracketColors []string
+
+	// ClassPrefix is prepended to the names of the CSS classes HTML writes
+	ClassPrefix string
+
+	// Depths is the number of depth classes HTML cycles through, a bracket
+	// of depth d gets the depth-(d%Depths) class
+	Depths int
+}
+
+// DefaultColors holds the SGR parameters of each class
+var DefaultColors = map[Class]string{
+	ClassComment: "90",
+	ClassString:  "32",
+	ClassChar:    "32",
+	ClassNumber:  "36",
+	ClassLiteral: "35",
+	ClassAtom:    "33",
+	ClassHead:    "1;34",
+	ClassTag:     "35",
+	ClassQuote:   "33",
+	ClassInvalid: "4;31",
+}
+
+// DefaultBracketColors holds the SGR parameters of brackets
+var DefaultBracketColors = []string{"31", "33", "32", "36", "34", "35"}
+
+// DefaultOptions are the options used by the package functions
+var DefaultOptions = Options{
+	Colors:        DefaultColors,
+	BracketColors: DefaultBracketColors,
+	ClassPrefix:   "sexpr-",
+	Depths:        len(DefaultBracketColors),
+}
+
+// Spans classifies a source using DefaultOptions
+func Spans(src []byte) []Span {
+	return DefaultOptions.Spans(src)
+}
+
+// Spans classifies the text of a source, the spans are sorted and cover the
+// whole source. Forms that are discarded with datum comments, like #;(a b),
+// are classified as ClassText.
+func (o Options) Spans(src []byte) []Span {
+	h := &highlighter{src: src, lines: []int{0}}
+	for i, c := range src {
+		if c == '\n' {
+			h.lines = append(h.lines, i+1)
+		}
+	}
+
+	options := o.Parser
+	options.AutoCloseOnEOF = true
+	p := parser.NewParser(bytes.NewReader(src))
+	p.SetOptions(options)
+	err := p.Parse()
+
+	// the tree holds the forms that were read before an error
+	if root := p.RootNode(); root != nil {
+		for _, n := range root.List() {
+			h.node(n, 0)
+		}
+	}
+	for _, tok := range p.Comments() {
+		h.token(tok, ClassComment, 0)
+	}
+
+	var syntaxErr *parser.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Line > 0 {
+		pos := scanner.Position{Line: syntaxErr.Line, Column: syntaxErr.Column}
+		h.token(lexer.NewToken(lexer.TokenInvalid, syntaxErr.Text, &pos), ClassInvalid, 0)
+	}
+
+	return h.result()
+}
+
+type highlighter struct {
+	src []byte
+
+	// lines holds the offset of the beginning of each line
+	lines []int
+	spans []Span
+}
+
+func (h *highlighter) add(start, end int, class Class, depth int) {
+	if start < end {
+		h.spans = append(h.spans, Span{Start: start, End: end, Class: class, Depth: depth})
+	}
+}
+
+// offset returns the offset of a token, tokens that were not read from the
+// source, like the quote symbol of 'x, are not found
+func (h *highlighter) offset(tok *lexer.Token) (int, bool) {
+	pos := tok.Pos()
+	if pos.Line < 1 || pos.Line > len(h.lines) {
+		return 0, false
+	}
+	offset := h.lines[pos.Line-1]
+	if pos.Column == 0 && pos.Line > 1 {
+		// like text/scanner, the lexer gives the newline that ends a line the
+		// column 0 of the next one
+		offset--
+	}
+	for col := 1; col < pos.Column && offset < len(h.src); col++ {
+		_, size := utf8.DecodeRune(h.src[offset:])
+		offset += size
+	}
+	return offset, bytes.HasPrefix(h.src[offset:], []byte(tok.Text()))
+}
+
+// token adds the span of the text of a token
+func (h *highlighter) token(tok *lexer.Token, class Class, depth int) {
+	if start, ok := h.offset(tok); ok {
+		h.add(start, start+len(tok.Text()), class, depth)
+	}
+}
+
+// bracket adds the span of a delimiter, the # of #{ and #( is part of it
+func (h *highlighter) bracket(tok *lexer.Token, depth int) {
+	start, ok := h.offset(tok)
+	if !ok {
+		return
+	}
+	end := start + len(tok.Text())
+	if tok.Type() == lexer.TokenHash && end < len(h.src) && bytes.IndexByte([]byte("([{"), h.src[end]) >= 0 {
+		end++
+	}
+	h.add(start, end, ClassBracket, depth)
+}
+
+func (h *highlighter) node(n *ast.Node, depth int) {
+	if !n.IsVector() {
+		h.value(n, valueClasses[n.Type()])
+		return
+	}
+
+	children := n.List()
+	tok := n.Token()
+	head := false
+	switch {
+	case tok == nil:
+	case n.Type() == ast.NodeTypeTagged && len(children) > 0:
+		// the tag is the first child, right after the #
+		start, ok := h.offset(tok)
+		if end, found := h.offset(children[0].Token()); ok && found {
+			h.add(start, end+len(children[0].Token().Text()), ClassTag, 0)
+		}
+		children = children[1:]
+	case isQuote(tok.Type()) && len(children) > 0:
+		// the head of (quote x) is not part of the source
+		h.token(tok, ClassQuote, 0)
+		children = children[1:]
+	default:
+		h.bracket(tok, depth)
+		if end := n.EndToken(); end != nil {
+			h.bracket(end, depth)
+		}
+		head = n.Type() == ast.NodeTypeExpression
+		depth++
+	}
+
+	for i, child := range children {
+		if i == 0 && head && child.Type() == ast.NodeTypeSymbol {
+			h.value(child, ClassHead)
+			continue
+		}
+		h.node(child, depth)
+	}
+	if tail := n.Tail(); tail != nil {
+		h.node(tail, depth)
+	}
+}
+
+func (h *highlighter) value(n *ast.Node, class Class) {
+	tok := n.Token()
+	if tok == nil {
+		return
+	}
+	start, ok := h.offset(tok)
+	if !ok {
+		return
+	}
+	end := start + len(tok.Text())
+	if n.Type() == ast.NodeTypeString && start > 0 && h.src[start-1] == '"' {
+		// the token of a string doesn't include the quotes
+		start--
+		if end < len(h.src) && h.src[end] == '"' {
+			end++
+		}
+	}
+	h.add(start, end, class, 0)
+}
+
+// result sorts the spans and fills the gaps between them with ClassText
+func (h *highlighter) result() []Span {
+	sort.SliceStable(h.spans, func(i, j int) bool {
+		return h.spans[i].Start < h.spans[j].Start
+	})
+
+	spans := make([]Span, 0, 2*len(h.spans)+1)
+	offset := 0
+	for _, s := range h.spans {
+		if s.Start < offset {
+			continue
+		}
+		if s.Start > offset {
+			spans = append(spans, Span{Start: offset, End: s.Start, Class: ClassText})
+		}
+		spans = append(spans, s)
+		offset = s.End
+	}
+	if offset < len(h.src) {
+		spans = append(spans, Span{Start: offset, End: len(h.src), Class: ClassText})
+	}
+	return spans
+}
+
+func isQuote(tt lexer.TokenType) bool {
+	switch tt {
+	case lexer.TokenQuote, lexer.TokenBackquote, lexer.TokenComma, lexer.TokenCommaAt:
+		return true
+	}
+	return false
+}
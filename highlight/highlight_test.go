@@ -0,0 +1,150 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/parser"
+)
+
+// describe returns the class and text of the spans that are not ClassText,
+// brackets include their depth
+func describe(src []byte, spans []Span) []string {
+	out := []string{}
+	for _, s := range spans {
+		switch s.Class {
+		case ClassText:
+		case ClassBracket:
+			out = append(out, fmt.Sprintf("%v/%d %s", s.Class, s.Depth, src[s.Start:s.End]))
+		default:
+			out = append(out, fmt.Sprintf("%v %s", s.Class, src[s.Start:s.End]))
+		}
+	}
+	return out
+}
+
+func TestSpans(t *testing.T) {
+	testCases := []struct {
+		Options parser.ParserOptions
+		In      string
+		Out     []string
+	}{
+		{
+			In:  `(fn_a [1 2.5 :b] "c\"d" e)`,
+			Out: []string{"bracket/0 (", "head fn_a", "bracket/1 [", "number 1", "number 2.5", "atom :b", "bracket/1 ]", `string "c\"d"`, "symbol e", "bracket/0 )"},
+		},
+		{
+			In:  `[a (b)] ((c) d)`,
+			Out: []string{"bracket/0 [", "symbol a", "bracket/1 (", "head b", "bracket/1 )", "bracket/0 ]", "bracket/0 (", "bracket/1 (", "head c", "bracket/1 )", "symbol d", "bracket/0 )"},
+		},
+		{
+			In:  "(é \"😊\") # comment\n",
+			Out: []string{"bracket/0 (", "head é", `string "😊"`, "bracket/0 )", "comment # comment"},
+		},
+		{
+			In:  "(a \"\nb\" c)",
+			Out: []string{"bracket/0 (", "head a", "string \"\nb\"", "symbol c", "bracket/0 )"},
+		},
+		{
+			In:  `(a (b`,
+			Out: []string{"bracket/0 (", "head a", "bracket/1 (", "head b"},
+		},
+		{
+			In:  `(a ] b)`,
+			Out: []string{"bracket/0 (", "head a", "invalid ]"},
+		},
+		{
+			Options: parser.SchemeOptions(),
+			In:      "; c\n(define (f x) #| b |# '(x) `(a ,b ,@c) #\\a #t #(1) #;(d) (e . f))",
+			Out: []string{
+				"comment ; c", "bracket/0 (", "head define", "bracket/1 (", "head f", "symbol x", "bracket/1 )",
+				"comment #| b |#", "quote '", "bracket/1 (", "head x", "bracket/1 )",
+				"quote `", "bracket/1 (", "head a", "quote ,", "symbol b", "quote ,@", "symbol c", "bracket/1 )",
+				"char #\\a", "literal #t", "bracket/1 #(", "number 1", "bracket/1 )",
+				"bracket/1 (", "head e", "symbol f", "bracket/1 )", "bracket/0 )",
+			},
+		},
+		{
+			Options: parser.EDNOptions(),
+			In:      `{:a #{1} #_ x #inst "2020" nil true \c}`,
+			Out:     []string{"bracket/0 {", "atom :a", "bracket/1 #{", "number 1", "bracket/1 }", "tag #inst", `string "2020"`, "literal nil", "literal true", `char \c`, "bracket/0 }"},
+		},
+		{
+			Options: parser.SMTLIBOptions(),
+			In:      `(assert (= |x y| #x1f "a""b"))`,
+			Out:     []string{"bracket/0 (", "head assert", "bracket/1 (", "head =", "symbol |x y|", "number #x1f", `string "a""b"`, "bracket/1 )", "bracket/0 )"},
+		},
+	}
+
+	for i, tc := range testCases {
+		src := []byte(tc.In)
+		spans := Options{Parser: tc.Options}.Spans(src)
+		assert.Equal(t, tc.Out, describe(src, spans), "case %d", i)
+
+		// spans cover the whole source
+		offset := 0
+		for _, s := range spans {
+			assert.Equal(t, offset, s.Start, "case %d", i)
+			assert.True(t, s.Start < s.End, "case %d", i)
+			offset = s.End
+		}
+		assert.Equal(t, len(src), offset, "case %d", i)
+	}
+}
+
+func TestANSI(t *testing.T) {
+	var buf bytes.Buffer
+	err := ANSI(&buf, []byte(`(a [b] "c") # d`))
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[31m(\x1b[0m\x1b[1;34ma\x1b[0m \x1b[33m[\x1b[0mb\x1b[33m]\x1b[0m \x1b[32m\"c\"\x1b[0m\x1b[31m)\x1b[0m \x1b[90m# d\x1b[0m", buf.String())
+
+	buf.Reset()
+	options := Options{Parser: parser.SchemeOptions(), Colors: map[Class]string{ClassComment: "2"}}
+	err = options.ANSI(&buf, []byte(`(a [b] "c") ; d`))
+	assert.NoError(t, err)
+	assert.Equal(t, "(a [b] \"c\") \x1b[2m; d\x1b[0m", buf.String())
+}
+
+func TestHTML(t *testing.T) {
+	var buf bytes.Buffer
+	err := HTML(&buf, []byte(`(a (b (c (d (e (f (g "<&>")))))))`))
+	assert.NoError(t, err)
+	assert.Equal(t, `<span class="sexpr-bracket sexpr-depth-0">(</span><span class="sexpr-head">a</span> `+
+		`<span class="sexpr-bracket sexpr-depth-1">(</span><span class="sexpr-head">b</span> `+
+		`<span class="sexpr-bracket sexpr-depth-2">(</span><span class="sexpr-head">c</span> `+
+		`<span class="sexpr-bracket sexpr-depth-3">(</span><span class="sexpr-head">d</span> `+
+		`<span class="sexpr-bracket sexpr-depth-4">(</span><span class="sexpr-head">e</span> `+
+		`<span class="sexpr-bracket sexpr-depth-5">(</span><span class="sexpr-head">f</span> `+
+		`<span class="sexpr-bracket sexpr-depth-0">(</span><span class="sexpr-head">g</span> <span class="sexpr-string">&#34;&lt;&amp;&gt;&#34;</span>`+
+		`<span class="sexpr-bracket sexpr-depth-0">)</span><span class="sexpr-bracket sexpr-depth-5">)</span>`+
+		`<span class="sexpr-bracket sexpr-depth-4">)</span><span class="sexpr-bracket sexpr-depth-3">)</span>`+
+		`<span class="sexpr-bracket sexpr-depth-2">)</span><span class="sexpr-bracket sexpr-depth-1">)</span>`+
+		`<span class="sexpr-bracket sexpr-depth-0">)</span>`, buf.String())
+
+	buf.Reset()
+	options := Options{ClassPrefix: "hl-"}
+	err = options.HTML(&buf, []byte("(a\n  b) ]"))
+	assert.NoError(t, err)
+	assert.Equal(t, "<span class=\"hl-bracket hl-depth-0\">(</span><span class=\"hl-head\">a</span>\n  <span class=\"hl-symbol\">b</span><span class=\"hl-bracket hl-depth-0\">)</span> <span class=\"hl-invalid\">]</span>", buf.String())
+}
+
+func TestSemanticTokens(t *testing.T) {
+	src := []byte("(define x 😊)\n#| a\n  b |# (f \"é\" :k)")
+	data := Options{Parser: parser.SchemeOptions()}.SemanticTokens(src)
+	assert.Equal(t, []uint32{
+		0, 1, 6, 6, 0, // define
+		0, 7, 1, 5, 0, // x
+		0, 2, 2, 5, 0, // 😊
+		1, 0, 4, 0, 0, // #| a
+		1, 0, 6, 0, 0, //   b |#
+		0, 8, 1, 6, 0, // f
+		0, 2, 3, 1, 0, // "é"
+		0, 4, 2, 4, 0, // :k
+	}, data)
+
+	for i := 3; i < len(data); i += 5 {
+		assert.True(t, int(data[i]) < len(SemanticTokenTypes))
+	}
+}
@@ -0,0 +1,160 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+// SemanticTokenTypes is the legend of the token types encoded by
+// SemanticTokens, language servers announce it to clients
+var SemanticTokenTypes = []string{
+	"comment",
+	"string",
+	"number",
+	"keyword",
+	"enumMember",
+	"variable",
+	"function",
+	"type",
+	"operator",
+}
+
+// SemanticTokenModifiers is the legend of the token modifiers encoded by
+// SemanticTokens, none are used
+var SemanticTokenModifiers = []string{}
+
+// semanticTokenTypes maps classes to their index in SemanticTokenTypes,
+// text, brackets and invalid tokens are left to the client
+var semanticTokenTypes = map[Class]uint32{
+	ClassComment: 0,
+	ClassString:  1,
+	ClassChar:    1,
+	ClassNumber:  2,
+	ClassLiteral: 3,
+	ClassAtom:    4,
+	ClassSymbol:  5,
+	ClassHead:    6,
+	ClassTag:     7,
+	ClassQuote:   8,
+}
+
+// ANSI writes a source highlighted with DefaultOptions
+func ANSI(w io.Writer, src []byte) error {
+	return DefaultOptions.ANSI(w, src)
+}
+
+// HTML writes a source highlighted with DefaultOptions
+func HTML(w io.Writer, src []byte) error {
+	return DefaultOptions.HTML(w, src)
+}
+
+// SemanticTokens encodes a source using DefaultOptions
+func SemanticTokens(src []byte) []uint32 {
+	return DefaultOptions.SemanticTokens(src)
+}
+
+// ANSI writes a source with the escape sequences that color its text in a
+// terminal
+func (o Options) ANSI(w io.Writer, src []byte) error {
+	var buf bytes.Buffer
+	for _, s := range o.Spans(src) {
+		code := o.Colors[s.Class]
+		if s.Class == ClassBracket && len(o.BracketColors) > 0 {
+			code = o.BracketColors[s.Depth%len(o.BracketColors)]
+		}
+		if code == "" {
+			buf.Write(src[s.Start:s.End])
+			continue
+		}
+		fmt.Fprintf(&buf, "\x1b[%sm%s\x1b[0m", code, src[s.Start:s.End])
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// HTML writes a source as escaped HTML, text that has a class is wrapped in a
+// span element with the name of the class prefixed by ClassPrefix, like
+// <span class="sexpr-head">define</span>. Brackets also get the class of
+// their depth, like sexpr-depth-1. The output is meant to be placed in a pre
+// element.
+func (o Options) HTML(w io.Writer, src []byte) error {
+	var buf bytes.Buffer
+	for _, s := range o.Spans(src) {
+		text := html.EscapeString(string(src[s.Start:s.End]))
+		if s.Class == ClassText {
+			buf.WriteString(text)
+			continue
+		}
+		class := o.ClassPrefix + s.Class.String()
+		if s.Class == ClassBracket {
+			depth := s.Depth
+			if o.Depths > 0 {
+				depth %= o.Depths
+			}
+			class += fmt.Sprintf(" %sdepth-%d", o.ClassPrefix, depth)
+		}
+		fmt.Fprintf(&buf, `<span class="%s">%s</span>`, class, text)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SemanticTokens encodes the classes of a source as the data of a Language
+// Server Protocol semantic tokens response: each token is made of five
+// integers, the line and the start character relative to the previous token,
+// the length, the index of its type in SemanticTokenTypes and its modifiers.
+// Characters are counted in UTF-16 code units and tokens that span several
+// lines are split into one token per line.
+func (o Options) SemanticTokens(src []byte) []uint32 {
+	lines := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+
+	data := []uint32{}
+	prevLine, prevChar := 0, 0
+	for _, s := range o.Spans(src) {
+		tokenType, ok := semanticTokenTypes[s.Class]
+		if !ok {
+			continue
+		}
+		for start := s.Start; start < s.End; {
+			end := s.End
+			if i := bytes.IndexByte(src[start:end], '\n'); i >= 0 {
+				end = start + i
+			}
+			if end > start {
+				line := sort.SearchInts(lines, start+1) - 1
+				char := utf16Len(src[lines[line]:start])
+				deltaChar := char
+				if line == prevLine {
+					deltaChar -= prevChar
+				}
+				data = append(data, uint32(line-prevLine), uint32(deltaChar), uint32(utf16Len(src[start:end])), tokenType, 0)
+				prevLine, prevChar = line, char
+			}
+			start = end + 1
+		}
+	}
+	return data
+}
+
+// utf16Len returns the number of UTF-16 code units of a text
+func utf16Len(text []byte) int {
+	n := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		if r >= 0x10000 {
+			n++
+		}
+		n++
+		text = text[size:]
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+package lexer
+
+// Delimiter is a pair of characters that enclose a group of tokens, like ( and
+// ).
+type Delimiter struct {
+	Open  rune
+	Close rune
+}
+
+// Config defines the characters the lexer recognizes as words, whitespace,
+// comments and delimiters, the characters that don't belong to any class are
+// read as sequences. Characters that make up other tokens, like quotes or
+// the #, can't be configured.
+type Config struct {
+	// Word are the characters that make up words, usually letters and the
+	// characters allowed in identifiers, like - or ?.
+	Word string
+
+	// Whitespace are the characters, other than the newline, that separate
+	// tokens.
+	Whitespace string
+
+	// LineComments are the characters that begin a comment, which ends at the
+	// end of the line. Comments are read as a single TokenComment, except
+	// within strings.
+	LineComments string
+
+	// Expressions, Lists and Maps are the pairs of characters that open and
+	// close expressions, lists and maps.
+	Expressions []Delimiter
+	Lists       []Delimiter
+	Maps        []Delimiter
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// DefaultConfig returns the configuration used by New: words are made of
+// ASCII letters and underscores, expressions are enclosed in parentheses,
+// lists in square brackets and maps in curly brackets and there are no
+// comments.
+func DefaultConfig() Config {
+	return Config{
+		Word:        letters + "_",
+		Whitespace:  " \f\t\r",
+		Expressions: []Delimiter{{'(', ')'}},
+		Lists:       []Delimiter{{'[', ']'}},
+		Maps:        []Delimiter{{'{', '}'}},
+	}
+}
+
+// LispConfig returns a configuration for Scheme and Common Lisp sources:
+// words can contain the characters allowed in Lisp identifiers, like - ? ! *
+// or <, and comments begin with a semicolon.
+func LispConfig() Config {
+	config := DefaultConfig()
+	config.Word = letters + "_-!?*+/<=>$%&~^"
+	config.LineComments = ";"
+	return config
+}
+
+// EDNConfig returns a configuration for EDN and Clojure sources: words can
+// contain the characters allowed in Clojure symbols, commas are whitespace
+// and comments begin with a semicolon.
+func EDNConfig() Config {
+	config := DefaultConfig()
+	config.Word = letters + "_-!?*+/<=>$%&"
+	config.Whitespace = config.Whitespace + ","
+	config.LineComments = ";"
+	return config
+}
+
+// classes returns the characters of each token type
+func (c Config) classes() map[TokenType][]rune {
+	classes := make(map[TokenType][]rune, len(tokenValues))
+	for tt, values := range tokenValues {
+		classes[tt] = values
+	}
+
+	classes[TokenWord] = []rune(c.Word)
+	classes[TokenWhitespace] = []rune(c.Whitespace)
+	classes[TokenComment] = []rune(c.LineComments)
+
+	delimiters := []struct {
+		pairs       []Delimiter
+		open, close TokenType
+	}{
+		{c.Expressions, TokenOpenExpression, TokenCloseExpression},
+		{c.Lists, TokenOpenList, TokenCloseList},
+		{c.Maps, TokenOpenMap, TokenCloseMap},
+	}
+	for _, d := range delimiters {
+		classes[d.open], classes[d.close] = nil, nil
+		for _, pair := range d.pairs {
+			classes[d.open] = append(classes[d.open], pair.Open)
+			classes[d.close] = append(classes[d.close], pair.Close)
+		}
+	}
+
+	return classes
+}
@@ -9,31 +9,14 @@ import (
 
 type lexState func(*Lexer) lexState
 
-var (
-	isOpenList  = isTokenType(TokenOpenList)
-	isCloseList = isTokenType(TokenCloseList)
-
-	isOpenMap  = isTokenType(TokenOpenMap)
-	isCloseMap = isTokenType(TokenCloseMap)
-
-	isOpenExpression  = isTokenType(TokenOpenExpression)
-	isCloseExpression = isTokenType(TokenCloseExpression)
-
-	isNewLine     = isTokenType(TokenNewLine)
-	isDoubleQuote = isTokenType(TokenDoubleQuote)
-	isHash        = isTokenType(TokenHash)
-	isWhitespace  = isTokenType(TokenWhitespace)
-
-	isWord    = isTokenType(TokenWord)
-	isInteger = isTokenType(TokenInteger)
-
-	isColon     = isTokenType(TokenColon)
-	isDot       = isTokenType(TokenDot)
-	isBackslash = isTokenType(TokenBackslash)
-)
-
-// New initializes a Lexer object
+// New initializes a Lexer object that uses DefaultConfig
 func New(r io.Reader) *Lexer {
+	return NewWithConfig(r, DefaultConfig())
+}
+
+// NewWithConfig initializes a Lexer object that recognizes the characters
+// defined by the given configuration
+func NewWithConfig(r io.Reader, config Config) *Lexer {
 	s := &scanner.Scanner{
 		Mode: scanner.ScanIdents | scanner.ScanFloats | scanner.ScanChars | scanner.ScanStrings | scanner.ScanRawStrings | scanner.ScanComments,
 	}
@@ -44,6 +27,7 @@ func New(r io.Reader) *Lexer {
 		scanning: make(chan struct{}),
 		tokens:   make(chan *Token),
 		buf:      []rune{},
+		classes:  config.classes(),
 	}
 }
 
@@ -63,11 +47,24 @@ type Lexer struct {
 
 	buf []rune
 
+	classes map[TokenType][]rune
+
+	// inString and escaped track whether the scanner is within a string and
+	// whether the last character was an escaping backslash
+	inString bool
+	escaped  bool
+
 	start  int
 	offset int
 	lines  int
 }
 
+// SetConfig changes the characters the lexer recognizes, it must be called
+// before Scan.
+func (lx *Lexer) SetConfig(config Config) {
+	lx.classes = config.classes()
+}
+
 // Next sends a signal to the Scan method for it to continue scanning
 func (lx *Lexer) Next() bool {
 	if lx.closed {
@@ -145,7 +142,28 @@ func (lx *Lexer) peek() rune {
 	return lx.in.Peek()
 }
 
+// next reads a character of a token and tracks the strings it opens or
+// closes
 func (lx *Lexer) next() (rune, error) {
+	r, err := lx.advance()
+	if err != nil {
+		return r, err
+	}
+
+	switch {
+	case lx.escaped:
+		lx.escaped = false
+	case r == '\\':
+		lx.escaped = true
+	case r == '"':
+		lx.inString = !lx.inString
+	}
+	return r, nil
+}
+
+// advance reads a character without looking at it, quotes and backslashes
+// within comments don't change the state of strings
+func (lx *Lexer) advance() (rune, error) {
 	lx.offset++
 
 	r := lx.in.Next()
@@ -157,6 +175,22 @@ func (lx *Lexer) next() (rune, error) {
 	return r, nil
 }
 
+// is returns true if the character belongs to the given token type
+func (lx *Lexer) is(tt TokenType, r rune) bool {
+	for _, v := range lx.classes[tt] {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+// isComment returns true if the character begins a comment, comment
+// characters within strings are not comments.
+func (lx *Lexer) isComment(r rune) bool {
+	return !lx.inString && lx.is(TokenComment, r)
+}
+
 func lexDefaultState(lx *Lexer) lexState {
 	r, err := lx.next()
 	if err != nil {
@@ -167,64 +201,71 @@ func lexDefaultState(lx *Lexer) lexState {
 	}
 
 	switch {
-	case isOpenList(r):
+	case lx.isComment(r):
+		return lexComment
+
+	case isAritmeticSign(r) && lx.is(TokenInteger, lx.peek()):
+		return lexCollectStream(TokenInteger)
+
+	case lx.is(TokenOpenList, r):
 		return lexEmit(TokenOpenList)
-	case isCloseList(r):
+	case lx.is(TokenCloseList, r):
 		return lexEmit(TokenCloseList)
 
-	case isOpenMap(r):
+	case lx.is(TokenOpenMap, r):
 		return lexEmit(TokenOpenMap)
-	case isCloseMap(r):
+	case lx.is(TokenCloseMap, r):
 		return lexEmit(TokenCloseMap)
 
-	case isOpenExpression(r):
+	case lx.is(TokenOpenExpression, r):
 		return lexEmit(TokenOpenExpression)
-	case isCloseExpression(r):
+	case lx.is(TokenCloseExpression, r):
 		return lexEmit(TokenCloseExpression)
 
-	case isDoubleQuote(r):
+	case lx.is(TokenDoubleQuote, r):
 		return lexEmit(TokenDoubleQuote)
-	case isHash(r):
+	case lx.is(TokenHash, r):
 		return lexEmit(TokenHash)
-	case isNewLine(r):
+	case lx.is(TokenNewLine, r):
 		return lexEmit(TokenNewLine)
-	case isWhitespace(r):
+	case lx.is(TokenWhitespace, r):
 		return lexCollectStream(TokenWhitespace)
 
-	case isWord(r):
+	case lx.is(TokenWord, r):
 		return lexCollectStream(TokenWord)
 
-	case isInteger(r):
+	case lx.is(TokenInteger, r):
 		return lexCollectStream(TokenInteger)
 
-	case isColon(r):
+	case lx.is(TokenColon, r):
 		return lexEmit(TokenColon)
-	case isDot(r):
+	case lx.is(TokenDot, r):
 		return lexEmit(TokenDot)
-	case isBackslash(r):
+	case lx.is(TokenBackslash, r):
 		return lexEmit(TokenBackslash)
 
+	case lx.is(TokenQuote, r):
+		return lexEmit(TokenQuote)
+	case lx.is(TokenBackquote, r):
+		return lexEmit(TokenBackquote)
+	case lx.is(TokenComma, r):
+		return lexComma
+	case lx.is(TokenPipe, r):
+		return lexEmit(TokenPipe)
+
 	default:
-		if isAritmeticSign(r) {
-			return lexNumeric
-		}
 		return lexSequence
 	}
 }
 
-func lexNumeric(lx *Lexer) lexState {
-	p := lx.peek()
-	if !isInteger(p) {
-		return lexSequence
-	}
-	if _, err := lx.next(); err != nil {
-		if err != io.EOF {
+func lexComma(lx *Lexer) lexState {
+	if lx.peek() == '@' {
+		if _, err := lx.next(); err != nil {
 			return lexStateError(err)
 		}
+		return lexEmit(TokenCommaAt)
 	}
-
-	lx.emit(TokenInteger)
-	return lexDefaultState
+	return lexEmit(TokenComma)
 }
 
 func lexSequence(lx *Lexer) lexState {
@@ -232,7 +273,9 @@ loop:
 	for {
 		p := lx.peek()
 		switch {
-		case isWhitespace(p), isNewLine(p), isDoubleQuote(p), isOpenList(p), isCloseList(p), isOpenExpression(p), isCloseExpression(p), isOpenMap(p), isCloseMap(p):
+		case lx.is(TokenWhitespace, p), lx.is(TokenNewLine, p), lx.is(TokenDoubleQuote, p), lx.is(TokenOpenList, p), lx.is(TokenCloseList, p), lx.is(TokenOpenExpression, p), lx.is(TokenCloseExpression, p), lx.is(TokenOpenMap, p), lx.is(TokenCloseMap, p):
+			break loop
+		case lx.is(TokenQuote, p), lx.is(TokenBackquote, p), lx.is(TokenComma, p), lx.is(TokenPipe, p), lx.isComment(p):
 			break loop
 		}
 		if _, err := lx.next(); err != nil {
@@ -246,6 +289,18 @@ loop:
 	return lexDefaultState
 }
 
+// lexComment reads a comment up to the end of the line, the newline is not
+// part of the comment.
+func lexComment(lx *Lexer) lexState {
+	for p := lx.peek(); p != scanner.EOF && !lx.is(TokenNewLine, p); p = lx.peek() {
+		if _, err := lx.advance(); err != nil {
+			return lexStateError(err)
+		}
+	}
+	lx.emit(TokenComment)
+	return lexDefaultState
+}
+
 func lexEmit(tt TokenType) lexState {
 	return func(lx *Lexer) lexState {
 		lx.emit(tt)
@@ -255,7 +310,7 @@ func lexEmit(tt TokenType) lexState {
 
 func lexCollectStream(tt TokenType) lexState {
 	return func(lx *Lexer) lexState {
-		for (isTokenType(tt))(lx.peek()) {
+		for lx.is(tt, lx.peek()) {
 			if _, err := lx.next(); err != nil {
 				if err == io.EOF {
 					break
@@ -277,10 +332,16 @@ func lexStateError(err error) lexState {
 // Tokenize takes an array of bytes and returns all the tokens within it,
 // or an error if a token can't be identified.
 func Tokenize(in []byte) ([]Token, error) {
+	return TokenizeWithConfig(in, DefaultConfig())
+}
+
+// TokenizeWithConfig is like Tokenize but recognizes the characters defined
+// by the given configuration.
+func TokenizeWithConfig(in []byte, config Config) ([]Token, error) {
 	tokens := []Token{}
 	done := make(chan struct{})
 
-	lx := New(bytes.NewReader(in))
+	lx := NewWithConfig(bytes.NewReader(in), config)
 
 	go func() {
 		for lx.Next() {
@@ -163,6 +163,51 @@ func TestTokenize(t *testing.T) {
 				TokenEOF,
 			},
 		},
+		{
+			"'a `(b ,c ,@d) e,f",
+			[]TokenType{
+				TokenQuote,
+				TokenWord,
+				TokenWhitespace,
+				TokenBackquote,
+				TokenOpenExpression,
+				TokenWord,
+				TokenWhitespace,
+				TokenComma,
+				TokenWord,
+				TokenWhitespace,
+				TokenCommaAt,
+				TokenWord,
+				TokenCloseExpression,
+				TokenWhitespace,
+				TokenWord,
+				TokenComma,
+				TokenWord,
+				TokenEOF,
+			},
+		},
+		{
+			"+'-",
+			[]TokenType{
+				TokenSequence,
+				TokenQuote,
+				TokenSequence,
+				TokenEOF,
+			},
+		},
+		{
+			"|a b|-|",
+			[]TokenType{
+				TokenPipe,
+				TokenWord,
+				TokenWhitespace,
+				TokenWord,
+				TokenPipe,
+				TokenSequence,
+				TokenPipe,
+				TokenEOF,
+			},
+		},
 	}
 
 	getTokenTypes := func(tokens []Token) []TokenType {
@@ -256,3 +301,77 @@ func TestColumnAndLines(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenizeWithConfig(t *testing.T) {
+	custom := DefaultConfig()
+	custom.Word = "abcdefghijklmnopqrstuvwxyz"
+	custom.Whitespace = " ,"
+	custom.LineComments = "%"
+	custom.Expressions = []Delimiter{{'(', ')'}, {'[', ']'}}
+	custom.Lists = []Delimiter{{'<', '>'}}
+	custom.Maps = nil
+
+	testCases := []struct {
+		In     string
+		Config Config
+		Out    []string
+	}{
+		{
+			"(list-ref? xs -1) ; the first\n",
+			LispConfig(),
+			[]string{`(`, `list-ref?`, ` `, `xs`, ` `, `-1`, `)`, ` `, `; the first`, "\n", ``},
+		},
+		{
+			`(a "b;c" \" ;d`,
+			LispConfig(),
+			[]string{`(`, `a`, ` `, `"`, `b`, `;c`, `"`, ` `, `\`, `"`, ` `, `;d`, ``},
+		},
+		{
+			`"a\";b" 1;c`,
+			LispConfig(),
+			[]string{`"`, `a`, `\`, `"`, `;b`, `"`, ` `, `1`, `;c`, ``},
+		},
+		{
+			`{:a 1, ns/b? c}`,
+			EDNConfig(),
+			[]string{`{`, `:`, `a`, ` `, `1`, `, `, `ns/b?`, ` `, `c`, `}`, ``},
+		},
+		{
+			`[a <b, C>]{} % comment`,
+			custom,
+			[]string{`[`, `a`, ` `, `<`, `b`, `, `, `C`, `>`, `]`, `{}`, ` `, `% comment`, ``},
+		},
+	}
+
+	for _, tc := range testCases {
+		tokens, err := TokenizeWithConfig([]byte(tc.In), tc.Config)
+		assert.NoError(t, err)
+
+		texts := make([]string, 0, len(tokens))
+		for i := range tokens {
+			texts = append(texts, tokens[i].Text())
+		}
+		assert.Equal(t, tc.Out, texts, tc.In)
+	}
+
+	tokens, err := TokenizeWithConfig([]byte("(a ;b\n[c])"), LispConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, TokenComment, tokens[3].Type())
+	assert.Equal(t, TokenOpenList, tokens[5].Type())
+	assert.Equal(t, 2, tokens[5].Pos().Line)
+
+	// quotes and backslashes within comments don't open strings
+	tokens, err = TokenizeWithConfig([]byte("; say \"hi\\\n(a ;b\n c)"), LispConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, `; say "hi\`, tokens[0].Text())
+	assert.Equal(t, TokenComment, tokens[0].Type())
+	assert.Equal(t, `;b`, tokens[5].Text())
+	assert.Equal(t, TokenComment, tokens[5].Type())
+	assert.Equal(t, 2, tokens[5].Pos().Line)
+
+	tokens, err = TokenizeWithConfig([]byte("[a <b>]"), custom)
+	assert.NoError(t, err)
+	assert.Equal(t, TokenOpenExpression, tokens[0].Type())
+	assert.Equal(t, TokenOpenList, tokens[3].Type())
+	assert.Equal(t, TokenCloseExpression, tokens[6].Type())
+}
@@ -15,32 +15,36 @@ const (
 	TokenNewLine                   // Newline: "\n"
 	TokenDoubleQuote               // Double quote: '"'
 	TokenHash                      // Hash: "#"
-	TokenWhitespace                // Space, tab, linefeed or carriage return: \s\f\t\r
-	TokenWord                      // Letters ([a-zA-Z]) and underscore
+	TokenWhitespace                // Space, tab, linefeed or carriage return: \s\f\t\r (see Config)
+	TokenWord                      // Letters ([a-zA-Z]) and underscore (see Config)
 	TokenInteger                   // Integers
 	TokenSequence                  // Extended sequence
 	TokenColon                     // Colon: ":"
 	TokenDot                       // Dot: "."
 	TokenBackslash                 // Backslash: "\"
+	TokenComment                   // Comment: from "#" or a comment character (see Config) to the end of the line
+	TokenQuote                     // Quote: "'"
+	TokenBackquote                 // Backquote: "`"
+	TokenComma                     // Comma: ","
+	TokenCommaAt                   // Comma followed by at sign: ",@"
+	TokenPipe                      // Vertical bar: "|"
 	TokenEOF                       // End of file
 )
 
+// tokenValues are the characters of the token types that are not set by
+// Config
 var tokenValues = map[TokenType][]rune{
-	TokenOpenList:        []rune{'['},
-	TokenCloseList:       []rune{']'},
-	TokenOpenMap:         []rune{'{'},
-	TokenCloseMap:        []rune{'}'},
-	TokenOpenExpression:  []rune{'('},
-	TokenCloseExpression: []rune{')'},
-	TokenNewLine:         []rune{'\n'},
-	TokenDoubleQuote:     []rune{'"'},
-	TokenHash:            []rune{'#'},
-	TokenWhitespace:      []rune(" \f\t\r"),
-	TokenWord:            []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_"),
-	TokenInteger:         []rune("0123456789"),
-	TokenColon:           []rune{':'},
-	TokenDot:             []rune{'.'},
-	TokenBackslash:       []rune{'\\'},
+	TokenNewLine:     []rune{'\n'},
+	TokenDoubleQuote: []rune{'"'},
+	TokenHash:        []rune{'#'},
+	TokenInteger:     []rune("0123456789"),
+	TokenColon:       []rune{':'},
+	TokenDot:         []rune{'.'},
+	TokenBackslash:   []rune{'\\'},
+	TokenQuote:       []rune{'\''},
+	TokenBackquote:   []rune{'`'},
+	TokenComma:       []rune{','},
+	TokenPipe:        []rune{'|'},
 }
 
 var tokenNames = map[TokenType]string{
@@ -60,6 +64,12 @@ var tokenNames = map[TokenType]string{
 	TokenColon:           "colon",
 	TokenDot:             "dot",
 	TokenSequence:        "sequence",
+	TokenComment:         "comment",
+	TokenQuote:           "quote",
+	TokenBackquote:       "backquote",
+	TokenComma:           "comma",
+	TokenCommaAt:         "comma_at",
+	TokenPipe:            "pipe",
 	TokenEOF:             "EOF",
 }
 
@@ -70,17 +80,6 @@ func (tt TokenType) String() string {
 	return tokenNames[TokenInvalid]
 }
 
-func isTokenType(tt TokenType) func(r rune) bool {
-	return func(r rune) bool {
-		for _, v := range tokenValues[tt] {
-			if v == r {
-				return true
-			}
-		}
-		return false
-	}
-}
-
 func isAritmeticSign(p rune) bool {
 	return p == '+' || p == '-'
 }
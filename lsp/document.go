@@ -0,0 +1,303 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+	"github.com/xiam/s-expr/parser"
+)
+
+// span is the text of a node, from its start to its end byte offsets
+type span struct {
+	start int
+	end   int
+}
+
+func (s span) contains(offset int) bool {
+	return s.start <= offset && offset < s.end
+}
+
+// document is a text document opened by the client along with the result of
+// parsing it
+type document struct {
+	version int
+	text    string
+	options parser.ParserOptions
+
+	// lines holds the offset of the beginning of each line
+	lines []int
+
+	// root is nil when the document can't be parsed, even after closing
+	// the forms that are left open
+	root     *ast.Node
+	spans    map[*ast.Node]span
+	comments []*lexer.Token
+	err      error
+}
+
+func newDocument(text string, options parser.ParserOptions) *document {
+	d := &document{options: options}
+	d.setText(text)
+	d.parse()
+	return d
+}
+
+func (d *document) setText(text string) {
+	d.text = text
+	d.lines = d.lines[:0]
+	d.lines = append(d.lines, 0)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// apply applies the changes sent by the client, in order, and parses the
+// resulting text
+func (d *document) apply(changes []contentChange) error {
+	for _, change := range changes {
+		if change.Range == nil {
+			d.setText(change.Text)
+			continue
+		}
+		start, end := d.offset(change.Range.Start), d.offset(change.Range.End)
+		if start > end {
+			return fmt.Errorf("invalid range: %v", *change.Range)
+		}
+		d.setText(d.text[:start] + change.Text + d.text[end:])
+	}
+	d.parse()
+	return nil
+}
+
+// offset returns the byte offset of a position, positions after the end of
+// a line or after the end of the text are moved back to the end
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text); {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		if r == '\n' {
+			break
+		}
+		units += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+// position returns the position of a byte offset
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	}) - 1
+	units := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		units += utf16Len(r)
+	}
+	return Position{Line: line, Character: units}
+}
+
+func (d *document) rangeOf(s span) Range {
+	return Range{Start: d.position(s.start), End: d.position(s.end)}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// tokenOffset returns the byte offset of the line and column of a token, the
+// lexer counts columns in runes starting from one
+func (d *document) tokenOffset(tok *lexer.Token) int {
+	pos := tok.Pos()
+	if pos.Line < 1 || pos.Line > len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line-1]
+	if pos.Column == 0 && pos.Line > 1 {
+		// like text/scanner, the lexer gives the newline that ends a line the
+		// column 0 of the next one
+		offset--
+	}
+	for col := 1; col < pos.Column && offset < len(d.text); col++ {
+		_, size := utf8.DecodeRuneInString(d.text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// tokenSpan returns the span of the text of a token, tokens that were not
+// read from the text, like the quote of 'x, have an empty span
+func (d *document) tokenSpan(tok *lexer.Token) span {
+	offset := d.tokenOffset(tok)
+	if strings.HasPrefix(d.text[offset:], tok.Text()) {
+		return span{offset, offset + len(tok.Text())}
+	}
+	return span{offset, offset}
+}
+
+func (d *document) parse() {
+	d.root, d.spans, d.comments, d.err = nil, nil, nil, nil
+
+	p := parser.NewParser(strings.NewReader(d.text))
+	p.SetOptions(d.options)
+	if err := p.Parse(); err != nil {
+		d.err = err
+		if d.options.AutoCloseOnEOF || !parser.IsIncomplete(err) {
+			return
+		}
+		options := d.options
+		options.AutoCloseOnEOF = true
+		p = parser.NewParser(strings.NewReader(d.text))
+		p.SetOptions(options)
+		if p.Parse() != nil {
+			return
+		}
+	}
+
+	d.root = p.RootNode()
+	d.comments = p.Comments()
+	d.spans = map[*ast.Node]span{}
+	d.measure(d.root)
+	d.spans[d.root] = span{0, len(d.text)}
+}
+
+// measure computes the spans of a node and its descendants
+func (d *document) measure(n *ast.Node) span {
+	var s span
+	if tok := n.Token(); tok != nil {
+		s = d.tokenSpan(tok)
+	} else {
+		s = span{-1, -1}
+	}
+
+	if !n.IsVector() {
+		if n.Type() == ast.NodeTypeString && s.start > 0 && d.text[s.start-1] == '"' {
+			// the token of a string doesn't include the quotes
+			s.start--
+			if s.end < len(d.text) && d.text[s.end] == '"' {
+				s.end++
+			}
+		}
+		d.spans[n] = s
+		return s
+	}
+
+	children := n.List()
+	if tail := n.Tail(); tail != nil {
+		children = append(children[:len(children):len(children)], tail)
+	}
+	for _, child := range children {
+		cs := d.measure(child)
+		if cs.start == cs.end {
+			continue
+		}
+		if s.start < 0 || cs.start < s.start {
+			s.start = cs.start
+		}
+		if cs.end > s.end {
+			s.end = cs.end
+		}
+	}
+	if end := n.EndToken(); end != nil {
+		s.end = d.tokenSpan(end).end
+	}
+	if s.start < 0 {
+		s.start = 0
+	}
+	if s.end < s.start {
+		s.end = s.start
+	}
+	d.spans[n] = s
+	return s
+}
+
+// contents returns the span between the delimiters of a vector, from the
+// beginning of its first child to the end of its last one
+func (d *document) contents(n *ast.Node) (span, bool) {
+	children := n.List()
+	if tail := n.Tail(); tail != nil {
+		children = append(children[:len(children):len(children)], tail)
+	}
+	if len(children) == 0 {
+		return span{}, false
+	}
+	return span{d.spans[children[0]].start, d.spans[children[len(children)-1]].end}, true
+}
+
+// path returns the nodes that contain an offset, from the root to the
+// innermost one. An offset right after the end of a leaf, like the cursor
+// after a word, is part of the leaf when it's not part of another node.
+func (d *document) path(offset int) []*ast.Node {
+	if d.root == nil {
+		return nil
+	}
+	path := []*ast.Node{d.root}
+	for n := d.root; n.IsVector(); {
+		children := n.List()
+		if tail := n.Tail(); tail != nil {
+			children = append(children[:len(children):len(children)], tail)
+		}
+		var next *ast.Node
+		for _, child := range children {
+			s := d.spans[child]
+			if s.contains(offset) {
+				next = child
+				break
+			}
+			if next == nil && !child.IsVector() && s.start < s.end && s.end == offset {
+				next = child
+			}
+		}
+		if next == nil {
+			break
+		}
+		path = append(path, next)
+		n = next
+	}
+	return path
+}
+
+func (d *document) diagnostics() []diagnostic {
+	diagnostics := []diagnostic{}
+	if d.err == nil {
+		return diagnostics
+	}
+
+	message := d.err.Error()
+	s := span{0, 0}
+	var syntaxErr *parser.SyntaxError
+	if errors.As(d.err, &syntaxErr) {
+		// the position is part of the range, not of the message
+		message = syntaxErr.Err.Error()
+		if syntaxErr.Err == parser.ErrUnexpectedToken {
+			message = fmt.Sprintf("%v %q", syntaxErr.Err, syntaxErr.Text)
+		}
+		if syntaxErr.Line > 0 {
+			pos := scanner.Position{Line: syntaxErr.Line, Column: syntaxErr.Column}
+			s = d.tokenSpan(lexer.NewToken(lexer.TokenInvalid, syntaxErr.Text, &pos))
+		}
+	}
+
+	return append(diagnostics, diagnostic{
+		Range:    d.rangeOf(s),
+		Severity: severityError,
+		Source:   "sexpr",
+		Message:  message,
+	})
+}
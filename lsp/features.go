@@ -0,0 +1,324 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/highlight"
+)
+
+// maxSymbolName is the length after which the names of symbols that are
+// taken from the text of a form are truncated
+const maxSymbolName = 40
+
+// definitions are the heads of the forms that define a name, the name is the
+// first argument or the head of the first argument, like in (define (f x) x)
+var definitions = map[string]bool{
+	"declare-const": true,
+	"declare-fun":   true,
+	"declare-sort":  true,
+	"def":           true,
+	"defconstant":   true,
+	"define":        true,
+	"define-fun":    true,
+	"define-sort":   true,
+	"define-syntax": true,
+	"defmacro":      true,
+	"defn":          true,
+	"defparameter":  true,
+	"defun":         true,
+	"defvar":        true,
+}
+
+// functionDefinitions are the heads of the forms that always define a
+// function or macro
+var functionDefinitions = map[string]bool{
+	"declare-fun":   true,
+	"define-fun":    true,
+	"define-syntax": true,
+	"defmacro":      true,
+	"defn":          true,
+	"defun":         true,
+}
+
+func (s *server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p textDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := []documentSymbol{}
+	if doc.root == nil {
+		return symbols, nil
+	}
+	for _, n := range doc.root.List() {
+		symbols = append(symbols, doc.symbol(n))
+	}
+	return symbols, nil
+}
+
+// symbol returns the symbol of a top-level form
+func (d *document) symbol(n *ast.Node) documentSymbol {
+	sym := documentSymbol{
+		Name:           symbolName(n),
+		Detail:         n.Type().String(),
+		Range:          d.rangeOf(d.spans[n]),
+		SelectionRange: d.rangeOf(d.spans[n]),
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeExpression:
+		sym.Kind = symbolKindArray
+		list := n.List()
+		if len(list) == 0 || list[0].Type() != ast.NodeTypeSymbol {
+			break
+		}
+		head := list[0].Value().(string)
+		sym.Name, sym.Detail, sym.Kind = head, head, symbolKindObject
+		sym.SelectionRange = d.rangeOf(d.spans[list[0]])
+		if !definitions[head] || len(list) < 2 {
+			break
+		}
+
+		name := list[1]
+		sym.Kind = symbolKindVariable
+		if functionDefinitions[head] {
+			sym.Kind = symbolKindFunction
+		}
+		if name.Type() == ast.NodeTypeExpression && len(name.List()) > 0 {
+			// (define (f x) ...)
+			name = name.List()[0]
+			sym.Kind = symbolKindFunction
+		} else if len(list) > 2 && isLambda(list[2]) {
+			// (define f (lambda (x) ...))
+			sym.Kind = symbolKindFunction
+		}
+		sym.Name = symbolName(name)
+		sym.SelectionRange = d.rangeOf(d.spans[name])
+
+	case ast.NodeTypeList, ast.NodeTypeSet:
+		sym.Kind = symbolKindArray
+	case ast.NodeTypeMap, ast.NodeTypeTagged:
+		sym.Kind = symbolKindObject
+	case ast.NodeTypeString, ast.NodeTypeChar:
+		sym.Kind = symbolKindString
+	case ast.NodeTypeInt, ast.NodeTypeFloat, ast.NodeTypeBitVector:
+		sym.Kind = symbolKindNumber
+	case ast.NodeTypeBool:
+		sym.Kind = symbolKindBoolean
+	case ast.NodeTypeNil:
+		sym.Kind = symbolKindNull
+	case ast.NodeTypeAtom:
+		sym.Kind = symbolKindKey
+	default:
+		sym.Kind = symbolKindVariable
+	}
+	return sym
+}
+
+func isLambda(n *ast.Node) bool {
+	if n.Type() != ast.NodeTypeExpression || len(n.List()) == 0 {
+		return false
+	}
+	head := n.List()[0]
+	return head.Type() == ast.NodeTypeSymbol && (head.Value() == "lambda" || head.Value() == "fn")
+}
+
+func symbolName(n *ast.Node) string {
+	if n.Type() == ast.NodeTypeSymbol {
+		return n.Value().(string)
+	}
+	name := []rune(string(ast.Encode(n)))
+	if len(name) > maxSymbolName {
+		return string(name[:maxSymbolName]) + "..."
+	}
+	return string(name)
+}
+
+func (s *server) selectionRange(params json.RawMessage) (interface{}, error) {
+	var p selectionRangeParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]selectionRange, 0, len(p.Positions))
+	for _, pos := range p.Positions {
+		ranges = append(ranges, doc.selectionRange(doc.offset(pos)))
+	}
+	return ranges, nil
+}
+
+// selectionRange returns the spans of the nodes around an offset, from the
+// innermost one, the contents of a vector come before its delimiters
+func (d *document) selectionRange(offset int) selectionRange {
+	var spans []span
+	add := func(s span) {
+		if len(spans) == 0 || s != spans[len(spans)-1] {
+			spans = append(spans, s)
+		}
+	}
+
+	path := d.path(offset)
+	for i := len(path) - 1; i > 0; i-- {
+		n := path[i]
+		if n.IsVector() {
+			if contents, ok := d.contents(n); ok && contents.start <= offset && offset <= contents.end {
+				add(contents)
+			}
+		}
+		add(d.spans[n])
+	}
+	add(span{0, len(d.text)})
+
+	// each range is the parent of the one before
+	var sel *selectionRange
+	for i := len(spans) - 1; i >= 0; i-- {
+		sel = &selectionRange{Range: d.rangeOf(spans[i]), Parent: sel}
+	}
+	return *sel
+}
+
+func (s *server) foldingRange(params json.RawMessage) (interface{}, error) {
+	var p textDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return doc.foldingRanges(), nil
+}
+
+// foldingRanges returns the ranges of the vectors and comments that span
+// several lines, consecutive line comments are folded together
+func (d *document) foldingRanges() []foldingRange {
+	ranges := []foldingRange{}
+	if d.root == nil {
+		return ranges
+	}
+
+	seen := map[int]bool{}
+	add := func(s span, kind string) {
+		start, end := d.position(s.start).Line, d.position(s.end).Line
+		if start < end && !seen[start] {
+			seen[start] = true
+			ranges = append(ranges, foldingRange{StartLine: start, EndLine: end, Kind: kind})
+		}
+	}
+
+	var comments []span
+	merge := false
+	for _, tok := range d.comments {
+		s := d.tokenSpan(tok)
+		line := !strings.Contains(tok.Text(), "\n")
+		if n := len(comments); merge && line && d.position(s.start).Line == d.position(comments[n-1].end).Line+1 {
+			comments[n-1].end = s.end
+			continue
+		}
+		comments = append(comments, s)
+		merge = line
+	}
+
+	ast.Walk(d.root, func(n *ast.Node) bool {
+		if n != d.root && n.IsVector() {
+			add(d.spans[n], "")
+		}
+		return true
+	})
+	for _, s := range comments {
+		add(s, "comment")
+	}
+	return ranges
+}
+
+func (s *server) formatting(params json.RawMessage) (interface{}, error) {
+	var p textDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.options.Format.Source([]byte(doc.text))
+	if err != nil {
+		return nil, &responseError{Code: codeRequestFailed, Message: err.Error()}
+	}
+	edits := []textEdit{}
+	if string(out) != doc.text {
+		edits = append(edits, textEdit{
+			Range:   doc.rangeOf(span{0, len(doc.text)}),
+			NewText: string(out),
+		})
+	}
+	return edits, nil
+}
+
+func (s *server) hover(params json.RawMessage) (interface{}, error) {
+	var p textDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	path := doc.path(doc.offset(p.Position))
+	if len(path) < 2 {
+		return nil, nil
+	}
+	n := path[len(path)-1]
+
+	var text string
+	if n.IsVector() {
+		count := len(n.List())
+		if n.Type() == ast.NodeTypeMap {
+			count /= 2
+		}
+		text = fmt.Sprintf("**%s** with %d %s", n.Type(), count, elements(n.Type(), count))
+	} else {
+		text = fmt.Sprintf("**%s** `%s`", n.Type(), ast.Encode(n))
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: text},
+		Range:    doc.rangeOf(doc.spans[n]),
+	}, nil
+}
+
+func elements(nt ast.NodeType, count int) string {
+	name := "element"
+	if nt == ast.NodeTypeMap {
+		name = "pair"
+	}
+	if count != 1 {
+		name += "s"
+	}
+	return name
+}
+
+func (s *server) semanticTokens(params json.RawMessage) (interface{}, error) {
+	var p textDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	options := highlight.Options{Parser: s.options.Parser}
+	return &semanticTokens{Data: options.SemanticTokens([]byte(doc.text))}, nil
+}
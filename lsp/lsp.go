@@ -0,0 +1,301 @@
+// Package lsp implements a Language Server Protocol server for S-expression
+// files, it talks JSON-RPC over a reader and a writer, usually the standard
+// input and output of the process started by the editor.
+//
+// The server keeps the documents opened by the editor in sync with
+// incremental changes and parses them after each change, syntax errors are
+// published as diagnostics. Documents with forms that are left open, which
+// happens all the time while typing, are parsed as if the forms were closed
+// so the rest of the features keep working. The server provides:
+//
+//	textDocument/documentSymbol  a symbol for each top-level form, named
+//	                             after the name defined by forms like
+//	                             (define (f x) ...) or after their head
+//	textDocument/selectionRange  the nodes around a position, the contents
+//	                             of a vector are selected before its
+//	                             delimiters
+//	textDocument/foldingRange    vectors and comments that span several
+//	                             lines
+//	textDocument/formatting      the output of the format package
+//	textDocument/hover           the type of the node under the cursor
+//	textDocument/semanticTokens  the classes of the highlight package, for
+//	                             the whole document
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xiam/s-expr/format"
+	"github.com/xiam/s-expr/highlight"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Error messages
+var (
+	ErrInvalidHeader = errors.New("invalid header")
+	ErrNoShutdown    = errors.New("exit notification received before shutdown")
+)
+
+// Options represents the settings of the server
+type Options struct {
+	// Parser are the options used to parse documents.
+	Parser parser.ParserOptions
+
+	// Format are the options used to format documents.
+	Format format.Options
+}
+
+// DefaultOptions are the options used by Serve
+var DefaultOptions = Options{
+	Format: format.DefaultOptions,
+}
+
+// Serve runs a server with DefaultOptions, see Options.Serve
+func Serve(r io.Reader, w io.Writer) error {
+	return DefaultOptions.Serve(r, w)
+}
+
+// Serve reads requests and notifications from r and writes responses and
+// notifications to w until the client sends the exit notification or r ends.
+// The error is nil if the client asked the server to shut down before
+// exiting.
+func (o Options) Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		options: o,
+		w:       w,
+		docs:    map[string]*document{},
+	}
+
+	in := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		var rpcErr *responseError
+		if errors.As(err, &rpcErr) {
+			// the ID of a message that can't be read is unknown
+			null := json.RawMessage("null")
+			if err := s.reply(&null, nil, rpcErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		if msg.ID == nil {
+			err = s.notification(msg)
+		} else {
+			err = s.request(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	options Options
+	w       io.Writer
+
+	docs map[string]*document
+
+	initialized bool
+	shutdown    bool
+}
+
+// requests maps the methods of requests to their handlers
+var requests = map[string]func(s *server, params json.RawMessage) (interface{}, error){
+	"shutdown":                         (*server).shutdownRequest,
+	"textDocument/documentSymbol":      (*server).documentSymbol,
+	"textDocument/selectionRange":      (*server).selectionRange,
+	"textDocument/foldingRange":        (*server).foldingRange,
+	"textDocument/formatting":          (*server).formatting,
+	"textDocument/hover":               (*server).hover,
+	"textDocument/semanticTokens/full": (*server).semanticTokens,
+}
+
+// notifications maps the methods of notifications to their handlers
+var notifications = map[string]func(s *server, params json.RawMessage) error{
+	"textDocument/didOpen":   (*server).didOpen,
+	"textDocument/didChange": (*server).didChange,
+	"textDocument/didClose":  (*server).didClose,
+}
+
+func (s *server) request(msg *message) error {
+	var result interface{}
+	var err error
+
+	handler, ok := requests[msg.Method]
+	switch {
+	case msg.Method == "initialize":
+		s.initialized = true
+		result = s.capabilities()
+	case !s.initialized:
+		err = &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	case s.shutdown:
+		err = &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	case !ok:
+		err = &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", msg.Method)}
+	default:
+		result, err = handler(s, msg.Params)
+	}
+
+	return s.reply(msg.ID, result, err)
+}
+
+func (s *server) notification(msg *message) error {
+	handler, ok := notifications[msg.Method]
+	if !ok || !s.initialized || s.shutdown {
+		// notifications that can't be handled are dropped, like the
+		// initialized and $/ ones
+		return nil
+	}
+	if err := handler(s, msg.Params); err != nil {
+		// there is no response to a notification, the error is logged
+		// by the client instead
+		return s.notify("window/logMessage", map[string]interface{}{
+			"type":    1,
+			"message": fmt.Sprintf("%s: %v", msg.Method, err),
+		})
+	}
+	return nil
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		rpcErr := &responseError{}
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &responseError{Code: codeInternalError, Message: err.Error()}
+		}
+		msg.Error = rpcErr
+		return writeMessage(s.w, msg)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(data)
+	msg.Result = &raw
+	return writeMessage(s.w, msg)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.w, &message{Method: method, Params: data})
+}
+
+func (s *server) capabilities() initializeResult {
+	return initializeResult{
+		Capabilities: serverCapabilities{
+			TextDocumentSync: textDocumentSyncOptions{
+				OpenClose: true,
+				Change:    syncIncremental,
+			},
+			DocumentSymbolProvider:     true,
+			SelectionRangeProvider:     true,
+			FoldingRangeProvider:       true,
+			DocumentFormattingProvider: true,
+			HoverProvider:              true,
+			SemanticTokensProvider: semanticTokensOptions{
+				Legend: semanticTokensLegend{
+					TokenTypes:     highlight.SemanticTokenTypes,
+					TokenModifiers: highlight.SemanticTokenModifiers,
+				},
+				Full: true,
+			},
+		},
+		ServerInfo: serverInfo{Name: "sexpr"},
+	}
+}
+
+func (s *server) shutdownRequest(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+// decode reads the params of a message
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// document returns an open document
+func (s *server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown document: %s", uri)}
+	}
+	return doc, nil
+}
+
+func (s *server) didOpen(params json.RawMessage) error {
+	var p didOpenParams
+	if err := decode(params, &p); err != nil {
+		return err
+	}
+	doc := newDocument(p.TextDocument.Text, s.options.Parser)
+	doc.version = p.TextDocument.Version
+	s.docs[p.TextDocument.URI] = doc
+	return s.publishDiagnostics(p.TextDocument.URI, doc)
+}
+
+func (s *server) didChange(params json.RawMessage) error {
+	var p didChangeParams
+	if err := decode(params, &p); err != nil {
+		return err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	if err := doc.apply(p.ContentChanges); err != nil {
+		return err
+	}
+	doc.version = p.TextDocument.Version
+	return s.publishDiagnostics(p.TextDocument.URI, doc)
+}
+
+func (s *server) didClose(params json.RawMessage) error {
+	var p didCloseParams
+	if err := decode(params, &p); err != nil {
+		return err
+	}
+	if _, err := s.document(p.TextDocument.URI); err != nil {
+		return err
+	}
+	delete(s.docs, p.TextDocument.URI)
+	// the diagnostics of closed documents are cleared
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []diagnostic{},
+	})
+}
+
+func (s *server) publishDiagnostics(uri string, doc *document) error {
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Version:     doc.version,
+		Diagnostics: doc.diagnostics(),
+	})
+}
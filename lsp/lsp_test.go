@@ -0,0 +1,320 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/parser"
+)
+
+const uri = "file:///tmp/test.sexp"
+
+func request(id int, method string, params string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, method, params)
+}
+
+func notification(method string, params string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","method":%q,"params":%s}`, method, params)
+}
+
+func didOpen(text string) string {
+	data, _ := json.Marshal(text)
+	return notification("textDocument/didOpen", fmt.Sprintf(`{"textDocument":{"uri":%q,"languageId":"sexpr","version":1,"text":%s}}`, uri, data))
+}
+
+// session sends the messages to a server and returns the messages written
+// by the server
+func session(options Options, messages ...string) ([]string, error) {
+	var in bytes.Buffer
+	for _, msg := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+
+	var out bytes.Buffer
+	err := options.Serve(&in, &out)
+
+	var written []string
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readBody(r)
+		if err != nil {
+			break
+		}
+		written = append(written, string(body))
+	}
+	return written, err
+}
+
+// initialized returns the responses to the messages sent after initializing
+// a server
+func initialized(t *testing.T, messages ...string) []string {
+	messages = append([]string{request(0, "initialize", `{}`), notification("initialized", `{}`)}, messages...)
+	out, err := session(DefaultOptions, messages...)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, out) {
+		return out[1:]
+	}
+	return nil
+}
+
+func TestLifecycle(t *testing.T) {
+	out, err := session(DefaultOptions,
+		request(1, "textDocument/hover", `{}`),
+		request(2, "initialize", `{"capabilities":{}}`),
+		request(3, "unknown/method", `{}`),
+		notification("$/cancelRequest", `{"id":1}`),
+		request(4, "shutdown", `null`),
+		request(5, "textDocument/hover", `{}`),
+		notification("exit", `null`),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"server not initialized"}}`,
+		`{"jsonrpc":"2.0","id":2,"result":{"capabilities":{"textDocumentSync":{"openClose":true,"change":2},"documentSymbolProvider":true,"selectionRangeProvider":true,"foldingRangeProvider":true,"documentFormattingProvider":true,"hoverProvider":true,"semanticTokensProvider":{"legend":{"tokenTypes":["comment","string","number","keyword","enumMember","variable","function","type","operator"],"tokenModifiers":[]},"full":true}},"serverInfo":{"name":"sexpr"}}}`,
+		`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"method not found: unknown/method"}}`,
+		`{"jsonrpc":"2.0","id":4,"result":null}`,
+		`{"jsonrpc":"2.0","id":5,"error":{"code":-32600,"message":"server is shutting down"}}`,
+	}, out)
+
+	_, err = session(DefaultOptions, request(1, "initialize", `{}`), notification("exit", `null`))
+	assert.Equal(t, ErrNoShutdown, err)
+
+	out, err = session(DefaultOptions, `{"jsonrpc":`, request(1, "initialize", `{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`, out[0])
+	assert.Len(t, out, 2)
+
+	var in bytes.Buffer
+	in.WriteString("Content-Type: application/json\r\n\r\n{}")
+	assert.True(t, errors.Is(DefaultOptions.Serve(&in, &bytes.Buffer{}), ErrInvalidHeader))
+}
+
+func TestDiagnostics(t *testing.T) {
+	out := initialized(t,
+		didOpen("(a\n  b]"),
+		notification("textDocument/didChange", fmt.Sprintf(`{"textDocument":{"uri":%q,"version":2},"contentChanges":[{"range":{"start":{"line":1,"character":3},"end":{"line":1,"character":4}},"text":")"}]}`, uri)),
+		notification("textDocument/didChange", fmt.Sprintf(`{"textDocument":{"uri":%q,"version":3},"contentChanges":[{"text":"(a \"b"}]}`, uri)),
+		notification("textDocument/didClose", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+		notification("textDocument/didClose", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+	)
+	assert.Equal(t, []string{
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///tmp/test.sexp","version":1,"diagnostics":[{"range":{"start":{"line":1,"character":3},"end":{"line":1,"character":4}},"severity":1,"source":"sexpr","message":"unexpected token \"]\""}]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///tmp/test.sexp","version":2,"diagnostics":[]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///tmp/test.sexp","version":3,"diagnostics":[{"range":{"start":{"line":0,"character":5},"end":{"line":0,"character":5}},"severity":1,"source":"sexpr","message":"unexpected EOF"}]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///tmp/test.sexp","version":0,"diagnostics":[]}}`,
+		`{"jsonrpc":"2.0","method":"window/logMessage","params":{"message":"textDocument/didClose: unknown document: file:///tmp/test.sexp","type":1}}`,
+	}, out)
+}
+
+func TestIncrementalSync(t *testing.T) {
+	change := func(startLine, startChar, endLine, endChar int, text string) contentChange {
+		return contentChange{
+			Range: &Range{
+				Start: Position{Line: startLine, Character: startChar},
+				End:   Position{Line: endLine, Character: endChar},
+			},
+			Text: text,
+		}
+	}
+
+	testCases := []struct {
+		In      string
+		Changes []contentChange
+		Out     string
+	}{
+		{"(a b)", []contentChange{change(0, 3, 0, 4, "c")}, "(a c)"},
+		{"(a b)", []contentChange{change(0, 5, 0, 5, "\n(d)")}, "(a b)\n(d)"},
+		{"(a\nb\nc)", []contentChange{change(0, 2, 2, 0, " ")}, "(a c)"},
+		{"(a b)", []contentChange{change(0, 1, 0, 2, "x"), change(0, 0, 0, 0, "y ")}, "y (x b)"},
+		{"(a b)", []contentChange{{Text: "(c)"}, change(0, 2, 0, 2, " d")}, "(c d)"},
+		// characters are counted in UTF-16 code units
+		{`("😀" é)`, []contentChange{change(0, 6, 0, 7, "e")}, `("😀" e)`},
+		{`("😀" é)`, []contentChange{change(0, 2, 0, 4, "x")}, `("x" é)`},
+		// positions after the end of a line or the text are clamped
+		{"(a)\n(b)", []contentChange{change(0, 10, 1, 0, "")}, "(a)(b)"},
+		{"(a)", []contentChange{change(5, 0, 6, 0, " ")}, "(a) "},
+	}
+
+	for _, tc := range testCases {
+		doc := newDocument(tc.In, parser.ParserOptions{})
+		assert.NoError(t, doc.apply(tc.Changes), tc.In)
+		assert.Equal(t, tc.Out, doc.text, tc.In)
+		assert.Equal(t, newDocument(tc.Out, parser.ParserOptions{}).lines, doc.lines, tc.In)
+	}
+
+	doc := newDocument("(a b)", parser.ParserOptions{})
+	assert.Error(t, doc.apply([]contentChange{change(0, 3, 0, 1, "")}))
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	src := `(define (square x) (* x x))
+(define pi 3.14)
+(define inc (lambda (x) (+ x 1)))
+(defn greet [name] (str "hi " name))
+(server {:port 8080})
+[1 2 3]
+"a string that is longer than forty characters"
+((f) 1)
+`
+	out := initialized(t, didOpen(src), request(1, "textDocument/documentSymbol", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)))
+
+	var res struct {
+		Result []documentSymbol `json:"result"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(out[1]), &res))
+
+	symbols := []string{}
+	for _, sym := range res.Result {
+		symbols = append(symbols, fmt.Sprintf("%s %s %d %v %v", sym.Name, sym.Detail, sym.Kind, sym.Range, sym.SelectionRange))
+	}
+	assert.Equal(t, []string{
+		`square define 12 {{0 0} {0 27}} {{0 9} {0 15}}`,
+		`pi define 13 {{1 0} {1 16}} {{1 8} {1 10}}`,
+		`inc define 12 {{2 0} {2 33}} {{2 8} {2 11}}`,
+		`greet defn 12 {{3 0} {3 36}} {{3 6} {3 11}}`,
+		`server server 19 {{4 0} {4 21}} {{4 1} {4 7}}`,
+		`[1 2 3] list 18 {{5 0} {5 7}} {{5 0} {5 7}}`,
+		`"a string that is longer than forty char... string 15 {{6 0} {6 47}} {{6 0} {6 47}}`,
+		`((f) 1) expression 18 {{7 0} {7 7}} {{7 0} {7 7}}`,
+	}, symbols)
+}
+
+func TestSelectionRanges(t *testing.T) {
+	src := "(define (f x)\n  (g [1 \"two\" x]))"
+
+	testCases := []struct {
+		Position Position
+		Ranges   []string
+	}{
+		{
+			Position{Line: 1, Character: 9},
+			[]string{`{{1 8} {1 13}}`, `{{1 6} {1 15}}`, `{{1 5} {1 16}}`, `{{1 3} {1 16}}`, `{{1 2} {1 17}}`, `{{0 1} {1 17}}`, `{{0 0} {1 18}}`},
+		},
+		{
+			// the cursor after a word
+			Position{Line: 0, Character: 7},
+			[]string{`{{0 1} {0 7}}`, `{{0 1} {1 17}}`, `{{0 0} {1 18}}`},
+		},
+		{
+			// the cursor on a bracket
+			Position{Line: 1, Character: 5},
+			[]string{`{{1 5} {1 16}}`, `{{1 3} {1 16}}`, `{{1 2} {1 17}}`, `{{0 1} {1 17}}`, `{{0 0} {1 18}}`},
+		},
+		{
+			// whitespace within a form
+			Position{Line: 1, Character: 0},
+			[]string{`{{0 1} {1 17}}`, `{{0 0} {1 18}}`},
+		},
+	}
+
+	for _, tc := range testCases {
+		params, _ := json.Marshal(map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"positions":    []Position{tc.Position},
+		})
+		out := initialized(t, didOpen(src), request(1, "textDocument/selectionRange", string(params)))
+
+		var res struct {
+			Result []selectionRange `json:"result"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(out[1]), &res))
+
+		ranges := []string{}
+		for sel := &res.Result[0]; sel != nil; sel = sel.Parent {
+			ranges = append(ranges, fmt.Sprintf("%v", sel.Range))
+		}
+		assert.Equal(t, tc.Ranges, ranges, "%v", tc.Position)
+	}
+}
+
+func TestFoldingRanges(t *testing.T) {
+	src := `; a comment
+; that spans two lines
+(define (f x)
+  (let ((y 1)
+        (z 2))
+    [x y
+     z]))
+#| block
+comment |#
+(g)
+(h
+  ; comment
+  1`
+	options := Options{Parser: parser.SchemeOptions()}
+	out, err := session(options,
+		request(0, "initialize", `{}`),
+		didOpen(src),
+		request(1, "textDocument/foldingRange", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[`+
+		`{"startLine":2,"endLine":6},`+
+		`{"startLine":3,"endLine":6},`+
+		`{"startLine":5,"endLine":6},`+
+		`{"startLine":10,"endLine":12},`+
+		`{"startLine":0,"endLine":1,"kind":"comment"},`+
+		`{"startLine":7,"endLine":8,"kind":"comment"}`+
+		`]}`, out[2])
+}
+
+func TestFormatting(t *testing.T) {
+	out := initialized(t,
+		didOpen("(a   b)\n"),
+		request(1, "textDocument/formatting", fmt.Sprintf(`{"textDocument":{"uri":%q},"options":{"tabSize":2,"insertSpaces":true}}`, uri)),
+		notification("textDocument/didChange", fmt.Sprintf(`{"textDocument":{"uri":%q,"version":2},"contentChanges":[{"text":"(a b)\n"}]}`, uri)),
+		request(2, "textDocument/formatting", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+		notification("textDocument/didChange", fmt.Sprintf(`{"textDocument":{"uri":%q,"version":3},"contentChanges":[{"text":"(a b"}]}`, uri)),
+		request(3, "textDocument/formatting", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+		request(4, "textDocument/formatting", `{"textDocument":{"uri":"file:///unknown.sexp"}}`),
+	)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[{"range":{"start":{"line":0,"character":0},"end":{"line":1,"character":0}},"newText":"(a b)\n"}]}`, out[1])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"result":[]}`, out[3])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":3,"error":{"code":-32803,"message":"syntax error: unexpected EOF (around (line: 1) (column 5))"}}`, out[5])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":4,"error":{"code":-32602,"message":"unknown document: file:///unknown.sexp"}}`, out[6])
+}
+
+func TestHover(t *testing.T) {
+	src := "(server {:port 8080}\n  \"😀\" (tags [:a :b]))"
+
+	testCases := []struct {
+		Position Position
+		Out      string
+	}{
+		{Position{Line: 0, Character: 2}, `{"contents":{"kind":"markdown","value":"**symbol** ` + "`server`" + `"},"range":{"start":{"line":0,"character":1},"end":{"line":0,"character":7}}}`},
+		{Position{Line: 0, Character: 8}, `{"contents":{"kind":"markdown","value":"**map** with 1 pair"},"range":{"start":{"line":0,"character":8},"end":{"line":0,"character":20}}}`},
+		{Position{Line: 0, Character: 15}, `{"contents":{"kind":"markdown","value":"**int** ` + "`8080`" + `"},"range":{"start":{"line":0,"character":15},"end":{"line":0,"character":19}}}`},
+		{Position{Line: 1, Character: 3}, `{"contents":{"kind":"markdown","value":"**string** ` + "`\\\"😀\\\"`" + `"},"range":{"start":{"line":1,"character":2},"end":{"line":1,"character":6}}}`},
+		{Position{Line: 1, Character: 13}, `{"contents":{"kind":"markdown","value":"**list** with 2 elements"},"range":{"start":{"line":1,"character":13},"end":{"line":1,"character":20}}}`},
+		{Position{Line: 1, Character: 0}, `{"contents":{"kind":"markdown","value":"**expression** with 4 elements"},"range":{"start":{"line":0,"character":0},"end":{"line":1,"character":22}}}`},
+	}
+
+	for _, tc := range testCases {
+		params, _ := json.Marshal(map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"position":     tc.Position,
+		})
+		out := initialized(t, didOpen(src), request(1, "textDocument/hover", string(params)))
+		assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":`+tc.Out+`}`, out[1], "%v", tc.Position)
+	}
+
+	out := initialized(t, didOpen("(a)  (b)"), request(1, "textDocument/hover", fmt.Sprintf(`{"textDocument":{"uri":%q},"position":{"line":0,"character":4}}`, uri)))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":null}`, out[1])
+
+	// a string that begins with a newline
+	out = initialized(t, didOpen("(a \"\nb\")"), request(1, "textDocument/hover", fmt.Sprintf(`{"textDocument":{"uri":%q},"position":{"line":1,"character":0}}`, uri)))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":{"contents":{"kind":"markdown","value":"**string** `+"`\\\"\\\\nb\\\"`"+`"},"range":{"start":{"line":0,"character":3},"end":{"line":1,"character":2}}}}`, out[1])
+}
+
+func TestSemanticTokens(t *testing.T) {
+	out := initialized(t,
+		didOpen("(a \"😀\"\n  [:b 1]) (c"),
+		request(1, "textDocument/semanticTokens/full", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, uri)),
+	)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":{"data":[0,1,1,6,0,0,2,4,1,0,1,3,2,4,0,0,3,1,2,0,0,5,1,6,0]}}`, out[1])
+}
@@ -0,0 +1,209 @@
+package macro
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// protect renames the variables that a lambda, define, let, let* or letrec
+// form binds when a macro used within the form introduces a free symbol with
+// the same name, otherwise the symbol would refer to the local variable
+// instead of the binding visible where the macro is defined. With inc
+// expanding into (+ x 1), (let ((+ -)) (inc 10)) becomes
+// (let ((+%1 -)) (inc 10)).
+func (e *Expander) protect(n *ast.Node) *ast.Node {
+	name, _ := headName(n)
+	args := n.List()[1:]
+	if len(args) < 2 || n.Tail() != nil {
+		return n
+	}
+
+	names := []string{}
+	switch name {
+	case "lambda":
+		names = symbols(args[0])
+	case "define":
+		if args[0].Type() != ast.NodeTypeExpression || len(args[0].List()) == 0 {
+			return n
+		}
+		names = symbols(args[0])[1:]
+	case "let", "let*", "letrec":
+		bindings := args[0]
+		if bindings.Type() == ast.NodeTypeSymbol {
+			// named let
+			names = append(names, bindings.Value().(string))
+			bindings = args[1]
+		}
+		if !bindings.IsVector() {
+			return n
+		}
+		for _, binding := range bindings.List() {
+			if binding.IsVector() && len(binding.List()) > 0 {
+				names = append(names, symbols(binding.List()[0])...)
+			}
+		}
+	default:
+		return n
+	}
+
+	renames := map[string]string{}
+	for _, name := range names {
+		if _, ok := renames[name]; ok || !e.captures(args, name) {
+			continue
+		}
+		e.count++
+		renames[name] = fmt.Sprintf("%s%%%d", name, e.count)
+	}
+	if len(renames) == 0 {
+		return n
+	}
+
+	out := newVector(n, n.Token())
+	out.SetEndToken(n.EndToken())
+	_ = out.Push(n.List()[0].Copy())
+
+	body := args[1:]
+	switch name {
+	case "lambda":
+		_ = out.Push(rename(args[0], renames))
+	case "define":
+		// the name of the function is bound where the define is
+		_ = out.Push(rebuild(args[0], func(i int, child *ast.Node) *ast.Node {
+			if i == 0 {
+				return child.Copy()
+			}
+			return rename(child, renames)
+		}))
+	default:
+		bindings := args[0]
+		if bindings.Type() == ast.NodeTypeSymbol {
+			_ = out.Push(rename(bindings, renames))
+			bindings, body = args[1], args[2:]
+		}
+		// the values of a let are out of the scope of its variables, the
+		// values of a let* see the variables bound before them
+		visible := map[string]string{}
+		if name == "letrec" {
+			visible = renames
+		}
+		_ = out.Push(rebuild(bindings, func(_ int, binding *ast.Node) *ast.Node {
+			if !binding.IsVector() || len(binding.List()) == 0 {
+				return rename(binding, visible)
+			}
+			v := rebuild(binding, func(i int, child *ast.Node) *ast.Node {
+				if i == 0 {
+					return rename(child, renames)
+				}
+				return rename(child, visible)
+			})
+			if name == "let*" {
+				for _, s := range symbols(binding.List()[0]) {
+					if renamed, ok := renames[s]; ok {
+						visible[s] = renamed
+					}
+				}
+			}
+			return v
+		}))
+	}
+	for _, child := range body {
+		_ = out.Push(rename(child, renames))
+	}
+	return out
+}
+
+// captures returns true if the nodes use a macro that introduces the given
+// free symbol, directly or through the macros it expands into
+func (e *Expander) captures(nodes []*ast.Node, name string) bool {
+	found := false
+	for _, node := range nodes {
+		ast.Walk(node, func(n *ast.Node) bool {
+			head, ok := headName(n)
+			if found || head == "quote" {
+				return false
+			}
+			if m := e.macros[head]; ok && m != nil && e.introduces(m, name, map[string]bool{}) {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// introduces returns true if the expansions of a macro can contain the given
+// free symbol
+func (e *Expander) introduces(m *Macro, name string, seen map[string]bool) bool {
+	if m.free[name] {
+		return true
+	}
+	seen[m.Name] = true
+	for s := range m.free {
+		if other := e.macros[s]; other != nil && !seen[s] && e.introduces(other, name, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbols returns the names of the symbols of a symbol or a parameter list
+func symbols(n *ast.Node) []string {
+	if n == nil {
+		return nil
+	}
+	if n.Type() == ast.NodeTypeSymbol {
+		return []string{n.Value().(string)}
+	}
+	names := []string{}
+	if n.Type() == ast.NodeTypeExpression || n.Type() == ast.NodeTypeList {
+		for _, child := range n.List() {
+			if child.Type() == ast.NodeTypeSymbol {
+				names = append(names, child.Value().(string))
+			}
+		}
+		names = append(names, symbols(n.Tail())...)
+	}
+	return names
+}
+
+// rebuild returns a copy of a vector with each child replaced with the
+// result of f, the tail is given with i set to -1
+func rebuild(n *ast.Node, f func(i int, child *ast.Node) *ast.Node) *ast.Node {
+	if !n.IsVector() {
+		return f(-1, n)
+	}
+	out := newVector(n, n.Token())
+	out.SetEndToken(n.EndToken())
+	for i, child := range n.List() {
+		_ = out.Push(f(i, child))
+	}
+	if tail := n.Tail(); tail != nil {
+		out.SetTail(f(-1, tail))
+	}
+	return out
+}
+
+// rename returns a copy of a node with the given symbols renamed, quoted
+// forms are not modified
+func rename(n *ast.Node, renames map[string]string) *ast.Node {
+	if n.Type() == ast.NodeTypeSymbol {
+		renamed, ok := renames[n.Value().(string)]
+		if !ok {
+			return n.Copy()
+		}
+		var tok *lexer.Token
+		if t := n.Token(); t != nil {
+			pos := t.Pos()
+			tok = lexer.NewToken(t.Type(), renamed, &pos)
+		}
+		return ast.NewNode(tok, ast.NewSymbolValue(renamed))
+	}
+	if name, _ := headName(n); !n.IsVector() || name == "quote" || len(renames) == 0 {
+		return n.Copy()
+	}
+	return rebuild(n, func(_ int, child *ast.Node) *ast.Node {
+		return rename(child, renames)
+	})
+}
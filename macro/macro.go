@@ -0,0 +1,241 @@
+// Package macro implements a syntax-rules macro expander for S-expression
+// trees.
+//
+// Macros are defined with the define-syntax form of Scheme:
+//
+//	(define-syntax my-or
+//	  (syntax-rules ()
+//	    ((_) #f)
+//	    ((_ e) e)
+//	    ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))
+//
+// Each rule is a pattern and a template. The first element of a pattern is
+// ignored, symbols in a pattern are pattern variables, except for _, the
+// ellipsis and the literals, which must match the same symbol. A subpattern
+// followed by ... matches zero or more elements and a dotted pattern, like
+// (_ a . rest), matches the remaining elements. Other values must match equal
+// values. The ellipsis can be replaced with another symbol by writing it
+// before the literals, like in (syntax-rules etc (literals...) rules...).
+//
+// Uses of a macro are replaced with the template of the first rule that
+// matches them, over and over until no macro uses are left. Definitions
+// apply to the forms that follow them, they are removed from the tree and
+// quoted forms are not expanded.
+//
+// Expansions are hygienic in the sense that the variables a template binds
+// with define, lambda, let, let* or letrec are renamed on each expansion, so
+// they can't capture the variables of the code passed to the macro, like the
+// t of (my-or #f t). Other symbols introduced by a template refer to the
+// bindings visible where the macro is defined: the local variables that would
+// capture them are renamed, like the + of (let ((+ -)) (inc 10)) when inc
+// expands into (+ x 1).
+//
+// The nodes that come from the code passed to a macro keep their positions,
+// the nodes introduced by a template take the position of the macro use, so
+// errors found after expanding point to the source the user wrote.
+package macro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// Error messages
+var (
+	ErrInvalidDefinition = errors.New("invalid macro definition")
+	ErrNoMatch           = errors.New("no syntax rule matches")
+	ErrInvalidTemplate   = errors.New("invalid template")
+	ErrExpansionLimit    = errors.New("expansion limit exceeded")
+)
+
+// MaxDepth is the number of nested expansions after which Expand gives up,
+// it stops macros that expand into themselves forever.
+const MaxDepth = 1000
+
+// Error is an error found while defining or expanding a macro, it holds the
+// position of the form that failed.
+type Error struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("macro: %v", e.Err)
+	}
+	return fmt.Sprintf("macro: line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func errorAt(n *ast.Node, err error) error {
+	e := &Error{Err: err}
+	if tok := n.Token(); tok != nil {
+		e.Line, e.Column = tok.Pos().Line, tok.Pos().Column
+	}
+	return e
+}
+
+// Expander holds macro definitions and expands their uses, definitions are
+// kept between calls to Expand.
+type Expander struct {
+	macros map[string]*Macro
+	count  int
+}
+
+// NewExpander returns an expander without definitions
+func NewExpander() *Expander {
+	return &Expander{macros: map[string]*Macro{}}
+}
+
+// Expand expands a tree with a new Expander
+func Expand(n *ast.Node) (*ast.Node, error) {
+	return NewExpander().Expand(n)
+}
+
+// Macro returns the macro defined with the given name, or nil
+func (e *Expander) Macro(name string) *Macro {
+	return e.macros[name]
+}
+
+// Define reads a (define-syntax name (syntax-rules ...)) form and adds the
+// macro to the expander
+func (e *Expander) Define(n *ast.Node) error {
+	m, err := parseDefinition(n)
+	if err != nil {
+		return err
+	}
+	e.macros[m.Name] = m
+	return nil
+}
+
+// Expand returns a copy of the tree with every macro use expanded and every
+// define-syntax form removed, the given tree is not modified.
+func (e *Expander) Expand(n *ast.Node) (*ast.Node, error) {
+	return e.ExpandContext(context.Background(), n, nil)
+}
+
+// ExpandContext is like Expand, but stops when the context is done. If check
+// is not nil it's called after each expansion with the macro use and the
+// number of nodes it was replaced with, an error returned by check stops the
+// expansion; it lets callers limit the work done for code that is not
+// trusted.
+func (e *Expander) ExpandContext(ctx context.Context, n *ast.Node, check func(use *ast.Node, size int) error) (*ast.Node, error) {
+	return e.expand(&limits{ctx: ctx, check: check}, n, 0)
+}
+
+// limits are the checks done on each expansion
+type limits struct {
+	ctx   context.Context
+	check func(use *ast.Node, size int) error
+}
+
+func (l *limits) expanded(use, out *ast.Node) error {
+	select {
+	case <-l.ctx.Done():
+		return errorAt(use, l.ctx.Err())
+	default:
+	}
+	if l.check == nil {
+		return nil
+	}
+	size := 0
+	ast.Walk(out, func(*ast.Node) bool {
+		size++
+		return true
+	})
+	return l.check(use, size)
+}
+
+func (e *Expander) expand(l *limits, n *ast.Node, depth int) (*ast.Node, error) {
+	if depth > MaxDepth {
+		return nil, errorAt(n, fmt.Errorf("%w: %d nested expansions", ErrExpansionLimit, MaxDepth))
+	}
+	if !n.IsVector() {
+		return n.Copy(), nil
+	}
+
+	if name, ok := headName(n); ok {
+		if name == "quote" {
+			return n.Copy(), nil
+		}
+		if m := e.macros[name]; m != nil {
+			e.count++
+			out, err := m.expand(n, e.count)
+			if err != nil {
+				return nil, err
+			}
+			if err := l.expanded(n, out); err != nil {
+				return nil, err
+			}
+			return e.expand(l, out, depth+1)
+		}
+		n = e.protect(n)
+	}
+
+	out := newVector(n, n.Token())
+	out.SetEndToken(n.EndToken())
+	for _, child := range n.List() {
+		if name, _ := headName(child); name == "define-syntax" {
+			if err := e.Define(child); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c, err := e.expand(l, child, depth)
+		if err != nil {
+			return nil, err
+		}
+		if err := out.Push(c); err != nil {
+			return nil, err
+		}
+	}
+	if tail := n.Tail(); tail != nil {
+		c, err := e.expand(l, tail, depth)
+		if err != nil {
+			return nil, err
+		}
+		out.SetTail(c)
+	}
+	return out, nil
+}
+
+// headName returns the name of the symbol at the head of an expression
+func headName(n *ast.Node) (string, bool) {
+	if n.Type() != ast.NodeTypeExpression || len(n.List()) == 0 {
+		return "", false
+	}
+	head := n.List()[0]
+	if head.Type() != ast.NodeTypeSymbol {
+		return "", false
+	}
+	return head.Value().(string), true
+}
+
+// isSymbol returns true if the node is the given symbol
+func isSymbol(n *ast.Node, name string) bool {
+	return n != nil && n.Type() == ast.NodeTypeSymbol && n.Value().(string) == name
+}
+
+// newVector returns an empty vector of the same type as the given node
+func newVector(n *ast.Node, tok *lexer.Token) *ast.Node {
+	switch n.Type() {
+	case ast.NodeTypeList:
+		return ast.NewList(tok)
+	case ast.NodeTypeMap:
+		return ast.NewMap(tok)
+	case ast.NodeTypeSet:
+		return ast.NewSet(tok)
+	case ast.NodeTypeTagged:
+		return ast.NewTagged(tok)
+	}
+	return ast.NewExpression(tok)
+}
@@ -0,0 +1,258 @@
+package macro
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func parse(t *testing.T, in string) *ast.Node {
+	p := parser.NewParser(bytes.NewReader([]byte(in)))
+	p.SetOptions(parser.SchemeOptions())
+	assert.NoError(t, p.Parse())
+	return p.RootNode()
+}
+
+func TestExpand(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			`(define-syntax unless
+			   (syntax-rules ()
+			     ((_ test body ...) (if test #f (begin body ...)))))
+			 (unless (> x 1) (print x) x)`,
+			`(if (> x 1) false (begin (print x) x))`,
+		},
+		{
+			`(define-syntax my-or
+			   (syntax-rules ()
+			     ((_) #f)
+			     ((_ e) e)
+			     ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))
+			 (my-or) (my-or a) (my-or a b c)`,
+			`false a (let ((t%3 a)) (if t%3 t%3 (let ((t%4 b)) (if t%4 t%4 c))))`,
+		},
+		{
+			// literals must match the same symbol
+			`(define-syntax for
+			   (syntax-rules (in from)
+			     ((_ x in xs body ...) (each (lambda (x) body ...) xs))
+			     ((_ x from a body ...) (range a (lambda (x) body ...)))))
+			 (for y in ys (f y)) (for i from 1 (g i))`,
+			`(each (lambda (y) (f y)) ys) (range 1 (lambda (i) (g i)))`,
+		},
+		{
+			// nested ellipses
+			`(define-syntax my-let*
+			   (syntax-rules ()
+			     ((_ () body ...) (let () body ...))
+			     ((_ ((x v) rest ...) body ...) (let ((x v)) (my-let* (rest ...) body ...)))))
+			 (my-let* ((a 1) (b a)) (+ a b))`,
+			`(let ((a 1)) (let ((b a)) (let () (+ a b))))`,
+		},
+		{
+			`(define-syntax cond-list
+			   (syntax-rules (=>)
+			     ((_ (k => v ...) ...) [(k v ...) ...])))
+			 (cond-list (a => 1 2) (b =>))`,
+			`[(a 1 2) (b)]`,
+		},
+		{
+			// dotted patterns and templates
+			`(define-syntax call
+			   (syntax-rules ()
+			     ((_ f . args) (f . args))))
+			 (call g 1 2) (call h)`,
+			`(g 1 2) (h)`,
+		},
+		{
+			// custom ellipsis and vectors
+			`(define-syntax vec
+			   (syntax-rules etc ()
+			     ((_ [x etc]) (list x etc))))
+			 (vec [1 2 3]) '(vec [1])`,
+			`(list 1 2 3) (quote (vec [1]))`,
+		},
+		{
+			// binders introduced by define, lambda and named lets
+			`(define-syntax counter
+			   (syntax-rules ()
+			     ((_ name n) (define (name) (let loop ((i 0)) (if (< i n) (loop (+ i 1)) i))))))
+			 (counter ten 10)`,
+			`(define (ten) (let loop%1 ((i%1 0)) (if (< i%1 10) (loop%1 (+ i%1 1)) i%1)))`,
+		},
+	}
+
+	for _, tc := range testCases {
+		out, err := Expand(parse(t, tc.In))
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, string(ast.EncodeDocument(out)), tc.In)
+		}
+	}
+}
+
+func TestHygiene(t *testing.T) {
+	root := parse(t, `
+		(define-syntax swap!
+		  (syntax-rules ()
+		    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+		(swap! tmp other)
+	`)
+	out, err := Expand(root)
+	assert.NoError(t, err)
+	assert.Equal(t, `(let ((tmp%1 tmp)) (set! tmp other) (set! other tmp%1))`, string(ast.EncodeDocument(out)))
+
+	// the given tree is not modified
+	assert.Equal(t, 2, len(root.List()))
+}
+
+func TestFreeSymbols(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			`(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+			 (let ((+ -)) (inc (+ 5 1)))`,
+			`(let ((+%1 -)) (+ (+%1 5 1) 1))`,
+		},
+		{
+			// the values of a let are out of the scope of its variables
+			`(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+			 (let loop ((+ +) (n 0)) (loop + (inc n)))`,
+			`(let loop ((+%1 +) (n 0)) (loop +%1 (+ n 1)))`,
+		},
+		{
+			`(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+			 (let* ((a +) (+ -) (b +)) (inc '+))`,
+			`(let* ((a +) (+%1 -) (b +%1)) (+ (quote +) 1))`,
+		},
+		{
+			// through the macros a template uses
+			`(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+			 (define-syntax inc2 (syntax-rules () ((_ x) (inc (inc x)))))
+			 (define (f + x) (inc2 x)) (lambda (+) (inc2 +))`,
+			`(define (f +%1 x) (+ (+ x 1) 1)) (lambda (+%5) (+ (+ +%5 1) 1))`,
+		},
+		{
+			// variables that don't capture anything keep their names
+			`(define-syntax inc (syntax-rules () ((_ x) (+ x 1))))
+			 (let ((x 1) (- +)) (inc x))`,
+			`(let ((x 1) (- +)) (+ x 1))`,
+		},
+	}
+
+	for _, tc := range testCases {
+		out, err := Expand(parse(t, tc.In))
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, string(ast.EncodeDocument(out)), tc.In)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	root := parse(t, `
+(define-syntax twice
+  (syntax-rules ()
+    ((_ e) (begin e e))))
+
+   (twice (f x))
+`)
+	out, err := Expand(root)
+	assert.NoError(t, err)
+
+	expr := out.List()[0]
+	assert.Equal(t, 6, expr.Token().Pos().Line)
+	assert.Equal(t, 4, expr.Token().Pos().Column)
+
+	// introduced by the template, takes the position of the use
+	begin := expr.List()[0]
+	assert.Equal(t, "begin", begin.Value())
+	assert.Equal(t, 6, begin.Token().Pos().Line)
+	assert.Equal(t, 4, begin.Token().Pos().Column)
+
+	// written by the user
+	f := expr.List()[1].List()[0]
+	assert.Equal(t, "f", f.Value())
+	assert.Equal(t, 6, f.Token().Pos().Line)
+	assert.Equal(t, 12, f.Token().Pos().Column)
+}
+
+func TestExpanderDefinitions(t *testing.T) {
+	e := NewExpander()
+
+	_, err := e.Expand(parse(t, `(define-syntax id (syntax-rules () ((_ x) x)))`))
+	assert.NoError(t, err)
+	assert.NotNil(t, e.Macro("id"))
+	assert.Nil(t, e.Macro("other"))
+
+	out, err := e.Expand(parse(t, `(id 1)`))
+	assert.NoError(t, err)
+	assert.Equal(t, `1`, string(ast.EncodeDocument(out)))
+
+	assert.NoError(t, e.Define(parse(t, `(define-syntax two (syntax-rules () ((_) 2)))`).List()[0]))
+	out, err = e.Expand(parse(t, `(id (two))`))
+	assert.NoError(t, err)
+	assert.Equal(t, `2`, string(ast.EncodeDocument(out)))
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Err error
+		Msg string
+	}{
+		{`(define-syntax)`, ErrInvalidDefinition, "macro: line 1, column 1: invalid macro definition: expecting (define-syntax name (syntax-rules ...))"},
+		{`(define-syntax m (lambda (x) x))`, ErrInvalidDefinition, "macro: line 1, column 18: invalid macro definition: expecting (syntax-rules (literals...) rules...)"},
+		{`(define-syntax m (syntax-rules (1)))`, ErrInvalidDefinition, "macro: line 1, column 33: invalid macro definition: expecting a literal symbol, got 1"},
+		{`(define-syntax m (syntax-rules () (x)))`, ErrInvalidDefinition, "macro: line 1, column 35: invalid macro definition: expecting (pattern template)"},
+		{`(define-syntax m (syntax-rules () ((_ ... x) x)))`, ErrInvalidDefinition, "macro: line 1, column 39: invalid macro definition: misplaced ..."},
+		{`(define-syntax m (syntax-rules () ((_ x ... y ...) x)))`, ErrInvalidDefinition, "macro: line 1, column 36: invalid macro definition: only one ... per list is supported"},
+		{"(define-syntax m (syntax-rules () ((_ x) x)))\n(m 1 2)", ErrNoMatch, "macro: line 2, column 1: no syntax rule matches: (m 1 2)"},
+		{"(define-syntax m (syntax-rules () ((_ x ...) x)))\n(m 1 2)", ErrInvalidTemplate, "macro: line 2, column 1: invalid template: x must be followed by ..."},
+		{"(define-syntax m (syntax-rules () ((_ x) (x ...))))\n(m 1)", ErrInvalidTemplate, "macro: line 2, column 1: invalid template: ... follows a template without pattern variables"},
+		{"(define-syntax m (syntax-rules () ((_) (m))))\n(m)", ErrExpansionLimit, "macro: line 2, column 1: expansion limit exceeded: 1000 nested expansions"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Expand(parse(t, tc.In))
+		assert.True(t, errors.Is(err, tc.Err), "%q: %v", tc.In, err)
+		if assert.Error(t, err, tc.In) {
+			assert.Equal(t, tc.Msg, err.Error(), tc.In)
+		}
+	}
+}
+
+func TestExpandContext(t *testing.T) {
+	e := NewExpander()
+	doc := parse(t, "(define-syntax twice (syntax-rules () ((_ x) (list x x))))\n(twice (twice (twice 1)))")
+
+	// check is called once per expansion with the size of its output
+	sizes := []int{}
+	out, err := e.ExpandContext(context.Background(), doc, func(use *ast.Node, size int) error {
+		sizes = append(sizes, size)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `(list (list (list 1 1) (list 1 1)) (list (list 1 1) (list 1 1)))`, string(ast.EncodeDocument(out)))
+	assert.Equal(t, []int{12, 8, 4, 4, 8, 4, 4}, sizes)
+
+	errLimit := errors.New("limit")
+	_, err = e.ExpandContext(context.Background(), doc, func(use *ast.Node, size int) error {
+		return errLimit
+	})
+	assert.Equal(t, errLimit, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.ExpandContext(ctx, doc, nil)
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	assert.Equal(t, "macro: line 2, column 1: context canceled", err.Error())
+}
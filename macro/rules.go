@@ -0,0 +1,497 @@
+package macro
+
+import (
+	"fmt"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// Macro is a macro defined with syntax-rules
+type Macro struct {
+	Name string
+
+	ellipsis string
+	literals map[string]bool
+	rules    []rule
+
+	// free are the symbols the templates introduce without binding them,
+	// they refer to the bindings visible where the macro is defined
+	free map[string]bool
+}
+
+type rule struct {
+	pattern  *ast.Node
+	template *ast.Node
+
+	// binders are the symbols the template binds, they are renamed on each
+	// expansion
+	binders []string
+}
+
+// match holds what a pattern variable matched: a node or, for the variables
+// followed by an ellipsis, a sequence of matches
+type match struct {
+	node *ast.Node
+	seq  []*match
+}
+
+type bindings map[string]*match
+
+// parseDefinition reads a (define-syntax name (syntax-rules ...)) form
+func parseDefinition(n *ast.Node) (*Macro, error) {
+	invalid := func(n *ast.Node, format string, args ...interface{}) error {
+		return errorAt(n, fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidDefinition}, args...)...))
+	}
+
+	args := n.List()[1:]
+	if len(args) != 2 || args[0].Type() != ast.NodeTypeSymbol {
+		return nil, invalid(n, "expecting (define-syntax name (syntax-rules ...))")
+	}
+	if name, _ := headName(args[1]); name != "syntax-rules" {
+		return nil, invalid(args[1], "expecting (syntax-rules (literals...) rules...)")
+	}
+
+	m := &Macro{
+		Name:     args[0].Value().(string),
+		ellipsis: "...",
+		literals: map[string]bool{},
+		free:     map[string]bool{},
+	}
+
+	spec := args[1].List()[1:]
+	if len(spec) > 0 && spec[0].Type() == ast.NodeTypeSymbol {
+		m.ellipsis, spec = spec[0].Value().(string), spec[1:]
+	}
+	if len(spec) == 0 || (spec[0].Type() != ast.NodeTypeExpression && spec[0].Type() != ast.NodeTypeList) {
+		return nil, invalid(args[1], "expecting a list of literals")
+	}
+	for _, literal := range spec[0].List() {
+		if literal.Type() != ast.NodeTypeSymbol {
+			return nil, invalid(literal, "expecting a literal symbol, got %s", ast.Encode(literal))
+		}
+		m.literals[literal.Value().(string)] = true
+	}
+
+	for _, r := range spec[1:] {
+		if r.Type() != ast.NodeTypeExpression || len(r.List()) != 2 || r.Tail() != nil {
+			return nil, invalid(r, "expecting (pattern template)")
+		}
+		pattern, template := r.List()[0], r.List()[1]
+		if pattern.Type() != ast.NodeTypeExpression || len(pattern.List()) == 0 {
+			return nil, invalid(pattern, "expecting a pattern like (_ args...)")
+		}
+		if len(pattern.List()) > 1 && m.isEllipsis(pattern.List()[1]) {
+			return nil, invalid(pattern.List()[1], "misplaced %s", m.ellipsis)
+		}
+		if err := m.checkPattern(pattern); err != nil {
+			return nil, err
+		}
+
+		vars := map[string]bool{}
+		for _, v := range m.patternVars(pattern.List()[1:], pattern.Tail()) {
+			vars[v] = true
+		}
+		r := rule{
+			pattern:  pattern,
+			template: template,
+			binders:  m.binders(template, vars),
+		}
+		m.rules = append(m.rules, r)
+
+		bound := map[string]bool{}
+		for _, name := range r.binders {
+			bound[name] = true
+		}
+		ast.Walk(template, func(n *ast.Node) bool {
+			if n.Type() == ast.NodeTypeSymbol && !m.isEllipsis(n) {
+				if name := n.Value().(string); !vars[name] && !bound[name] {
+					m.free[name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	return m, nil
+}
+
+// checkPattern makes sure that a pattern has at most one ellipsis per list and
+// that lists with an ellipsis are not dotted
+func (m *Macro) checkPattern(p *ast.Node) error {
+	if !p.IsVector() {
+		return nil
+	}
+	children := p.List()
+	ellipses := 0
+	for i, child := range children {
+		if m.isEllipsis(child) {
+			if i == 0 || m.isEllipsis(children[i-1]) {
+				return errorAt(child, fmt.Errorf("%w: misplaced %s", ErrInvalidDefinition, m.ellipsis))
+			}
+			ellipses++
+			continue
+		}
+		if err := m.checkPattern(child); err != nil {
+			return err
+		}
+	}
+	if ellipses > 1 || (ellipses == 1 && p.Tail() != nil) {
+		return errorAt(p, fmt.Errorf("%w: only one %s per list is supported", ErrInvalidDefinition, m.ellipsis))
+	}
+	if tail := p.Tail(); tail != nil {
+		return m.checkPattern(tail)
+	}
+	return nil
+}
+
+func (m *Macro) isEllipsis(n *ast.Node) bool {
+	return isSymbol(n, m.ellipsis)
+}
+
+// isVar returns true if the node is a pattern variable
+func (m *Macro) isVar(n *ast.Node) bool {
+	if n.Type() != ast.NodeTypeSymbol {
+		return false
+	}
+	name := n.Value().(string)
+	return name != "_" && name != m.ellipsis && !m.literals[name]
+}
+
+// patternVars returns the names of the pattern variables of a list of
+// subpatterns and their tail
+func (m *Macro) patternVars(patterns []*ast.Node, tail *ast.Node) []string {
+	vars := []string{}
+	for _, p := range append(patterns[:len(patterns):len(patterns)], tail) {
+		if p == nil {
+			continue
+		}
+		ast.Walk(p, func(n *ast.Node) bool {
+			if m.isVar(n) {
+				vars = append(vars, n.Value().(string))
+			}
+			return true
+		})
+	}
+	return vars
+}
+
+// expand replaces a macro use with the template of the first rule that
+// matches it, id makes the names of the renamed binders unique
+func (m *Macro) expand(n *ast.Node, id int) (*ast.Node, error) {
+	for _, r := range m.rules {
+		b := bindings{}
+		if !m.matchList(r.pattern.List()[1:], r.pattern.Tail(), n.List()[1:], n.Tail(), b) {
+			continue
+		}
+
+		x := &expansion{
+			Macro:   m,
+			use:     n,
+			renames: map[string]string{},
+		}
+		for _, name := range r.binders {
+			x.renames[name] = fmt.Sprintf("%s%%%d", name, id)
+		}
+		return x.template(r.template, b)
+	}
+	return nil, errorAt(n, fmt.Errorf("%w: %s", ErrNoMatch, ast.Encode(n)))
+}
+
+// match matches a node against a pattern and adds the pattern variables to b
+func (m *Macro) match(p, n *ast.Node, b bindings) bool {
+	switch {
+	case m.isVar(p):
+		b[p.Value().(string)] = &match{node: n}
+		return true
+	case isSymbol(p, "_"):
+		return true
+	case p.IsVector():
+		if p.Type() != n.Type() {
+			return false
+		}
+		return m.matchList(p.List(), p.Tail(), n.List(), n.Tail(), b)
+	}
+	return p.Type() == n.Type() && p.Encode() == n.Encode()
+}
+
+// matchList matches the elements and the tail of a vector against a list of
+// subpatterns and a tail pattern
+func (m *Macro) matchList(patterns []*ast.Node, ptail *ast.Node, nodes []*ast.Node, ntail *ast.Node, b bindings) bool {
+	ellipsis := -1
+	for i := range patterns {
+		if m.isEllipsis(patterns[i]) {
+			ellipsis = i - 1
+			break
+		}
+	}
+
+	if ellipsis < 0 {
+		if ptail == nil {
+			if len(nodes) != len(patterns) || ntail != nil {
+				return false
+			}
+		} else if len(nodes) < len(patterns) {
+			return false
+		}
+		for i := range patterns {
+			if !m.match(patterns[i], nodes[i], b) {
+				return false
+			}
+		}
+		if ptail == nil {
+			return true
+		}
+		return m.match(ptail, rest(nodes[len(patterns):], ntail), b)
+	}
+
+	before, sub, after := patterns[:ellipsis], patterns[ellipsis], patterns[ellipsis+2:]
+	count := len(nodes) - len(before) - len(after)
+	if count < 0 || ntail != nil {
+		return false
+	}
+	for i := range before {
+		if !m.match(before[i], nodes[i], b) {
+			return false
+		}
+	}
+	for i := range after {
+		if !m.match(after[i], nodes[len(before)+count+i], b) {
+			return false
+		}
+	}
+
+	vars := m.patternVars([]*ast.Node{sub}, nil)
+	seqs := make(map[string]*match, len(vars))
+	for _, v := range vars {
+		seqs[v] = &match{seq: []*match{}}
+	}
+	for _, n := range nodes[len(before) : len(before)+count] {
+		bi := bindings{}
+		if !m.match(sub, n, bi) {
+			return false
+		}
+		for _, v := range vars {
+			seqs[v].seq = append(seqs[v].seq, bi[v])
+		}
+	}
+	for v, s := range seqs {
+		b[v] = s
+	}
+	return true
+}
+
+// rest returns the elements that a dotted pattern matches, as an expression
+func rest(nodes []*ast.Node, tail *ast.Node) *ast.Node {
+	if len(nodes) == 0 && tail != nil {
+		return tail
+	}
+	n := ast.NewExpression(nil)
+	for _, node := range nodes {
+		_ = n.Push(node.Copy())
+	}
+	if tail != nil {
+		n.SetTail(tail.Copy())
+	}
+	return n
+}
+
+// binders returns the symbols a template binds with define, lambda, let, let*
+// or letrec, pattern variables are not included
+func (m *Macro) binders(template *ast.Node, vars map[string]bool) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(n *ast.Node) {
+		if n == nil || n.Type() != ast.NodeTypeSymbol || !m.isVar(n) {
+			return
+		}
+		name := n.Value().(string)
+		if vars[name] || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	addAll := func(n *ast.Node) {
+		if n.Type() == ast.NodeTypeSymbol {
+			add(n)
+			return
+		}
+		if n.Type() == ast.NodeTypeExpression || n.Type() == ast.NodeTypeList {
+			for _, child := range n.List() {
+				add(child)
+			}
+			add(n.Tail())
+		}
+	}
+
+	ast.Walk(template, func(n *ast.Node) bool {
+		name, ok := headName(n)
+		if !ok || len(n.List()) < 2 {
+			return true
+		}
+		args := n.List()[1:]
+		switch name {
+		case "define", "lambda":
+			addAll(args[0])
+		case "let", "let*", "letrec":
+			bindings := args[0]
+			if bindings.Type() == ast.NodeTypeSymbol && len(args) > 1 {
+				// named let
+				add(bindings)
+				bindings = args[1]
+			}
+			if bindings.IsVector() {
+				for _, binding := range bindings.List() {
+					if binding.IsVector() && len(binding.List()) > 0 {
+						add(binding.List()[0])
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	return names
+}
+
+// expansion is the expansion of a single macro use
+type expansion struct {
+	*Macro
+
+	use     *ast.Node
+	renames map[string]string
+}
+
+// token returns a token with the type of the given one, the given text and
+// the position of the macro use
+func (x *expansion) token(tok *lexer.Token, text string) *lexer.Token {
+	if tok == nil {
+		return nil
+	}
+	if use := x.use.Token(); use != nil {
+		pos := use.Pos()
+		return lexer.NewToken(tok.Type(), text, &pos)
+	}
+	return lexer.NewToken(tok.Type(), text, nil)
+}
+
+// retoken returns a copy of a template node that has the position of the
+// macro use
+func (x *expansion) retoken(t *ast.Node) *ast.Node {
+	out := t.Copy()
+	if tok := t.Token(); tok != nil {
+		out.SetToken(x.token(tok, tok.Text()))
+	}
+	return out
+}
+
+func (x *expansion) template(t *ast.Node, b bindings) (*ast.Node, error) {
+	if t.Type() == ast.NodeTypeSymbol {
+		name := t.Value().(string)
+		if v, ok := b[name]; ok {
+			if v.node == nil {
+				return nil, errorAt(x.use, fmt.Errorf("%w: %s must be followed by %s", ErrInvalidTemplate, name, x.ellipsis))
+			}
+			return v.node.Copy(), nil
+		}
+		if renamed, ok := x.renames[name]; ok {
+			return ast.NewNode(x.token(t.Token(), renamed), ast.NewSymbolValue(renamed)), nil
+		}
+	}
+
+	if !t.IsVector() {
+		return x.retoken(t), nil
+	}
+
+	out := newVector(t, nil)
+	if tok := t.Token(); tok != nil {
+		out.SetToken(x.token(tok, tok.Text()))
+	}
+	if tok := t.EndToken(); tok != nil {
+		out.SetEndToken(x.token(tok, tok.Text()))
+	}
+	children := t.List()
+	for i := 0; i < len(children); i++ {
+		depth := 0
+		for i+depth+1 < len(children) && x.isEllipsis(children[i+depth+1]) {
+			depth++
+		}
+		nodes, err := x.repeat(children[i], b, depth)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			if err := out.Push(n); err != nil {
+				return nil, err
+			}
+		}
+		i += depth
+	}
+
+	if tail := t.Tail(); tail != nil {
+		n, err := x.template(tail, b)
+		if err != nil {
+			return nil, err
+		}
+		if n.Type() == ast.NodeTypeExpression {
+			// (a . (b c)) is (a b c)
+			for _, child := range n.List() {
+				if err := out.Push(child); err != nil {
+					return nil, err
+				}
+			}
+			n = n.Tail()
+		}
+		out.SetTail(n)
+	}
+
+	return out, nil
+}
+
+// repeat expands a subtemplate followed by the given number of ellipses
+func (x *expansion) repeat(t *ast.Node, b bindings, depth int) ([]*ast.Node, error) {
+	if depth == 0 {
+		n, err := x.template(t, b)
+		if err != nil {
+			return nil, err
+		}
+		return []*ast.Node{n}, nil
+	}
+
+	vars := []string{}
+	count := -1
+	ast.Walk(t, func(n *ast.Node) bool {
+		if n.Type() != ast.NodeTypeSymbol {
+			return true
+		}
+		name := n.Value().(string)
+		if v, ok := b[name]; ok && v.node == nil {
+			vars = append(vars, name)
+			if count < 0 || len(v.seq) < count {
+				count = len(v.seq)
+			}
+		}
+		return true
+	})
+	if len(vars) == 0 {
+		return nil, errorAt(x.use, fmt.Errorf("%w: %s follows a template without pattern variables", ErrInvalidTemplate, x.ellipsis))
+	}
+
+	nodes := []*ast.Node{}
+	for i := 0; i < count; i++ {
+		bi := make(bindings, len(b))
+		for k, v := range b {
+			bi[k] = v
+		}
+		for _, name := range vars {
+			bi[name] = b[name].seq[i]
+		}
+		expanded, err := x.repeat(t, bi, depth-1)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, expanded...)
+	}
+	return nodes, nil
+}
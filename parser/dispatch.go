@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// DispatchFunc reads the syntax that begins with a # followed by a key
+// registered in ParserOptions.Dispatch, like #_ or #inst. The function
+// receives the # token and the token that matched the key is the next token
+// (see PeekToken and NextToken), keys are matched against the whole text of
+// the token that follows the # or against its first character, in which case
+// the token is split. The returned node is added to the tree, a nil node
+// means the syntax produces no node, like a comment.
+type DispatchFunc func(p *Parser, tok *lexer.Token) (*ast.Node, error)
+
+// DatumComment is a DispatchFunc that discards the node that follows its key,
+// usually registered as #_.
+func DatumComment(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	p.NextToken()
+	if _, err := p.ReadDatum(); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// PeekToken returns the next token without consuming it
+func (p *Parser) PeekToken() *lexer.Token {
+	return p.peek()
+}
+
+// NextToken consumes and returns the next token
+func (p *Parser) NextToken() *lexer.Token {
+	return p.next()
+}
+
+// ReadDatum reads and returns the next complete node, whitespace and comments
+// before the node are skipped.
+func (p *Parser) ReadDatum() (*ast.Node, error) {
+	node, state := expectDatum(p)
+	for state != nil {
+		state = state(p)
+	}
+	if p.lastErr != nil {
+		return nil, p.lastErr
+	}
+	return node, nil
+}
+
+// lookupDispatch returns the function registered for the token that follows a
+// #, the token is split when only its first character matches.
+func (p *Parser) lookupDispatch() DispatchFunc {
+	if len(p.options.Dispatch) == 0 {
+		return nil
+	}
+
+	next := p.peek()
+	if next.Type() == lexer.TokenEOF {
+		return nil
+	}
+
+	text := next.Text()
+	if fn, ok := p.options.Dispatch[text]; ok {
+		return fn
+	}
+
+	_, size := utf8.DecodeRuneInString(text)
+	if fn, ok := p.options.Dispatch[text[:size]]; ok {
+		if size < len(text) {
+			p.splitNext(size)
+		}
+		return fn
+	}
+
+	return p.options.Dispatch[""]
+}
+
+// splitNext splits the next token at the given offset, the second part of the
+// token is read right after the first one.
+func (p *Parser) splitNext(offset int) {
+	next := p.peek()
+	text, pos := next.Text(), next.Pos()
+	p.nextTok = lexer.NewToken(next.Type(), text[:offset], &pos)
+	pos.Column += utf8.RuneCountInString(text[:offset])
+	p.pending = append([]*lexer.Token{lexer.NewToken(next.Type(), text[offset:], &pos)}, p.pending...)
+}
+
+func parserStateDispatch(root *ast.Node) parserState {
+	return func(p *Parser) parserState {
+		tok := p.curr()
+
+		literal, err := expectHashLiteral(p)
+		if err != nil {
+			return parserErrorState(err)
+		}
+		if literal != nil {
+			if err := root.Push(literal); err != nil {
+				return parserErrorState(err)
+			}
+			return nil
+		}
+
+		fn := p.lookupDispatch()
+		if fn == nil {
+			return parserStateComment(root)(p)
+		}
+
+		node, err := fn(p, tok)
+		if err != nil {
+			return parserErrorState(err)
+		}
+		if node == nil {
+			return nil
+		}
+		if err := root.Push(node); err != nil {
+			return parserErrorState(err)
+		}
+		return nil
+	}
+}
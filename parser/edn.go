@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// TagFunc validates or transforms a tagged element, like #inst "2020-01-01".
+// The function receives the tagged node, whose children are the tag symbol and
+// the tagged value, and returns the node that replaces it in the tree.
+type TagFunc func(n *ast.Node) (*ast.Node, error)
+
+// EDNOptions returns the options that make the parser read EDN, the extensible
+// data notation used by Clojure: nil, true and false are literals, keywords
+// are atoms, vectors are lists, #{} are sets, #_ discards the next element,
+// characters are written as \c, commas are whitespace, integers have no size
+// limit, comments begin with ;
+// and tagged elements are read as tagged nodes, with #inst and #uuid being
+// validated. Maps with repeated keys and sets with repeated elements are
+// rejected. Tag handlers can be added to the returned Tags map.
+func EDNOptions() ParserOptions {
+	return ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"_": DatumComment,
+			"{": SetLiteral,
+			"#": SymbolicValue,
+			"":  TaggedElement,
+		},
+		Tags: map[string]TagFunc{
+			"inst": InstTag,
+			"uuid": UUIDTag,
+		},
+		Literals:          true,
+		Chars:             true,
+		SemicolonComments: true,
+		CommaWhitespace:   true,
+		ExtendedNumbers:   true,
+		BigInts:           true,
+		UniqueKeys:        true,
+	}
+}
+
+// SetLiteral is a DispatchFunc that reads the map that follows its key as a
+// set, usually registered as #{.
+func SetLiteral(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	elements, err := p.ReadDatum()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.options.UniqueKeys {
+		if err := uniqueElements(elements.List(), 1); err != nil {
+			return nil, err
+		}
+	}
+	return moveChildren(ast.NewSet(tok), elements)
+}
+
+// uniqueElements returns ErrDuplicateKey if two of the nodes, taken every
+// step nodes, are equal
+func uniqueElements(nodes []*ast.Node, step int) error {
+	seen := map[string]bool{}
+	for i := 0; i < len(nodes); i += step {
+		key := datumKey(nodes[i])
+		if seen[key] {
+			return ErrDuplicateKey
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// datumKey returns a text that is the same for equal nodes, the order of the
+// elements of sets and of the entries of maps is not taken into account
+func datumKey(n *ast.Node) string {
+	if !n.IsVector() {
+		return n.Type().String() + " " + n.Encode()
+	}
+
+	children := n.List()
+	keys := make([]string, 0, len(children))
+	switch n.Type() {
+	case ast.NodeTypeSet:
+		for _, child := range children {
+			keys = append(keys, datumKey(child))
+		}
+		sort.Strings(keys)
+	case ast.NodeTypeMap:
+		for i := 0; i+1 < len(children); i += 2 {
+			keys = append(keys, datumKey(children[i])+" "+datumKey(children[i+1]))
+		}
+		sort.Strings(keys)
+	default:
+		for _, child := range children {
+			keys = append(keys, datumKey(child))
+		}
+	}
+	if tail := n.Tail(); tail != nil {
+		keys = append(keys, ".", datumKey(tail))
+	}
+	return n.Type().String() + " (" + strings.Join(keys, " ") + ")"
+}
+
+// SymbolicValue is a DispatchFunc that reads the ##Inf, ##-Inf and ##NaN
+// floats, it must be registered as #.
+func SymbolicValue(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	tokens := []*lexer.Token{tok, p.NextToken()}
+	if !isSymbolPart(p, p.PeekToken()) {
+		p.NextToken()
+		return nil, ErrUnexpectedToken
+	}
+	p.NextToken()
+	tokens = append(tokens, expectSymbolTokens(p)...)
+
+	name := mergeTokens(lexer.TokenSequence, tokens)
+	switch name.Text() {
+	case "##Inf":
+		return ast.NewNode(name, ast.NewFloatValue(math.Inf(1))), nil
+	case "##-Inf":
+		return ast.NewNode(name, ast.NewFloatValue(math.Inf(-1))), nil
+	case "##NaN":
+		return ast.NewNode(name, ast.NewFloatValue(math.NaN())), nil
+	}
+	return nil, ErrUnexpectedToken
+}
+
+// TaggedElement is a DispatchFunc that reads a tag and the element that
+// follows it as a tagged node, usually registered as the empty key so it
+// matches any tag. If the tag has a function in ParserOptions.Tags the node
+// is passed to it.
+func TaggedElement(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	if p.PeekToken().Type() != lexer.TokenWord {
+		p.NextToken()
+		return nil, ErrUnexpectedToken
+	}
+	p.NextToken()
+	tag := mergeTokens(lexer.TokenSequence, expectSymbolTokens(p))
+
+	value, err := p.ReadDatum()
+	if err != nil {
+		return nil, err
+	}
+
+	node := ast.NewTagged(tok)
+	if _, err := node.PushValue(tag, ast.NewSymbolValue(tag.Text())); err != nil {
+		return nil, err
+	}
+	if err := node.Push(value); err != nil {
+		return nil, err
+	}
+
+	if fn, ok := p.options.Tags[tag.Text()]; ok {
+		return fn(node)
+	}
+	return node, nil
+}
+
+// instLayouts are the formats accepted by #inst, RFC 3339 timestamps and
+// their prefixes
+var instLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// InstTag is a TagFunc that makes sure the value of an #inst element is a
+// RFC 3339 timestamp.
+func InstTag(n *ast.Node) (*ast.Node, error) {
+	value := n.List()[1]
+	if value.Type() == ast.NodeTypeString {
+		for _, layout := range instLayouts {
+			if _, err := time.Parse(layout, value.Value().(string)); err == nil {
+				return n, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: #inst %s", ErrInvalidTag, ast.Encode(value))
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-([0-9a-fA-F]{4}-){3}[0-9a-fA-F]{12}$`)
+
+// UUIDTag is a TagFunc that makes sure the value of an #uuid element is a
+// UUID in its canonical form.
+func UUIDTag(n *ast.Node) (*ast.Node, error) {
+	value := n.List()[1]
+	if value.Type() == ast.NodeTypeString && uuidPattern.MatchString(value.Value().(string)) {
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: #uuid %s", ErrInvalidTag, ast.Encode(value))
+}
@@ -2,9 +2,50 @@ package parser
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	ErrUnexpectedEOF   = errors.New("unexpected EOF")
 	ErrUnexpectedToken = errors.New("unexpected token")
+	ErrInvalidChar     = errors.New("invalid character")
+	ErrInvalidNumber   = errors.New("invalid number")
+	ErrInvalidTag      = errors.New("invalid tagged element")
+	ErrInvalidEdit     = errors.New("invalid edit")
+	ErrDuplicateKey    = errors.New("duplicate key")
 )
+
+// IsIncomplete returns true if the error was caused by an input that ended
+// before the forms it began were complete, like an unclosed list, string or
+// block comment, or a quote with nothing after it. More input can make an
+// incomplete source valid, while a source with any other syntax error is
+// invalid whatever follows it. Sources parsed with AutoCloseOnEOF are never
+// incomplete.
+func IsIncomplete(err error) bool {
+	return errors.Is(err, ErrUnexpectedEOF)
+}
+
+// SyntaxError is returned when the input is not valid, Line and Column are
+// the position of the token where the parser stopped and Text is the text of
+// that token. Syntax errors wrap one of the errors above.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Text   string
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("syntax error: %v", e.Err)
+	case e.Err == ErrUnexpectedToken:
+		return fmt.Sprintf("syntax error: %v %q (around (line %v) (column %v))", e.Err, e.Text, e.Line, e.Column)
+	}
+	return fmt.Sprintf("syntax error: %v (around (line: %v) (column %v))", e.Err, e.Line, e.Column)
+}
+
+// Unwrap returns the underlying error
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidEscape is returned when a string contains a malformed escape
+// sequence
+var ErrInvalidEscape = errors.New("invalid escape sequence")
+
+// isEscaped returns true if the text ends with an odd number of backslashes,
+// meaning that the character that comes after it is escaped.
+func isEscaped(text string) bool {
+	n := 0
+	for i := len(text) - 1; i >= 0 && text[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescape interprets the escape sequences of a string, the supported escape
+// sequences are the same ones Go uses for double-quoted strings. Escape
+// sequences that are not recognized are kept verbatim.
+func unescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+
+	var buf strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\\')
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:i])
+		s = s[i:]
+
+		if len(s) < 2 {
+			return "", ErrInvalidEscape
+		}
+
+		switch c := s[1]; c {
+		case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '"', 'x', 'u', 'U', '0', '1', '2', '3', '4', '5', '6', '7':
+			r, multibyte, tail, err := strconv.UnquoteChar(s, '"')
+			if err != nil {
+				return "", ErrInvalidEscape
+			}
+			if r < utf8.RuneSelf || !multibyte && (c == 'x' || c >= '0' && c <= '7') {
+				buf.WriteByte(byte(r))
+			} else {
+				buf.WriteRune(r)
+			}
+			s = tail
+		default:
+			buf.WriteString(s[:2])
+			s = s[2:]
+		}
+	}
+
+	return buf.String(), nil
+}
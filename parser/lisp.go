@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// SchemeOptions returns the options that make the parser read R7RS Scheme:
+// quotes are expanded, comments begin with ; and #| |# and #; comment out
+// blocks and nodes, #t, #f, #true and #false are bools, #\a and #\space are
+// chars, #(...) are vectors (read as lists), (a . b) are dotted expressions
+// and the #!fold-case and #!no-fold-case directives change the case folding.
+// Symbols can be enclosed in vertical bars, like |hello world|. Numbers that are not ints or floats, like 1/3, are read as symbols.
+func SchemeOptions() ParserOptions {
+	return ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"|":     BlockComment,
+			";":     DatumComment,
+			"t":     BooleanLiteral,
+			"f":     BooleanLiteral,
+			"true":  BooleanLiteral,
+			"false": BooleanLiteral,
+			`\`:     SchemeChar,
+			"(":     VectorLiteral,
+			"!":     FoldCaseDirective,
+			"":      Unsupported,
+		},
+		ExpandQuotes:      true,
+		SemicolonComments: true,
+		ExtendedNumbers:   true,
+		NumericSymbols:    true,
+		DottedPairs:       true,
+		QuotedSymbols:     true,
+	}
+}
+
+// CommonLispOptions returns the options that make the parser read Common
+// Lisp: quotes are expanded, #'f is read as (function f), comments begin
+// with ; and #| |# comments out blocks, #\a and #\Space are chars, #(...) are
+// vectors (read as lists), (a . b) are dotted expressions, tokens like 1+ are
+// symbols, t and nil are read as the true and nil literals and symbols and
+// keywords are converted to upper case, except for the ones enclosed in
+// vertical bars, like |Hello World|.
+func CommonLispOptions() ParserOptions {
+	return ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"|": BlockComment,
+			`\`: CommonLispChar,
+			"(": VectorLiteral,
+			"'": FunctionQuote,
+			"":  Unsupported,
+		},
+		ExpandQuotes:      true,
+		SemicolonComments: true,
+		ExtendedNumbers:   true,
+		NumericSymbols:    true,
+		DottedPairs:       true,
+		QuotedSymbols:     true,
+		Literals:          true,
+		LiteralNames:      CommonLispLiterals,
+		CaseFolding:       CaseUpper,
+	}
+}
+
+// Unsupported is a DispatchFunc that fails, registered as the empty key it
+// makes any # syntax that is not registered an error instead of a comment.
+func Unsupported(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	p.NextToken()
+	return nil, ErrUnexpectedToken
+}
+
+// BlockComment is a DispatchFunc that skips everything up to the matching |#,
+// block comments can be nested. It must be registered as |.
+func BlockComment(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	tokens := []*lexer.Token{tok, p.NextToken()}
+
+	depth, prev := 1, rune(0)
+	for depth > 0 {
+		next := p.PeekToken()
+		if next.Type() == lexer.TokenEOF {
+			p.NextToken()
+			return nil, ErrUnexpectedEOF
+		}
+
+		text := next.Text()
+		for i, r := range text {
+			switch {
+			case prev == '|' && r == '#':
+				depth, r = depth-1, 0
+			case prev == '#' && r == '|':
+				depth, r = depth+1, 0
+			}
+			prev = r
+
+			if depth == 0 {
+				if end := i + 1; end < len(text) {
+					p.splitNext(end)
+				}
+				break
+			}
+		}
+		tokens = append(tokens, p.NextToken())
+	}
+
+	p.comments = append(p.comments, mergeTokens(lexer.TokenComment, tokens))
+	return nil, nil
+}
+
+// booleanNames maps the names that can follow a # to bools
+var booleanNames = map[string]bool{
+	"t":     true,
+	"true":  true,
+	"f":     false,
+	"false": false,
+}
+
+// BooleanLiteral is a DispatchFunc that reads #t, #f, #true and #false as
+// bools, it must be registered as each one of those names.
+func BooleanLiteral(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	name := p.NextToken()
+	value, ok := booleanNames[strings.ToLower(name.Text())]
+	if !ok || isSymbolPart(p, p.PeekToken()) {
+		return nil, ErrUnexpectedToken
+	}
+	return ast.NewNode(mergeTokens(lexer.TokenSequence, []*lexer.Token{tok, name}), ast.NewBoolValue(value)), nil
+}
+
+// schemeCharNames are the names of characters in R7RS
+var schemeCharNames = map[string]rune{
+	"alarm":     '\a',
+	"backspace": '\b',
+	"delete":    '\x7f',
+	"escape":    '\x1b',
+	"newline":   '\n',
+	"null":      0,
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+// commonLispCharNames are the names of characters in Common Lisp, names are
+// not case sensitive
+var commonLispCharNames = map[string]rune{
+	"backspace": '\b',
+	"linefeed":  '\n',
+	"newline":   '\n',
+	"page":      '\f',
+	"return":    '\r',
+	"rubout":    '\x7f',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+// SchemeChar is a DispatchFunc that reads R7RS characters: #\a, #\space or
+// #\x3bb. It must be registered as a backslash.
+func SchemeChar(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	return readNamedChar(p, tok, func(name string) (rune, bool) {
+		if r, ok := schemeCharNames[name]; ok {
+			return r, true
+		}
+		if name[0] == 'x' {
+			if code, err := strconv.ParseUint(name[1:], 16, 32); err == nil && utf8.ValidRune(rune(code)) {
+				return rune(code), true
+			}
+		}
+		return 0, false
+	})
+}
+
+// CommonLispChar is a DispatchFunc that reads Common Lisp characters: #\a or
+// #\Space. It must be registered as a backslash.
+func CommonLispChar(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	return readNamedChar(p, tok, func(name string) (rune, bool) {
+		r, ok := commonLispCharNames[strings.ToLower(name)]
+		return r, ok
+	})
+}
+
+// readNamedChar reads the character that follows #\, names of more than one
+// character are passed to the lookup function.
+func readNamedChar(p *Parser, tok *lexer.Token, lookup func(string) (rune, bool)) (*ast.Node, error) {
+	p.NextToken()
+	tokens, err := expectCharTokens(p)
+	if err != nil {
+		return nil, err
+	}
+
+	charTok := mergeTokens(lexer.TokenSequence, append([]*lexer.Token{tok}, tokens...))
+	name := charTok.Text()[2:]
+
+	r, _ := utf8.DecodeRuneInString(name)
+	if utf8.RuneCountInString(name) > 1 {
+		var ok bool
+		if r, ok = lookup(name); !ok {
+			return nil, ErrInvalidChar
+		}
+	}
+	return ast.NewNode(charTok, ast.NewCharValue(r)), nil
+}
+
+// VectorLiteral is a DispatchFunc that reads the expression that follows its
+// key as a list, usually registered as #(.
+func VectorLiteral(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	elements, err := p.ReadDatum()
+	if err != nil {
+		return nil, err
+	}
+	if elements.Tail() != nil {
+		return nil, ErrUnexpectedToken
+	}
+	return moveChildren(ast.NewList(tok), elements)
+}
+
+// FunctionQuote is a DispatchFunc that reads #'f as (function f), it must be
+// registered as '.
+func FunctionQuote(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	p.NextToken()
+	datum, err := p.ReadDatum()
+	if err != nil {
+		return nil, err
+	}
+
+	pos := tok.Pos()
+	node := ast.NewExpression(tok)
+	if _, err := node.PushValue(lexer.NewToken(lexer.TokenSequence, "function", &pos), ast.NewSymbolValue(foldCase(p, "function"))); err != nil {
+		return nil, err
+	}
+	if err := node.Push(datum); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// FoldCaseDirective is a DispatchFunc that reads the #!fold-case and
+// #!no-fold-case directives, which enable and disable the conversion of the
+// symbols that follow them to lower case. It must be registered as !.
+func FoldCaseDirective(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	tokens := []*lexer.Token{tok, p.NextToken()}
+	if !isSymbolPart(p, p.PeekToken()) {
+		p.NextToken()
+		return nil, ErrUnexpectedToken
+	}
+	p.NextToken()
+	tokens = append(tokens, expectSymbolTokens(p)...)
+
+	directive := mergeTokens(lexer.TokenComment, tokens)
+	switch directive.Text() {
+	case "#!fold-case":
+		p.options.CaseFolding = CaseLower
+	case "#!no-fold-case":
+		p.options.CaseFolding = CasePreserve
+	default:
+		return nil, ErrUnexpectedToken
+	}
+
+	p.comments = append(p.comments, directive)
+	return nil, nil
+}
+
+// moveChildren moves the children of a node into another one, which takes
+// the end token of the first one.
+func moveChildren(dst *ast.Node, src *ast.Node) (*ast.Node, error) {
+	for _, child := range src.List() {
+		if err := dst.Push(child); err != nil {
+			return nil, err
+		}
+	}
+	dst.SetEndToken(src.EndToken())
+	return dst, nil
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// LiteralNames are the spellings of the bool and nil literals
+type LiteralNames struct {
+	True  []string
+	False []string
+	Nil   []string
+}
+
+// Spellings of the literals of some dialects
+var (
+	// DefaultLiterals are the true, false and nil symbols of EDN and Clojure
+	DefaultLiterals = LiteralNames{
+		True:  []string{"true"},
+		False: []string{"false"},
+		Nil:   []string{"nil"},
+	}
+
+	// SchemeLiterals are the #t, #true, #f and #false literals of Scheme,
+	// which has no nil
+	SchemeLiterals = LiteralNames{
+		True:  []string{"#t", "#true"},
+		False: []string{"#f", "#false"},
+	}
+
+	// CommonLispLiterals are the t and nil symbols of Common Lisp, where nil
+	// is also false
+	CommonLispLiterals = LiteralNames{
+		True: []string{"t"},
+		Nil:  []string{"nil"},
+	}
+)
+
+func (l LiteralNames) isEmpty() bool {
+	return len(l.True) == 0 && len(l.False) == 0 && len(l.Nil) == 0
+}
+
+// literalValue returns the value of a literal, names are compared after
+// applying the case folding rules.
+func literalValue(p *Parser, name string) (ast.Valuer, bool) {
+	if !p.options.Literals {
+		return nil, false
+	}
+
+	names := p.options.LiteralNames
+	if names.isEmpty() {
+		names = DefaultLiterals
+	}
+
+	name = foldCase(p, name)
+	matches := func(spellings []string) bool {
+		for _, s := range spellings {
+			if foldCase(p, s) == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case matches(names.True):
+		return ast.NewBoolValue(true), true
+	case matches(names.False):
+		return ast.NewBoolValue(false), true
+	case matches(names.Nil):
+		return ast.NewNilValue(), true
+	}
+	return nil, false
+}
+
+// expectHashLiteral reads a literal that begins with a #, like #t. It returns
+// nil when the token that follows the # doesn't form a literal, in which case
+// no token is consumed.
+func expectHashLiteral(p *Parser) (*ast.Node, error) {
+	hash, next := p.curr(), p.peek()
+	if !isSymbolPart(p, next) {
+		return nil, nil
+	}
+
+	value, ok := literalValue(p, hash.Text()+next.Text())
+	if !ok {
+		return nil, nil
+	}
+
+	p.next()
+	if isSymbolPart(p, p.peek()) {
+		return nil, ErrUnexpectedToken
+	}
+	return ast.NewNode(mergeTokens(lexer.TokenSequence, []*lexer.Token{hash, next}), value), nil
+}
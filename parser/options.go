@@ -1,7 +1,103 @@
 package parser
 
+import "github.com/xiam/s-expr/lexer"
+
+// CaseFolding represents the way the parser changes the case of symbols and
+// atoms
+type CaseFolding int
+
+// Case folding rules
+const (
+	// CasePreserve keeps symbols as they were written
+	CasePreserve CaseFolding = iota
+	// CaseUpper converts symbols to upper case, like Common Lisp does
+	CaseUpper
+	// CaseLower converts symbols to lower case, like Scheme does after a
+	// #!fold-case directive
+	CaseLower
+)
+
 type ParserOptions struct {
 	AutoCloseOnEOF bool
+
+	// ExpandQuotes makes the parser read the ', `, , and ,@ prefixes as
+	// reader macros: 'x, `x, ,x and ,@x are expanded into the (quote x),
+	// (quasiquote x), (unquote x) and (unquote-splicing x) expressions. When
+	// disabled, these prefixes are read as part of a symbol.
+	ExpandQuotes bool
+
+	// Dispatch maps the text that follows a # to the function that reads
+	// it, see DispatchFunc. The empty key matches any token that doesn't
+	// match another key. A # that is not followed by a registered key starts
+	// a line comment.
+	Dispatch map[string]DispatchFunc
+
+	// Tags maps the tags of tagged elements, like #inst, to the functions
+	// that validate or transform them, see TaggedElement.
+	Tags map[string]TagFunc
+
+	// Literals makes the parser read the nil, true and false symbols as nil
+	// and bool nodes, other spellings can be set with LiteralNames.
+	Literals bool
+
+	// LiteralNames are the spellings of the literals read when Literals is
+	// enabled, DefaultLiterals are used when no spelling is set. Spellings
+	// that begin with a #, like #t, are matched before the Dispatch table.
+	LiteralNames LiteralNames
+
+	// Chars makes the parser read a backslash followed by a character, a
+	// character name (\newline, \space, \tab, \return, \formfeed or
+	// \backspace) or a unicode code point (\u03bb) as a char node.
+	Chars bool
+
+	// SemicolonComments makes ; start a line comment.
+	SemicolonComments bool
+
+	// CommaWhitespace makes the parser treat commas as whitespace.
+	CommaWhitespace bool
+
+	// ExtendedNumbers makes the parser accept exponents (1.5e-3) and the N
+	// and M suffixes of arbitrary precision numbers (42N, 1.5M), which are
+	// read as ints and floats.
+	ExtendedNumbers bool
+
+	// NumericSymbols makes the parser read the tokens that begin like a
+	// number but are not numbers, like 1+ or .5, as symbols instead of
+	// failing. Only used along with ExtendedNumbers.
+	NumericSymbols bool
+
+	// BigInts makes the parser read the integers that don't fit in an int64
+	// as int nodes whose value is a *big.Int, see ast.NewBigIntValue, instead
+	// of failing.
+	BigInts bool
+
+	// UniqueKeys makes the parser reject maps with repeated keys and sets
+	// with repeated elements.
+	UniqueKeys bool
+
+	// DottedPairs makes the parser read a dot that stands alone before the
+	// last element of an expression as the tail of the expression (see
+	// ast.Node.Tail), as in (a . b) or (a b . c).
+	DottedPairs bool
+
+	// QuotedSymbols makes the parser read the text enclosed between vertical
+	// bars as a symbol, like |hello world|, the bars are not part of the name
+	// of the symbol.
+	QuotedSymbols bool
+
+	// DoubledQuotes makes the parser read strings the way SMT-LIB does: a
+	// double quote is escaped by another double quote ("a""b") and backslashes
+	// have no special meaning.
+	DoubledQuotes bool
+
+	// CaseFolding sets the case of symbols and atoms, the tokens keep the
+	// text as it was written.
+	CaseFolding CaseFolding
+
+	// Lexer sets the characters the lexer reads as words, whitespace,
+	// comments and delimiters, the lexer uses lexer.DefaultConfig when nil.
+	// Comments found by the lexer are added to the parser comments.
+	Lexer *lexer.Config
 }
 
 var parserDefaultOptions = ParserOptions{}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/big"
+	"regexp"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/xiam/s-expr/ast"
 	"github.com/xiam/s-expr/lexer"
@@ -23,9 +27,18 @@ type Parser struct {
 	lastTok *lexer.Token
 	nextTok *lexer.Token
 
+	// pending holds the tokens that are left after splitting a token
+	pending []*lexer.Token
+
 	options ParserOptions
 
+	comments []*lexer.Token
+
+	scanErr chan error
 	lastErr error
+
+	// stopped is true after the lexer was stopped
+	stopped bool
 }
 
 // New creates a new parser that reads from the given input
@@ -39,6 +52,9 @@ func NewParser(r io.Reader) *Parser {
 
 func (p *Parser) SetOptions(options ParserOptions) {
 	p.options = options
+	if options.Lexer != nil {
+		p.lx.SetConfig(*options.Lexer)
+	}
 }
 
 func (p *Parser) Options() ParserOptions {
@@ -49,6 +65,12 @@ func (p *Parser) RootNode() *ast.Node {
 	return p.root
 }
 
+// Comments returns all the comments the parser found while reading the input,
+// in the same order they appeared.
+func (p *Parser) Comments() []*lexer.Token {
+	return p.comments
+}
+
 // Parse tokenizes the input and transforms it into a AST
 func (p *Parser) Parse() error {
 	errCh := make(chan error)
@@ -69,16 +91,94 @@ func (p *Parser) Parse() error {
 	return p.lastErr
 }
 
+// NextNode parses and returns the next top-level node from the input without
+// adding it to the root node, this allows reading large inputs one form at a
+// time. NextNode returns io.EOF when there are no more nodes to read, Close
+// must be called when the input is not read until then. Parse and NextNode
+// must not be used on the same parser.
+func (p *Parser) NextNode() (*ast.Node, error) {
+	if p.lastErr != nil {
+		return nil, p.lastErr
+	}
+
+	if p.scanErr == nil {
+		p.scanErr = make(chan error, 1)
+		go func() {
+			p.scanErr <- p.lx.Scan()
+		}()
+	}
+
+	container := ast.NewList(nil)
+	for {
+		tok := p.next()
+		if tok.Type() == lexer.TokenEOF {
+			if err := <-p.scanErr; err != nil && err != lexer.ErrForceStopped {
+				p.lastErr = fmt.Errorf("lexer error: %v", err)
+				return nil, p.lastErr
+			}
+			p.lastErr = io.EOF
+			return nil, p.lastErr
+		}
+
+		for state := parserStateData(container)(p); state != nil; {
+			state = state(p)
+		}
+		if p.lastErr != nil {
+			return nil, p.lastErr
+		}
+
+		if nodes := container.List(); len(nodes) > 0 {
+			return nodes[0], nil
+		}
+	}
+}
+
+// Close stops the lexer of a parser that is read with NextNode, the lexer
+// keeps waiting in its own goroutine until the end of the input is read or
+// until Close is called. Later calls to NextNode return io.EOF. Calling Close
+// more than once has no effect.
+func (p *Parser) Close() error {
+	if p.lastErr == nil {
+		p.lastErr = io.EOF
+	}
+	p.stopLexer()
+	return nil
+}
+
+func (p *Parser) stopLexer() {
+	if !p.stopped {
+		p.stopped = true
+		p.lx.Stop()
+	}
+}
+
 func (p *Parser) curr() *lexer.Token {
 	return p.lastTok
 }
 
 func (p *Parser) read() *lexer.Token {
+	if len(p.pending) > 0 {
+		tok := p.pending[0]
+		p.pending = p.pending[1:]
+		return tok
+	}
+
 	if ok := p.lx.Next(); !ok {
 		return EOF
 	}
 
-	return p.lx.Token()
+	tok := p.lx.Token()
+	if p.options.SemicolonComments && tok.Type() == lexer.TokenSequence {
+		// the lexer doesn't break sequences at semicolons, a comment that
+		// follows a symbol is split from it
+		if i := strings.IndexByte(tok.Text(), ';'); i > 0 {
+			text, pos := tok.Text(), tok.Pos()
+			tok = lexer.NewToken(lexer.TokenSequence, text[:i], &pos)
+			pos.Column += utf8.RuneCountInString(text[:i])
+			p.pending = append(p.pending, lexer.NewToken(lexer.TokenSequence, text[i:], &pos))
+		}
+	}
+	return tok
 }
 
 func (p *Parser) peek() *lexer.Token {
@@ -121,23 +221,20 @@ func parserDefaultState(p *Parser) parserState {
 
 func parserErrorState(err error) parserState {
 	return func(p *Parser) parserState {
-		p.lx.Stop()
+		if p.lastErr != nil {
+			// the error was already reported, by a nested reader
+			return nil
+		}
+		p.stopLexer()
 
 		tok := p.curr()
 		if tok == nil {
-			p.lastErr = fmt.Errorf("syntax error: %w", err)
+			p.lastErr = &SyntaxError{Err: err}
 			return nil
 		}
 
 		pos := tok.Pos()
-		line, col := pos.Line, pos.Column
-		switch err {
-		case ErrUnexpectedToken:
-			p.lastErr = fmt.Errorf("syntax error: %w %q (around (line %v) (column %v))", err, tok.Text(), line, col)
-			return nil
-		}
-		// TODO: extract a code snippet around line and col
-		p.lastErr = fmt.Errorf("syntax error: %w (around (line: %v) (column %v))", err, line, col)
+		p.lastErr = &SyntaxError{Line: pos.Line, Column: pos.Column, Text: tok.Text(), Err: err}
 		return nil
 	}
 }
@@ -180,6 +277,9 @@ func parserStateData(root *ast.Node) parserState {
 		case lexer.TokenWhitespace, lexer.TokenNewLine:
 			// continue
 
+		case lexer.TokenComment:
+			p.comments = append(p.comments, tok)
+
 		case lexer.TokenDoubleQuote:
 			if state := parserStateString(root)(p); state != nil {
 				return state
@@ -200,13 +300,52 @@ func parserStateData(root *ast.Node) parserState {
 				return state
 			}
 
-		case lexer.TokenSequence:
+		case lexer.TokenSequence, lexer.TokenDot:
+			if isLineComment(p, tok) {
+				if state := parserStateComment(root)(p); state != nil {
+					return state
+				}
+				break
+			}
+			if state := parserStateWord(root)(p); state != nil {
+				return state
+			}
+
+		case lexer.TokenBackslash:
+			if !p.options.Chars {
+				return parserErrorState(ErrUnexpectedToken)
+			}
+			if state := parserStateChar(root)(p); state != nil {
+				return state
+			}
+
+		case lexer.TokenPipe:
+			if p.options.QuotedSymbols {
+				if state := parserStateQuotedSymbol(root)(p); state != nil {
+					return state
+				}
+				break
+			}
+			if state := parserStateWord(root)(p); state != nil {
+				return state
+			}
+
+		case lexer.TokenQuote, lexer.TokenBackquote, lexer.TokenComma, lexer.TokenCommaAt:
+			if tok.Type() == lexer.TokenComma && p.options.CommaWhitespace {
+				break
+			}
+			if p.options.ExpandQuotes {
+				if state := parserStateQuote(root)(p); state != nil {
+					return state
+				}
+				break
+			}
 			if state := parserStateWord(root)(p); state != nil {
 				return state
 			}
 
 		case lexer.TokenHash:
-			if state := parserStateComment(root)(p); state != nil {
+			if state := parserStateDispatch(root)(p); state != nil {
 				return state
 			}
 
@@ -246,6 +385,10 @@ func parserStateData(root *ast.Node) parserState {
 }
 
 func expectIntegerNode(p *Parser) (*ast.Node, error) {
+	if p.options.ExtendedNumbers {
+		return expectNumberNode(p)
+	}
+
 	curr := p.curr()
 
 	next := p.peek()
@@ -271,6 +414,9 @@ func expectIntegerNode(p *Parser) (*ast.Node, error) {
 		// natural end for an integer
 		i64, err := strconv.ParseInt(curr.Text(), 10, 64)
 		if err != nil {
+			if v, ok := new(big.Int).SetString(curr.Text(), 10); ok && p.options.BigInts {
+				return ast.NewNode(curr, ast.NewBigIntValue(v)), nil
+			}
 			return nil, err
 		}
 
@@ -278,8 +424,63 @@ func expectIntegerNode(p *Parser) (*ast.Node, error) {
 	}
 }
 
+var (
+	intPattern   = regexp.MustCompile(`^[+-]?[0-9]+N?$`)
+	floatPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]*)?([eE][+-]?[0-9]+)?M?$`)
+)
+
+// expectNumberNode reads a number along with its exponent and suffix, the
+// number and all the tokens that immediately follow it must form a valid
+// number.
+func expectNumberNode(p *Parser) (*ast.Node, error) {
+	tok := mergeTokens(lexer.TokenSequence, expectSymbolTokens(p))
+	text := tok.Text()
+
+	switch {
+	case intPattern.MatchString(text):
+		i64, err := strconv.ParseInt(strings.TrimSuffix(text, "N"), 10, 64)
+		if err != nil {
+			if v, ok := new(big.Int).SetString(strings.TrimSuffix(text, "N"), 10); ok && p.options.BigInts {
+				return ast.NewNode(tok, ast.NewBigIntValue(v)), nil
+			}
+			return nil, err
+		}
+		return ast.NewNode(tok, ast.NewIntValue(i64)), nil
+
+	case floatPattern.MatchString(text):
+		f64, err := strconv.ParseFloat(strings.TrimSuffix(text, "M"), 64)
+		if err != nil {
+			return nil, err
+		}
+		return ast.NewNode(tok, ast.NewFloatValue(f64)), nil
+
+	case p.options.NumericSymbols:
+		return ast.NewNode(tok, ast.NewSymbolValue(foldCase(p, text))), nil
+	}
+
+	return nil, ErrInvalidNumber
+}
+
+// foldCase applies the CaseFolding option to the name of a symbol or an atom
+func foldCase(p *Parser, name string) string {
+	switch p.options.CaseFolding {
+	case CaseUpper:
+		return strings.ToUpper(name)
+	case CaseLower:
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// isLineComment returns true if the token begins a ; comment
+func isLineComment(p *Parser, tok *lexer.Token) bool {
+	return p.options.SemicolonComments && tok.Type() == lexer.TokenSequence && strings.HasPrefix(tok.Text(), ";")
+}
+
 func parserStateComment(root *ast.Node) parserState {
 	return func(p *Parser) parserState {
+		tokens := []*lexer.Token{p.curr()}
+
 	loop:
 		for {
 			tok := p.next()
@@ -287,14 +488,20 @@ func parserStateComment(root *ast.Node) parserState {
 			case lexer.TokenEOF, lexer.TokenNewLine:
 				break loop
 			}
+			tokens = append(tokens, tok)
 		}
+
+		p.comments = append(p.comments, mergeTokens(lexer.TokenComment, tokens))
 		return nil
 	}
 }
 
 func parserStateString(root *ast.Node) parserState {
 	return func(p *Parser) parserState {
+		quote := p.curr()
+
 		tokens := []*lexer.Token{}
+		text := ""
 
 	loop:
 		for {
@@ -302,18 +509,47 @@ func parserStateString(root *ast.Node) parserState {
 
 			switch tok.Type() {
 			case lexer.TokenDoubleQuote:
-				break loop
+				if p.options.DoubledQuotes {
+					if p.peek().Type() != lexer.TokenDoubleQuote {
+						break loop
+					}
+					tokens = append(tokens, tok, p.next())
+					text = text + `""`
+					continue
+				}
+				if !isEscaped(text) {
+					break loop
+				}
+				tokens = append(tokens, tok)
+				text = text + tok.Text()
 
 			case lexer.TokenEOF:
 				return parserErrorState(ErrUnexpectedEOF)
 
 			default:
 				tokens = append(tokens, tok)
+				text = text + tok.Text()
 			}
 		}
 
-		tok := mergeTokens(lexer.TokenSequence, tokens)
-		if err := root.Push(ast.NewNode(tok, ast.NewStringValue(tok.Text()))); err != nil {
+		var tok *lexer.Token
+		if len(tokens) > 0 {
+			tok = mergeTokens(lexer.TokenSequence, tokens)
+		} else {
+			pos := quote.Pos()
+			pos.Column++
+			tok = lexer.NewToken(lexer.TokenSequence, "", &pos)
+		}
+
+		value := strings.Replace(tok.Text(), `""`, `"`, -1)
+		if !p.options.DoubledQuotes {
+			var err error
+			if value, err = unescape(tok.Text()); err != nil {
+				return parserErrorState(err)
+			}
+		}
+
+		if err := root.Push(ast.NewNode(tok, ast.NewStringValue(value))); err != nil {
 			return parserErrorState(err)
 		}
 		return nil
@@ -333,10 +569,149 @@ func parserStateNumeric(root *ast.Node) parserState {
 	}
 }
 
+// isSymbolPart returns true if the token can be part of a symbol that began
+// with a preceding token, like the "-bar" sequence in "foo-bar" or the integer
+// in "abc123". Quote prefixes are only part of symbols when they're not
+// expanded.
+func isSymbolPart(p *Parser, tok *lexer.Token) bool {
+	switch tok.Type() {
+	case lexer.TokenWord, lexer.TokenInteger, lexer.TokenDot, lexer.TokenColon:
+		return true
+	case lexer.TokenSequence:
+		return !isLineComment(p, tok)
+	case lexer.TokenComma:
+		return !p.options.ExpandQuotes && !p.options.CommaWhitespace
+	case lexer.TokenQuote, lexer.TokenBackquote, lexer.TokenCommaAt:
+		return !p.options.ExpandQuotes
+	case lexer.TokenPipe:
+		return !p.options.QuotedSymbols
+	}
+	return false
+}
+
+// expectSymbolTokens returns the current token along with all the tokens that
+// immediately follow it and are part of the same symbol.
+func expectSymbolTokens(p *Parser) []*lexer.Token {
+	tokens := []*lexer.Token{p.curr()}
+	for isSymbolPart(p, p.peek()) {
+		tokens = append(tokens, p.next())
+	}
+	return tokens
+}
+
 func parserStateWord(root *ast.Node) parserState {
 	return func(p *Parser) parserState {
-		curr := p.curr()
-		if _, err := root.PushValue(curr, ast.NewSymbolValue(curr.Text())); err != nil {
+		tok := mergeTokens(lexer.TokenSequence, expectSymbolTokens(p))
+		if p.options.ExtendedNumbers && !p.options.NumericSymbols && isNumberLike(tok.Text()) {
+			return parserErrorState(ErrInvalidNumber)
+		}
+
+		value, ok := literalValue(p, tok.Text())
+		if !ok {
+			value = ast.NewSymbolValue(foldCase(p, tok.Text()))
+		}
+
+		if _, err := root.PushValue(tok, value); err != nil {
+			return parserErrorState(err)
+		}
+		return nil
+	}
+}
+
+// isNumberLike returns true if the text begins like a number, with a sign or
+// a dot followed by a digit, like .5
+func isNumberLike(text string) bool {
+	return len(text) > 1 && strings.IndexByte("+-.", text[0]) >= 0 && text[1] >= '0' && text[1] <= '9'
+}
+
+// charNames maps the names of the characters that can be written after a
+// backslash
+var charNames = map[string]rune{
+	"newline":   '\n',
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+	"formfeed":  '\f',
+	"backspace": '\b',
+}
+
+// charValue returns the character represented by the text that follows a
+// backslash.
+func charValue(text string) (rune, error) {
+	if utf8.RuneCountInString(text) == 1 {
+		r, _ := utf8.DecodeRuneInString(text)
+		return r, nil
+	}
+	if r, ok := charNames[text]; ok {
+		return r, nil
+	}
+	if len(text) == 5 && text[0] == 'u' {
+		if code, err := strconv.ParseUint(text[1:], 16, 16); err == nil {
+			return rune(code), nil
+		}
+	}
+	return 0, ErrInvalidChar
+}
+
+// expectCharTokens returns the current token, a backslash, along with the
+// tokens of the character that follows it.
+func expectCharTokens(p *Parser) ([]*lexer.Token, error) {
+	tokens := []*lexer.Token{p.curr()}
+
+	switch next := p.next(); next.Type() {
+	case lexer.TokenEOF:
+		return nil, ErrUnexpectedEOF
+	case lexer.TokenWhitespace, lexer.TokenNewLine:
+		return nil, ErrInvalidChar
+	case lexer.TokenWord, lexer.TokenInteger, lexer.TokenSequence, lexer.TokenDot, lexer.TokenColon:
+		tokens = append(tokens, expectSymbolTokens(p)...)
+	default:
+		// delimiters and other single character tokens
+		tokens = append(tokens, next)
+	}
+	return tokens, nil
+}
+
+func parserStateChar(root *ast.Node) parserState {
+	return func(p *Parser) parserState {
+		tokens, err := expectCharTokens(p)
+		if err != nil {
+			return parserErrorState(err)
+		}
+
+		tok := mergeTokens(lexer.TokenSequence, tokens)
+		r, err := charValue(tok.Text()[1:])
+		if err != nil {
+			return parserErrorState(err)
+		}
+
+		if _, err := root.PushValue(tok, ast.NewCharValue(r)); err != nil {
+			return parserErrorState(err)
+		}
+		return nil
+	}
+}
+
+// parserStateQuotedSymbol reads the text up to the next vertical bar as the
+// name of a symbol.
+func parserStateQuotedSymbol(root *ast.Node) parserState {
+	return func(p *Parser) parserState {
+		tokens := []*lexer.Token{p.curr()}
+		name := ""
+
+		for {
+			tok := p.next()
+			if tok.Type() == lexer.TokenEOF {
+				return parserErrorState(ErrUnexpectedEOF)
+			}
+			tokens = append(tokens, tok)
+			if tok.Type() == lexer.TokenPipe {
+				break
+			}
+			name = name + tok.Text()
+		}
+
+		if _, err := root.PushValue(mergeTokens(lexer.TokenSequence, tokens), ast.NewSymbolValue(name)); err != nil {
 			return parserErrorState(err)
 		}
 		return nil
@@ -347,13 +722,68 @@ func parserStateAtom(root *ast.Node) parserState {
 	return func(p *Parser) parserState {
 		curr := p.curr()
 
-		atomName, err := expectTokens(p, lexer.TokenWord)
-		if err != nil {
+		if _, err := expectTokens(p, lexer.TokenWord); err != nil {
 			return parserErrorState(err)
 		}
 
-		tok := mergeTokens(lexer.TokenSequence, append([]*lexer.Token{curr}, atomName...))
-		node := ast.NewNode(tok, ast.NewAtomValue(tok.Text()))
+		tok := mergeTokens(lexer.TokenSequence, append([]*lexer.Token{curr}, expectSymbolTokens(p)...))
+		node := ast.NewNode(tok, ast.NewAtomValue(foldCase(p, tok.Text())))
+		if err := root.Push(node); err != nil {
+			return parserErrorState(err)
+		}
+		return nil
+	}
+}
+
+// quoteNames maps quote prefixes to the head of the expressions they expand to
+var quoteNames = map[lexer.TokenType]string{
+	lexer.TokenQuote:     "quote",
+	lexer.TokenBackquote: "quasiquote",
+	lexer.TokenComma:     "unquote",
+	lexer.TokenCommaAt:   "unquote-splicing",
+}
+
+// expectDatum reads the next complete node from the input, whitespace and
+// comments before the node are skipped.
+func expectDatum(p *Parser) (*ast.Node, parserState) {
+	container := ast.NewList(nil)
+	for {
+		tok := p.next()
+		if tok.Type() == lexer.TokenEOF {
+			return nil, parserErrorState(ErrUnexpectedEOF)
+		}
+
+		if state := parserStateData(container)(p); state != nil {
+			return nil, state
+		}
+
+		if nodes := container.List(); len(nodes) > 0 {
+			return nodes[0], nil
+		}
+	}
+}
+
+// parserStateQuote expands a quote prefix and the node that follows it into
+// an expression, both the expression and its head symbol are placed at the
+// position of the prefix.
+func parserStateQuote(root *ast.Node) parserState {
+	return func(p *Parser) parserState {
+		tok := p.curr()
+
+		datum, state := expectDatum(p)
+		if state != nil {
+			return state
+		}
+
+		name, pos := quoteNames[tok.Type()], tok.Pos()
+
+		node := ast.NewExpression(tok)
+		if _, err := node.PushValue(lexer.NewToken(lexer.TokenSequence, name, &pos), ast.NewSymbolValue(foldCase(p, name))); err != nil {
+			return parserErrorState(err)
+		}
+		if err := node.Push(datum); err != nil {
+			return parserErrorState(err)
+		}
 		if err := root.Push(node); err != nil {
 			return parserErrorState(err)
 		}
@@ -372,6 +802,12 @@ func parserStateOpenMap(root *ast.Node) parserState {
 			}
 			return parserErrorState(ErrUnexpectedEOF)
 		case lexer.TokenCloseMap:
+			root.SetEndToken(tok)
+			if p.options.UniqueKeys && root.Type() == ast.NodeTypeMap {
+				if err := uniqueElements(root.List(), 2); err != nil {
+					return parserErrorState(err)
+				}
+			}
 			return nil
 
 		default:
@@ -396,8 +832,17 @@ func parserStateOpenExpression(root *ast.Node) parserState {
 			return parserErrorState(ErrUnexpectedEOF)
 
 		case lexer.TokenCloseExpression:
+			root.SetEndToken(tok)
 			return nil
 
+		case lexer.TokenDot:
+			if p.options.DottedPairs && !isSymbolPart(p, p.peek()) {
+				return parserStateDottedTail(root)(p)
+			}
+			if state := parserStateData(root)(p); state != nil {
+				return state
+			}
+
 		default:
 			if state := parserStateData(root)(p); state != nil {
 				return state
@@ -408,6 +853,58 @@ func parserStateOpenExpression(root *ast.Node) parserState {
 	}
 }
 
+// parserStateDottedTail reads the node that follows the dot of a dotted
+// expression and the end of the expression, only whitespace and comments can
+// be between them.
+func parserStateDottedTail(root *ast.Node) parserState {
+	return func(p *Parser) parserState {
+		if len(root.List()) == 0 {
+			return parserErrorState(ErrUnexpectedToken)
+		}
+
+		tail, state := expectDatum(p)
+		if state != nil {
+			return state
+		}
+		if tail.Type() == ast.NodeTypeExpression {
+			// an expression after the dot continues the list, like in Lisp:
+			// (a . (b . c)) is read as (a b . c) and (a . (b)) as (a b)
+			for _, child := range tail.List() {
+				if err := root.Push(child); err != nil {
+					return parserErrorState(err)
+				}
+			}
+			tail = tail.Tail()
+		}
+		root.SetTail(tail)
+
+		container := ast.NewList(nil)
+		for {
+			tok := p.next()
+
+			switch tok.Type() {
+			case lexer.TokenEOF:
+				if p.options.AutoCloseOnEOF {
+					return nil
+				}
+				return parserErrorState(ErrUnexpectedEOF)
+
+			case lexer.TokenCloseExpression:
+				root.SetEndToken(tok)
+				return nil
+
+			default:
+				if state := parserStateData(container)(p); state != nil {
+					return state
+				}
+				if len(container.List()) > 0 {
+					return parserErrorState(ErrUnexpectedToken)
+				}
+			}
+		}
+	}
+}
+
 func parserStateOpenList(root *ast.Node) parserState {
 	return func(p *Parser) parserState {
 		tok := p.next()
@@ -420,6 +917,7 @@ func parserStateOpenList(root *ast.Node) parserState {
 			return parserErrorState(ErrUnexpectedEOF)
 
 		case lexer.TokenCloseList:
+			root.SetEndToken(tok)
 			return nil
 
 		default:
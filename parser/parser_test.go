@@ -1,11 +1,19 @@
 package parser
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
 )
 
 func TestParserBuildTree(t *testing.T) {
@@ -95,7 +103,7 @@ func TestParserBuildTree(t *testing.T) {
 		},
 		{
 			In: "\"ABC	\\n	DEF	[] GHI :jkl mno\" # AABBCBCC\n:aBC #def ghij\n \"foo\" # BAR",
-			Out: `"ABC\t\\n\tDEF\t[] GHI :jkl mno" :aBC "foo"`,
+			Out: `"ABC\t\n\tDEF\t[] GHI :jkl mno" :aBC "foo"`,
 		},
 		{
 			In:  `{}`,
@@ -145,6 +153,22 @@ func TestParserBuildTree(t *testing.T) {
 			In:  `[+ -1 55 +6.3 +2 -3.23 4.01]`,
 			Out: `[+ -1 55 6.3 2 -3.23 4.01]`,
 		},
+		{
+			In:  `[-35 -123.456 1000000.0 3.0]`,
+			Out: `[-35 -123.456 1000000.0 3.0]`,
+		},
+		{
+			In:  `(foo-bar abc123 ready? c-d-e-f a.b :key-name [b...])`,
+			Out: `(foo-bar abc123 ready? c-d-e-f a.b :key-name [b...])`,
+		},
+		{
+			In:  `"" ["" ""]`,
+			Out: `"" ["" ""]`,
+		},
+		{
+			In:  `"say \"hi\"" "back\\slash" "tab\tnew\nline" "\u00e9\x41"`,
+			Out: `"say \"hi\"" "back\\slash" "tab\tnew\nline" "éA"`,
+		},
 	}
 
 	for i := range testCases {
@@ -153,7 +177,7 @@ func TestParserBuildTree(t *testing.T) {
 		assert.NotNil(t, root)
 
 		ast.Print(root)
-		s := ast.Encode(root)
+		s := ast.EncodeDocument(root)
 
 		assert.Equal(t, testCases[i].Out, string(s))
 	}
@@ -248,7 +272,7 @@ func TestAutoCloseOnEOF(t *testing.T) {
 			assert.NotNil(t, p.root)
 			assert.NoError(t, err)
 
-			s := ast.Encode(p.root)
+			s := ast.EncodeDocument(p.root)
 			assert.Equal(t, testCases[i].Out, string(s))
 		}
 	}
@@ -295,3 +319,633 @@ func TestParserErrors(t *testing.T) {
 		t.Log(err)
 	}
 }
+
+func TestIsIncomplete(t *testing.T) {
+	testCases := []struct {
+		In         string
+		Incomplete bool
+	}{
+		{`(a`, true},
+		{`[1 (2`, true},
+		{`{:a 1`, true},
+		{`"abc`, true},
+		{"(a \"b\nc", true},
+		{`"a\`, true},
+		{`#|comment`, true},
+		{`'`, true},
+		{`(a . `, true},
+		{`#(1`, true},
+		{`#\`, true},
+		{`(a ; comment`, true},
+		{`)`, false},
+		{`(a))`, false},
+		{`(a]`, false},
+		{`(a . b c`, false},
+		{`[1 #{2`, false},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(SchemeOptions())
+		err := p.Parse()
+		assert.Error(t, err, tc.In)
+		assert.Equal(t, tc.Incomplete, IsIncomplete(err), "%q: %v", tc.In, err)
+	}
+
+	assert.False(t, IsIncomplete(nil))
+
+	// the parser closes the forms that are left open
+	p := NewParser(strings.NewReader(`(a [1`))
+	p.SetOptions(ParserOptions{AutoCloseOnEOF: true})
+	assert.NoError(t, p.Parse())
+}
+
+func TestSyntaxError(t *testing.T) {
+	testCases := []struct {
+		In     string
+		Line   int
+		Column int
+		Text   string
+		Err    error
+	}{
+		{"(a\n  b]", 2, 4, "]", ErrUnexpectedToken},
+		{"(a\n (b", 2, 4, "", ErrUnexpectedEOF},
+		{`(a #xZZ)`, 1, 6, "ZZ", ErrInvalidNumber},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(SMTLIBOptions())
+		err := p.Parse()
+		var syntaxErr *SyntaxError
+		if assert.True(t, errors.As(err, &syntaxErr), "%q: %v", tc.In, err) {
+			assert.Equal(t, tc.Line, syntaxErr.Line, tc.In)
+			assert.Equal(t, tc.Column, syntaxErr.Column, tc.In)
+			assert.Equal(t, tc.Text, syntaxErr.Text, tc.In)
+			assert.True(t, errors.Is(err, tc.Err), tc.In)
+		}
+	}
+}
+
+func TestNextNode(t *testing.T) {
+	p := NewParser(strings.NewReader("(a 1) # comment\n[2 3] \n\n {:b \"c\"}"))
+
+	expected := []string{`(a 1)`, `[2 3]`, `{:b "c"}`}
+	for i := range expected {
+		node, err := p.NextNode()
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], string(ast.Encode(node)))
+	}
+
+	for i := 0; i < 2; i++ {
+		node, err := p.NextNode()
+		assert.Nil(t, node)
+		assert.Equal(t, io.EOF, err)
+	}
+
+	p = NewParser(strings.NewReader("(a 1) (b"))
+
+	node, err := p.NextNode()
+	assert.NoError(t, err)
+	assert.Equal(t, `(a 1)`, string(ast.Encode(node)))
+
+	node, err = p.NextNode()
+	assert.Nil(t, node)
+	assert.True(t, errors.Is(err, ErrUnexpectedEOF))
+	assert.NoError(t, p.Close())
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		p := NewParser(strings.NewReader("(a 1) (b 2) (c 3)"))
+
+		node, err := p.NextNode()
+		assert.NoError(t, err)
+		assert.Equal(t, `(a 1)`, string(ast.Encode(node)))
+
+		assert.NoError(t, p.Close())
+		assert.NoError(t, p.Close())
+
+		node, err = p.NextNode()
+		assert.Nil(t, node)
+		assert.Equal(t, io.EOF, err)
+	}
+
+	// the lexers return once they are stopped
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestExpandQuotes(t *testing.T) {
+	testCases := []struct {
+		In       string
+		Expanded string
+		Plain    string
+	}{
+		{
+			In:       `'a`,
+			Expanded: `(quote a)`,
+			Plain:    `'a`,
+		},
+		{
+			In:       "`(a ,b ,@c)",
+			Expanded: `(quasiquote (a (unquote b) (unquote-splicing c)))`,
+			Plain:    "` (a ,b ,@c)",
+		},
+		{
+			In:       `'(1 2) '[3] '{:a 4} '"s" ':k '-1 '2.5`,
+			Expanded: `(quote (1 2)) (quote [3]) (quote {:a 4}) (quote "s") (quote :k) (quote -1) (quote 2.5)`,
+			Plain:    `' (1 2) ' [3] ' {:a 4} ' "s" ':k '-1 '2.5`,
+		},
+		{
+			In:       "''a",
+			Expanded: `(quote (quote a))`,
+			Plain:    `''a`,
+		},
+		{
+			In:       "' # comment\n  a",
+			Expanded: `(quote a)`,
+			Plain:    `' a`,
+		},
+		{
+			In:       "a,b c'd",
+			Expanded: `a (unquote b) c (quote d)`,
+			Plain:    `a,b c'd`,
+		},
+	}
+
+	for _, tc := range testCases {
+		root, err := Parse([]byte(tc.In))
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Plain, string(ast.EncodeDocument(root)), tc.In)
+		}
+
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(ParserOptions{ExpandQuotes: true})
+		if assert.NoError(t, p.Parse(), tc.In) {
+			assert.Equal(t, tc.Expanded, string(ast.EncodeDocument(p.RootNode())), tc.In)
+		}
+	}
+}
+
+func TestExpandQuotesPositions(t *testing.T) {
+	p := NewParser(strings.NewReader("(a\n  `[b ,@c])"))
+	p.SetOptions(ParserOptions{ExpandQuotes: true})
+	assert.NoError(t, p.Parse())
+
+	quasi := p.RootNode().List()[0].List()[1]
+	assert.Equal(t, ast.NodeTypeExpression, quasi.Type())
+	assert.Equal(t, 2, quasi.Token().Pos().Line)
+	assert.Equal(t, 3, quasi.Token().Pos().Column)
+	assert.Equal(t, "quasiquote", quasi.List()[0].Token().Text())
+	assert.Equal(t, 3, quasi.List()[0].Token().Pos().Column)
+
+	splice := quasi.List()[1].List()[1]
+	assert.Equal(t, "(unquote-splicing c)", string(ast.Encode(splice)))
+	assert.Equal(t, 2, splice.Token().Pos().Line)
+	assert.Equal(t, 7, splice.Token().Pos().Column)
+	assert.Equal(t, 9, splice.List()[1].Token().Pos().Column)
+}
+
+func TestExpandQuotesErrors(t *testing.T) {
+	for _, in := range []string{`'`, `(a ')`, "`", `[,@]`, `',`} {
+		p := NewParser(strings.NewReader(in))
+		p.SetOptions(ParserOptions{ExpandQuotes: true, AutoCloseOnEOF: true})
+		assert.Error(t, p.Parse(), in)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	wrap := func(name string) DispatchFunc {
+		return func(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+			if p.PeekToken().Type() == lexer.TokenWord {
+				p.NextToken()
+			}
+			datum, err := p.ReadDatum()
+			if err != nil {
+				return nil, err
+			}
+			node := ast.NewExpression(tok)
+			node.PushValue(tok, ast.NewSymbolValue(name))
+			if datum.Type() == ast.NodeTypeMap {
+				for _, child := range datum.List() {
+					node.Push(child)
+				}
+				return node, nil
+			}
+			node.Push(datum)
+			return node, nil
+		}
+	}
+
+	options := ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"_":    DatumComment,
+			"{":    wrap("set"),
+			`"`:    wrap("regex"),
+			"inst": wrap("inst"),
+		},
+	}
+
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{`#{1 2 3}`, `(set 1 2 3)`},
+		{`#"a+b"`, `(regex "a+b")`},
+		{`#inst "1985-04-12"`, `(inst "1985-04-12")`},
+		{`[1 #_ 2 3]`, `[1 3]`},
+		{`[1 #_(2 (4)) 3]`, `[1 3]`},
+		{`[1 #_foo bar]`, `[1 bar]`},
+		{`[1 #_#_ 2 3 4]`, `[1 4]`},
+		{"[1 # comment\n 2]", `[1 2]`},
+		{"[1 #instant comment\n 2]", `[1 2]`},
+		{"#_\n  # comment\n  skipped kept", `kept`},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(options)
+		if assert.NoError(t, p.Parse(), tc.In) {
+			assert.Equal(t, tc.Out, string(ast.EncodeDocument(p.RootNode())), tc.In)
+		}
+	}
+
+	p := NewParser(strings.NewReader(`(a #_b c)`))
+	p.SetOptions(options)
+	assert.NoError(t, p.Parse())
+	assert.Len(t, p.Comments(), 0)
+	c := p.RootNode().List()[0].List()[1]
+	assert.Equal(t, "c", c.Value())
+	assert.Equal(t, 8, c.Token().Pos().Column)
+}
+
+func TestDispatchErrors(t *testing.T) {
+	failing := errors.New("failing")
+
+	options := ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"_": DatumComment,
+			"!": func(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+				return nil, failing
+			},
+		},
+	}
+
+	testCases := []struct {
+		In  string
+		Err error
+	}{
+		{`#_`, ErrUnexpectedEOF},
+		{`(a #_)`, ErrUnexpectedToken},
+		{`(a #_(b)`, ErrUnexpectedEOF},
+		{`(a #!)`, failing},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(options)
+		err := p.Parse()
+		assert.True(t, errors.Is(err, tc.Err), "%s: %v", tc.In, err)
+	}
+}
+
+func TestEDN(t *testing.T) {
+	testCases := []struct {
+		In    string
+		Out   string
+		Types []ast.NodeType
+	}{
+		{`nil true false nil?`, `nil true false nil?`, []ast.NodeType{ast.NodeTypeNil, ast.NodeTypeBool, ast.NodeTypeBool, ast.NodeTypeSymbol}},
+		{`:ns/name my.ns/sym /`, `:ns/name my.ns/sym /`, []ast.NodeType{ast.NodeTypeAtom, ast.NodeTypeSymbol, ast.NodeTypeSymbol}},
+		{`\a \λ \newline \u03bb \( \\ \,`, `\a \λ \newline \λ \( \\ \,`, []ast.NodeType{ast.NodeTypeChar, ast.NodeTypeChar, ast.NodeTypeChar, ast.NodeTypeChar, ast.NodeTypeChar, ast.NodeTypeChar, ast.NodeTypeChar}},
+		{`42N +7 -1.5e-3 2E2 1.5M 3.`, `42 7 -0.0015 200.0 1.5 3.0`, []ast.NodeType{ast.NodeTypeInt, ast.NodeTypeInt, ast.NodeTypeFloat, ast.NodeTypeFloat, ast.NodeTypeFloat, ast.NodeTypeFloat}},
+		{`##Inf ##-Inf`, `\+Inf \-Inf`, []ast.NodeType{ast.NodeTypeFloat, ast.NodeTypeFloat}},
+		{`#{1 #{2}} #{}`, `#{1 #{2}} #{}`, []ast.NodeType{ast.NodeTypeSet, ast.NodeTypeSet}},
+		{`#{1 1.0 "1" :a a} {1 1 1.0 1}`, `#{1 1.0 "1" :a a} {1 1 1.0 1}`, []ast.NodeType{ast.NodeTypeSet, ast.NodeTypeMap}},
+		{`.-5 a.5`, `.-5 a.5`, []ast.NodeType{ast.NodeTypeSymbol, ast.NodeTypeSymbol}},
+		{`#inst "2020-01-02" #my/tag [1]`, `#inst "2020-01-02" #my/tag [1]`, []ast.NodeType{ast.NodeTypeTagged, ast.NodeTypeTagged}},
+		{"{:a 1, :b 2} ; comment\n[x;y\n z]", `{:a 1 :b 2} [x z]`, []ast.NodeType{ast.NodeTypeMap, ast.NodeTypeList}},
+		{`[a #_ b c "d;e"]`, `[a c "d;e"]`, []ast.NodeType{ast.NodeTypeList}},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(EDNOptions())
+		if !assert.NoError(t, p.Parse(), tc.In) {
+			continue
+		}
+		assert.Equal(t, tc.Out, string(ast.EncodeDocument(p.RootNode())), tc.In)
+
+		types := []ast.NodeType{}
+		for _, node := range p.RootNode().List() {
+			types = append(types, node.Type())
+		}
+		assert.Equal(t, tc.Types, types, tc.In)
+	}
+
+	p := NewParser(strings.NewReader("[x;y\n z]"))
+	p.SetOptions(EDNOptions())
+	assert.NoError(t, p.Parse())
+	assert.Equal(t, ";y", p.Comments()[0].Text())
+	assert.Equal(t, 3, p.Comments()[0].Pos().Column)
+
+	// without the options the same input keeps its previous meaning
+	root, err := Parse([]byte(`nil a,b x;y 1e3`))
+	assert.NoError(t, err)
+	assert.Equal(t, `nil a,b x;y 1 e3`, string(ast.EncodeDocument(root)))
+	assert.Equal(t, ast.NodeTypeSymbol, root.List()[0].Type())
+}
+
+func TestEDNErrors(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Err error
+	}{
+		{`\`, ErrUnexpectedEOF},
+		{`\ a`, ErrInvalidChar},
+		{`\abc`, ErrInvalidChar},
+		{`1e`, ErrInvalidNumber},
+		{`1.5N`, ErrInvalidNumber},
+		{`##Foo`, ErrUnexpectedToken},
+		{`# x`, ErrUnexpectedToken},
+		{`#inst 1`, ErrInvalidTag},
+		{`#inst "04/12/1985"`, ErrInvalidTag},
+		{`#uuid "f81d4fae"`, ErrInvalidTag},
+		{`#tag`, ErrUnexpectedEOF},
+		{`#{1 2`, ErrUnexpectedEOF},
+		{`.5`, ErrInvalidNumber},
+		{`[a .5]`, ErrInvalidNumber},
+		{`#{1 2 1}`, ErrDuplicateKey},
+		{`#{[1 2] [1 2]}`, ErrDuplicateKey},
+		{`#{#{1 2} #{2 1}}`, ErrDuplicateKey},
+		{`{:a 1 :b 2 :a 3}`, ErrDuplicateKey},
+		{`{{:a 1 :b 2} x {:b 2 :a 1} y}`, ErrDuplicateKey},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(EDNOptions())
+		err := p.Parse()
+		assert.True(t, errors.Is(err, tc.Err), "%s: %v", tc.In, err)
+	}
+
+	_, err := Parse([]byte(`\a`))
+	assert.True(t, errors.Is(err, ErrUnexpectedToken))
+
+	// integers of any size are only read along with BigInts
+	_, err = Parse([]byte(`12345678901234567890`))
+	assert.Error(t, err)
+
+	p := NewParser(strings.NewReader(`[12345678901234567890 -12345678901234567890N]`))
+	p.SetOptions(EDNOptions())
+	assert.NoError(t, p.Parse())
+	assert.Equal(t, `[12345678901234567890 -12345678901234567890]`, string(ast.EncodeDocument(p.RootNode())))
+
+	// other dialects keep accepting them
+	root, err := Parse([]byte(`.5 {:a 1 :a 2}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `.5 {:a 1 :a 2}`, string(ast.EncodeDocument(root)))
+}
+
+func TestDialectCorpus(t *testing.T) {
+	dialects := []struct {
+		Dir     string
+		Ext     string
+		Options ParserOptions
+	}{
+		{"scheme", ".scm", SchemeOptions()},
+		{"commonlisp", ".lisp", CommonLispOptions()},
+	}
+
+	for _, dialect := range dialects {
+		files, err := filepath.Glob(filepath.Join("testdata", dialect.Dir, "*"+dialect.Ext))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, files, dialect.Dir)
+
+		for _, file := range files {
+			in, err := ioutil.ReadFile(file)
+			assert.NoError(t, err)
+			expected, err := ioutil.ReadFile(strings.TrimSuffix(file, dialect.Ext) + ".out")
+			assert.NoError(t, err)
+
+			p := NewParser(bytes.NewReader(in))
+			p.SetOptions(dialect.Options)
+			if !assert.NoError(t, p.Parse(), file) {
+				continue
+			}
+
+			var out strings.Builder
+			for _, node := range p.RootNode().List() {
+				out.Write(ast.Encode(node))
+				out.WriteString("\n")
+			}
+			assert.Equal(t, string(expected), out.String(), file)
+		}
+	}
+}
+
+func TestDialectErrors(t *testing.T) {
+	testCases := []struct {
+		In      string
+		Options ParserOptions
+		Err     error
+	}{
+		{`#| unterminated`, SchemeOptions(), ErrUnexpectedEOF},
+		{`#| #| nested |#`, SchemeOptions(), ErrUnexpectedEOF},
+		{`#tru`, SchemeOptions(), ErrUnexpectedToken},
+		{`#\nope`, SchemeOptions(), ErrInvalidChar},
+		{`#\xZZ`, SchemeOptions(), ErrInvalidChar},
+		{`#!unknown`, SchemeOptions(), ErrUnexpectedToken},
+		{`#u8(1 2)`, SchemeOptions(), ErrUnexpectedToken},
+		{`(. a)`, SchemeOptions(), ErrUnexpectedToken},
+		{`(a . b c)`, SchemeOptions(), ErrUnexpectedToken},
+		{`(a . )`, SchemeOptions(), ErrUnexpectedToken},
+		{`(a . b`, SchemeOptions(), ErrUnexpectedEOF},
+		{`#(a . b)`, SchemeOptions(), ErrUnexpectedToken},
+		{`#\Nope`, CommonLispOptions(), ErrInvalidChar},
+		{`#+sbcl (a)`, CommonLispOptions(), ErrUnexpectedToken},
+		{`#t`, CommonLispOptions(), ErrUnexpectedToken},
+		{`|unterminated`, SchemeOptions(), ErrUnexpectedEOF},
+		{`#xfg`, SMTLIBOptions(), ErrInvalidNumber},
+		{`#b012`, SMTLIBOptions(), ErrInvalidNumber},
+		{`#b`, SMTLIBOptions(), ErrInvalidNumber},
+		{`#o17`, SMTLIBOptions(), ErrUnexpectedToken},
+		{`"unterminated""`, SMTLIBOptions(), ErrUnexpectedEOF},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(tc.Options)
+		err := p.Parse()
+		assert.True(t, errors.Is(err, tc.Err), "%s: %v", tc.In, err)
+	}
+}
+
+func TestDottedPairs(t *testing.T) {
+	p := NewParser(strings.NewReader("(a b . ; comment\n c)"))
+	p.SetOptions(ParserOptions{DottedPairs: true, SemicolonComments: true})
+	assert.NoError(t, p.Parse())
+
+	expr := p.RootNode().List()[0]
+	assert.Equal(t, "(a b . c)", string(ast.Encode(expr)))
+	assert.Len(t, expr.List(), 2)
+	assert.Equal(t, "c", expr.Tail().Value())
+	assert.Equal(t, expr, expr.Tail().Parent())
+	assert.Equal(t, 2, expr.EndToken().Pos().Line)
+
+	// without the option the dot is a symbol
+	root, err := Parse([]byte("(a . b) (1.5 .c)"))
+	assert.NoError(t, err)
+	assert.Equal(t, "(a . b) (1.5 .c)", string(ast.EncodeDocument(root)))
+	assert.Nil(t, root.List()[0].Tail())
+	assert.Len(t, root.List()[0].List(), 3)
+
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{`(a . (b . (c . d)))`, `(a b c . d)`},
+		{`(a . (b c))`, `(a b c)`},
+		{`(a . ())`, `(a)`},
+		{`(a . [b])`, `(a . [b])`},
+		{`(a . 'b)`, `(a quote b)`},
+		{`((a . 1) (b . 2))`, `((a . 1) (b . 2))`},
+	}
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(ParserOptions{DottedPairs: true, ExpandQuotes: true})
+		if assert.NoError(t, p.Parse(), tc.In) {
+			assert.Equal(t, tc.Out, string(ast.EncodeDocument(p.RootNode())), tc.In)
+		}
+	}
+}
+
+func TestSMTLIB(t *testing.T) {
+	script := `; check a bit vector
+(set-logic QF_BV)
+(declare-const |x y| (_ BitVec 8))
+(assert (= (bvand |x y| #xF0) #b10100000)) ; masked
+(echo "say ""hi"" \o/")
+(check-sat)
+`
+	p := NewParser(strings.NewReader(script))
+	p.SetOptions(SMTLIBOptions())
+	assert.NoError(t, p.Parse())
+
+	nodes := p.RootNode().List()
+	assert.Len(t, nodes, 5)
+	assert.Len(t, p.Comments(), 2)
+
+	name := nodes[1].List()[1]
+	assert.Equal(t, ast.NodeTypeSymbol, name.Type())
+	assert.Equal(t, "x y", name.Value())
+	assert.Equal(t, "|x y|", name.Token().Text())
+
+	args := nodes[2].List()[1].List()
+	assert.Equal(t, ast.NodeTypeBitVector, args[1].List()[2].Type())
+	assert.Equal(t, "11110000", args[1].List()[2].Value())
+	assert.Equal(t, "10100000", args[2].Value())
+	assert.Equal(t, "#xa0", args[2].Encode())
+
+	assert.Equal(t, `say "hi" \o/`, nodes[3].List()[1].Value())
+
+	p = NewParser(strings.NewReader(`sat
+(model
+  (define-fun x () (_ BitVec 4) #b0101)
+  (define-fun |a;b| () Bool false))`))
+	p.SetOptions(SMTLIBOptions())
+	assert.NoError(t, p.Parse())
+
+	model := p.RootNode().List()[1].List()
+	assert.Equal(t, "model", model[0].Value())
+	assert.Equal(t, "#x5", model[1].List()[4].Encode())
+	assert.Equal(t, "a;b", model[2].List()[1].Value())
+
+	p = NewParser(strings.NewReader(`(|Hello World| x)`))
+	p.SetOptions(CommonLispOptions())
+	assert.NoError(t, p.Parse())
+	assert.Equal(t, "Hello World", p.RootNode().List()[0].List()[0].Value())
+	assert.Equal(t, "X", p.RootNode().List()[0].List()[1].Value())
+}
+
+func TestLexerConfig(t *testing.T) {
+	config := lexer.LispConfig()
+
+	p := NewParser(strings.NewReader("(define (null? xs) ; empty\n  (eq? xs '()))"))
+	p.SetOptions(ParserOptions{ExpandQuotes: true, Lexer: &config})
+	assert.NoError(t, p.Parse())
+
+	assert.Equal(t, `[(define (null? xs) (eq? xs (quote ())))]`, string(ast.Encode(p.RootNode())))
+	if assert.Len(t, p.Comments(), 1) {
+		assert.Equal(t, "; empty", p.Comments()[0].Text())
+	}
+	assert.Equal(t, "null?", p.RootNode().List()[0].List()[1].List()[0].Token().Text())
+
+	// a quote within a comment doesn't open a string
+	p = NewParser(strings.NewReader("; say \"hi\n(a ; comment\n b)"))
+	p.SetOptions(ParserOptions{Lexer: &config})
+	assert.NoError(t, p.Parse())
+	assert.Equal(t, `[(a b)]`, string(ast.Encode(p.RootNode())))
+	assert.Len(t, p.Comments(), 2)
+}
+
+func TestLiterals(t *testing.T) {
+	testCases := []struct {
+		In      string
+		Options ParserOptions
+		Out     string
+		Types   []ast.NodeType
+	}{
+		{
+			`true false nil`,
+			ParserOptions{Literals: true},
+			`true false nil`,
+			[]ast.NodeType{ast.NodeTypeBool, ast.NodeTypeBool, ast.NodeTypeNil},
+		},
+		{
+			`true nil`,
+			ParserOptions{},
+			`true nil`,
+			[]ast.NodeType{ast.NodeTypeSymbol, ast.NodeTypeSymbol},
+		},
+		{
+			`#t #false t nil`,
+			ParserOptions{Literals: true, LiteralNames: SchemeLiterals},
+			`true false t nil`,
+			[]ast.NodeType{ast.NodeTypeBool, ast.NodeTypeBool, ast.NodeTypeSymbol, ast.NodeTypeSymbol},
+		},
+		{
+			`T nil Nil true`,
+			ParserOptions{Literals: true, LiteralNames: CommonLispLiterals, CaseFolding: CaseUpper},
+			`true nil nil TRUE`,
+			[]ast.NodeType{ast.NodeTypeBool, ast.NodeTypeNil, ast.NodeTypeNil, ast.NodeTypeSymbol},
+		},
+		{
+			`yes no none`,
+			ParserOptions{Literals: true, LiteralNames: LiteralNames{True: []string{"yes"}, False: []string{"no"}, Nil: []string{"none"}}},
+			`true false nil`,
+			[]ast.NodeType{ast.NodeTypeBool, ast.NodeTypeBool, ast.NodeTypeNil},
+		},
+	}
+
+	for _, tc := range testCases {
+		p := NewParser(strings.NewReader(tc.In))
+		p.SetOptions(tc.Options)
+		if !assert.NoError(t, p.Parse(), tc.In) {
+			continue
+		}
+
+		assert.Equal(t, tc.Out, string(ast.EncodeDocument(p.RootNode())))
+		for i, node := range p.RootNode().List() {
+			assert.Equal(t, tc.Types[i], node.Type(), tc.In)
+		}
+	}
+
+	p := NewParser(strings.NewReader(`#t1`))
+	p.SetOptions(ParserOptions{Literals: true, LiteralNames: SchemeLiterals})
+	assert.True(t, errors.Is(p.Parse(), ErrUnexpectedToken))
+}
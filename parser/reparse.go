@@ -0,0 +1,299 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// Tree is a parsed document that keeps the offsets of its top-level forms,
+// Reparse uses them to update the tree after an edit without parsing the
+// whole document again.
+type Tree struct {
+	root    *ast.Node
+	src     []byte
+	options ParserOptions
+
+	// lines holds the offset of the beginning of each line, starts and ends
+	// hold the offsets of the beginning and the end of each top-level form
+	lines  []int
+	starts []int
+	ends   []int
+}
+
+// Edit replaces the Removed bytes found at Offset with Inserted
+type Edit struct {
+	Offset   int
+	Removed  int
+	Inserted []byte
+}
+
+// NewTree parses a source and returns its tree
+func NewTree(src []byte, options ParserOptions) (*Tree, error) {
+	t := newTree(src, options)
+
+	p := NewParser(bytes.NewReader(src))
+	p.SetOptions(options)
+	defer p.Close()
+	for {
+		n, err := p.NextNode()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := t.push(n, t.start(n), t.end(n)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newTree(src []byte, options ParserOptions) *Tree {
+	t := &Tree{
+		root:    ast.NewList(nil),
+		src:     src,
+		options: options,
+		lines:   []int{0},
+	}
+	for i, c := range src {
+		if c == '\n' {
+			t.lines = append(t.lines, i+1)
+		}
+	}
+	return t
+}
+
+// Root returns the root node of the tree, like the one returned by Parse
+func (t *Tree) Root() *ast.Node {
+	return t.root
+}
+
+// Source returns the source of the tree
+func (t *Tree) Source() []byte {
+	return t.src
+}
+
+func (t *Tree) push(n *ast.Node, start int, end int) error {
+	if err := t.root.Push(n); err != nil {
+		return err
+	}
+	t.starts = append(t.starts, start)
+	t.ends = append(t.ends, end)
+	return nil
+}
+
+// start returns the offset of the beginning of a node
+func (t *Tree) start(n *ast.Node) int {
+	offset := t.offset(n.Token().Pos())
+	if n.Type() == ast.NodeTypeString {
+		// the token of a string begins after its opening quote
+		offset--
+	}
+	return offset
+}
+
+// end returns the offset of the end of a node, the tokens that were not read
+// from the source, like the quote of 'x, are skipped
+func (t *Tree) end(n *ast.Node) int {
+	if n.Type() == ast.NodeTypeString {
+		// the text of a string is found between its quotes
+		return t.start(n) + len(n.Token().Text()) + 2
+	}
+
+	end := 0
+	tokenEnd := func(tok *lexer.Token) {
+		offset := t.offset(tok.Pos())
+		if !bytes.HasPrefix(t.src[offset:], []byte(tok.Text())) {
+			return
+		}
+		offset += len(tok.Text())
+		if offset > end {
+			end = offset
+		}
+	}
+
+	if tok := n.Token(); tok != nil {
+		tokenEnd(tok)
+	}
+	if tok := n.EndToken(); tok != nil {
+		tokenEnd(tok)
+	}
+	if !n.IsVector() {
+		return end
+	}
+	for _, child := range n.List() {
+		if offset := t.end(child); offset > end {
+			end = offset
+		}
+	}
+	if tail := n.Tail(); tail != nil {
+		if offset := t.end(tail); offset > end {
+			end = offset
+		}
+	}
+	return end
+}
+
+// offset returns the offset of a line and column, columns are counted in
+// runes. Like text/scanner, the lexer gives the newline that ends a line
+// the column 0 of the next one.
+func (t *Tree) offset(pos scanner.Position) int {
+	if pos.Line < 1 || pos.Line > len(t.lines) {
+		return len(t.src)
+	}
+	if pos.Column == 0 && pos.Line > 1 {
+		return t.lines[pos.Line-1] - 1
+	}
+	offset := t.lines[pos.Line-1]
+	for col := 1; col < pos.Column && offset < len(t.src); col++ {
+		_, size := utf8.DecodeRune(t.src[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// position returns the line and column of an offset
+func (t *Tree) position(offset int) scanner.Position {
+	line := sort.SearchInts(t.lines, offset+1)
+	col := utf8.RuneCount(t.src[t.lines[line-1]:offset]) + 1
+	return scanner.Position{Line: line, Column: col}
+}
+
+// Reparse applies an edit to the source of a tree and returns the tree of the
+// resulting source, which is identical to the one returned by NewTree. The
+// top-level forms that end before the edit are kept as they are and the parser
+// reads the source that follows them until it finds a form that begins where
+// one of the forms after the edit began, the rest of the forms are kept too,
+// their tokens are moved to their new lines and columns. Nodes are moved from
+// the previous tree to the new one, the previous tree must not be used after
+// Reparse.
+//
+// The #!fold-case and #!no-fold-case directives change the way the forms that
+// follow them are read, sources that have them are parsed again entirely.
+func Reparse(prev *Tree, edit Edit) (*Tree, error) {
+	if edit.Offset < 0 || edit.Removed < 0 || edit.Offset+edit.Removed > len(prev.src) {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d of a %d bytes source", ErrInvalidEdit, edit.Removed, edit.Offset, len(prev.src))
+	}
+
+	src := make([]byte, 0, len(prev.src)-edit.Removed+len(edit.Inserted))
+	src = append(src, prev.src[:edit.Offset]...)
+	src = append(src, edit.Inserted...)
+	src = append(src, prev.src[edit.Offset+edit.Removed:]...)
+
+	if _, ok := prev.options.Dispatch["!"]; ok && (bytes.Contains(prev.src, []byte("#!")) || bytes.Contains(src, []byte("#!"))) {
+		return NewTree(src, prev.options)
+	}
+
+	t := newTree(src, prev.options)
+	forms := prev.root.List()
+
+	// the last form that begins before the edit is read again unless it
+	// ends before the edit, as the edit can change its end
+	m := sort.SearchInts(prev.starts, edit.Offset)
+	from := 0
+	if m > 0 {
+		if from = prev.ends[m-1]; from >= edit.Offset {
+			m--
+			from = prev.starts[m]
+		}
+	}
+	for i := 0; i < m; i++ {
+		if err := t.push(forms[i], prev.starts[i], prev.ends[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// the parser begins at the same column, its lines are moved afterwards
+	pos := t.position(from)
+	in := io.MultiReader(strings.NewReader(strings.Repeat(" ", pos.Column-1)), bytes.NewReader(src[from:]))
+	p := NewParser(in)
+	p.SetOptions(prev.options)
+	defer p.Close()
+
+	delta := len(edit.Inserted) - edit.Removed
+	for {
+		n, err := p.NextNode()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			// errors are reported with the positions of the whole source
+			return NewTree(src, prev.options)
+		}
+		relocate(n, 0, pos.Line-1, 0)
+
+		start := t.start(n)
+		if start >= edit.Offset+len(edit.Inserted) {
+			if j := sort.SearchInts(prev.starts, start-delta); j < len(prev.starts) && prev.starts[j] == start-delta {
+				if err := t.reuse(prev, forms[j:], prev.starts[j:], prev.ends[j:], edit); err != nil {
+					return nil, err
+				}
+				return t, nil
+			}
+		}
+
+		if err := t.push(n, start, t.end(n)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reuse adds the forms that follow an edit to the tree, moving their tokens
+func (t *Tree) reuse(prev *Tree, forms []*ast.Node, starts []int, ends []int, edit Edit) error {
+	before := prev.position(edit.Offset + edit.Removed)
+	after := t.position(edit.Offset + len(edit.Inserted))
+	lines, columns := after.Line-before.Line, after.Column-before.Column
+
+	delta := len(edit.Inserted) - edit.Removed
+	for i, n := range forms {
+		// when the number of lines doesn't change, only the forms that
+		// begin in the line of the edit need to be moved
+		if lines != 0 || n.Token().Pos().Line <= before.Line {
+			relocate(n, before.Line, lines, columns)
+		}
+		if err := t.push(n, starts[i]+delta, ends[i]+delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relocate moves the tokens of a node and its descendants by a number of
+// lines, the tokens in the given line are also moved by a number of columns
+func relocate(n *ast.Node, line int, lines int, columns int) {
+	move := func(tok *lexer.Token) *lexer.Token {
+		pos := tok.Pos()
+		if pos.Line == 0 {
+			return tok
+		}
+		if pos.Line == line {
+			pos.Column += columns
+		}
+		pos.Line += lines
+		return lexer.NewToken(tok.Type(), tok.Text(), &pos)
+	}
+
+	if tok := n.Token(); tok != nil {
+		n.SetToken(move(tok))
+	}
+	if tok := n.EndToken(); tok != nil {
+		n.SetEndToken(move(tok))
+	}
+	if !n.IsVector() {
+		return
+	}
+	for _, child := range n.List() {
+		relocate(child, line, lines, columns)
+	}
+	if tail := n.Tail(); tail != nil {
+		relocate(tail, line, lines, columns)
+	}
+}
@@ -0,0 +1,547 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+)
+
+const (
+	symbolStart = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_"
+	symbolRest  = symbolStart + "0123456789-?!*<>=/"
+)
+
+var operators = []string{"+", "-", "*", "/", "<", "<=", ">=", "->", "!="}
+
+// separatorRunes are the runes that can't be part of a symbol that is
+// encoded
+var separatorRunes = []rune{' ', '\t', '\n', '(', ')', '[', ']', '{', '}', '"', '\\', '|'}
+
+// notEncodable are the floats that can't be encoded
+var notEncodable = []float64{math.Inf(1), math.Inf(-1), math.NaN()}
+
+var specialRunes = []rune{'"', '\\', '\n', '\t', '\r', '\x00', '\x7f', '#', '(', ')', '[', ']', '{', '}', ':', ' ', 'é', '😊', '\u00a0', '\ufeff'}
+
+// randomTree is a quick.Generator of random document trees
+type randomTree struct {
+	root *ast.Node
+}
+
+func (randomTree) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(randomTree{root: randomRoot(r, false)})
+}
+
+// extendedTree is a quick.Generator of random document trees that also have
+// the nodes that need extendedOptions: chars, bit vectors, bools, nils, sets
+// and tagged elements
+type extendedTree struct {
+	root *ast.Node
+}
+
+func (extendedTree) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(extendedTree{root: randomRoot(r, true)})
+}
+
+// extendedOptions reads every node type, maps and sets may have repeated
+// elements since the trees are random
+func extendedOptions() ParserOptions {
+	options := EDNOptions()
+	options.Dispatch["x"] = BitVectorLiteral
+	options.Dispatch["b"] = BitVectorLiteral
+	options.UniqueKeys = false
+	return options
+}
+
+func randomRoot(r *rand.Rand, extended bool) *ast.Node {
+	root := ast.NewList(nil)
+	for i := r.Intn(5); i >= 0; i-- {
+		_ = root.Push(randomNode(r, 3, extended))
+	}
+	return root
+}
+
+func randomWord(r *rand.Rand, first, rest string) string {
+	var b strings.Builder
+	b.WriteByte(first[r.Intn(len(first))])
+	for i := r.Intn(8); i > 0; i-- {
+		b.WriteByte(rest[r.Intn(len(rest))])
+	}
+	return b.String()
+}
+
+func randomString(r *rand.Rand) string {
+	var b strings.Builder
+	for i := r.Intn(12); i > 0; i-- {
+		switch r.Intn(4) {
+		case 0:
+			b.WriteRune(specialRunes[r.Intn(len(specialRunes))])
+		case 1:
+			b.WriteByte(byte(r.Intn(256)))
+		default:
+			b.WriteRune(rune(' ' + r.Intn(95)))
+		}
+	}
+	return b.String()
+}
+
+func randomFloat(r *rand.Rand) float64 {
+	if r.Intn(100) == 0 {
+		return notEncodable[r.Intn(len(notEncodable))]
+	}
+	switch r.Intn(4) {
+	case 0:
+		return float64(r.Int63n(1000) - 500)
+	case 1:
+		return r.NormFloat64() * math.Pow(10, float64(r.Intn(40)-20))
+	}
+	return r.NormFloat64() * 1000
+}
+
+// randomValue returns a value node, kinds from 5 are only read with
+// extendedOptions. Some floats and symbols can't be encoded.
+func randomValue(r *rand.Rand, kind int) *ast.Node {
+	switch kind {
+	case 0:
+		return ast.NewNode(nil, ast.NewIntValue(r.Int63()-r.Int63()))
+	case 1:
+		return ast.NewNode(nil, ast.NewFloatValue(randomFloat(r)))
+	case 2:
+		switch r.Intn(100) {
+		case 0:
+			separator := string(separatorRunes[r.Intn(len(separatorRunes))])
+			return ast.NewNode(nil, ast.NewSymbolValue(randomWord(r, symbolStart, symbolRest)+separator+randomWord(r, symbolStart, symbolRest)))
+		case 1, 2, 3, 4:
+			return ast.NewNode(nil, ast.NewSymbolValue(operators[r.Intn(len(operators))]))
+		}
+		return ast.NewNode(nil, ast.NewSymbolValue(randomWord(r, symbolStart, symbolRest)))
+	case 3:
+		return ast.NewNode(nil, ast.NewAtomValue(":"+randomWord(r, symbolStart, symbolRest)))
+	case 4:
+		return ast.NewNode(nil, ast.NewStringValue(randomString(r)))
+	case 5:
+		if r.Intn(2) == 0 {
+			return ast.NewNode(nil, ast.NewCharValue(specialRunes[r.Intn(len(specialRunes))]))
+		}
+		return ast.NewNode(nil, ast.NewCharValue(rune('!'+r.Intn(94))))
+	case 6:
+		var bits strings.Builder
+		for i := r.Intn(16); i >= 0; i-- {
+			bits.WriteByte(byte('0' + r.Intn(2)))
+		}
+		return ast.NewNode(nil, ast.NewBitVectorValue(bits.String()))
+	default:
+		if r.Intn(3) == 0 {
+			return ast.NewNode(nil, ast.NewNilValue())
+		}
+		return ast.NewNode(nil, ast.NewBoolValue(r.Intn(2) == 0))
+	}
+}
+
+func randomNode(r *rand.Rand, depth int, extended bool) *ast.Node {
+	values, vectors := 5, 3
+	if extended {
+		values, vectors = 8, 5
+	}
+	kind := r.Intn(values + vectors)
+	if depth <= 0 {
+		kind = r.Intn(values)
+	}
+
+	if kind < values {
+		return randomValue(r, kind)
+	}
+
+	var node *ast.Node
+	switch kind - values {
+	case 0:
+		node = ast.NewList(nil)
+	case 1:
+		node = ast.NewMap(nil)
+	case 2:
+		node = ast.NewExpression(nil)
+	case 3:
+		node = ast.NewSet(nil)
+	default:
+		node = ast.NewTagged(nil)
+		_ = node.Push(ast.NewNode(nil, ast.NewSymbolValue("tag-"+randomWord(r, symbolStart, symbolRest))))
+		_ = node.Push(randomNode(r, depth-1, extended))
+		return node
+	}
+	for i := r.Intn(5); i > 0; i-- {
+		_ = node.Push(randomNode(r, depth-1, extended))
+	}
+	return node
+}
+
+func equalNodes(a, b *ast.Node) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	if !a.IsVector() {
+		return a.Value() == b.Value()
+	}
+	la, lb := a.List(), b.List()
+	if len(la) != len(lb) {
+		return false
+	}
+	for i := range la {
+		if !equalNodes(la[i], lb[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// roundTrip parses the output of MarshalDocument and checks that the tree is
+// preserved, trees that can't be encoded must fail to parse from the output
+// of EncodeDocument
+func roundTrip(t *testing.T, tree *ast.Node, options ParserOptions) bool {
+	parse := func(src []byte) (*ast.Node, error) {
+		p := NewParser(bytes.NewReader(src))
+		p.SetOptions(options)
+		if err := p.Parse(); err != nil {
+			return nil, err
+		}
+		return p.RootNode(), nil
+	}
+
+	encoded, err := ast.MarshalDocument(tree)
+	if err != nil {
+		if !errors.Is(err, ast.ErrNotEncodable) {
+			t.Logf("unexpected error: %v", err)
+			return false
+		}
+		if _, err := parse(ast.EncodeDocument(tree)); err == nil {
+			t.Logf("%q was parsed: %v", ast.EncodeDocument(tree), err)
+			return false
+		}
+		return true
+	}
+
+	root, err := parse(encoded)
+	if err != nil {
+		t.Logf("could not parse %q: %v", encoded, err)
+		return false
+	}
+	if !equalNodes(tree, root) {
+		t.Logf("tree %q was not preserved", encoded)
+		return false
+	}
+	return true
+}
+
+func TestEncodeDocumentRoundTrip(t *testing.T) {
+	property := func(tree randomTree) bool {
+		return roundTrip(t, tree.root, parserDefaultOptions)
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 2000})
+	assert.NoError(t, err)
+}
+
+func TestEncodeNodeRoundTrip(t *testing.T) {
+	property := func(tree randomTree) bool {
+		for _, node := range tree.root.List() {
+			encoded, err := ast.Marshal(node)
+			if err != nil {
+				if _, err := Parse(ast.Encode(node)); !errors.Is(err, ErrUnexpectedToken) {
+					t.Logf("%q was parsed: %v", ast.Encode(node), err)
+					return false
+				}
+				continue
+			}
+
+			root, err := Parse(encoded)
+			if err != nil {
+				t.Logf("could not parse %q: %v", encoded, err)
+				return false
+			}
+			if len(root.List()) != 1 || !equalNodes(node, root.List()[0]) {
+				t.Logf("node %q was not preserved", encoded)
+				return false
+			}
+		}
+		return true
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 2000})
+	assert.NoError(t, err)
+}
+
+func TestEncodeExtendedRoundTrip(t *testing.T) {
+	property := func(tree extendedTree) bool {
+		return roundTrip(t, tree.root, extendedOptions())
+	}
+
+	err := quick.Check(property, &quick.Config{MaxCount: 2000})
+	assert.NoError(t, err)
+}
+
+func TestEncodeNotEncodable(t *testing.T) {
+	testCases := []struct {
+		Value ast.Valuer
+		Text  string
+		Err   string
+	}{
+		{ast.NewFloatValue(math.Inf(1)), `\+Inf`, `value can't be encoded: float +Inf`},
+		{ast.NewFloatValue(math.Inf(-1)), `\-Inf`, `value can't be encoded: float -Inf`},
+		{ast.NewFloatValue(math.NaN()), `\NaN`, `value can't be encoded: float NaN`},
+		{ast.NewSymbolValue("Hello World"), `\symbol"Hello World"`, `value can't be encoded: symbol "Hello World"`},
+		{ast.NewSymbolValue("a|b"), `\symbol"a|b"`, `value can't be encoded: symbol "a|b"`},
+		{ast.NewSymbolValue(""), `\symbol""`, `value can't be encoded: symbol ""`},
+	}
+
+	for _, tc := range testCases {
+		root := ast.NewList(nil)
+		_, _ = root.PushValue(nil, tc.Value)
+		_, _ = root.PushValue(nil, ast.NewIntValue(5))
+		expr, _ := root.PushExpression(nil)
+		_, _ = expr.PushValue(nil, tc.Value)
+		_, _ = expr.PushValue(nil, ast.NewIntValue(1))
+
+		encoded := ast.EncodeDocument(root)
+		assert.Equal(t, tc.Text+" 5 ("+tc.Text+" 1)", string(encoded))
+
+		// the output is rejected instead of being read as a different tree
+		for _, options := range []ParserOptions{parserDefaultOptions, SchemeOptions(), EDNOptions(), SMTLIBOptions()} {
+			p := NewParser(bytes.NewReader(encoded))
+			p.SetOptions(options)
+			assert.Error(t, p.Parse(), string(encoded))
+		}
+
+		_, err := ast.MarshalDocument(root)
+		assert.True(t, errors.Is(err, ast.ErrNotEncodable))
+		assert.EqualError(t, err, tc.Err)
+		_, err = ast.Marshal(expr)
+		assert.EqualError(t, err, tc.Err)
+	}
+
+	out, err := ast.MarshalDocument(ast.NewList(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, ``, string(out))
+}
+
+func TestEncodeSubtree(t *testing.T) {
+	root, err := Parse([]byte(`(a [1 2] {:b (c)})`))
+	assert.NoError(t, err)
+
+	expr := root.List()[0]
+	assert.Equal(t, `(a [1 2] {:b (c)})`, string(ast.Encode(expr)))
+	assert.Equal(t, `[1 2]`, string(ast.Encode(expr.List()[1])))
+	assert.Equal(t, `[(a [1 2] {:b (c)})]`, string(ast.Encode(root)))
+	assert.Equal(t, `(a [1 2] {:b (c)})`, string(ast.EncodeDocument(root)))
+}
+
+// dump describes a tree with the types, values and positions of its nodes
+func dump(n *ast.Node) string {
+	var b strings.Builder
+	var walk func(n *ast.Node, depth int)
+	walk = func(n *ast.Node, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		if tok := n.Token(); tok != nil {
+			fmt.Fprintf(&b, "%d:%d ", tok.Pos().Line, tok.Pos().Column)
+		}
+		if n.IsVector() {
+			b.WriteString(n.Type().String())
+			if tok := n.EndToken(); tok != nil {
+				fmt.Fprintf(&b, " %d:%d", tok.Pos().Line, tok.Pos().Column)
+			}
+			b.WriteString("\n")
+			for _, child := range n.List() {
+				walk(child, depth+1)
+			}
+			if tail := n.Tail(); tail != nil {
+				walk(tail, depth+1)
+			}
+			return
+		}
+		fmt.Fprintf(&b, "%s %#v\n", n.Type(), n.Value())
+	}
+	walk(n, 0)
+	return b.String()
+}
+
+func TestNewTree(t *testing.T) {
+	src := []byte("(a \"b\"\n  [1 2])\n\"é\" 'd  {:f 3.5}")
+
+	tree, err := NewTree(src, parserDefaultOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, src, tree.Source())
+	assert.Equal(t, []int{0, 16, 21, 25}, tree.starts)
+	assert.Equal(t, []int{15, 20, 23, 33}, tree.ends)
+
+	root, err := Parse(src)
+	assert.NoError(t, err)
+	assert.Equal(t, dump(root), dump(tree.Root()))
+
+	_, err = NewTree([]byte("(a b"), parserDefaultOptions)
+	assert.True(t, IsIncomplete(err))
+}
+
+func TestReparse(t *testing.T) {
+	testCases := []struct {
+		Options ParserOptions
+		Src     string
+		Offset  int
+		Removed int
+		Text    string
+
+		// Reused is the number of top-level forms that are kept from the
+		// previous tree
+		Reused int
+		Err    string
+	}{
+		{Src: "(a b) (c d) (e f)", Offset: 7, Text: "x ", Reused: 2},
+		{Src: "(a b) (c d) (e f)", Offset: 7, Removed: 1, Text: "long-name", Reused: 2},
+		{Src: "(a b)\n(c d)\n(e f)", Offset: 8, Text: "\n\n", Reused: 2},
+		{Src: "(a b)\n(c\n d) (e f)\n(g)", Offset: 10, Removed: 1, Reused: 3},
+		{Src: "(a b) (c d) (e f)", Offset: 0, Text: "(z) ", Reused: 3},
+		{Src: "(a b) (c d) (e f)", Offset: 17, Text: " (g)", Reused: 2},
+		{Src: "(a b) (c d) (e f)", Offset: 5, Removed: 6, Reused: 1},
+		{Src: "abc def ghi", Offset: 3, Text: "x", Reused: 2},
+		{Src: "abc def ghi", Offset: 3, Removed: 1, Reused: 1},
+		{Src: "(a b) (c d) (e f)", Offset: 6, Removed: 1, Err: "syntax error: unexpected token \")\" (around (line 1) (column 10))"},
+		{Src: "(a b) (c d) (e f)", Offset: 6, Text: "(", Err: "syntax error: unexpected EOF (around (line: 1) (column 19))"},
+		{Src: "(a b) (c d) (e f)", Offset: 6, Text: "\"", Err: "syntax error: unexpected EOF (around (line: 1) (column 19))"},
+		{Src: "(a b) \"c) (d\" (e f)", Offset: 6, Removed: 1, Reused: 1},
+		{Src: "(a b) (c d) (e f)", Offset: 6, Text: "\"x\" ", Reused: 3},
+		{Options: SchemeOptions(), Src: "(a b) (c d) (e f)", Offset: 6, Text: "; x\n", Reused: 3},
+		{Options: SchemeOptions(), Src: "(a b) (c d)\n(e f)", Offset: 6, Text: ";", Reused: 2},
+		{Src: "(a b) 'c (d e)", Offset: 6, Removed: 1, Reused: 2},
+		{Src: "(a b) c (d e)", Offset: 6, Text: "'", Reused: 2},
+		{Src: "(é b) (😊 d)\n(e f)", Offset: 12, Text: "ü", Reused: 2},
+		{Src: "(a b) (c\n\"d\"\n) (e f)", Offset: 2, Removed: 3, Text: "\n  x\n)", Reused: 2},
+		{Src: "\"\nab\" x", Offset: 3, Removed: 1, Text: "Z", Reused: 1},
+		{Src: "(a) \"\n\n\" (b)", Offset: 8, Text: "c", Reused: 2},
+		{Src: "(a b) (c d) (e f)", Offset: 18, Err: "invalid edit: 0 bytes at offset 18 of a 17 bytes source"},
+		{Src: "(a b) (c d) (e f)", Offset: 10, Removed: 8, Err: "invalid edit: 8 bytes at offset 10 of a 17 bytes source"},
+		{Options: SchemeOptions(), Src: "(a b) (c d) (e f)", Offset: 6, Text: "#;", Reused: 2},
+		{Options: SchemeOptions(), Src: "(a b) #| (c d) |# (e f)", Offset: 6, Removed: 2, Reused: 2},
+		{Options: SchemeOptions(), Src: "(a b) (c . d) (e f)", Offset: 9, Removed: 2, Reused: 2},
+		{Options: SchemeOptions(), Src: "(a b) (C d)", Offset: 0, Text: "#!fold-case ", Reused: 0},
+		{Options: SchemeOptions(), Src: "#!fold-case (a b) (C d)", Offset: 21, Text: "E", Reused: 0},
+		{Options: EDNOptions(), Src: "{:a 1} #{2 3} [4]", Offset: 8, Text: " 5", Reused: 2},
+		{Options: EDNOptions(), Src: "{:a 1} #{2 3} [4]", Offset: 7, Removed: 1, Reused: 2},
+		{Options: EDNOptions(), Src: "{:a 1} #_ [2] (3) [4]", Offset: 7, Removed: 2, Reused: 3},
+	}
+
+	for i, tc := range testCases {
+		options := tc.Options
+		prev, err := NewTree([]byte(tc.Src), options)
+		assert.NoError(t, err, "case %d", i)
+		forms := append([]*ast.Node{}, prev.Root().List()...)
+
+		edit := Edit{Offset: tc.Offset, Removed: tc.Removed, Inserted: []byte(tc.Text)}
+		tree, err := Reparse(prev, edit)
+
+		if tc.Offset > len(tc.Src) || tc.Offset+tc.Removed > len(tc.Src) {
+			assert.Error(t, err, "case %d", i)
+			assert.Equal(t, tc.Err, err.Error(), "case %d", i)
+			assert.True(t, errors.Is(err, ErrInvalidEdit), "case %d", i)
+			continue
+		}
+
+		src := tc.Src[:tc.Offset] + tc.Text + tc.Src[tc.Offset+tc.Removed:]
+		expected, expectedErr := NewTree([]byte(src), options)
+		if expectedErr != nil {
+			assert.Error(t, err, "case %d", i)
+			assert.Equal(t, expectedErr.Error(), err.Error(), "case %d", i)
+			if tc.Err != "" {
+				assert.Equal(t, tc.Err, err.Error(), "case %d", i)
+			}
+			continue
+		}
+
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		assert.Equal(t, src, string(tree.Source()), "case %d", i)
+		assert.Equal(t, dump(expected.Root()), dump(tree.Root()), "case %d: %q", i, src)
+		assert.Equal(t, expected.starts, tree.starts, "case %d", i)
+		assert.Equal(t, expected.ends, tree.ends, "case %d", i)
+
+		reused := 0
+		for _, n := range tree.Root().List() {
+			for _, m := range forms {
+				if n == m {
+					reused++
+				}
+			}
+			assert.Equal(t, tree.Root(), n.Parent(), "case %d", i)
+		}
+		assert.Equal(t, tc.Reused, reused, "case %d: %q", i, src)
+	}
+}
+
+// TestReparseRandomEdits applies random edits to documents and compares the
+// trees with the ones of a full parse
+func TestReparseRandomEdits(t *testing.T) {
+	documents := []struct {
+		Options ParserOptions
+		Src     string
+	}{
+		{Src: "(define (f x)\n  (* x x))\n\n(display (f 2))\n\"a string\" 'sym [1 2.5 3]\n"},
+		{Src: "(a (b (c)) \"é 😊\")\n\t(d\n\t e)\nf g h\n{:k v}"},
+		{Options: SchemeOptions(), Src: "; header\n(define (f x) #| inline |# x)\n#;(skipped)\n(g . h) #\\a\n"},
+		{Options: EDNOptions(), Src: "{:a [1 2]\n :b #{3}}\n#_ (c) \"d\" :e/f\n"},
+		{Src: "\"\nab\" x\n(\"\n\n\" \"\") \"é\n\"\n\"\" y"},
+	}
+	fragments := []string{"(", ")", "\"", " ", "\n", "x", "ü", "; c\n", "#|", "|#", "#;", "#_", "'", "[1 2]", "(a b)", "\n(c)\n", "\"\n", "\n\""}
+
+	r := rand.New(rand.NewSource(1))
+	for _, doc := range documents {
+		tree, err := NewTree([]byte(doc.Src), doc.Options)
+		assert.NoError(t, err)
+
+		for i := 0; i < 500; i++ {
+			src := tree.Source()
+			offset := r.Intn(len(src) + 1)
+			removed := 0
+			if offset < len(src) && r.Intn(2) == 0 {
+				removed = r.Intn(len(src)-offset+1) % 8
+			}
+			for offset+removed < len(src) && !utf8.RuneStart(src[offset+removed]) {
+				removed++
+			}
+			for offset > 0 && offset < len(src) && !utf8.RuneStart(src[offset]) {
+				offset--
+				removed++
+			}
+			edit := Edit{Offset: offset, Removed: removed, Inserted: []byte(fragments[r.Intn(len(fragments))])}
+
+			next := append(append(append([]byte{}, src[:offset]...), edit.Inserted...), src[offset+removed:]...)
+			expected, expectedErr := NewTree(next, doc.Options)
+
+			reparsed, err := Reparse(tree, edit)
+			if expectedErr != nil {
+				if assert.Error(t, err, "%q", next) {
+					assert.Equal(t, expectedErr.Error(), err.Error(), "%q", next)
+				}
+				// the edit is discarded, the previous tree is parsed again
+				tree, err = NewTree(src, doc.Options)
+				assert.NoError(t, err)
+				continue
+			}
+			if !assert.NoError(t, err, "%q", next) {
+				return
+			}
+			if !assert.Equal(t, dump(expected.Root()), dump(reparsed.Root()), "%q", next) {
+				return
+			}
+			assert.True(t, bytes.Equal(next, reparsed.Source()))
+			assert.Equal(t, expected.starts, reparsed.starts, "%q", next)
+			assert.Equal(t, expected.ends, reparsed.ends, "%q", next)
+			tree = reparsed
+		}
+	}
+}
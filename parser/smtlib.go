@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+// SMTLIBOptions returns the options that make the parser read SMT-LIB v2
+// scripts and solver responses: comments begin with ;, |hello world| is a
+// symbol, a double quote within a string is written as "", numerals have no
+// size limit and #x1f and #b0101 are bit vectors. Any other # syntax is an
+// error.
+func SMTLIBOptions() ParserOptions {
+	return ParserOptions{
+		Dispatch: map[string]DispatchFunc{
+			"x": BitVectorLiteral,
+			"b": BitVectorLiteral,
+			"":  Unsupported,
+		},
+		SemicolonComments: true,
+		QuotedSymbols:     true,
+		DoubledQuotes:     true,
+		BigInts:           true,
+	}
+}
+
+// BitVectorLiteral is a DispatchFunc that reads the hexadecimal (#x1f) and
+// binary (#b0101) bit vectors of SMT-LIB, it must be registered as x and b.
+func BitVectorLiteral(p *Parser, tok *lexer.Token) (*ast.Node, error) {
+	p.NextToken()
+	tokens := append([]*lexer.Token{tok}, expectSymbolTokens(p)...)
+
+	literal := mergeTokens(lexer.TokenSequence, tokens)
+	digits := literal.Text()[2:]
+	if digits == "" {
+		return nil, ErrInvalidNumber
+	}
+
+	var bits strings.Builder
+	for _, r := range digits {
+		switch literal.Text()[1] {
+		case 'x':
+			v, err := strconv.ParseUint(string(r), 16, 8)
+			if err != nil {
+				return nil, ErrInvalidNumber
+			}
+			bits.WriteString(strconv.FormatUint(v|0x10, 2)[1:])
+		case 'b':
+			if r != '0' && r != '1' {
+				return nil, ErrInvalidNumber
+			}
+			bits.WriteRune(r)
+		}
+	}
+
+	return ast.NewNode(literal, ast.NewBitVectorValue(bits.String())), nil
+}
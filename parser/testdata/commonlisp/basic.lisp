@@ -0,0 +1,12 @@
+;;;; Common Lisp basics
+(defun fact (n)
+  "Returns the factorial of N."
+  (if (zerop n)
+      1
+      (* n (fact (1- n)))))
+
+(defparameter *items* (list :apple :Banana 'cherry))
+(mapcar #'1+ '(1 2 3))
+(defmacro swap (a b) `(rotatef ,a ,b))
+(cl-user::foo :key 1.5e2)
+(member nil (list T Nil) :test #'equal)
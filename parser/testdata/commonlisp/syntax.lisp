@@ -0,0 +1,7 @@
+#| block comments
+   #| can be nested |#
+|#
+(setq chars (list #\a #\Space #\NEWLINE #\Tab #\( #\)))
+(setq v #(1 2 #(3)))
+(setq alist '((a . 1) (b . (2 3)) (c d . e)))
+(setq x '(1 . 2)) ; a comment
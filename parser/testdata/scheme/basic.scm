@@ -0,0 +1,9 @@
+;;; R7RS basics
+(define (fact n)
+  (if (= n 0)
+      1
+      (* n (fact (- n 1)))))
+
+(define-record-type point (make-point x y) point? (x point-x) (y point-y set-point-y!))
+(let loop ((i 0)) (when (< i 10) (display i) (loop (+ i 1))))
+(list->vector '(1 2 3)) (string=? "a" "b") (exact->inexact 1/3)
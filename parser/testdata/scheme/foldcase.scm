@@ -0,0 +1,5 @@
+(Define Hello 'World)
+#!fold-case
+(DEFINE Hello 'World)
+#!no-fold-case
+(Define Hello #\A)
@@ -0,0 +1,9 @@
+#| a block comment
+   #| nested |# still a comment |#
+(define flags '(#t #f #true #false))
+(define chars (list #\a #\A #\space #\newline #\x3bb #\( #\\ #\;))
+(define v #(1 "two" #(3)))
+(define pairs '((a . 1) (b . 2) (c d . e)))
+#;(this form is ignored)
+(display `(1 ,(+ 1 1) ,@(list 3 4))) ; trailing comment
+(define x 1.5e3)
@@ -0,0 +1,296 @@
+// Package query selects nodes of S-expression trees with path-like selectors,
+// like the ones used to read values from configuration files:
+//
+//	(server
+//	  (name "web")
+//	  (listen {:host "0.0.0.0" :port 8080})
+//	  (tags [:a :b]))
+//
+//	server/name          (name "web")
+//	server/name/[0]      "web"
+//	server/listen/*/port 8080
+//	**/tags[0][-1]       :b
+//
+// A selector is a sequence of steps separated by slashes, each step is
+// applied to the nodes selected by the previous one, starting with the root
+// of the document:
+//
+//	name    selects the expressions within the nodes whose head is name,
+//	        the whole expression and not its arguments, and the values of
+//	        the map entries whose key is name. Heads and keys are compared
+//	        with their encoding, strings and atoms also match their name
+//	        without quotes or colon, so port matches port, "port" and
+//	        :port. A quoted name, like "a/b", only matches strings, it can
+//	        hold slashes or spaces.
+//	*       selects the children of the nodes: the arguments of expressions,
+//	        the values of maps and the elements of lists and sets.
+//	**      selects the nodes and all their descendants.
+//	[n]     selects the nth child of the nodes, in the same order as *,
+//	        negative indexes count from the end.
+//
+// Indexes can follow another step, name[n] is the same as name/[n] and
+// *[0][1] the same as */[0]/[1].
+package query
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// ErrInvalidSelector is returned when a selector can't be compiled
+var ErrInvalidSelector = errors.New("invalid selector")
+
+type stepKind int
+
+const (
+	stepName stepKind = iota
+	stepChildren
+	stepDescendants
+	stepIndex
+)
+
+type step struct {
+	kind   stepKind
+	name   string
+	quoted bool
+	index  int
+}
+
+// Selector is a compiled selector
+type Selector struct {
+	expr  string
+	steps []step
+}
+
+// Compile compiles a selector expression
+func Compile(expr string) (*Selector, error) {
+	s := &Selector{expr: expr}
+
+	segments, err := split(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if err := s.parseSegment(segment); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MustCompile is like Compile but panics if the selector can't be compiled
+func MustCompile(expr string) *Selector {
+	s, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Select returns the nodes of a document that match a selector
+func Select(root *ast.Node, expr string) ([]*ast.Node, error) {
+	s, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Select(root), nil
+}
+
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Select returns the nodes that match the selector, in the order they appear
+// in the document. The given node is the root of the document, like the one
+// returned by the parser.
+func (s *Selector) Select(root *ast.Node) []*ast.Node {
+	nodes := []*ast.Node{root}
+	for _, st := range s.steps {
+		var next []*ast.Node
+		seen := map[*ast.Node]bool{}
+		add := func(n *ast.Node) {
+			if !seen[n] {
+				seen[n] = true
+				next = append(next, n)
+			}
+		}
+		for _, n := range nodes {
+			st.apply(n, add)
+		}
+		nodes = next
+	}
+
+	if len(nodes) > 1 {
+		order := map[*ast.Node]int{}
+		ast.Walk(root, func(n *ast.Node) bool {
+			order[n] = len(order)
+			return true
+		})
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return order[nodes[i]] < order[nodes[j]]
+		})
+	}
+	return nodes
+}
+
+func (st step) apply(n *ast.Node, add func(*ast.Node)) {
+	if !n.IsVector() {
+		return
+	}
+
+	switch st.kind {
+	case stepName:
+		if n.Type() == ast.NodeTypeMap {
+			list := n.List()
+			for i := 0; i+1 < len(list); i += 2 {
+				if st.match(list[i]) {
+					add(list[i+1])
+				}
+			}
+			return
+		}
+		for _, child := range n.List() {
+			if child.Type() != ast.NodeTypeExpression || len(child.List()) == 0 {
+				continue
+			}
+			if st.match(child.List()[0]) {
+				add(child)
+			}
+		}
+
+	case stepChildren:
+		for _, child := range children(n) {
+			add(child)
+		}
+
+	case stepDescendants:
+		ast.Walk(n, func(n *ast.Node) bool {
+			add(n)
+			return true
+		})
+
+	case stepIndex:
+		list := children(n)
+		i := st.index
+		if i < 0 {
+			i += len(list)
+		}
+		if i >= 0 && i < len(list) {
+			add(list[i])
+		}
+	}
+}
+
+// children returns the arguments of an expression, the values of a map or the
+// elements of any other vector
+func children(n *ast.Node) []*ast.Node {
+	list := n.List()
+	switch n.Type() {
+	case ast.NodeTypeExpression:
+		if len(list) == 0 {
+			return nil
+		}
+		return list[1:]
+	case ast.NodeTypeMap:
+		values := make([]*ast.Node, 0, len(list)/2)
+		for i := 1; i < len(list); i += 2 {
+			values = append(values, list[i])
+		}
+		return values
+	}
+	return list
+}
+
+// match returns true if the name of the step matches the head of an
+// expression or a map key
+func (st step) match(key *ast.Node) bool {
+	if st.quoted {
+		return key.Type() == ast.NodeTypeString && key.Value().(string) == st.name
+	}
+	if string(ast.Encode(key)) == st.name {
+		return true
+	}
+	switch key.Type() {
+	case ast.NodeTypeString:
+		return key.Value().(string) == st.name
+	case ast.NodeTypeAtom:
+		return strings.TrimPrefix(key.Value().(string), ":") == st.name
+	}
+	return false
+}
+
+// split splits a selector at the slashes that are not within quotes
+func split(expr string) ([]string, error) {
+	var segments []string
+	var segment strings.Builder
+	quoted, escaped := false, false
+	for _, r := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == '/' && !quoted:
+			segments = append(segments, segment.String())
+			segment.Reset()
+			continue
+		}
+		segment.WriteRune(r)
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: %q: unterminated string", ErrInvalidSelector, expr)
+	}
+	return append(segments, segment.String()), nil
+}
+
+func (s *Selector) parseSegment(segment string) error {
+	segment = strings.TrimSpace(segment)
+
+	// indexes are read from the end of the segment
+	var indexes []int
+	for !strings.HasPrefix(segment, `"`) && strings.HasSuffix(segment, "]") {
+		i := strings.LastIndex(segment, "[")
+		if i < 0 {
+			break
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(segment[i+1 : len(segment)-1]))
+		if err != nil {
+			return fmt.Errorf("%w: %q: invalid index %q", ErrInvalidSelector, s.expr, segment[i+1:len(segment)-1])
+		}
+		indexes = append([]int{index}, indexes...)
+		segment = segment[:i]
+	}
+
+	switch segment {
+	case "":
+		if len(indexes) == 0 {
+			return fmt.Errorf("%w: %q: empty step", ErrInvalidSelector, s.expr)
+		}
+	case "*":
+		s.steps = append(s.steps, step{kind: stepChildren})
+	case "**":
+		s.steps = append(s.steps, step{kind: stepDescendants})
+	default:
+		if !strings.HasPrefix(segment, `"`) {
+			s.steps = append(s.steps, step{kind: stepName, name: segment})
+			break
+		}
+		name, err := strconv.Unquote(segment)
+		if err != nil {
+			return fmt.Errorf("%w: %q: invalid string %s", ErrInvalidSelector, s.expr, segment)
+		}
+		s.steps = append(s.steps, step{kind: stepName, name: name, quoted: true})
+	}
+
+	for _, index := range indexes {
+		s.steps = append(s.steps, step{kind: stepIndex, index: index})
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+const config = `
+(server
+  (name "web")
+  (listen {:host "0.0.0.0" :port 8080})
+  (tags [:a :b]))
+
+(server
+  (name "db")
+  (listen {"host" "10.0.0.1" "port" 5432 "a/b" true})
+  (replicas (server (name "db-2"))))
+
+("a b" 1)
+`
+
+func encode(nodes []*ast.Node) []string {
+	out := []string{}
+	for _, n := range nodes {
+		out = append(out, string(ast.Encode(n)))
+	}
+	return out
+}
+
+func TestSelect(t *testing.T) {
+	root, err := parser.Parse([]byte(config))
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		Selector string
+		Out      []string
+	}{
+		{`server/name`, []string{`(name "web")`, `(name "db")`}},
+		{`server/name/[0]`, []string{`"web"`, `"db"`}},
+		{`server/name[0]`, []string{`"web"`, `"db"`}},
+		{`server/listen/*/port`, []string{`8080`, `5432`}},
+		{`server/listen/*/:host`, []string{`"0.0.0.0"`}},
+		{`server/listen/*/"host"`, []string{`"10.0.0.1"`}},
+		{`server/listen/*/"a/b"`, []string{`true`}},
+		{`**/tags/[0]/[-1]`, []string{`:b`}},
+		{`**/tags[0][-1]`, []string{`:b`}},
+		{`**/tags/[5]`, []string{}},
+		{`**/server/name/[0]`, []string{`"web"`, `"db"`, `"db-2"`}},
+		{`[1]/[0]`, []string{`(name "db")`}},
+		{`server[0]`, []string{`(name "web")`, `(name "db")`}},
+		{`server/tags/*`, []string{`[:a :b]`}},
+		{`server/tags/*/*`, []string{`:a`, `:b`}},
+		{` server / name `, []string{`(name "web")`, `(name "db")`}},
+		{`client`, []string{}},
+		{`**/*/[0]`, []string{`(name "web")`, `"web"`, `{:host "0.0.0.0" :port 8080}`, `"0.0.0.0"`, `[:a :b]`, `:a`, `(name "db")`, `"db"`, `{"host" "10.0.0.1" "port" 5432 "a/b" true}`, `"10.0.0.1"`, `(server (name "db-2"))`, `(name "db-2")`, `"db-2"`, `1`}},
+		{`server/"name"`, []string{}},
+		{`"a b"`, []string{`("a b" 1)`}},
+	}
+
+	for _, tc := range testCases {
+		nodes, err := Select(root, tc.Selector)
+		if assert.NoError(t, err, tc.Selector) {
+			assert.Equal(t, tc.Out, encode(nodes), tc.Selector)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		Selector string
+		Msg      string
+	}{
+		{``, `invalid selector: "": empty step`},
+		{`a//b`, `invalid selector: "a//b": empty step`},
+		{`a/"b`, `invalid selector: "a/\"b": unterminated string`},
+		{`a[x]`, `invalid selector: "a[x]": invalid index "x"`},
+	}
+
+	for _, tc := range testCases {
+		_, err := Compile(tc.Selector)
+		assert.True(t, errors.Is(err, ErrInvalidSelector), tc.Selector)
+		if assert.Error(t, err, tc.Selector) {
+			assert.Equal(t, tc.Msg, err.Error(), tc.Selector)
+		}
+	}
+
+	assert.Panics(t, func() { MustCompile(`a[x]`) })
+	assert.Equal(t, `a/b`, MustCompile(`a/b`).String())
+}
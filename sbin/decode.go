@@ -0,0 +1,345 @@
+package sbin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"text/scanner"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+type reader struct {
+	data []byte
+	pos  int
+
+	strings []string
+}
+
+func (r *reader) corrupted(format string, args ...interface{}) error {
+	return fmt.Errorf("sbin: %w: %s", ErrCorrupted, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, r.corrupted("unexpected end of data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.corrupted("invalid varint at offset %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *reader) varint() (int64, error) {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.corrupted("invalid varint at offset %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *reader) int() (int, error) {
+	v, err := r.varint()
+	if err != nil {
+		return 0, err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return 0, r.corrupted("value %d out of range", v)
+	}
+	return int(v), nil
+}
+
+func (r *reader) text() (string, error) {
+	ref, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if ref > 0 {
+		if ref > uint64(len(r.strings)) {
+			return "", r.corrupted("invalid string reference %d", ref)
+		}
+		return r.strings[ref-1], nil
+	}
+
+	length, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(len(r.data)-r.pos) {
+		return "", r.corrupted("string length %d exceeds data", length)
+	}
+	s := string(r.data[r.pos : r.pos+int(length)])
+	r.pos += int(length)
+	r.strings = append(r.strings, s)
+	return s, nil
+}
+
+type decoder struct {
+	tree      *reader
+	positions *reader
+
+	line int
+}
+
+// Decode decodes a tree, the position table is only read if Positions is
+// enabled.
+func (o Options) Decode(data []byte) (*ast.Node, error) {
+	if len(data) < len(Magic)+2 || string(data[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("sbin: %w", ErrInvalidHeader)
+	}
+	if version := data[len(Magic)]; version != Version {
+		return nil, fmt.Errorf("sbin: %w %d", ErrUnsupportedVersion, version)
+	}
+	flags := data[len(Magic)+1]
+
+	header := &reader{data: data, pos: len(Magic) + 2}
+	length, err := header.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(data)-header.pos) {
+		return nil, header.corrupted("tree length %d exceeds data", length)
+	}
+	treeEnd := header.pos + int(length)
+
+	d := &decoder{tree: &reader{data: data[header.pos:treeEnd]}}
+	if o.Positions && flags&flagPositions != 0 {
+		d.positions = &reader{data: data[treeEnd:]}
+	}
+
+	n, err := d.node()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.tree.pos != len(d.tree.data) {
+		return nil, d.tree.corrupted("trailing data in tree")
+	}
+	if d.positions != nil && d.positions.pos != len(d.positions.data) {
+		return nil, d.positions.corrupted("trailing data in position table")
+	}
+	return n, nil
+}
+
+// position reads the position of a token, relative to the previous one
+func (d *decoder) position() (*scanner.Position, error) {
+	delta, err := d.positions.int()
+	if err != nil {
+		return nil, err
+	}
+	column, err := d.positions.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if column > math.MaxInt32 {
+		return nil, d.positions.corrupted("column %d out of range", column)
+	}
+	d.line += delta
+	return &scanner.Position{Line: d.line, Column: int(column)}, nil
+}
+
+// token reads the first part of the position table entry of a node and
+// returns the token of the node, if any. Texts are only stored when they are
+// not the canonical text of the node.
+func (d *decoder) token(nt ast.NodeType, v valuer) (*lexer.Token, byte, error) {
+	flags, err := d.positions.byte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if flags&posStart == 0 {
+		return nil, flags, nil
+	}
+	pos, err := d.position()
+	if err != nil {
+		return nil, 0, err
+	}
+	text := ""
+	if flags&posText != 0 {
+		if text, err = d.positions.text(); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		text = tokenText(nt, v)
+	}
+	tt, _, _ := tokenTypes(nt)
+	return lexer.NewToken(tt, text, pos), flags, nil
+}
+
+func (d *decoder) node() (*ast.Node, error) {
+	tag, err := d.tree.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case tagExpression, tagList, tagMap, tagDotted, tagSet, tagTagged:
+		return d.vector(tag)
+	}
+
+	var v ast.Valuer
+	switch tag {
+	case tagInt:
+		i64, err := d.tree.varint()
+		if err != nil {
+			return nil, err
+		}
+		v = ast.NewIntValue(i64)
+
+	case tagFloat:
+		if len(d.tree.data)-d.tree.pos < 8 {
+			return nil, d.tree.corrupted("unexpected end of data")
+		}
+		bits := binary.LittleEndian.Uint64(d.tree.data[d.tree.pos:])
+		d.tree.pos += 8
+		v = ast.NewFloatValue(math.Float64frombits(bits))
+
+	case tagString, tagSymbol, tagAtom:
+		s, err := d.tree.text()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagString:
+			v = ast.NewStringValue(s)
+		case tagSymbol:
+			v = ast.NewSymbolValue(s)
+		default:
+			v = ast.NewAtomValue(s)
+		}
+
+	case tagFalse, tagTrue:
+		v = ast.NewBoolValue(tag == tagTrue)
+
+	case tagNil:
+		v = ast.NewNilValue()
+
+	case tagBigInt:
+		s, err := d.tree.text()
+		if err != nil {
+			return nil, err
+		}
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, d.tree.corrupted("invalid int %q", s)
+		}
+		v = ast.NewBigIntValue(i)
+
+	case tagChar:
+		r, err := d.tree.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if r > utf8.MaxRune {
+			return nil, d.tree.corrupted("char %d out of range", r)
+		}
+		v = ast.NewCharValue(rune(r))
+
+	case tagBitVector:
+		bits, err := d.tree.text()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Trim(bits, "01") != "" {
+			return nil, d.tree.corrupted("invalid bit vector %q", bits)
+		}
+		v = ast.NewBitVectorValue(bits)
+
+	default:
+		return nil, d.tree.corrupted("unknown tag %d at offset %d", tag, d.tree.pos-1)
+	}
+
+	if d.positions == nil {
+		return ast.NewNode(nil, v), nil
+	}
+
+	tok, _, err := d.token(v.Type(), v)
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewNode(tok, v), nil
+}
+
+func (d *decoder) vector(tag byte) (*ast.Node, error) {
+	nt := ast.NodeTypeMap
+	switch tag {
+	case tagExpression, tagDotted:
+		nt = ast.NodeTypeExpression
+	case tagList:
+		nt = ast.NodeTypeList
+	case tagSet:
+		nt = ast.NodeTypeSet
+	case tagTagged:
+		nt = ast.NodeTypeTagged
+	}
+
+	var tok *lexer.Token
+	var flags byte
+	if d.positions != nil {
+		var err error
+		if tok, flags, err = d.token(nt, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var n *ast.Node
+	switch nt {
+	case ast.NodeTypeExpression:
+		n = ast.NewExpression(tok)
+	case ast.NodeTypeList:
+		n = ast.NewList(tok)
+	case ast.NodeTypeSet:
+		n = ast.NewSet(tok)
+	case ast.NodeTypeTagged:
+		n = ast.NewTagged(tok)
+	default:
+		n = ast.NewMap(tok)
+	}
+
+	count, err := d.tree.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(d.tree.data)-d.tree.pos) {
+		return nil, d.tree.corrupted("vector length %d exceeds data", count)
+	}
+	for i := uint64(0); i < count; i++ {
+		child, err := d.node()
+		if err != nil {
+			return nil, err
+		}
+		if err := n.Push(child); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag == tagDotted {
+		tail, err := d.node()
+		if err != nil {
+			return nil, err
+		}
+		n.SetTail(tail)
+	}
+
+	if flags&posEnd != 0 {
+		pos, err := d.position()
+		if err != nil {
+			return nil, err
+		}
+		_, close, text := tokenTypes(nt)
+		n.SetEndToken(lexer.NewToken(close, text, pos))
+	}
+	return n, nil
+}
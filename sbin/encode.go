@@ -0,0 +1,257 @@
+package sbin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/lexer"
+)
+
+const (
+	posStart byte = 1 << iota
+	posEnd
+	posText
+)
+
+type writer struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+
+	strings map[string]uint64
+}
+
+func newWriter() *writer {
+	return &writer{strings: map[string]uint64{}}
+}
+
+func (w *writer) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) uvarint(v uint64) {
+	n := binary.PutUvarint(w.scratch[:], v)
+	w.buf = append(w.buf, w.scratch[:n]...)
+}
+
+func (w *writer) varint(v int64) {
+	n := binary.PutVarint(w.scratch[:], v)
+	w.buf = append(w.buf, w.scratch[:n]...)
+}
+
+// text writes an interned string: a reference to a previous occurrence or the
+// string itself.
+func (w *writer) text(s string) {
+	if idx, ok := w.strings[s]; ok {
+		w.uvarint(idx + 1)
+		return
+	}
+	w.strings[s] = uint64(len(w.strings))
+	w.uvarint(0)
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// Encode encodes the given tree, a nil node can't be encoded.
+func (o Options) Encode(n *ast.Node) ([]byte, error) {
+	if n == nil {
+		return nil, fmt.Errorf("sbin: %w: nil", ErrUnsupportedNode)
+	}
+
+	tree := newWriter()
+	if err := tree.node(n); err != nil {
+		return nil, err
+	}
+
+	header := newWriter()
+	header.buf = append(header.buf, Magic...)
+	header.byte(Version)
+
+	var flags byte
+	if o.Positions {
+		flags |= flagPositions
+	}
+	header.byte(flags)
+	header.uvarint(uint64(len(tree.buf)))
+
+	out := append(header.buf, tree.buf...)
+	if o.Positions {
+		positions := newWriter()
+		positions.positions(n, 0)
+		out = append(out, positions.buf...)
+	}
+	return out, nil
+}
+
+func (w *writer) node(n *ast.Node) error {
+	switch n.Type() {
+	case ast.NodeTypeExpression, ast.NodeTypeList, ast.NodeTypeMap, ast.NodeTypeSet, ast.NodeTypeTagged:
+		switch {
+		case n.Tail() != nil:
+			w.byte(tagDotted)
+		case n.Type() == ast.NodeTypeExpression:
+			w.byte(tagExpression)
+		case n.Type() == ast.NodeTypeList:
+			w.byte(tagList)
+		case n.Type() == ast.NodeTypeMap:
+			w.byte(tagMap)
+		case n.Type() == ast.NodeTypeSet:
+			w.byte(tagSet)
+		case n.Type() == ast.NodeTypeTagged:
+			w.byte(tagTagged)
+		}
+		children := n.List()
+		w.uvarint(uint64(len(children)))
+		for _, child := range children {
+			if err := w.node(child); err != nil {
+				return err
+			}
+		}
+		if tail := n.Tail(); tail != nil {
+			return w.node(tail)
+		}
+		return nil
+
+	case ast.NodeTypeInt:
+		if v, ok := n.Int(); ok {
+			w.byte(tagInt)
+			w.varint(v)
+			break
+		}
+		w.byte(tagBigInt)
+		w.text(n.Encode())
+
+	case ast.NodeTypeFloat:
+		w.byte(tagFloat)
+		bits := math.Float64bits(n.Value().(float64))
+		w.buf = append(w.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(w.buf[len(w.buf)-8:], bits)
+
+	case ast.NodeTypeString:
+		w.byte(tagString)
+		w.text(n.Value().(string))
+
+	case ast.NodeTypeSymbol:
+		w.byte(tagSymbol)
+		w.text(n.Value().(string))
+
+	case ast.NodeTypeAtom:
+		w.byte(tagAtom)
+		w.text(n.Value().(string))
+
+	case ast.NodeTypeBool:
+		if n.Value().(bool) {
+			w.byte(tagTrue)
+		} else {
+			w.byte(tagFalse)
+		}
+
+	case ast.NodeTypeNil:
+		w.byte(tagNil)
+
+	case ast.NodeTypeChar:
+		w.byte(tagChar)
+		w.uvarint(uint64(n.Value().(rune)))
+
+	case ast.NodeTypeBitVector:
+		w.byte(tagBitVector)
+		w.text(n.Value().(string))
+
+	default:
+		return fmt.Errorf("sbin: %w: %v", ErrUnsupportedNode, n.Type())
+	}
+	return nil
+}
+
+// positions writes the position table entries of the given tree, in the same
+// order as the nodes, line numbers are written as the difference from the
+// previous line.
+func (w *writer) positions(n *ast.Node, line int) int {
+	var flags byte
+	tok, end := n.Token(), n.EndToken()
+	if tok != nil {
+		flags |= posStart
+		if tok.Text() != tokenText(n.Type(), n) {
+			flags |= posText
+		}
+	}
+	if n.IsVector() && end != nil {
+		flags |= posEnd
+	}
+	w.byte(flags)
+
+	if tok != nil {
+		pos := tok.Pos()
+		w.varint(int64(pos.Line - line))
+		w.uvarint(uint64(pos.Column))
+		line = pos.Line
+		if flags&posText != 0 {
+			w.text(tok.Text())
+		}
+	}
+
+	if n.IsVector() {
+		for _, child := range n.List() {
+			line = w.positions(child, line)
+		}
+		if tail := n.Tail(); tail != nil {
+			line = w.positions(tail, line)
+		}
+		if end != nil {
+			pos := end.Pos()
+			w.varint(int64(pos.Line - line))
+			w.uvarint(uint64(pos.Column))
+			line = pos.Line
+		}
+	}
+
+	return line
+}
+
+// valuer is implemented by nodes and by the values of nodes
+type valuer interface {
+	Value() interface{}
+	Encode() string
+}
+
+// tokenText returns the text of the token of a node, as the parser would
+// produce it for the canonical encoding of the node. The value is only used
+// by value nodes.
+func tokenText(nt ast.NodeType, v valuer) string {
+	switch nt {
+	case ast.NodeTypeExpression:
+		return "("
+	case ast.NodeTypeList:
+		return "["
+	case ast.NodeTypeMap:
+		return "{"
+	case ast.NodeTypeSet, ast.NodeTypeTagged:
+		return "#"
+	case ast.NodeTypeString:
+		s := strconv.Quote(v.Value().(string))
+		return s[1 : len(s)-1]
+	}
+	return v.Encode()
+}
+
+// tokenTypes returns the type of the token of a node and, for vector nodes,
+// the type and the text of the end token.
+func tokenTypes(nt ast.NodeType) (lexer.TokenType, lexer.TokenType, string) {
+	switch nt {
+	case ast.NodeTypeExpression:
+		return lexer.TokenOpenExpression, lexer.TokenCloseExpression, ")"
+	case ast.NodeTypeList:
+		return lexer.TokenOpenList, lexer.TokenCloseList, "]"
+	case ast.NodeTypeMap:
+		return lexer.TokenOpenMap, lexer.TokenCloseMap, "}"
+	case ast.NodeTypeSet:
+		return lexer.TokenHash, lexer.TokenCloseMap, "}"
+	case ast.NodeTypeTagged:
+		return lexer.TokenHash, lexer.TokenInvalid, ""
+	case ast.NodeTypeInt:
+		return lexer.TokenInteger, lexer.TokenInvalid, ""
+	}
+	return lexer.TokenSequence, lexer.TokenInvalid, ""
+}
@@ -0,0 +1,86 @@
+// Package sbin implements a compact binary encoding of ASTs, meant to be used
+// as a cache of parsed files: decoding a tree is much faster than parsing its
+// source, since the lexer and the parser are not involved.
+//
+// An encoded tree begins with a header made of the "SEXB" magic, a version
+// byte, a flags byte and the length of the tree section. The tree section
+// contains every node in pre-order: a type tag followed by the value of the
+// node (a zigzag varint for ints, the IEEE 754 bits for floats, a varint
+// with the code point for chars, nothing for bools and nil, whose tags include
+// the value) or by the number of children of the node (for vectors), dotted
+// expressions are followed by their tail. Strings, symbols, atoms, the
+// binary digits of bit vectors and the decimal digits of the ints that don't
+// fit in an int64 are interned, the first occurrence of a text is
+// written along with its length and the following occurrences refer to it by
+// index.
+//
+// The tree can be followed by a position table that keeps the line, column
+// and text of the token of each node, so that tools that report positions or
+// reformat sources can use a cached tree. Decoders that don't need positions
+// skip the table.
+package sbin
+
+import (
+	"errors"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Magic identifies encoded trees
+const Magic = "SEXB"
+
+// Version is the version of the encoding
+const Version = 1
+
+// Error messages
+var (
+	ErrInvalidHeader      = errors.New("invalid header")
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrCorrupted          = errors.New("corrupted data")
+	ErrUnsupportedNode    = errors.New("unsupported node")
+)
+
+const (
+	flagPositions byte = 1 << iota
+)
+
+const (
+	tagExpression byte = iota + 1
+	tagList
+	tagMap
+	tagInt
+	tagFloat
+	tagString
+	tagSymbol
+	tagAtom
+	tagFalse
+	tagTrue
+	tagNil
+	tagDotted
+	tagSet
+	tagTagged
+	tagChar
+	tagBitVector
+	tagBigInt
+)
+
+// Options represents the settings of the encoder and the decoder
+type Options struct {
+	// Positions makes the encoder write the position table and the decoder
+	// attach the positions to the nodes, as tokens. When the encoded tree has
+	// no position table the decoded nodes have no tokens.
+	Positions bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{Positions: true}
+
+// Encode encodes a tree using DefaultOptions
+func Encode(n *ast.Node) ([]byte, error) {
+	return DefaultOptions.Encode(n)
+}
+
+// Decode decodes a tree using DefaultOptions
+func Decode(data []byte) (*ast.Node, error) {
+	return DefaultOptions.Decode(data)
+}
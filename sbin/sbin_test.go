@@ -0,0 +1,257 @@
+package sbin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+const sample = `(defn fib [n]
+  (if (< n 2)
+    n
+    (+ (fib (- n 1)) (fib (- n 2)))))
+
+{:name "fib" :tags ["math" "recursion"] :ratio 1.50 :escaped "a\tbé"}
+(-35 0.5 :key "" sym)
+`
+
+func assertSameTokens(t *testing.T, expected, actual *ast.Node) {
+	if assert.Equal(t, expected.Token() == nil, actual.Token() == nil) && expected.Token() != nil {
+		assert.Equal(t, expected.Token().Pos().Line, actual.Token().Pos().Line, expected.String())
+		assert.Equal(t, expected.Token().Pos().Column, actual.Token().Pos().Column, expected.String())
+		assert.Equal(t, expected.Token().Text(), actual.Token().Text(), expected.String())
+	}
+	if !expected.IsVector() {
+		return
+	}
+	if assert.Equal(t, expected.EndToken() == nil, actual.EndToken() == nil) && expected.EndToken() != nil {
+		assert.Equal(t, expected.EndToken().Pos().Line, actual.EndToken().Pos().Line)
+		assert.Equal(t, expected.EndToken().Pos().Column, actual.EndToken().Pos().Column)
+		assert.Equal(t, expected.EndToken().Text(), actual.EndToken().Text())
+	}
+	for i := range expected.List() {
+		assertSameTokens(t, expected.List()[i], actual.List()[i])
+	}
+	if expected.Tail() != nil && assert.NotNil(t, actual.Tail()) {
+		assertSameTokens(t, expected.Tail(), actual.Tail())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	root, err := parser.Parse([]byte(sample))
+	assert.NoError(t, err)
+
+	data, err := Encode(root)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte(Magic)))
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.EncodeDocument(root)), string(ast.EncodeDocument(decoded)))
+	assertSameTokens(t, root, decoded)
+
+	// the position table is skipped when positions are not wanted
+	decoded, err = Options{}.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.EncodeDocument(root)), string(ast.EncodeDocument(decoded)))
+	assert.Nil(t, decoded.List()[0].Token())
+}
+
+func TestWithoutPositions(t *testing.T) {
+	root, err := parser.Parse([]byte(sample))
+	assert.NoError(t, err)
+
+	withPositions, err := Encode(root)
+	assert.NoError(t, err)
+
+	data, err := Options{}.Encode(root)
+	assert.NoError(t, err)
+	assert.True(t, len(data) < len(withPositions))
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.EncodeDocument(root)), string(ast.EncodeDocument(decoded)))
+	assert.Nil(t, decoded.List()[0].Token())
+}
+
+func TestValues(t *testing.T) {
+	root := ast.NewList(nil)
+	root.PushValue(nil, ast.NewIntValue(math.MinInt64))
+	root.PushValue(nil, ast.NewIntValue(math.MaxInt64))
+	root.PushValue(nil, ast.NewFloatValue(math.Inf(-1)))
+	root.PushValue(nil, ast.NewFloatValue(-0.0))
+	root.PushValue(nil, ast.NewStringValue("\x00\xff"))
+	root.PushValue(nil, ast.NewSymbolValue("x"))
+	root.PushValue(nil, ast.NewAtomValue(":x"))
+	root.PushValue(nil, ast.NewStringValue("x"))
+	m, _ := root.PushMap(nil)
+	m.PushValue(nil, ast.NewAtomValue(":x"))
+	m.PushValue(nil, ast.NewSymbolValue("x"))
+	root.PushValue(nil, ast.NewBoolValue(true))
+	root.PushValue(nil, ast.NewBoolValue(false))
+	root.PushValue(nil, ast.NewNilValue())
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	root.PushValue(nil, ast.NewBigIntValue(huge))
+
+	data, err := Encode(root)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.Encode(root)), string(ast.Encode(decoded)))
+	assert.Equal(t, ast.NodeTypeString, decoded.List()[7].Type())
+	assert.Equal(t, ast.NodeTypeMap, decoded.List()[8].Type())
+	assert.Equal(t, ast.NodeTypeBool, decoded.List()[10].Type())
+	assert.True(t, decoded.List()[11].IsNil())
+	v, ok := decoded.List()[12].BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, 0, huge.Cmp(v))
+}
+
+func TestDottedExpressions(t *testing.T) {
+	p := parser.NewParser(bytes.NewReader([]byte("((a . 1)\n (b c . [d]))")))
+	p.SetOptions(parser.ParserOptions{DottedPairs: true})
+	assert.NoError(t, p.Parse())
+	root := p.RootNode()
+
+	data, err := Encode(root)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "((a . 1) (b c . [d]))", string(ast.EncodeDocument(decoded)))
+	assertSameTokens(t, root, decoded)
+}
+
+func TestEDNNodes(t *testing.T) {
+	p := parser.NewParser(bytes.NewReader([]byte("#{1 :a \\b}\n(#point [1 2] \\newline \\u00e9)")))
+	p.SetOptions(parser.EDNOptions())
+	assert.NoError(t, p.Parse())
+	root := p.RootNode()
+
+	data, err := Encode(root)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.EncodeDocument(root)), string(ast.EncodeDocument(decoded)))
+	assert.Equal(t, ast.NodeTypeSet, decoded.List()[0].Type())
+	assert.Equal(t, ast.NodeTypeTagged, decoded.List()[1].List()[0].Type())
+	assert.Equal(t, ast.NodeTypeChar, decoded.List()[1].List()[1].Type())
+	assertSameTokens(t, root, decoded)
+}
+
+func TestBitVectors(t *testing.T) {
+	p := parser.NewParser(bytes.NewReader([]byte("(bvadd #x1f #b101)")))
+	p.SetOptions(parser.SMTLIBOptions())
+	assert.NoError(t, p.Parse())
+	root := p.RootNode()
+	root.List()[0].PushValue(nil, ast.NewBitVectorValue(""))
+
+	data, err := Encode(root)
+	assert.NoError(t, err)
+
+	decoded, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "(bvadd #x1f #b101 #b)", string(ast.EncodeDocument(decoded)))
+	assert.Equal(t, ast.NodeTypeBitVector, decoded.List()[0].List()[3].Type())
+	assertSameTokens(t, root, decoded)
+
+	_, err = Decode([]byte("SEXB\x01\x00\x04\x10\x00\x01\x32"))
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+func TestInterning(t *testing.T) {
+	src := bytes.Repeat([]byte("(a_long_symbol_name :an_atom_name) "), 100)
+	root, err := parser.Parse(src)
+	assert.NoError(t, err)
+
+	data, err := Options{}.Encode(root)
+	assert.NoError(t, err)
+	assert.True(t, len(data) < 800, "got %d bytes", len(data))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	root, err := parser.Parse([]byte(sample))
+	assert.NoError(t, err)
+	data, err := Encode(root)
+	assert.NoError(t, err)
+
+	_, err = Decode([]byte("SEX"))
+	assert.True(t, errors.Is(err, ErrInvalidHeader))
+
+	_, err = Decode([]byte("SEXB\x02\x00\x00"))
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+	assert.Equal(t, "sbin: unsupported version 2", err.Error())
+
+	// every truncation must be detected
+	for i := len(Magic) + 2; i < len(data); i++ {
+		_, err := Decode(data[:i])
+		assert.True(t, errors.Is(err, ErrCorrupted), "truncated at %d: %v", i, err)
+	}
+
+	_, err = Decode(append(data, 0))
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	_, err = Decode([]byte("SEXB\x01\x00\x02\xff\x00"))
+	assert.True(t, errors.Is(err, ErrCorrupted))
+	assert.Equal(t, "sbin: corrupted data: unknown tag 255 at offset 0", err.Error())
+
+	_, err = Decode([]byte("SEXB\x01\x00\x03\x01\xff\x01"))
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	_, err = Encode(nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedNode))
+}
+
+func largeSource(forms int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < forms; i++ {
+		fmt.Fprintf(&buf, "(defn fn_%d [a b]\n  (let [c (* a %d.5)] {:sum (+ a b c) :name \"fn_%d\" :tags [:x :y]}))\n", i, i, i)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := largeSource(1000)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.Parse(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, o Options) {
+	root, err := parser.Parse(largeSource(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := o.Encode(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := o.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, Options{})
+}
+
+func BenchmarkDecodePositions(b *testing.B) {
+	benchmarkDecode(b, Options{Positions: true})
+}
@@ -0,0 +1,488 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// basicTypes maps the names of the types that match node types to them, any
+// matches every node
+var basicTypes = map[string][]ast.NodeType{
+	"any":    nil,
+	"int":    {ast.NodeTypeInt},
+	"float":  {ast.NodeTypeFloat},
+	"number": {ast.NodeTypeInt, ast.NodeTypeFloat},
+	"string": {ast.NodeTypeString},
+	"symbol": {ast.NodeTypeSymbol},
+	"atom":   {ast.NodeTypeAtom},
+	"bool":   {ast.NodeTypeBool},
+	"nil":    {ast.NodeTypeNil},
+	"char":   {ast.NodeTypeChar},
+}
+
+// entries are the types given to a forms or block type, they are checked
+// once all the definitions are known
+type entries struct {
+	nodes []*ast.Node
+	types []typ
+}
+
+type compiler struct {
+	schema *Schema
+
+	// defs holds the definitions of named types, refs the symbols that
+	// refer to them
+	defs    map[string]*ast.Node
+	refs    []*ast.Node
+	entries []entries
+}
+
+func (c *compiler) errorf(n *ast.Node, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	if line, column := position(n); line > 0 {
+		return fmt.Errorf("%w: %d:%d: %s", ErrInvalidSchema, line, column, message)
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidSchema, message)
+}
+
+func (c *compiler) document(root *ast.Node) error {
+	c.defs = map[string]*ast.Node{}
+
+	var rootType *ast.Node
+	var names []string
+	for _, n := range root.List() {
+		head, _ := headOf(n)
+		switch head {
+		case "define":
+			list := n.List()
+			if len(list) != 3 || list[1].Type() != ast.NodeTypeSymbol {
+				return c.errorf(n, "expected (define name type)")
+			}
+			name := list[1].Value().(string)
+			if _, ok := basicTypes[name]; ok {
+				return c.errorf(list[1], "%s is a built-in type", name)
+			}
+			if _, ok := c.defs[name]; ok {
+				return c.errorf(list[1], "duplicate definition of %s", name)
+			}
+			c.defs[name] = n
+			names = append(names, name)
+		case "root":
+			list := n.List()
+			if len(list) != 2 {
+				return c.errorf(n, "expected (root type)")
+			}
+			if rootType != nil {
+				return c.errorf(n, "duplicate root")
+			}
+			rootType = list[1]
+		default:
+			return c.errorf(n, "expected (define name type) or (root type), found %s", describe(n))
+		}
+	}
+	if rootType == nil {
+		return c.errorf(root, "missing (root type)")
+	}
+
+	for _, name := range names {
+		t, err := c.typ(c.defs[name].List()[2])
+		if err != nil {
+			return err
+		}
+		c.schema.types[name] = t
+	}
+	t, err := c.typ(rootType)
+	if err != nil {
+		return err
+	}
+	c.schema.root = t
+
+	for _, ref := range c.refs {
+		if name := ref.Value().(string); c.defs[name] == nil {
+			return c.errorf(ref, "undefined type %s", name)
+		}
+	}
+	if err := c.checkCycles(names); err != nil {
+		return err
+	}
+	return c.checkEntries()
+}
+
+// checkCycles makes sure that types are not defined in terms of themselves
+// without a node in between, like (define a (or b int)) and (define b a)
+func (c *compiler) checkCycles(names []string) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+
+	// visit returns the name of a type that is part of a cycle
+	var visit func(name string) string
+	visit = func(name string) string {
+		switch state[name] {
+		case visiting:
+			return name
+		case visited:
+			return ""
+		}
+		state[name] = visiting
+		for _, next := range unguarded(c.schema.types[name]) {
+			if cycle := visit(next); cycle != "" {
+				return cycle
+			}
+		}
+		state[name] = visited
+		return ""
+	}
+
+	for _, name := range names {
+		if cycle := visit(name); cycle != "" {
+			return c.errorf(c.defs[cycle], "%s is defined in terms of itself", cycle)
+		}
+	}
+	return nil
+}
+
+// unguarded returns the names of the types a type is made of without
+// reading a node first
+func unguarded(t typ) []string {
+	switch t := t.(type) {
+	case *refType:
+		return []string{t.name}
+	case *orType:
+		var names []string
+		for _, alt := range t.alts {
+			names = append(names, unguarded(alt)...)
+		}
+		return names
+	}
+	return nil
+}
+
+// checkEntries makes sure that the entries of forms and blocks are forms or
+// blocks with different heads
+func (c *compiler) checkEntries() error {
+	for _, e := range c.entries {
+		heads := map[string]bool{}
+		for i, t := range e.types {
+			switch resolve(t).(type) {
+			case *formType, *blockType:
+			default:
+				return c.errorf(e.nodes[i], "expected a form or block, found %s", t)
+			}
+			head := entryHead(t)
+			if heads[head] {
+				return c.errorf(e.nodes[i], "duplicate form (%s ...)", head)
+			}
+			heads[head] = true
+		}
+	}
+	return nil
+}
+
+func (c *compiler) typ(n *ast.Node) (typ, error) {
+	if n.Type() == ast.NodeTypeSymbol {
+		name := n.Value().(string)
+		if types, ok := basicTypes[name]; ok {
+			return &valueType{name: name, types: types}, nil
+		}
+		c.refs = append(c.refs, n)
+		return &refType{name: name, schema: c.schema}, nil
+	}
+
+	head, ok := headOf(n)
+	if !ok {
+		return nil, c.errorf(n, "expected a type, found %s", describe(n))
+	}
+	switch head {
+	case "int", "float", "number", "string":
+		return c.valueType(n, head)
+	case "enum":
+		return c.enumType(n)
+	case "list":
+		return c.listType(n)
+	case "map":
+		return c.mapType(n)
+	case "form":
+		return c.formType(n)
+	case "block":
+		return c.blockType(n)
+	case "forms":
+		return c.formsType(n)
+	case "or":
+		return c.orType(n)
+	}
+	return nil, c.errorf(n, "unknown type (%s ...)", head)
+}
+
+// options splits the elements of a type expression, from the given index
+// on, into arguments and options. Options are atoms, the ones in valued are
+// followed by a value and the ones in flags are not.
+func (c *compiler) options(n *ast.Node, from int, valued []string, flags []string) ([]*ast.Node, map[string]*ast.Node, error) {
+	var args []*ast.Node
+	options := map[string]*ast.Node{}
+
+	list := n.List()
+	for i := from; i < len(list); i++ {
+		elem := list[i]
+		if elem.Type() != ast.NodeTypeAtom {
+			args = append(args, elem)
+			continue
+		}
+		name := string(ast.Encode(elem))
+		if _, ok := options[name]; ok {
+			return nil, nil, c.errorf(elem, "duplicate option %s", name)
+		}
+		switch {
+		case contains(valued, name):
+			if i+1 == len(list) {
+				return nil, nil, c.errorf(elem, "option %s expects a value", name)
+			}
+			i++
+			options[name] = list[i]
+		case contains(flags, name):
+			options[name] = elem
+		default:
+			return nil, nil, c.errorf(elem, "unknown option %s", name)
+		}
+	}
+	return args, options, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, s := range names {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bounds reads the :min and :max options, ints is true for the types whose
+// bounds must be ints: int and the lengths of strings and lists
+func (c *compiler) bounds(options map[string]*ast.Node, ints bool) (bounds, error) {
+	var b bounds
+	for _, name := range []string{":min", ":max"} {
+		n, ok := options[name]
+		if !ok {
+			continue
+		}
+		f, ok := n.Float()
+		i, isInt := n.Int()
+		if isInt {
+			f, ok = float64(i), true
+		}
+		if !ok {
+			return b, c.errorf(n, "option %s expects a number, found %s", name, describe(n))
+		}
+		if ints && !isInt {
+			return b, c.errorf(n, "option %s expects an int, found %s", name, describe(n))
+		}
+		if name == ":min" {
+			b.min = &f
+		} else {
+			b.max = &f
+		}
+	}
+	if b.min != nil && b.max != nil && *b.min > *b.max {
+		return b, c.errorf(options[":max"], ":max is less than :min")
+	}
+	return b, nil
+}
+
+func (c *compiler) valueType(n *ast.Node, head string) (typ, error) {
+	valued := []string{":min", ":max"}
+	if head == "string" {
+		valued = append(valued, ":pattern")
+	}
+	args, options, err := c.options(n, 1, valued, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 {
+		return nil, c.errorf(args[0], "unexpected %s", describe(args[0]))
+	}
+
+	t := &valueType{name: head, types: basicTypes[head]}
+	if t.bounds, err = c.bounds(options, head == "int" || head == "string"); err != nil {
+		return nil, err
+	}
+	if pattern, ok := options[":pattern"]; ok {
+		if pattern.Type() != ast.NodeTypeString {
+			return nil, c.errorf(pattern, "option :pattern expects a string, found %s", describe(pattern))
+		}
+		if t.pattern, err = regexp.Compile(pattern.Value().(string)); err != nil {
+			return nil, c.errorf(pattern, "%v", err)
+		}
+	}
+	return t, nil
+}
+
+func (c *compiler) enumType(n *ast.Node) (typ, error) {
+	values := n.List()[1:]
+	if len(values) == 0 {
+		return nil, c.errorf(n, "expected (enum value ...)")
+	}
+	t := &enumType{}
+	for _, value := range values {
+		if value.IsVector() {
+			return nil, c.errorf(value, "expected a value, found %s", describe(value))
+		}
+		t.values = append(t.values, string(ast.Encode(value)))
+	}
+	return t, nil
+}
+
+func (c *compiler) listType(n *ast.Node) (typ, error) {
+	args, options, err := c.options(n, 1, []string{":min", ":max"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, c.errorf(n, "expected (list type)")
+	}
+
+	t := &listType{}
+	if t.elem, err = c.typ(args[0]); err != nil {
+		return nil, err
+	}
+	if t.bounds, err = c.bounds(options, true); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *compiler) mapType(n *ast.Node) (typ, error) {
+	args, options, err := c.options(n, 1, nil, []string{":open"})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &mapType{open: options[":open"] != nil}
+	for _, arg := range args {
+		if arg.Type() != ast.NodeTypeExpression || len(arg.List()) < 2 || arg.List()[0].IsVector() {
+			return nil, c.errorf(arg, "expected (key type), found %s", describe(arg))
+		}
+		list := arg.List()
+		key := string(ast.Encode(list[0]))
+		if t.field(key) != nil {
+			return nil, c.errorf(list[0], "duplicate key %s", key)
+		}
+
+		fieldArgs, fieldOptions, err := c.options(arg, 1, nil, []string{":optional"})
+		if err != nil {
+			return nil, err
+		}
+		if len(fieldArgs) != 1 {
+			return nil, c.errorf(arg, "expected (key type)")
+		}
+		f := &field{key: key, optional: fieldOptions[":optional"] != nil}
+		if f.typ, err = c.typ(fieldArgs[0]); err != nil {
+			return nil, err
+		}
+		t.fields = append(t.fields, f)
+	}
+	return t, nil
+}
+
+// formHead reads the head of a form or block type
+func (c *compiler) formHead(n *ast.Node, head string) (string, error) {
+	list := n.List()
+	if len(list) < 2 || list[1].Type() != ast.NodeTypeSymbol {
+		return "", c.errorf(n, "expected (%s head ...)", head)
+	}
+	return list[1].Value().(string), nil
+}
+
+func (c *compiler) formType(n *ast.Node) (typ, error) {
+	head, err := c.formHead(n, "form")
+	if err != nil {
+		return nil, err
+	}
+	args, options, err := c.options(n, 2, []string{":rest"}, []string{":optional", ":repeated"})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &formType{
+		flags: flags{optional: options[":optional"] != nil, repeated: options[":repeated"] != nil},
+		head:  head,
+	}
+	for _, arg := range args {
+		argType, err := c.typ(arg)
+		if err != nil {
+			return nil, err
+		}
+		t.args = append(t.args, argType)
+	}
+	if rest, ok := options[":rest"]; ok {
+		if t.rest, err = c.typ(rest); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (c *compiler) blockType(n *ast.Node) (typ, error) {
+	head, err := c.formHead(n, "block")
+	if err != nil {
+		return nil, err
+	}
+	args, options, err := c.options(n, 2, nil, []string{":optional", ":repeated"})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &blockType{
+		flags: flags{optional: options[":optional"] != nil, repeated: options[":repeated"] != nil},
+		head:  head,
+	}
+	if t.body, err = c.forms(args); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *compiler) formsType(n *ast.Node) (typ, error) {
+	args, _, err := c.options(n, 1, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.forms(args)
+}
+
+func (c *compiler) forms(args []*ast.Node) (*formsType, error) {
+	t := &formsType{}
+	for _, arg := range args {
+		entry, err := c.typ(arg)
+		if err != nil {
+			return nil, err
+		}
+		t.entries = append(t.entries, entry)
+	}
+	c.entries = append(c.entries, entries{nodes: args, types: t.entries})
+	return t, nil
+}
+
+func (c *compiler) orType(n *ast.Node) (typ, error) {
+	args, _, err := c.options(n, 1, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, c.errorf(n, "expected (or type ...)")
+	}
+
+	t := &orType{}
+	for _, arg := range args {
+		alt, err := c.typ(arg)
+		if err != nil {
+			return nil, err
+		}
+		t.alts = append(t.alts, alt)
+	}
+	return t, nil
+}
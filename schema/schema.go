@@ -0,0 +1,178 @@
+// Package schema validates S-expression documents against schemas that are
+// written in S-expressions too:
+//
+//	(define port (int :min 1 :max 65535))
+//
+//	(root
+//	  (forms
+//	    (block server
+//	      (form name (string :min 1))
+//	      (form listen (map (:host string) (:port port)))
+//	      (form level (enum debug info warn error) :optional)
+//	      (form tags (list atom) :optional))))
+//
+// A schema is made of a root type, which the whole document must match, and
+// definitions of named types that can be used anywhere a type is expected,
+// including in their own definition. Types are:
+//
+//	any                        any node
+//	int, float, number         values of a node type, number is an int or a
+//	string, symbol, atom       float
+//	bool, nil, char
+//	(int :min a :max b)        numbers within a range, both bounds are
+//	                           inclusive and optional, also for float and
+//	                           number; the bounds of int and of lengths
+//	                           must be ints
+//	(string :min a :max b      strings with a length in characters within a
+//	  :pattern re)             range that match a regular expression
+//	(enum v ...)               nodes equal to one of the values
+//	(list T :min a :max b)     lists with elements of type T and a length
+//	                           within a range
+//	(map (key T) ... :open)    maps with the given keys, keys are required
+//	                           unless their field has the :optional flag,
+//	                           like (key T :optional), and keys that are not
+//	                           listed are only allowed with the :open flag
+//	(form head T ... :rest T)  expressions with a head and arguments of the
+//	                           given types, with :rest any number of
+//	                           arguments of type T can follow
+//	(block head F ...)         expressions with a head followed by forms, see
+//	                           forms
+//	(forms F ...)              lists made of forms, like documents. F are
+//	                           form or block types, a form can't be repeated
+//	                           and must be present unless its type has the
+//	                           :repeated or :optional flags
+//	(or T ...)                 nodes of any of the types
+//	name                       the type defined with (define name T)
+//
+// The validator doesn't stop at the first violation, it reports all of them
+// along with the position of the node that caused them.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// ErrInvalidSchema is returned when a schema can't be compiled
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Schema is a compiled schema
+type Schema struct {
+	root  typ
+	types map[string]typ
+}
+
+// Violation is a part of a document that doesn't match its schema
+type Violation struct {
+	// Line and Column are the position of the node that caused the
+	// violation, they are zero for the root of the document
+	Line   int
+	Column int
+
+	Message string
+}
+
+func (v Violation) Error() string {
+	if v.Line == 0 {
+		return v.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", v.Line, v.Column, v.Message)
+}
+
+// Compile compiles the source of a schema
+func Compile(src []byte) (*Schema, error) {
+	root, err := parser.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return CompileNode(root)
+}
+
+// CompileNode compiles a schema that was already parsed, the given node is
+// the root of the document
+func CompileNode(root *ast.Node) (*Schema, error) {
+	c := &compiler{schema: &Schema{types: map[string]typ{}}}
+	if err := c.document(root); err != nil {
+		return nil, err
+	}
+	return c.schema, nil
+}
+
+// MustCompile is like Compile but panics if the schema can't be compiled
+func MustCompile(src []byte) *Schema {
+	s, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Validate returns the violations of a document, sorted by position. The
+// given node is the root of the document, like the one returned by the
+// parser.
+func (s *Schema) Validate(root *ast.Node) []Violation {
+	v := &validator{}
+	s.root.validate(v, root)
+	sort.SliceStable(v.violations, func(i, j int) bool {
+		a, b := v.violations[i], v.violations[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.violations
+}
+
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) report(n *ast.Node, format string, args ...interface{}) {
+	line, column := position(n)
+	v.violations = append(v.violations, Violation{
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// position returns the line and column of a node
+func position(n *ast.Node) (int, int) {
+	tok := n.Token()
+	if tok == nil {
+		return 0, 0
+	}
+	pos := tok.Pos()
+	if n.Type() == ast.NodeTypeString && pos.Column > 1 {
+		// the token of a string begins after the quote
+		pos.Column--
+	}
+	return pos.Line, pos.Column
+}
+
+// headOf returns the head of an expression, if it's a symbol
+func headOf(n *ast.Node) (string, bool) {
+	if n.Type() != ast.NodeTypeExpression || len(n.List()) == 0 {
+		return "", false
+	}
+	head := n.List()[0]
+	if head.Type() != ast.NodeTypeSymbol {
+		return "", false
+	}
+	return head.Value().(string), true
+}
+
+// describe returns a short description of a node for messages
+func describe(n *ast.Node) string {
+	if head, ok := headOf(n); ok {
+		return fmt.Sprintf("(%s ...)", head)
+	}
+	if n.IsVector() {
+		return n.Type().String()
+	}
+	return fmt.Sprintf("%v %s", n.Type(), ast.Encode(n))
+}
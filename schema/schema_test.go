@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/parser"
+)
+
+const config = `
+(define port (int :min 1 :max 65535))
+
+(root
+  (forms
+    (block server :repeated
+      (form name (string :min 1 :pattern "^[a-z0-9-]+$"))
+      (form listen (map (:host string) (:port port) (:tls bool :optional)))
+      (form level (enum debug info warn error) :optional)
+      (form tags (list atom :max 2) :optional)
+      (form env symbol (or string number) :optional :repeated)
+      (form include string :rest string :optional))))
+`
+
+func messages(violations []Violation) []string {
+	out := []string{}
+	for _, v := range violations {
+		out = append(out, v.Error())
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	s := MustCompile([]byte(config))
+
+	testCases := []struct {
+		In  string
+		Out []string
+	}{
+		{
+			In: `
+(server
+  (name "web")
+  (listen {:host "0.0.0.0" :port 8080})
+  (env HOME "/root")
+  (env N 2.5)
+  (include "a" "b" "c"))`,
+			Out: []string{},
+		},
+		{
+			In:  `(server (name "") (listen {:host 1 :port 70000 :x 2}))`,
+			Out: []string{"1:15: expected a length of at least 1, found 0", `1:15: "" doesn't match "^[a-z0-9-]+$"`, "1:34: expected string, found int 1", "1:42: expected a value between 1 and 65535, found 70000", "1:48: unknown key :x"},
+		},
+		{
+			In: `(server
+  (name "é")
+  (listen {:port 80 :port 81 :tls "yes"})
+  (level trace)
+  (tags [:a b :c]))`,
+			Out: []string{
+				`2:9: "é" doesn't match "^[a-z0-9-]+$"`,
+				"3:11: missing key :host",
+				"3:21: duplicate key :port",
+				"3:35: expected bool, found string \"yes\"",
+				"4:10: expected one of debug, info, warn, error, found symbol trace",
+				"5:9: expected a length of at most 2, found 3",
+				"5:13: expected atom, found symbol b",
+			},
+		},
+		{
+			In:  `(server (name "a" "b") (listen {:host "h" :port 1}) (level info) (level warn) (include) (env "x" :y)) (other) 1`,
+			Out: []string{"1:9: (name ...) expects 1 argument, found 2", "1:66: duplicate form (level ...)", "1:79: (include ...) expects at least 1 argument, found 0", "1:94: expected symbol, found string \"x\"", "1:98: expected string or number, found atom :y", "1:103: unexpected form (other ...)", "1:111: expected a form, found int 1"},
+		},
+		{
+			In:  `(server (tags {}))`,
+			Out: []string{"1:1: missing form (name ...)", "1:1: missing form (listen ...)", "1:15: expected list, found map"},
+		},
+	}
+
+	for i, tc := range testCases {
+		root, err := parser.Parse([]byte(tc.In))
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, tc.Out, messages(s.Validate(root)), "case %d", i)
+	}
+}
+
+func TestValidateRecursive(t *testing.T) {
+	s := MustCompile([]byte(`
+(define value (or string int (list value) (map (:name string) (:children (list value) :optional))))
+(root (list value))
+`))
+
+	root, err := parser.Parse([]byte(`["a" [1 [2.5]] {:name "b" :children [{:name 3}]}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"1:10: expected string or int or list or map, found float 2.5",
+		"1:45: expected string, found int 3",
+	}, messages(s.Validate(root)))
+
+	s = MustCompile([]byte(`(root (forms (form a any) (form b :optional)))`))
+	root, err = parser.Parse([]byte(``))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"missing form (a ...)"}, messages(s.Validate(root)))
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Err string
+	}{
+		{`(define a int)`, "missing (root type)"},
+		{`(root int) (root int)`, "1:12: duplicate root"},
+		{`(root int) x`, "1:12: expected (define name type) or (root type), found symbol x"},
+		{`(define int string) (root int)`, "1:9: int is a built-in type"},
+		{`(define a int) (define a int) (root a)`, "1:24: duplicate definition of a"},
+		{`(root b)`, "1:7: undefined type b"},
+		{`(root (tuple int))`, "1:7: unknown type (tuple ...)"},
+		{`(root [int])`, "1:7: expected a type, found list"},
+		{`(root (int :min 1 :min 2))`, "1:19: duplicate option :min"},
+		{`(root (int :max))`, "1:12: option :max expects a value"},
+		{`(root (int :pattern "a"))`, "1:12: unknown option :pattern"},
+		{`(root (int :min "1"))`, "1:17: option :min expects a number, found string \"1\""},
+		{`(root (int :min 1.5))`, "1:17: option :min expects an int, found float 1.5"},
+		{`(root (string :max 2.0))`, "1:20: option :max expects an int, found float 2.0"},
+		{`(root (list int :min 0.5))`, "1:22: option :min expects an int, found float 0.5"},
+		{`(root (int :min 2 :max 1))`, "1:24: :max is less than :min"},
+		{`(root (string :pattern "("))`, "1:24: error parsing regexp: missing closing ): `(`"},
+		{`(root (enum))`, "1:7: expected (enum value ...)"},
+		{`(root (list int string))`, "1:7: expected (list type)"},
+		{`(root (map (:a int) (:a int)))`, "1:22: duplicate key :a"},
+		{`(root (map :a))`, "1:12: unknown option :a"},
+		{`(root (map (:a)))`, "1:12: expected (key type), found expression"},
+		{`(root (form "a"))`, "1:7: expected (form head ...)"},
+		{`(root (forms int))`, "1:14: expected a form or block, found int"},
+		{`(root (forms (form a) (block a)))`, "1:23: duplicate form (a ...)"},
+		{`(root (or))`, "1:7: expected (or type ...)"},
+		{`(define a (or b int)) (define b a) (root a)`, "1:1: a is defined in terms of itself"},
+		{`(root (`, "unexpected EOF"},
+	}
+
+	for i, tc := range testCases {
+		_, err := Compile([]byte(tc.In))
+		if assert.Error(t, err, "case %d", i) {
+			assert.True(t, errors.Is(err, ErrInvalidSchema), "case %d", i)
+			assert.Contains(t, err.Error(), tc.Err, "case %d", i)
+		}
+	}
+
+	// floats and numbers take any bound
+	_, err := Compile([]byte(`(root (or (float :min 0.5) (number :min 1 :max 1.5)))`))
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() {
+		MustCompile([]byte(`(root)`))
+	})
+}
@@ -0,0 +1,442 @@
+package schema
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// typ is a compiled type
+type typ interface {
+	// accepts returns true if a node has the shape of the type, like its node
+	// type or head, without looking at its contents
+	accepts(n *ast.Node) bool
+
+	// validate reports the violations of a node
+	validate(v *validator, n *ast.Node)
+
+	// String describes the type for messages
+	String() string
+}
+
+// bounds is an inclusive range, both ends are optional
+type bounds struct {
+	min *float64
+	max *float64
+}
+
+func (b bounds) contains(f float64) bool {
+	return (b.min == nil || f >= *b.min) && (b.max == nil || f <= *b.max)
+}
+
+func (b bounds) String() string {
+	switch {
+	case b.min != nil && b.max != nil:
+		return "between " + formatFloat(*b.min) + " and " + formatFloat(*b.max)
+	case b.min != nil:
+		return "of at least " + formatFloat(*b.min)
+	}
+	return "of at most " + formatFloat(*b.max)
+}
+
+func (b bounds) set() bool {
+	return b.min != nil || b.max != nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// valueType matches nodes of some node types, like int or string
+type valueType struct {
+	name    string
+	types   []ast.NodeType
+	bounds  bounds
+	pattern *regexp.Regexp
+}
+
+func (t *valueType) accepts(n *ast.Node) bool {
+	if len(t.types) == 0 {
+		return true
+	}
+	for _, nt := range t.types {
+		if n.Type() == nt {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *valueType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+
+	if n.Type() == ast.NodeTypeString {
+		s := n.Value().(string)
+		if length := utf8.RuneCountInString(s); !t.bounds.contains(float64(length)) {
+			v.report(n, "expected a length %s, found %d", t.bounds, length)
+		}
+		if t.pattern != nil && !t.pattern.MatchString(s) {
+			v.report(n, "%s doesn't match %q", ast.Encode(n), t.pattern)
+		}
+		return
+	}
+	if !t.bounds.set() {
+		return
+	}
+	f, ok := n.Float()
+	if i, isInt := n.Int(); isInt {
+		f, ok = float64(i), true
+	}
+	if ok && !t.bounds.contains(f) {
+		v.report(n, "expected a value %s, found %s", t.bounds, ast.Encode(n))
+	}
+}
+
+func (t *valueType) String() string {
+	return t.name
+}
+
+// enumType matches nodes that are equal to one of its values
+type enumType struct {
+	values []string
+}
+
+func (t *enumType) accepts(n *ast.Node) bool {
+	if n.IsVector() {
+		return false
+	}
+	text := string(ast.Encode(n))
+	for _, value := range t.values {
+		if text == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *enumType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+	}
+}
+
+func (t *enumType) String() string {
+	return "one of " + strings.Join(t.values, ", ")
+}
+
+// listType matches lists with elements of a type
+type listType struct {
+	elem   typ
+	bounds bounds
+}
+
+func (t *listType) accepts(n *ast.Node) bool {
+	return n.Type() == ast.NodeTypeList
+}
+
+func (t *listType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+	elems := n.List()
+	if !t.bounds.contains(float64(len(elems))) {
+		v.report(n, "expected a length %s, found %d", t.bounds, len(elems))
+	}
+	for _, elem := range elems {
+		t.elem.validate(v, elem)
+	}
+}
+
+func (t *listType) String() string {
+	return "list"
+}
+
+// field is a key of a map type
+type field struct {
+	key      string
+	typ      typ
+	optional bool
+}
+
+// mapType matches maps with some keys
+type mapType struct {
+	fields []*field
+	open   bool
+}
+
+func (t *mapType) accepts(n *ast.Node) bool {
+	return n.Type() == ast.NodeTypeMap
+}
+
+func (t *mapType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+
+	seen := map[string]bool{}
+	items := n.List()
+	for i := 0; i+1 < len(items); i += 2 {
+		key, value := items[i], items[i+1]
+		name := string(ast.Encode(key))
+		if seen[name] {
+			v.report(key, "duplicate key %s", name)
+			continue
+		}
+		seen[name] = true
+
+		f := t.field(name)
+		if f == nil {
+			if !t.open {
+				v.report(key, "unknown key %s", name)
+			}
+			continue
+		}
+		f.typ.validate(v, value)
+	}
+
+	for _, f := range t.fields {
+		if !seen[f.key] && !f.optional {
+			v.report(n, "missing key %s", f.key)
+		}
+	}
+}
+
+func (t *mapType) field(key string) *field {
+	for _, f := range t.fields {
+		if f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *mapType) String() string {
+	return "map"
+}
+
+// flags are the settings of the forms that are part of a forms type
+type flags struct {
+	optional bool
+	repeated bool
+}
+
+// formType matches expressions with a head and some arguments
+type formType struct {
+	flags
+	head string
+	args []typ
+	rest typ
+}
+
+func (t *formType) accepts(n *ast.Node) bool {
+	head, ok := headOf(n)
+	return ok && head == t.head
+}
+
+func (t *formType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+
+	args := n.List()[1:]
+	switch {
+	case t.rest == nil && len(args) != len(t.args):
+		v.report(n, "%s expects %s, found %d", t, plural(len(t.args), "argument"), len(args))
+	case t.rest != nil && len(args) < len(t.args):
+		v.report(n, "%s expects at least %s, found %d", t, plural(len(t.args), "argument"), len(args))
+	}
+
+	for i, arg := range args {
+		switch {
+		case i < len(t.args):
+			t.args[i].validate(v, arg)
+		case t.rest != nil:
+			t.rest.validate(v, arg)
+		}
+	}
+}
+
+func (t *formType) String() string {
+	return "(" + t.head + " ...)"
+}
+
+// blockType matches expressions with a head followed by forms
+type blockType struct {
+	flags
+	head string
+	body *formsType
+}
+
+func (t *blockType) accepts(n *ast.Node) bool {
+	head, ok := headOf(n)
+	return ok && head == t.head
+}
+
+func (t *blockType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+	t.body.validateForms(v, n, n.List()[1:])
+}
+
+func (t *blockType) String() string {
+	return "(" + t.head + " ...)"
+}
+
+// formsType matches lists made of forms
+type formsType struct {
+	// entries are form and block types, or references to them
+	entries []typ
+}
+
+func (t *formsType) accepts(n *ast.Node) bool {
+	return n.Type() == ast.NodeTypeList
+}
+
+func (t *formsType) validate(v *validator, n *ast.Node) {
+	if !t.accepts(n) {
+		v.report(n, "expected %s, found %s", t, describe(n))
+		return
+	}
+	t.validateForms(v, n, n.List())
+}
+
+// validateForms reports the forms of a parent node that are not expected, are
+// repeated or are missing
+func (t *formsType) validateForms(v *validator, parent *ast.Node, forms []*ast.Node) {
+	heads := map[string]int{}
+	for i, entry := range t.entries {
+		heads[entryHead(entry)] = i
+	}
+
+	counts := make([]int, len(t.entries))
+	for _, n := range forms {
+		head, ok := headOf(n)
+		if !ok {
+			v.report(n, "expected a form, found %s", describe(n))
+			continue
+		}
+		i, ok := heads[head]
+		if !ok {
+			v.report(n, "unexpected form (%s ...)", head)
+			continue
+		}
+		counts[i]++
+		if counts[i] > 1 && !entryFlags(t.entries[i]).repeated {
+			v.report(n, "duplicate form (%s ...)", head)
+		}
+		t.entries[i].validate(v, n)
+	}
+
+	for i, entry := range t.entries {
+		if counts[i] == 0 && !entryFlags(entry).optional {
+			v.report(parent, "missing form (%s ...)", entryHead(entry))
+		}
+	}
+}
+
+func (t *formsType) String() string {
+	return "forms"
+}
+
+// entryHead returns the head of a form or block type
+func entryHead(t typ) string {
+	switch t := resolve(t).(type) {
+	case *formType:
+		return t.head
+	case *blockType:
+		return t.head
+	}
+	return ""
+}
+
+// entryFlags returns the flags of a form or block type
+func entryFlags(t typ) flags {
+	switch t := resolve(t).(type) {
+	case *formType:
+		return t.flags
+	case *blockType:
+		return t.flags
+	}
+	return flags{}
+}
+
+// orType matches nodes that match any of its alternatives
+type orType struct {
+	alts []typ
+}
+
+func (t *orType) accepts(n *ast.Node) bool {
+	for _, alt := range t.alts {
+		if alt.accepts(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate validates a node with the first alternative that accepts it, so
+// that the violations found within a node are reported
+func (t *orType) validate(v *validator, n *ast.Node) {
+	for _, alt := range t.alts {
+		if alt.accepts(n) {
+			alt.validate(v, n)
+			return
+		}
+	}
+	v.report(n, "expected %s, found %s", t, describe(n))
+}
+
+func (t *orType) String() string {
+	names := make([]string, 0, len(t.alts))
+	for _, alt := range t.alts {
+		names = append(names, alt.String())
+	}
+	return strings.Join(names, " or ")
+}
+
+// refType is a type that was defined with a name
+type refType struct {
+	name   string
+	schema *Schema
+}
+
+func (t *refType) accepts(n *ast.Node) bool {
+	return t.schema.types[t.name].accepts(n)
+}
+
+func (t *refType) validate(v *validator, n *ast.Node) {
+	t.schema.types[t.name].validate(v, n)
+}
+
+func (t *refType) String() string {
+	return t.schema.types[t.name].String()
+}
+
+// resolve returns the type a reference points to
+func resolve(t typ) typ {
+	for {
+		ref, ok := t.(*refType)
+		if !ok {
+			return t
+		}
+		t = ref.schema.types[ref.name]
+	}
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return strconv.Itoa(n) + " " + word + "s"
+}
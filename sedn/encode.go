@@ -0,0 +1,204 @@
+package sedn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Encode returns the EDN representation of a node
+func (o Options) Encode(n *ast.Node) ([]byte, error) {
+	var buf strings.Builder
+	if err := encodeNode(&buf, n); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// EncodeDocument returns the EDN representation of the children of a root
+// node, one per line
+func (o Options) EncodeDocument(root *ast.Node) ([]byte, error) {
+	if root == nil || !root.IsVector() {
+		return nil, fmt.Errorf("sedn: %w: expecting a root node", ErrUnsupportedNode)
+	}
+
+	var buf strings.Builder
+	for _, child := range root.List() {
+		if err := encodeNode(&buf, child); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return []byte(buf.String()), nil
+}
+
+func encodeNode(buf *strings.Builder, n *ast.Node) error {
+	if n == nil {
+		return fmt.Errorf("sedn: %w: nil", ErrUnsupportedNode)
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeInt, ast.NodeTypeBool, ast.NodeTypeNil, ast.NodeTypeChar:
+		buf.WriteString(n.Encode())
+		if _, ok := n.Int(); !ok && n.Type() == ast.NodeTypeInt {
+			// ints that don't fit in an int64 are arbitrary precision
+			buf.WriteString("N")
+		}
+
+	case ast.NodeTypeFloat:
+		buf.WriteString(encodeFloat(n.Value().(float64)))
+
+	case ast.NodeTypeString:
+		buf.WriteString(quote(n.Value().(string)))
+
+	case ast.NodeTypeSymbol:
+		s := n.Value().(string)
+		if !isSymbol(s) {
+			return fmt.Errorf("sedn: %w: %q", ErrInvalidSymbol, s)
+		}
+		buf.WriteString(s)
+
+	case ast.NodeTypeAtom:
+		s := n.Value().(string)
+		if !strings.HasPrefix(s, ":") || !isSymbol(s[1:]) || s == ":/" {
+			return fmt.Errorf("sedn: %w: %q", ErrInvalidKeyword, s)
+		}
+		buf.WriteString(s)
+
+	case ast.NodeTypeExpression:
+		if n.Tail() != nil {
+			return fmt.Errorf("sedn: %w: dotted expression", ErrUnsupportedNode)
+		}
+		return encodeList(buf, "(", n.List(), ")")
+
+	case ast.NodeTypeList:
+		return encodeList(buf, "[", n.List(), "]")
+
+	case ast.NodeTypeMap:
+		if len(n.List())%2 != 0 {
+			return fmt.Errorf("sedn: %w", ErrOddMap)
+		}
+		return encodeList(buf, "{", n.List(), "}")
+
+	case ast.NodeTypeSet:
+		return encodeList(buf, "#{", n.List(), "}")
+
+	case ast.NodeTypeTagged:
+		children := n.List()
+		if len(children) != 2 || children[0].Type() != ast.NodeTypeSymbol || !isTag(children[0].Value().(string)) {
+			return fmt.Errorf("sedn: %w: %s", ErrInvalidTag, ast.Encode(n))
+		}
+		buf.WriteString("#" + children[0].Value().(string) + " ")
+		return encodeNode(buf, children[1])
+
+	default:
+		return fmt.Errorf("sedn: %w: %v", ErrUnsupportedNode, n.Type())
+	}
+	return nil
+}
+
+func encodeList(buf *strings.Builder, open string, nodes []*ast.Node, close string) error {
+	buf.WriteString(open)
+	for i, node := range nodes {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		if err := encodeNode(buf, node); err != nil {
+			return err
+		}
+	}
+	buf.WriteString(close)
+	return nil
+}
+
+// encodeFloat returns the shortest representation of the float that is read
+// back as a float
+func encodeFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "##Inf"
+	case math.IsInf(f, -1):
+		return "##-Inf"
+	case math.IsNaN(f):
+		return "##NaN"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s = s + ".0"
+	}
+	return s
+}
+
+// quote returns an EDN string, EDN only has the \t, \r, \n, \\, \" and \u
+// escapes.
+func quote(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			if r < utf8.RuneSelf && !unicode.IsPrint(r) {
+				fmt.Fprintf(&buf, `\u%04x`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// symbolChars are the characters, other than letters and digits, allowed in
+// symbols
+const symbolChars = ".*+!-_?$%&=<>/#:'"
+
+// isSymbol returns true if the text is a valid EDN symbol: symbols can't
+// begin with a digit, a # or a :, a leading -, + or . can't be followed by a
+// digit and a / can only be used once, to separate the namespace from the
+// name.
+func isSymbol(s string) bool {
+	if s == "/" {
+		return true
+	}
+	if s == "" || strings.Count(s, "/") > 1 || strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+		case unicode.IsDigit(r), r == '#', r == ':', r == '\'':
+			if i == 0 {
+				return false
+			}
+		case strings.ContainsRune(symbolChars, r):
+		default:
+			return false
+		}
+	}
+	if len(s) > 1 && strings.ContainsRune("-+.", rune(s[0])) && unicode.IsDigit(rune(s[1])) {
+		return false
+	}
+	return true
+}
+
+// isTag returns true if the text is a valid tag, a symbol that begins with a
+// letter.
+func isTag(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r) && isSymbol(s)
+}
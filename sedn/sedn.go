@@ -0,0 +1,83 @@
+// Package sedn converts S-expression trees to EDN, the extensible data
+// notation used by Clojure, and EDN documents to S-expression trees.
+//
+// EDN is read by the parser itself (see parser.EDNOptions), the types of EDN
+// map directly to node types:
+//
+//	nil, true, false  -> nil and bool
+//	42, -1.5e3, ##Inf -> int and float
+//	"hello"           -> string
+//	\a, \newline      -> char
+//	foo, ns/name      -> symbol
+//	:key, :ns/name    -> atom
+//	(1 2)             -> expression
+//	[1 2]             -> list
+//	{:a 1}            -> map
+//	#{1 2}            -> set
+//	#inst "2020-01-01" -> tagged
+//
+// The encoder writes each node with the EDN syntax of its type and fails when
+// a node can't be represented faithfully: symbols, keywords and tags must be
+// valid EDN names and maps must have an even number of children. The true,
+// false and nil symbols are written as is and are read back as literals. Ints
+// that don't fit in an int64 are written with the N suffix.
+package sedn
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Error messages
+var (
+	ErrOddMap          = errors.New("map with an odd number of children")
+	ErrInvalidSymbol   = errors.New("invalid symbol")
+	ErrInvalidKeyword  = errors.New("invalid keyword")
+	ErrInvalidTag      = errors.New("invalid tagged element")
+	ErrUnsupportedNode = errors.New("unsupported node")
+)
+
+// Options represents the settings of the converter
+type Options struct {
+	// Tags are the tag handlers used by the decoder along with the ones for
+	// #inst and #uuid, see parser.TagFunc.
+	Tags map[string]parser.TagFunc
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Encode returns the EDN representation of a node using DefaultOptions
+func Encode(n *ast.Node) ([]byte, error) {
+	return DefaultOptions.Encode(n)
+}
+
+// EncodeDocument returns the EDN representation of the children of a root
+// node, one per line, using DefaultOptions
+func EncodeDocument(root *ast.Node) ([]byte, error) {
+	return DefaultOptions.EncodeDocument(root)
+}
+
+// Decode reads an EDN document into a root node using DefaultOptions
+func Decode(data []byte) (*ast.Node, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// Decode reads an EDN document into a root node, like the one returned by the
+// parser.
+func (o Options) Decode(data []byte) (*ast.Node, error) {
+	options := parser.EDNOptions()
+	for tag, fn := range o.Tags {
+		options.Tags[tag] = fn
+	}
+
+	p := parser.NewParser(bytes.NewReader(data))
+	p.SetOptions(options)
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return p.RootNode(), nil
+}
@@ -0,0 +1,155 @@
+package sedn
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+const sample = `; an order
+{:order/id #uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6",
+ :order/placed #inst "1985-04-12T23:20:50.52Z"
+ :items [{:sku "A-1" :qty 2 :price 9.95M} {:sku "B-2" :qty 10N :price 1.5e2}]
+ :tags #{:new :gift}
+ :note nil :paid? true #_ {:ignored "x"}
+ :sep \, :initial \λ :newline \newline
+ :customer #myapp/Person {:first "Fred" :last "Mertz"}
+ :ratio ##Inf}
+(defn total [items] (reduce + (map :price items)))
+`
+
+func TestDecode(t *testing.T) {
+	root, err := Decode([]byte(sample))
+	assert.NoError(t, err)
+
+	nodes := root.List()
+	assert.Equal(t, 2, len(nodes))
+
+	order := nodes[0].List()
+	assert.Equal(t, ast.NodeTypeMap, nodes[0].Type())
+	assert.Equal(t, ast.NodeTypeTagged, order[1].Type())
+	assert.Equal(t, "uuid", order[1].List()[0].Value())
+	assert.Equal(t, ast.NodeTypeList, order[5].Type())
+	assert.Equal(t, int64(10), order[5].List()[1].List()[3].Value())
+	assert.Equal(t, 150.0, order[5].List()[1].List()[5].Value())
+	assert.Equal(t, ast.NodeTypeSet, order[7].Type())
+	assert.Equal(t, ast.NodeTypeNil, order[9].Type())
+	assert.Equal(t, true, order[11].Value())
+	assert.Equal(t, ',', order[13].Value())
+	assert.Equal(t, 'λ', order[15].Value())
+	assert.Equal(t, '\n', order[17].Value())
+	assert.Equal(t, "myapp/Person", order[19].List()[0].Value())
+	assert.True(t, math.IsInf(order[21].Value().(float64), 1))
+	assert.Equal(t, ast.NodeTypeExpression, nodes[1].Type())
+}
+
+func TestRoundTrip(t *testing.T) {
+	root, err := Decode([]byte(sample))
+	assert.NoError(t, err)
+
+	out, err := EncodeDocument(root)
+	assert.NoError(t, err)
+	assert.Equal(t, `{:order/id #uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" :order/placed #inst "1985-04-12T23:20:50.52Z" :items [{:sku "A-1" :qty 2 :price 9.95} {:sku "B-2" :qty 10 :price 150.0}] :tags #{:new :gift} :note nil :paid? true :sep \, :initial \λ :newline \newline :customer #myapp/Person {:first "Fred" :last "Mertz"} :ratio ##Inf}
+(defn total [items] (reduce + (map :price items)))
+`, string(out))
+
+	back, err := Decode(out)
+	assert.NoError(t, err)
+	again, err := EncodeDocument(back)
+	assert.NoError(t, err)
+	assert.Equal(t, string(out), string(again))
+}
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		node *ast.Node
+		out  string
+	}{
+		{ast.NewNode(nil, ast.NewFloatValue(1e21)), `1e+21`},
+		{ast.NewNode(nil, ast.NewFloatValue(-2)), `-2.0`},
+		{ast.NewNode(nil, ast.NewFloatValue(math.NaN())), `##NaN`},
+		{ast.NewNode(nil, ast.NewStringValue("a\"b\\\t\x00é")), `"a\"b\\\t\u0000é"`},
+		{ast.NewNode(nil, ast.NewCharValue(' ')), `\space`},
+		{ast.NewNode(nil, ast.NewCharValue('\x01')), `\u0001`},
+		{ast.NewNode(nil, ast.NewSymbolValue("ns/name")), `ns/name`},
+		{ast.NewNode(nil, ast.NewSymbolValue("/")), `/`},
+		{ast.NewNode(nil, ast.NewSymbolValue("-a")), `-a`},
+		{ast.NewNode(nil, ast.NewAtomValue(":a.b/c?")), `:a.b/c?`},
+		{ast.NewNode(nil, ast.NewBigIntValue(new(big.Int).Lsh(big.NewInt(-1), 70))), `-1180591620717411303424N`},
+		{ast.NewNode(nil, ast.NewBigIntValue(big.NewInt(42))), `42`},
+	}
+
+	for _, tc := range testCases {
+		out, err := Encode(tc.node)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.out, string(out))
+
+		back, err := Decode(out)
+		if assert.NoError(t, err, string(out)) {
+			assert.Equal(t, string(ast.Encode(tc.node)), string(ast.Encode(back.List()[0])))
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	oddMap := ast.NewMap(nil)
+	oddMap.PushValue(nil, ast.NewAtomValue(":a"))
+
+	dotted := ast.NewExpression(nil)
+	dotted.PushValue(nil, ast.NewSymbolValue("a"))
+	dotted.SetTail(ast.NewNode(nil, ast.NewSymbolValue("b")))
+
+	badTag := ast.NewTagged(nil)
+	badTag.PushValue(nil, ast.NewSymbolValue("-tag"))
+	badTag.PushValue(nil, ast.NewIntValue(1))
+
+	testCases := []struct {
+		node *ast.Node
+		err  error
+	}{
+		{nil, ErrUnsupportedNode},
+		{oddMap, ErrOddMap},
+		{dotted, ErrUnsupportedNode},
+		{badTag, ErrInvalidTag},
+		{ast.NewTagged(nil), ErrInvalidTag},
+		{ast.NewNode(nil, ast.NewSymbolValue("1a")), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewSymbolValue("-1")), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewSymbolValue("a b")), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewSymbolValue("a/b/c")), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewAtomValue("::a")), ErrInvalidKeyword},
+		{ast.NewNode(nil, ast.NewAtomValue(":")), ErrInvalidKeyword},
+	}
+
+	for _, tc := range testCases {
+		_, err := Encode(tc.node)
+		assert.True(t, errors.Is(err, tc.err), "%v: %v", tc.node, err)
+	}
+
+	_, err := EncodeDocument(nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedNode))
+}
+
+func TestDecodeTags(t *testing.T) {
+	_, err := Decode([]byte(`#inst "yesterday"`))
+	assert.Error(t, err)
+
+	_, err = Decode([]byte(`#uuid "f81d4fae"`))
+	assert.Error(t, err)
+
+	opts := Options{
+		Tags: map[string]parser.TagFunc{
+			"double": func(n *ast.Node) (*ast.Node, error) {
+				v := n.List()[1].Value().(int64)
+				return ast.NewNode(n.Token(), ast.NewIntValue(v*2)), nil
+			},
+		},
+	}
+	root, err := opts.Decode([]byte(`[#double 21 #other 1]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `[42 #other 1]`, string(ast.Encode(root.List()[0])))
+}
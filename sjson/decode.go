@@ -0,0 +1,296 @@
+package sjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Decoder reads JSON values from an input stream and converts them into
+// nodes, the nodes are built directly from the JSON tokens.
+type Decoder struct {
+	dec *json.Decoder
+	o   Options
+}
+
+// NewDecoder returns a decoder that reads from r
+func NewDecoder(r io.Reader, o Options) *Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Decoder{dec: dec, o: o}
+}
+
+// More reports whether there is another value to decode
+func (d *Decoder) More() bool {
+	return d.dec.More()
+}
+
+// Decode reads the next JSON value and converts it into a node
+func (d *Decoder) Decode() (*ast.Node, error) {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeToken(tok)
+}
+
+func (d *Decoder) decodeToken(tok json.Token) (*ast.Node, error) {
+	if d.o.Tagged {
+		return d.decodeTagged(tok)
+	}
+	return d.decodePlain(tok)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decodeNumber(num json.Number) (*ast.Node, error) {
+	s := num.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if v, ok := new(big.Int).SetString(s, 10); ok {
+			return ast.NewNode(nil, ast.NewBigIntValue(v)), nil
+		}
+	}
+	f64, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewNode(nil, ast.NewFloatValue(f64)), nil
+}
+
+func (d *Decoder) decodePlain(tok json.Token) (*ast.Node, error) {
+	switch v := tok.(type) {
+	case nil:
+		return ast.NewNode(nil, ast.NewNilValue()), nil
+	case bool:
+		return ast.NewNode(nil, ast.NewBoolValue(v)), nil
+	case json.Number:
+		return decodeNumber(v)
+	case string:
+		return ast.NewNode(nil, ast.NewStringValue(v)), nil
+	case json.Delim:
+		switch v {
+		case '[':
+			list := ast.NewList(nil)
+			if err := d.decodeChildren(list, ']', d.decodePlain); err != nil {
+				return nil, err
+			}
+			return list, nil
+		case '{':
+			return d.decodeObject()
+		}
+	}
+	return nil, fmt.Errorf("sjson: unexpected token %v", tok)
+}
+
+func (d *Decoder) decodeChildren(parent *ast.Node, end json.Delim, decode func(json.Token) (*ast.Node, error)) error {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if tok == end {
+			return nil
+		}
+		node, err := decode(tok)
+		if err != nil {
+			return err
+		}
+		if err := parent.Push(node); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Decoder) decodeObject() (*ast.Node, error) {
+	m := ast.NewMap(nil)
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if tok == json.Delim('}') {
+			return m, nil
+		}
+
+		key := tok.(string)
+		if d.o.AtomKeys && ast.IsAtomName(key) {
+			_, err = m.PushValue(nil, ast.NewAtomValue(":"+key))
+		} else {
+			_, err = m.PushValue(nil, ast.NewStringValue(key))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.dec.Token()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		node, err := d.decodePlain(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Push(node); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *Decoder) decodeTagged(tok json.Token) (*ast.Node, error) {
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("%w: expecting object, got %v", ErrInvalidTaggedValue, tok)
+	}
+
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	tag, _ := tok.(string)
+
+	value, err := d.dec.Token()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	var node *ast.Node
+	switch tag {
+	case ast.NodeTypeInt.String():
+		num, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%w: expecting number", ErrInvalidTaggedValue)
+		}
+		v, ok := new(big.Int).SetString(num.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid int %s", ErrInvalidTaggedValue, num)
+		}
+		node = ast.NewNode(nil, ast.NewBigIntValue(v))
+
+	case ast.NodeTypeFloat.String():
+		var s string
+		switch v := value.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			s = v
+		default:
+			return nil, fmt.Errorf("%w: expecting number", ErrInvalidTaggedValue)
+		}
+		f64, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTaggedValue, err)
+		}
+		node = ast.NewNode(nil, ast.NewFloatValue(f64))
+
+	case ast.NodeTypeString.String(), ast.NodeTypeSymbol.String(), ast.NodeTypeAtom.String():
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: expecting string", ErrInvalidTaggedValue)
+		}
+		switch tag {
+		case ast.NodeTypeString.String():
+			node = ast.NewNode(nil, ast.NewStringValue(s))
+		case ast.NodeTypeSymbol.String():
+			node = ast.NewNode(nil, ast.NewSymbolValue(s))
+		default:
+			node = ast.NewNode(nil, ast.NewAtomValue(s))
+		}
+
+	case ast.NodeTypeChar.String():
+		s, ok := value.(string)
+		if !ok || utf8.RuneCountInString(s) != 1 {
+			return nil, fmt.Errorf("%w: expecting a single character", ErrInvalidTaggedValue)
+		}
+		r, _ := utf8.DecodeRuneInString(s)
+		node = ast.NewNode(nil, ast.NewCharValue(r))
+
+	case ast.NodeTypeBitVector.String():
+		s, ok := value.(string)
+		if !ok || strings.Trim(s, "01") != "" {
+			return nil, fmt.Errorf("%w: expecting binary digits", ErrInvalidTaggedValue)
+		}
+		node = ast.NewNode(nil, ast.NewBitVectorValue(s))
+
+	case ast.NodeTypeBool.String():
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: expecting bool", ErrInvalidTaggedValue)
+		}
+		node = ast.NewNode(nil, ast.NewBoolValue(b))
+
+	case ast.NodeTypeNil.String():
+		if value != nil {
+			return nil, fmt.Errorf("%w: expecting null", ErrInvalidTaggedValue)
+		}
+		node = ast.NewNode(nil, ast.NewNilValue())
+
+	case ast.NodeTypeList.String(), ast.NodeTypeExpression.String(), ast.NodeTypeMap.String(),
+		ast.NodeTypeSet.String(), ast.NodeTypeTagged.String():
+		if value != json.Delim('[') {
+			return nil, fmt.Errorf("%w: expecting array", ErrInvalidTaggedValue)
+		}
+		switch tag {
+		case ast.NodeTypeList.String():
+			node = ast.NewList(nil)
+		case ast.NodeTypeExpression.String():
+			node = ast.NewExpression(nil)
+		case ast.NodeTypeSet.String():
+			node = ast.NewSet(nil)
+		case ast.NodeTypeTagged.String():
+			node = ast.NewTagged(nil)
+		default:
+			node = ast.NewMap(nil)
+		}
+		if err := d.decodeChildren(node, ']', d.decodeTagged); err != nil {
+			return nil, err
+		}
+		if tag == ast.NodeTypeTagged.String() {
+			if children := node.List(); len(children) != 2 || children[0].Type() != ast.NodeTypeSymbol {
+				return nil, fmt.Errorf("%w: expecting a tag and a value", ErrInvalidTaggedValue)
+			}
+		}
+
+	case tagDotted:
+		if value != json.Delim('[') {
+			return nil, fmt.Errorf("%w: expecting array", ErrInvalidTaggedValue)
+		}
+		items := ast.NewExpression(nil)
+		if err := d.decodeChildren(items, ']', d.decodeTagged); err != nil {
+			return nil, err
+		}
+		children := items.List()
+		if len(children) < 2 {
+			return nil, fmt.Errorf("%w: dotted expression without a tail", ErrInvalidTaggedValue)
+		}
+		node = ast.NewExpression(nil)
+		for _, child := range children[:len(children)-1] {
+			if err := node.Push(child); err != nil {
+				return nil, err
+			}
+		}
+		node.SetTail(children[len(children)-1])
+
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidTaggedValue, tag)
+	}
+
+	tok, err = d.dec.Token()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if tok != json.Delim('}') {
+		return nil, fmt.Errorf("%w: expecting a single key", ErrInvalidTaggedValue)
+	}
+
+	return node, nil
+}
@@ -0,0 +1,226 @@
+package sjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Encoder writes the JSON representation of nodes to an output stream, nodes
+// are written as they are visited, without building the whole document in
+// memory.
+type Encoder struct {
+	w *bufio.Writer
+	o Options
+}
+
+// NewEncoder returns an encoder that writes to w
+func NewEncoder(w io.Writer, o Options) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), o: o}
+}
+
+// Encode writes the JSON representation of the node followed by a newline
+func (e *Encoder) Encode(n *ast.Node) error {
+	if err := e.encode(n); err != nil {
+		return err
+	}
+	if err := e.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *Encoder) encode(n *ast.Node) error {
+	if e.o.Tagged {
+		return e.encodeTagged(n)
+	}
+	return e.encodePlain(n)
+}
+
+func (e *Encoder) write(s string) error {
+	_, err := e.w.WriteString(s)
+	return err
+}
+
+func (e *Encoder) writeString(s string) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+func formatFloat(f float64) (string, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedValue, f)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s = s + ".0"
+	}
+	return s, nil
+}
+
+func (e *Encoder) encodeList(nodes []*ast.Node, encode func(*ast.Node) error) error {
+	if err := e.write("["); err != nil {
+		return err
+	}
+	for i := range nodes {
+		if i > 0 {
+			if err := e.write(","); err != nil {
+				return err
+			}
+		}
+		if err := encode(nodes[i]); err != nil {
+			return err
+		}
+	}
+	return e.write("]")
+}
+
+func (e *Encoder) encodePlain(n *ast.Node) error {
+	if n == nil {
+		return e.write("null")
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeInt:
+		return e.write(n.Encode())
+	case ast.NodeTypeFloat:
+		s, err := formatFloat(n.Value().(float64))
+		if err != nil {
+			return err
+		}
+		return e.write(s)
+	case ast.NodeTypeString, ast.NodeTypeAtom:
+		return e.writeString(n.Value().(string))
+	case ast.NodeTypeBool:
+		return e.write(n.Encode())
+	case ast.NodeTypeNil:
+		return e.write("null")
+	case ast.NodeTypeSymbol:
+		switch name := n.Value().(string); name {
+		case "true", "false":
+			return e.write(name)
+		case "nil":
+			return e.write("null")
+		default:
+			return e.writeString(name)
+		}
+	case ast.NodeTypeList, ast.NodeTypeExpression:
+		if n.Tail() != nil {
+			return fmt.Errorf("%w: dotted %v", ErrUnsupportedNode, n.Type())
+		}
+		return e.encodeList(n.List(), e.encodePlain)
+	case ast.NodeTypeMap:
+		return e.encodeMap(n)
+	}
+
+	return fmt.Errorf("%w: %v", ErrUnsupportedNode, n.Type())
+}
+
+func mapKey(n *ast.Node) (string, error) {
+	switch n.Type() {
+	case ast.NodeTypeString, ast.NodeTypeSymbol:
+		return n.Value().(string), nil
+	case ast.NodeTypeAtom:
+		return strings.TrimPrefix(n.Value().(string), ":"), nil
+	case ast.NodeTypeInt, ast.NodeTypeFloat:
+		return n.Encode(), nil
+	}
+	return "", fmt.Errorf("%w: %v", ErrUnsupportedKey, n.Type())
+}
+
+func (e *Encoder) encodeMap(n *ast.Node) error {
+	nodes := n.List()
+	if len(nodes)%2 != 0 {
+		return ErrOddMap
+	}
+
+	if err := e.write("{"); err != nil {
+		return err
+	}
+	for i := 0; i < len(nodes); i += 2 {
+		if i > 0 {
+			if err := e.write(","); err != nil {
+				return err
+			}
+		}
+		key, err := mapKey(nodes[i])
+		if err != nil {
+			return err
+		}
+		if err := e.writeString(key); err != nil {
+			return err
+		}
+		if err := e.write(":"); err != nil {
+			return err
+		}
+		if err := e.encodePlain(nodes[i+1]); err != nil {
+			return err
+		}
+	}
+	return e.write("}")
+}
+
+func (e *Encoder) encodeTagged(n *ast.Node) error {
+	if n == nil {
+		return e.write("null")
+	}
+
+	tag := n.Type().String()
+	if n.Tail() != nil {
+		tag = tagDotted
+	}
+
+	if err := e.write("{"); err != nil {
+		return err
+	}
+	if err := e.writeString(tag); err != nil {
+		return err
+	}
+	if err := e.write(":"); err != nil {
+		return err
+	}
+
+	var err error
+	switch n.Type() {
+	case ast.NodeTypeInt:
+		err = e.write(n.Encode())
+	case ast.NodeTypeFloat:
+		f := n.Value().(float64)
+		if s, ferr := formatFloat(f); ferr == nil {
+			err = e.write(s)
+		} else {
+			err = e.writeString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case ast.NodeTypeString, ast.NodeTypeSymbol, ast.NodeTypeAtom, ast.NodeTypeBitVector:
+		err = e.writeString(n.Value().(string))
+	case ast.NodeTypeChar:
+		err = e.writeString(string(n.Value().(rune)))
+	case ast.NodeTypeBool:
+		err = e.write(n.Encode())
+	case ast.NodeTypeNil:
+		err = e.write("null")
+	case ast.NodeTypeList, ast.NodeTypeExpression, ast.NodeTypeMap, ast.NodeTypeSet, ast.NodeTypeTagged:
+		nodes := n.List()
+		if tail := n.Tail(); tail != nil {
+			nodes = append(nodes[:len(nodes):len(nodes)], tail)
+		}
+		err = e.encodeList(nodes, e.encodeTagged)
+	default:
+		err = fmt.Errorf("%w: %v", ErrUnsupportedNode, n.Type())
+	}
+	if err != nil {
+		return err
+	}
+
+	return e.write("}")
+}
@@ -0,0 +1,133 @@
+// Package sjson converts S-expression trees to JSON and JSON documents to
+// S-expression trees.
+//
+// The default (plain) mapping produces idiomatic JSON and is lossy:
+//
+//	int         -> number                  1
+//	float       -> number                  1.5 (always with a decimal point)
+//	string      -> string                  "hello"
+//	symbol      -> string                  "hello"
+//	atom        -> string                  ":hello"
+//	bool        -> true or false           true
+//	nil         -> null                    null
+//	list        -> array                   [1, 2]
+//	expression  -> array                   ["fn", 1]
+//	map         -> object                  {"key": 1}
+//
+// The true, false and nil symbols are also mapped to the true, false and null
+// JSON literals. Map keys must be strings, symbols, atoms or numbers; atoms lose
+// their leading colon when they are used as keys and maps must have an even
+// number of children.
+//
+// When converting JSON into an AST, objects become maps with string keys (or
+// atom keys, see Options.AtomKeys), arrays become lists, numbers become ints
+// or floats (a number with a decimal point or an exponent is a float, ints
+// that don't fit in an int64 are kept as big ints), strings become strings,
+// true and false become bools and null becomes nil.
+//
+// The tagged mapping is lossless, every node is represented by an object with
+// a single key that names the type of the node:
+//
+//	{"int": 1}
+//	{"float": 1.5}
+//	{"string": "hello"}
+//	{"symbol": "hello"}
+//	{"atom": ":hello"}
+//	{"bool": true}
+//	{"nil": null}
+//	{"char": "a"}
+//	{"bitvector": "0101"}
+//	{"list": [{"int": 1}, {"int": 2}]}
+//	{"expression": [{"symbol": "fn"}, {"int": 1}]}
+//	{"map": [{"atom": ":key"}, {"int": 1}]}
+//	{"set": [{"int": 1}, {"int": 2}]}
+//	{"tagged": [{"symbol": "inst"}, {"string": "2020-01-01"}]}
+//	{"dotted": [{"symbol": "a"}, {"int": 1}]}
+//
+// Dotted expressions, like (a . 1), are tagged as dotted and the last element
+// of their array is the tail. Bit vectors are written as their binary digits
+// and the array of a tagged element holds its tag and its value. The plain
+// mapping can't represent any of them, nor sets and chars.
+//
+// Floats that can't be represented as JSON numbers (NaN and infinities) are
+// written as strings in the tagged mapping.
+package sjson
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// tagDotted names dotted expressions in the tagged mapping
+const tagDotted = "dotted"
+
+// Error messages
+var (
+	ErrOddMap             = errors.New("map with an odd number of children")
+	ErrUnsupportedKey     = errors.New("unsupported map key")
+	ErrUnsupportedNode    = errors.New("unsupported node type")
+	ErrUnsupportedValue   = errors.New("value can't be represented in JSON")
+	ErrInvalidTaggedValue = errors.New("invalid tagged value")
+)
+
+// Options represents the settings of the converter
+type Options struct {
+	// Tagged enables the lossless mapping
+	Tagged bool
+
+	// AtomKeys makes the plain decoder convert object keys into atoms, as long
+	// as the key is a valid atom name. Keys that can't be atoms are kept as
+	// strings.
+	AtomKeys bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Encode returns the JSON representation of a node using the plain mapping
+func Encode(n *ast.Node) ([]byte, error) {
+	return DefaultOptions.Encode(n)
+}
+
+// Decode converts a JSON document into a node using the plain mapping
+func Decode(data []byte) (*ast.Node, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// WriteJSON reads S-expressions from r and writes them to w as a JSON array,
+// see Options.WriteJSON
+func WriteJSON(w io.Writer, r io.Reader) error {
+	return DefaultOptions.WriteJSON(w, r)
+}
+
+// WriteSexpr reads a JSON document from r and writes it to w as
+// S-expressions, see Options.WriteSexpr
+func WriteSexpr(w io.Writer, r io.Reader) error {
+	return DefaultOptions.WriteSexpr(w, r)
+}
+
+// Encode returns the JSON representation of a node
+func (o Options) Encode(n *ast.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, o).Encode(n); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Decode converts a JSON document into a node
+func (o Options) Decode(data []byte) (*ast.Node, error) {
+	dec := NewDecoder(bytes.NewReader(data), o)
+
+	node, err := dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("sjson: unexpected data after top-level value")
+	}
+	return node, nil
+}
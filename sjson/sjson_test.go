@@ -0,0 +1,272 @@
+package sjson
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func parse(t *testing.T, in string) *ast.Node {
+	root, err := parser.Parse([]byte(in))
+	assert.NoError(t, err)
+	return root
+}
+
+func TestEncodePlain(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{``, `[]`},
+		{`1 -2 3.5 4.0`, `[1,-2,3.5,4.0]`},
+		{`"hello \"world\"" sym :atom`, `["hello \"world\"","sym",":atom"]`},
+		{`true false nil`, `[true,false,null]`},
+		{`(fn [a b] {:c 1 "d" [2] e 3.5})`, `[["fn",["a","b"],{"c":1,"d":[2],"e":3.5}]]`},
+		{`{1 :one 2.5 :two}`, `[{"1":":one","2.5":":two"}]`},
+	}
+
+	for i := range testCases {
+		out, err := Encode(parse(t, testCases[i].In))
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, string(out))
+	}
+}
+
+func TestEncodePlainErrors(t *testing.T) {
+	_, err := Encode(parse(t, `{:a}`))
+	assert.True(t, errors.Is(err, ErrOddMap))
+
+	_, err = Encode(parse(t, `{[1] 2}`))
+	assert.True(t, errors.Is(err, ErrUnsupportedKey))
+}
+
+func TestDecodePlain(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{`1`, `1`},
+		{`1.5`, `1.5`},
+		{`1e3`, `1000.0`},
+		{`12345678901234567890`, `12345678901234567890`},
+		{`"a\nb"`, `"a\nb"`},
+		{`[true, false, null]`, `[true false nil]`},
+		{`{"name": "x", "tags": [1, 2], "nested": {"a b": {}}}`, `{"name" "x" "tags" [1 2] "nested" {"a b" {}}}`},
+	}
+
+	for i := range testCases {
+		node, err := Decode([]byte(testCases[i].In))
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, string(ast.Encode(node)))
+	}
+
+	_, err := Decode([]byte(`[1, 2`))
+	assert.Error(t, err)
+
+	_, err = Decode([]byte(`1 2`))
+	assert.Error(t, err)
+}
+
+func TestDecodeAtomKeys(t *testing.T) {
+	opts := Options{AtomKeys: true}
+
+	node, err := opts.Decode([]byte(`{"name": "x", "max-len": 2, "a b": 3, "1x": 4}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{:name "x" :max-len 2 "a b" 3 "1x" 4}`, string(ast.Encode(node)))
+
+	out, err := Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"x","max-len":2,"a b":3,"1x":4}`, string(out))
+}
+
+func TestTaggedRoundTrip(t *testing.T) {
+	opts := Options{Tagged: true}
+
+	in := `(fn "str\t" :atom sym [1 2.0 -3.5] {:a (b)} true nil) [] {}`
+	root := parse(t, in)
+
+	out, err := opts.Encode(root)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), `{"list":[{"expression":[{"symbol":"fn"},{"string":"str\t"}`))
+
+	node, err := opts.Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, in, string(ast.EncodeDocument(node)))
+
+	_, err = opts.Decode([]byte(`{"int": 1, "float": 2}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+
+	_, err = opts.Decode([]byte(`{"unknown": 1}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+
+	_, err = opts.Decode([]byte(`[1]`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WriteJSON(&buf, strings.NewReader("(a 1)\n# comment\n[b 2.5]\n{:c \"d\"}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[[\"a\",1],[\"b\",2.5],{\"c\":\"d\"}]\n", buf.String())
+
+	buf.Reset()
+	err = Options{Tagged: true}.WriteJSON(&buf, strings.NewReader("(a) 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"list\":[{\"expression\":[{\"symbol\":\"a\"}]},{\"int\":1}]}\n", buf.String())
+
+	err = WriteJSON(&buf, strings.NewReader("(a 1"))
+	assert.Error(t, err)
+}
+
+func TestWriteJSONStopsParser(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		err := WriteJSON(&buf, strings.NewReader("{:a} (b 1) (c 2)"))
+		assert.True(t, errors.Is(err, ErrOddMap))
+	}
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestWriteSexpr(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WriteSexpr(&buf, strings.NewReader(`[{"a": 1}, [2, "x"], null] 3`))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"a\" 1}\n[2 \"x\"]\nnil\n3\n", buf.String())
+
+	buf.Reset()
+
+	var out bytes.Buffer
+	opts := Options{Tagged: true}
+	assert.NoError(t, opts.WriteJSON(&buf, strings.NewReader(`(a :b) [1.5]`)))
+	assert.NoError(t, opts.WriteSexpr(&out, &buf))
+	assert.Equal(t, "(a :b)\n[1.5]\n", out.String())
+}
+
+func TestBoolAndNil(t *testing.T) {
+	node, err := Decode([]byte(`[true, null]`))
+	assert.NoError(t, err)
+	assert.Equal(t, ast.NodeTypeBool, node.List()[0].Type())
+	assert.Equal(t, ast.NodeTypeNil, node.List()[1].Type())
+
+	out, err := Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `[true,null]`, string(out))
+
+	opts := Options{Tagged: true}
+	out, err = opts.Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"list":[{"bool":true},{"nil":null}]}`, string(out))
+
+	back, err := opts.Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, ast.NodeTypeBool, back.List()[0].Type())
+	assert.Equal(t, ast.NodeTypeNil, back.List()[1].Type())
+
+	_, err = opts.Decode([]byte(`{"bool": "yes"}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+
+	_, err = opts.Decode([]byte(`{"nil": 0}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+}
+
+func TestBigInts(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	node := ast.NewNode(nil, ast.NewBigIntValue(huge))
+
+	out, err := Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `123456789012345678901234567890`, string(out))
+
+	opts := Options{Tagged: true}
+	out, err = opts.Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"int":123456789012345678901234567890}`, string(out))
+
+	back, err := opts.Decode(out)
+	assert.NoError(t, err)
+	v, ok := back.BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, 0, huge.Cmp(v))
+
+	_, err = opts.Decode([]byte(`{"int": 1.5}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+
+	// the plain mapping keeps ints of any size too
+	back, err = Decode([]byte(`[123456789012345678901234567890, -12345678901234567890, 42]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `[123456789012345678901234567890 -12345678901234567890 42]`, string(ast.Encode(back)))
+	v, ok = back.List()[0].BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, 0, huge.Cmp(v))
+	i, ok := back.List()[2].Int()
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), i)
+}
+
+func TestTaggedEDNNodes(t *testing.T) {
+	opts := Options{Tagged: true}
+
+	p := parser.NewParser(strings.NewReader(`#{1 \a} #point [1 2] \newline`))
+	p.SetOptions(parser.EDNOptions())
+	assert.NoError(t, p.Parse())
+	root := p.RootNode()
+	root.PushValue(nil, ast.NewBitVectorValue("0101"))
+
+	out, err := opts.Encode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"list":[{"set":[{"int":1},{"char":"a"}]},{"tagged":[{"symbol":"point"},{"list":[{"int":1},{"int":2}]}]},{"char":"\n"},{"bitvector":"0101"}]}`, string(out))
+
+	back, err := opts.Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.EncodeDocument(root)), string(ast.EncodeDocument(back)))
+	assert.Equal(t, ast.NodeTypeSet, back.List()[0].Type())
+	assert.Equal(t, ast.NodeTypeTagged, back.List()[1].Type())
+
+	_, err = Encode(root)
+	assert.True(t, errors.Is(err, ErrUnsupportedNode))
+
+	for _, in := range []string{
+		`{"char": "ab"}`,
+		`{"char": 1}`,
+		`{"bitvector": "012"}`,
+		`{"tagged": [{"symbol": "point"}]}`,
+		`{"tagged": [{"int": 1}, {"int": 2}]}`,
+	} {
+		_, err = opts.Decode([]byte(in))
+		assert.True(t, errors.Is(err, ErrInvalidTaggedValue), in)
+	}
+}
+
+func TestDottedExpressions(t *testing.T) {
+	node := ast.NewPair(nil, ast.NewNode(nil, ast.NewSymbolValue("a")), ast.NewNode(nil, ast.NewIntValue(1)))
+
+	_, err := Encode(node)
+	assert.True(t, errors.Is(err, ErrUnsupportedNode))
+
+	opts := Options{Tagged: true}
+	out, err := opts.Encode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"dotted":[{"symbol":"a"},{"int":1}]}`, string(out))
+
+	back, err := opts.Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `(a . 1)`, string(ast.Encode(back)))
+
+	_, err = opts.Decode([]byte(`{"dotted": [{"int": 1}]}`))
+	assert.True(t, errors.Is(err, ErrInvalidTaggedValue))
+}
@@ -0,0 +1,150 @@
+package sjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// WriteJSON reads S-expressions from r, one top-level form at a time, and
+// writes them to w as the elements of a JSON array. Only one top-level form is
+// kept in memory at any given time. In tagged mode the array is wrapped into a
+// tagged list.
+func (o Options) WriteJSON(w io.Writer, r io.Reader) error {
+	e := NewEncoder(w, o)
+	p := parser.NewParser(r)
+	defer p.Close()
+
+	open, close := "[", "]\n"
+	if o.Tagged {
+		open, close = `{"list":[`, "]}\n"
+	}
+
+	if err := e.write(open); err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		node, err := p.NextNode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if err := e.write(","); err != nil {
+				return err
+			}
+		}
+		if err := e.encode(node); err != nil {
+			return err
+		}
+	}
+	if err := e.write(close); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+// WriteSexpr reads JSON values from r and writes them to w as S-expressions,
+// one form per line. The elements of top-level arrays (or tagged lists) are
+// converted and written one at a time, so only one element is kept in memory
+// at any given time.
+func (o Options) WriteSexpr(w io.Writer, r io.Reader) error {
+	d := NewDecoder(r, o)
+	bw := bufio.NewWriter(w)
+
+	write := func(n *ast.Node) error {
+		if _, err := bw.Write(ast.Encode(n)); err != nil {
+			return err
+		}
+		return bw.WriteByte('\n')
+	}
+
+	for d.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if o.Tagged {
+			err = d.streamTagged(tok, write)
+		} else {
+			err = d.streamPlain(tok, write)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (d *Decoder) streamElements(fn func(*ast.Node) error) error {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if tok == json.Delim(']') {
+			return nil
+		}
+		node, err := d.decodeToken(tok)
+		if err != nil {
+			return err
+		}
+		if err := fn(node); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Decoder) streamPlain(tok json.Token, fn func(*ast.Node) error) error {
+	if tok == json.Delim('[') {
+		return d.streamElements(fn)
+	}
+	node, err := d.decodePlain(tok)
+	if err != nil {
+		return err
+	}
+	return fn(node)
+}
+
+func (d *Decoder) streamTagged(tok json.Token, fn func(*ast.Node) error) error {
+	if tok != json.Delim('{') {
+		return fmt.Errorf("%w: expecting object, got %v", ErrInvalidTaggedValue, tok)
+	}
+
+	tag, err := d.dec.Token()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if tag != ast.NodeTypeList.String() {
+		return fmt.Errorf("%w: expecting a tagged list, got %v", ErrInvalidTaggedValue, tag)
+	}
+
+	tok, err = d.dec.Token()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("%w: expecting array", ErrInvalidTaggedValue)
+	}
+	if err := d.streamElements(fn); err != nil {
+		return err
+	}
+
+	tok, err = d.dec.Token()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if tok != json.Delim('}') {
+		return fmt.Errorf("%w: expecting a single key", ErrInvalidTaggedValue)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package smtlib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Encode returns the SMT-LIB representation of a node
+func (o Options) Encode(n *ast.Node) ([]byte, error) {
+	var buf strings.Builder
+	if err := o.encodeNode(&buf, n); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// EncodeScript returns the SMT-LIB representation of the children of a root
+// node, one command per line
+func (o Options) EncodeScript(root *ast.Node) ([]byte, error) {
+	if root == nil || !root.IsVector() {
+		return nil, fmt.Errorf("smtlib: %w: expecting a root node", ErrUnsupportedNode)
+	}
+
+	var buf strings.Builder
+	for _, child := range root.List() {
+		if err := o.encodeNode(&buf, child); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return []byte(buf.String()), nil
+}
+
+func (o Options) encodeNode(buf *strings.Builder, n *ast.Node) error {
+	if n == nil {
+		return fmt.Errorf("smtlib: %w: nil", ErrUnsupportedNode)
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeInt:
+		if text := n.Encode(); strings.HasPrefix(text, "-") {
+			fmt.Fprintf(buf, "(- %s)", text[1:])
+		} else {
+			buf.WriteString(text)
+		}
+
+	case ast.NodeTypeFloat:
+		v := n.Value().(float64)
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("smtlib: %w: %v", ErrInvalidNumber, v)
+		}
+		if v < 0 {
+			fmt.Fprintf(buf, "(- %s)", encodeDecimal(-v))
+			break
+		}
+		buf.WriteString(encodeDecimal(math.Abs(v)))
+
+	case ast.NodeTypeBool, ast.NodeTypeBitVector:
+		buf.WriteString(n.Encode())
+
+	case ast.NodeTypeString:
+		buf.WriteString(`"` + strings.Replace(n.Value().(string), `"`, `""`, -1) + `"`)
+
+	case ast.NodeTypeSymbol:
+		s := n.Value().(string)
+		if strings.ContainsAny(s, `|\`) {
+			return fmt.Errorf("smtlib: %w: %q", ErrInvalidSymbol, s)
+		}
+		if o.QuoteAll || !isSimpleSymbol(s) {
+			s = "|" + s + "|"
+		}
+		buf.WriteString(s)
+
+	case ast.NodeTypeAtom:
+		s := n.Value().(string)
+		if !strings.HasPrefix(s, ":") || !isSimpleSymbol(s[1:]) {
+			return fmt.Errorf("smtlib: %w: %q", ErrInvalidKeyword, s)
+		}
+		buf.WriteString(s)
+
+	case ast.NodeTypeExpression, ast.NodeTypeList:
+		if n.Tail() != nil {
+			return fmt.Errorf("smtlib: %w: dotted expression", ErrUnsupportedNode)
+		}
+		buf.WriteByte('(')
+		for i, child := range n.List() {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			if err := o.encodeNode(buf, child); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(')')
+
+	default:
+		return fmt.Errorf("smtlib: %w: %v", ErrUnsupportedNode, n.Type())
+	}
+	return nil
+}
+
+// encodeDecimal returns a non-negative float as an SMT-LIB decimal, which
+// can't use exponents and must have digits on both sides of the dot.
+func encodeDecimal(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s = s + ".0"
+	}
+	return s
+}
+
+// symbolChars are the characters, other than letters and digits, allowed in
+// simple symbols
+const symbolChars = "~!@$%^&*_-+=<>.?/"
+
+// isSimpleSymbol returns true if the text is an SMT-LIB simple symbol: a
+// non-empty sequence of ASCII letters, digits and symbolChars that does not
+// begin with a digit.
+func isSimpleSymbol(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				return false
+			}
+		case strings.ContainsRune(symbolChars, r):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+// Package smtlib converts S-expression trees to SMT-LIB v2 text, the language
+// spoken by SMT solvers like Z3 and CVC5, and SMT-LIB scripts and solver
+// responses to S-expression trees.
+//
+// SMT-LIB is read by the parser itself (see parser.SMTLIBOptions), the syntax
+// of SMT-LIB maps to node types:
+//
+//	42, 1.5          -> int and float, ints of any size
+//	"say ""hi"""     -> string
+//	#x1f, #b0101     -> bitvector
+//	foo, |a b|       -> symbol
+//	:named           -> atom
+//	(assert x)       -> expression
+//
+// The encoder writes text that solvers accept: negative numbers are written as
+// (- n), symbols that are not simple symbols are enclosed in vertical bars and
+// bools are written as true and false, which are read back as symbols, as in
+// SMT-LIB they are the constants of the Core theory. Nodes that have no SMT-LIB syntax, like
+// maps or chars, make the encoder fail.
+package smtlib
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+// Error messages
+var (
+	ErrInvalidSymbol   = errors.New("invalid symbol")
+	ErrInvalidKeyword  = errors.New("invalid keyword")
+	ErrInvalidNumber   = errors.New("invalid number")
+	ErrUnsupportedNode = errors.New("unsupported node")
+)
+
+// Options represents the settings of the converter
+type Options struct {
+	// QuoteAll makes the encoder enclose all symbols in vertical bars, even
+	// the ones that are simple symbols.
+	QuoteAll bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Encode returns the SMT-LIB representation of a node using DefaultOptions
+func Encode(n *ast.Node) ([]byte, error) {
+	return DefaultOptions.Encode(n)
+}
+
+// EncodeScript returns the SMT-LIB representation of the children of a root
+// node, one command per line, using DefaultOptions
+func EncodeScript(root *ast.Node) ([]byte, error) {
+	return DefaultOptions.EncodeScript(root)
+}
+
+// Decode reads an SMT-LIB script or a solver response into a root node using
+// DefaultOptions
+func Decode(data []byte) (*ast.Node, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// Decode reads an SMT-LIB script or a solver response into a root node, like
+// the one returned by the parser.
+func (o Options) Decode(data []byte) (*ast.Node, error) {
+	p := parser.NewParser(bytes.NewReader(data))
+	p.SetOptions(parser.SMTLIBOptions())
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return p.RootNode(), nil
+}
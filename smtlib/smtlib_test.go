@@ -0,0 +1,159 @@
+package smtlib
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+)
+
+const script = `; find x such that x & 0xf0 = 0xa0
+(set-logic QF_BV)
+(set-option :produce-models true)
+(declare-const |the x| (_ BitVec 8))
+(assert (! (= (bvand |the x| #xF0) #b10100000) :named masked))
+(assert (> (- 3) -2.5))
+(echo "say ""hi""")
+(check-sat)
+(get-model)
+`
+
+func TestDecode(t *testing.T) {
+	root, err := Decode([]byte(script))
+	assert.NoError(t, err)
+
+	nodes := root.List()
+	assert.Equal(t, 8, len(nodes))
+	assert.Equal(t, "the x", nodes[2].List()[1].Value())
+
+	named := nodes[3].List()[1].List()
+	assert.Equal(t, "!", named[0].Value())
+	assert.Equal(t, ast.NodeTypeBitVector, named[1].List()[2].Type())
+	assert.Equal(t, ":named", named[2].Value())
+	assert.Equal(t, `say "hi"`, nodes[5].List()[1].Value())
+}
+
+func TestDecodeResponse(t *testing.T) {
+	root, err := Decode([]byte(`sat
+(
+  (define-fun |the x| () (_ BitVec 8) #xa0)
+  (define-fun y () Real (/ 1.0 3.0))
+)
+(error "line 3 column 10: unknown constant z")
+`))
+	assert.NoError(t, err)
+
+	nodes := root.List()
+	assert.Equal(t, "sat", nodes[0].Value())
+
+	model := nodes[1].List()
+	assert.Equal(t, 2, len(model))
+	assert.Equal(t, "the x", model[0].List()[1].Value())
+	assert.Equal(t, "10100000", model[0].List()[4].Value())
+	assert.Equal(t, "error", nodes[2].List()[0].Value())
+}
+
+func TestRoundTrip(t *testing.T) {
+	root, err := Decode([]byte(script))
+	assert.NoError(t, err)
+
+	out, err := EncodeScript(root)
+	assert.NoError(t, err)
+	assert.Equal(t, `(set-logic QF_BV)
+(set-option :produce-models true)
+(declare-const |the x| (_ BitVec 8))
+(assert (! (= (bvand |the x| #xf0) #xa0) :named masked))
+(assert (> (- 3) (- 2.5)))
+(echo "say ""hi""")
+(check-sat)
+(get-model)
+`, string(out))
+
+	back, err := Decode(out)
+	assert.NoError(t, err)
+	again, err := EncodeScript(back)
+	assert.NoError(t, err)
+	assert.Equal(t, string(out), string(again))
+}
+
+func TestBigNumerals(t *testing.T) {
+	root, err := Decode([]byte("(assert (= x 340282366920938463463374607431768211456))"))
+	assert.NoError(t, err)
+
+	n := root.List()[0].List()[1].List()[2]
+	assert.Equal(t, ast.NodeTypeInt, n.Type())
+	v, ok := n.BigInt()
+	assert.True(t, ok)
+	assert.Equal(t, "340282366920938463463374607431768211456", v.String())
+
+	out, err := EncodeScript(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "(assert (= x 340282366920938463463374607431768211456))\n", string(out))
+
+	v.Neg(v)
+	out, err = Encode(ast.NewNode(nil, ast.NewBigIntValue(v)))
+	assert.NoError(t, err)
+	assert.Equal(t, "(- 340282366920938463463374607431768211456)", string(out))
+}
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		node *ast.Node
+		out  string
+	}{
+		{ast.NewNode(nil, ast.NewIntValue(-7)), `(- 7)`},
+		{ast.NewNode(nil, ast.NewIntValue(math.MinInt64)), `(- 9223372036854775808)`},
+		{ast.NewNode(nil, ast.NewFloatValue(1e21)), `1000000000000000000000.0`},
+		{ast.NewNode(nil, ast.NewFloatValue(0.125)), `0.125`},
+		{ast.NewNode(nil, ast.NewBoolValue(false)), `false`},
+		{ast.NewNode(nil, ast.NewBitVectorValue("101")), `#b101`},
+		{ast.NewNode(nil, ast.NewBitVectorValue("00001111")), `#x0f`},
+		{ast.NewNode(nil, ast.NewStringValue("a\"b\\c")), `"a""b\c"`},
+		{ast.NewNode(nil, ast.NewSymbolValue("x!1")), `x!1`},
+		{ast.NewNode(nil, ast.NewSymbolValue("1x")), `|1x|`},
+		{ast.NewNode(nil, ast.NewSymbolValue("a;b")), `|a;b|`},
+		{ast.NewNode(nil, ast.NewSymbolValue("λ")), `|λ|`},
+		{ast.NewNode(nil, ast.NewAtomValue(":named")), `:named`},
+	}
+
+	for _, tc := range testCases {
+		out, err := Encode(tc.node)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.out, string(out))
+	}
+
+	out, err := Options{QuoteAll: true}.Encode(ast.NewNode(nil, ast.NewSymbolValue("x")))
+	assert.NoError(t, err)
+	assert.Equal(t, `|x|`, string(out))
+}
+
+func TestEncodeErrors(t *testing.T) {
+	dotted := ast.NewExpression(nil)
+	dotted.PushValue(nil, ast.NewSymbolValue("a"))
+	dotted.SetTail(ast.NewNode(nil, ast.NewSymbolValue("b")))
+
+	testCases := []struct {
+		node *ast.Node
+		err  error
+	}{
+		{nil, ErrUnsupportedNode},
+		{dotted, ErrUnsupportedNode},
+		{ast.NewMap(nil), ErrUnsupportedNode},
+		{ast.NewNode(nil, ast.NewNilValue()), ErrUnsupportedNode},
+		{ast.NewNode(nil, ast.NewCharValue('a')), ErrUnsupportedNode},
+		{ast.NewNode(nil, ast.NewFloatValue(math.Inf(1))), ErrInvalidNumber},
+		{ast.NewNode(nil, ast.NewSymbolValue("a|b")), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewSymbolValue(`a\b`)), ErrInvalidSymbol},
+		{ast.NewNode(nil, ast.NewAtomValue(":a b")), ErrInvalidKeyword},
+	}
+
+	for _, tc := range testCases {
+		_, err := Encode(tc.node)
+		assert.True(t, errors.Is(err, tc.err), "%v: %v", tc.node, err)
+	}
+
+	_, err := EncodeScript(nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedNode))
+}
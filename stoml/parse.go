@@ -0,0 +1,903 @@
+package stoml
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type valueKind uint8
+
+const (
+	kindString valueKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindDatetime
+	kindArray
+	kindTable
+)
+
+// value represents a TOML value along with the position where it was defined
+type value struct {
+	kind valueKind
+	v    interface{}
+
+	// arrayOfTables is true for arrays that were defined by [[header]]
+	arrayOfTables bool
+
+	line, column int
+}
+
+// table represents a TOML table, it keeps the order in which the keys were
+// defined.
+type table struct {
+	keys   []string
+	values map[string]*value
+
+	// defined is true for tables that were defined by a [header]
+	defined bool
+	// dotted is true for tables that were created by dotted keys
+	dotted bool
+	// inline is true for inline tables, which can't be extended
+	inline bool
+}
+
+func newTable() *table {
+	return &table{values: map[string]*value{}}
+}
+
+func (t *table) get(key string) *value {
+	return t.values[key]
+}
+
+func (t *table) set(key string, v *value) {
+	t.keys = append(t.keys, key)
+	t.values[key] = v
+}
+
+// SyntaxError represents an error found while parsing a TOML document
+type SyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("toml: line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+var errEOF = errors.New("unexpected end of input")
+
+type tomlParser struct {
+	src string
+	pos int
+
+	line, column int
+
+	root    *table
+	current *table
+
+	// comments holds the text of the comments, as string values
+	comments []*value
+}
+
+// parse reads a TOML document, it returns its root table and its comments
+func parse(src string) (*table, []*value, error) {
+	p := &tomlParser{
+		src:    strings.TrimPrefix(src, "\ufeff"),
+		line:   1,
+		column: 1,
+		root:   newTable(),
+	}
+	p.current = p.root
+
+	if err := p.document(); err != nil {
+		return nil, nil, err
+	}
+	return p.root, p.comments, nil
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.line, Column: p.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) peekAt(offset int) byte {
+	if p.pos+offset >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+offset]
+}
+
+func (p *tomlParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+// next consumes and returns the next rune
+func (p *tomlParser) next() rune {
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	if r == '\n' {
+		p.line++
+		p.column = 1
+	} else {
+		p.column++
+	}
+	return r
+}
+
+func (p *tomlParser) skip(n int) {
+	for i := 0; i < n; i++ {
+		p.next()
+	}
+}
+
+func (p *tomlParser) skipWhitespace() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.next()
+	}
+}
+
+func (p *tomlParser) isNewline() bool {
+	return p.peek() == '\n' || p.hasPrefix("\r\n")
+}
+
+func (p *tomlParser) skipNewline() {
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	p.next()
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\t' || r == 0x7f
+}
+
+func (p *tomlParser) skipComment() error {
+	if p.peek() != '#' {
+		return nil
+	}
+	comment := &value{kind: kindString, line: p.line, column: p.column}
+	start := p.pos
+	for !p.eof() && !p.isNewline() {
+		if r := p.next(); isControl(r) {
+			return p.errorf("control character %U in comment", r)
+		}
+	}
+	comment.v = p.src[start:p.pos]
+	p.comments = append(p.comments, comment)
+	return nil
+}
+
+// skipBlank skips whitespace, comments and newlines, as allowed inside of
+// arrays
+func (p *tomlParser) skipBlank() error {
+	for {
+		p.skipWhitespace()
+		if err := p.skipComment(); err != nil {
+			return err
+		}
+		if !p.isNewline() {
+			return nil
+		}
+		p.skipNewline()
+	}
+}
+
+// endOfLine expects the end of an expression
+func (p *tomlParser) endOfLine() error {
+	p.skipWhitespace()
+	if err := p.skipComment(); err != nil {
+		return err
+	}
+	if p.eof() {
+		return nil
+	}
+	if !p.isNewline() {
+		return p.errorf("expecting end of line, got %q", p.peek())
+	}
+	p.skipNewline()
+	return nil
+}
+
+func (p *tomlParser) document() error {
+	for !p.eof() {
+		p.skipWhitespace()
+
+		switch c := p.peek(); {
+		case c == '#':
+			// handled by endOfLine
+		case c == '\n' || c == '\r':
+			// empty line
+		case c == '[':
+			if err := p.tableHeader(); err != nil {
+				return err
+			}
+		default:
+			if err := p.keyValue(p.current); err != nil {
+				return err
+			}
+		}
+
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) simpleKey() (string, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		if p.hasPrefix(`"""`) {
+			return "", p.errorf("multi-line strings can't be keys")
+		}
+		return p.basicString()
+	case c == '\'':
+		if p.hasPrefix(`'''`) {
+			return "", p.errorf("multi-line strings can't be keys")
+		}
+		return p.literalString()
+	case isBareKeyChar(c):
+		start := p.pos
+		for isBareKeyChar(p.peek()) {
+			p.next()
+		}
+		return p.src[start:p.pos], nil
+	case p.eof():
+		return "", p.errorf("%v", errEOF)
+	}
+	return "", p.errorf("invalid key character %q", p.peek())
+}
+
+func (p *tomlParser) key() ([]string, error) {
+	keys := []string{}
+	for {
+		k, err := p.simpleKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+
+		p.skipWhitespace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.next()
+		p.skipWhitespace()
+	}
+}
+
+func (p *tomlParser) tableHeader() error {
+	line, column := p.line, p.column
+
+	p.next()
+	array := false
+	if p.peek() == '[' {
+		p.next()
+		array = true
+	}
+
+	p.skipWhitespace()
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+
+	if array {
+		if !p.hasPrefix("]]") {
+			return p.errorf("expecting ]]")
+		}
+		p.skip(2)
+	} else {
+		if p.peek() != ']' {
+			return p.errorf("expecting ]")
+		}
+		p.next()
+	}
+
+	t := p.root
+	for _, k := range keys[:len(keys)-1] {
+		v := t.get(k)
+		switch {
+		case v == nil:
+			next := newTable()
+			t.set(k, &value{kind: kindTable, v: next, line: line, column: column})
+			t = next
+		case v.kind == kindTable && !v.v.(*table).inline:
+			t = v.v.(*table)
+		case v.kind == kindArray && v.arrayOfTables:
+			items := v.v.([]*value)
+			t = items[len(items)-1].v.(*table)
+		default:
+			return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("key %q is not a table", k)}
+		}
+	}
+
+	last := keys[len(keys)-1]
+	v := t.get(last)
+
+	if array {
+		next := newTable()
+		item := &value{kind: kindTable, v: next, line: line, column: column}
+		switch {
+		case v == nil:
+			t.set(last, &value{kind: kindArray, v: []*value{item}, arrayOfTables: true, line: line, column: column})
+		case v.kind == kindArray && v.arrayOfTables:
+			v.v = append(v.v.([]*value), item)
+		default:
+			return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("key %q is not an array of tables", last)}
+		}
+		p.current = next
+		return nil
+	}
+
+	switch {
+	case v == nil:
+		next := newTable()
+		next.defined = true
+		t.set(last, &value{kind: kindTable, v: next, line: line, column: column})
+		p.current = next
+	case v.kind == kindTable:
+		existing := v.v.(*table)
+		if existing.defined || existing.dotted || existing.inline {
+			return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("table %q redefined", strings.Join(keys, "."))}
+		}
+		existing.defined = true
+		p.current = existing
+	default:
+		return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("key %q is not a table", last)}
+	}
+
+	return nil
+}
+
+func (p *tomlParser) keyValue(t *table) error {
+	line, column := p.line, p.column
+
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+
+	p.skipWhitespace()
+	if p.peek() != '=' {
+		return p.errorf("expecting =")
+	}
+	p.next()
+	p.skipWhitespace()
+
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys[:len(keys)-1] {
+		existing := t.get(k)
+		switch {
+		case existing == nil:
+			next := newTable()
+			next.dotted = true
+			t.set(k, &value{kind: kindTable, v: next, line: line, column: column})
+			t = next
+		case existing.kind == kindTable && existing.v.(*table).dotted:
+			t = existing.v.(*table)
+		default:
+			return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("key %q can't be extended", k)}
+		}
+	}
+
+	last := keys[len(keys)-1]
+	if t.get(last) != nil {
+		return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf("duplicate key %q", strings.Join(keys, "."))}
+	}
+	t.set(last, v)
+
+	return nil
+}
+
+func (p *tomlParser) value() (*value, error) {
+	line, column := p.line, p.column
+
+	v, err := p.rawValue()
+	if err != nil {
+		return nil, err
+	}
+	v.line, v.column = line, column
+	return v, nil
+}
+
+func (p *tomlParser) rawValue() (*value, error) {
+	switch c := p.peek(); {
+	case p.eof():
+		return nil, p.errorf("%v", errEOF)
+
+	case c == '"':
+		var s string
+		var err error
+		if p.hasPrefix(`"""`) {
+			s, err = p.multilineBasicString()
+		} else {
+			s, err = p.basicString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &value{kind: kindString, v: s}, nil
+
+	case c == '\'':
+		var s string
+		var err error
+		if p.hasPrefix(`'''`) {
+			s, err = p.multilineLiteralString()
+		} else {
+			s, err = p.literalString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &value{kind: kindString, v: s}, nil
+
+	case c == '[':
+		return p.array()
+
+	case c == '{':
+		return p.inlineTable()
+
+	case p.hasPrefix("true") && !isBareKeyChar(p.peekAt(4)):
+		p.skip(4)
+		return &value{kind: kindBool, v: true}, nil
+
+	case p.hasPrefix("false") && !isBareKeyChar(p.peekAt(5)):
+		p.skip(5)
+		return &value{kind: kindBool, v: false}, nil
+	}
+
+	return p.scalar()
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.next()
+
+	var b strings.Builder
+	for {
+		if p.eof() || p.isNewline() {
+			return "", p.errorf("unterminated string")
+		}
+		r := p.next()
+		switch {
+		case r == '"':
+			return b.String(), nil
+		case r == '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case isControl(r):
+			return "", p.errorf("control character %U in string", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func (p *tomlParser) multilineBasicString() (string, error) {
+	p.skip(3)
+	if p.isNewline() {
+		p.skipNewline()
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if p.hasPrefix(`"""`) {
+			// up to two additional quotes are allowed before the delimiter
+			quotes := 3
+			for quotes < 5 && p.peekAt(quotes) == '"' {
+				quotes++
+			}
+			b.WriteString(strings.Repeat(`"`, quotes-3))
+			p.skip(quotes)
+			return b.String(), nil
+		}
+		if p.hasPrefix("\r\n") {
+			p.skipNewline()
+			b.WriteByte('\n')
+			continue
+		}
+
+		r := p.next()
+		switch {
+		case r == '\\':
+			// a line ending backslash trims all the whitespace that follows
+			save, line, column := p.pos, p.line, p.column
+			p.skipWhitespace()
+			if p.isNewline() {
+				for {
+					p.skipWhitespace()
+					if !p.isNewline() {
+						break
+					}
+					p.skipNewline()
+				}
+				continue
+			}
+			p.pos, p.line, p.column = save, line, column
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case r == '\n':
+			b.WriteRune(r)
+		case isControl(r):
+			return "", p.errorf("control character %U in string", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+func (p *tomlParser) escape(b *strings.Builder) error {
+	if p.eof() {
+		return p.errorf("%v", errEOF)
+	}
+	switch r := p.next(); r {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if r == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("%v", errEOF)
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid unicode escape \\%c%s", r, p.src[p.pos:p.pos+n])
+		}
+		p.skip(n)
+		b.WriteRune(rune(code))
+	default:
+		return p.errorf("invalid escape sequence \\%c", r)
+	}
+	return nil
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.next()
+
+	start := p.pos
+	for {
+		if p.eof() || p.isNewline() {
+			return "", p.errorf("unterminated string")
+		}
+		r := p.next()
+		if r == '\'' {
+			return p.src[start : p.pos-1], nil
+		}
+		if isControl(r) {
+			return "", p.errorf("control character %U in string", r)
+		}
+	}
+}
+
+func (p *tomlParser) multilineLiteralString() (string, error) {
+	p.skip(3)
+	if p.isNewline() {
+		p.skipNewline()
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if p.hasPrefix(`'''`) {
+			quotes := 3
+			for quotes < 5 && p.peekAt(quotes) == '\'' {
+				quotes++
+			}
+			b.WriteString(strings.Repeat(`'`, quotes-3))
+			p.skip(quotes)
+			return b.String(), nil
+		}
+		if p.hasPrefix("\r\n") {
+			p.skipNewline()
+			b.WriteByte('\n')
+			continue
+		}
+		r := p.next()
+		if r != '\n' && isControl(r) {
+			return "", p.errorf("control character %U in string", r)
+		}
+		b.WriteRune(r)
+	}
+}
+
+func (p *tomlParser) array() (*value, error) {
+	p.next()
+
+	items := []*value{}
+	for {
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ']' {
+			p.next()
+			return &value{kind: kindArray, v: items}, nil
+		}
+
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		switch p.peek() {
+		case ',':
+			p.next()
+		case ']':
+			p.next()
+			return &value{kind: kindArray, v: items}, nil
+		default:
+			if p.eof() {
+				return nil, p.errorf("%v", errEOF)
+			}
+			return nil, p.errorf("expecting , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (*value, error) {
+	p.next()
+
+	t := newTable()
+
+	p.skipWhitespace()
+	if p.peek() == '}' {
+		p.next()
+		t.inline = true
+		return &value{kind: kindTable, v: t}, nil
+	}
+
+	for {
+		p.skipWhitespace()
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipWhitespace()
+
+		switch p.peek() {
+		case ',':
+			p.next()
+		case '}':
+			p.next()
+			markInline(t)
+			return &value{kind: kindTable, v: t}, nil
+		default:
+			if p.eof() {
+				return nil, p.errorf("%v", errEOF)
+			}
+			return nil, p.errorf("expecting , or } in inline table")
+		}
+	}
+}
+
+// markInline marks a table and the tables defined by its dotted keys as
+// inline, which makes them immutable
+func markInline(t *table) {
+	t.inline = true
+	for _, k := range t.keys {
+		if v := t.values[k]; v.kind == kindTable && v.v.(*table).dotted {
+			markInline(v.v.(*table))
+		}
+	}
+}
+
+func isScalarChar(c byte) bool {
+	return isBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
+
+func (p *tomlParser) scalar() (*value, error) {
+	start := p.pos
+	for isScalarChar(p.peek()) {
+		p.next()
+		// a space is allowed between the date and the time of a datetime
+		if p.pos-start == 10 && p.peek() == ' ' && isDigit(p.peekAt(1)) && isDate(p.src[start:p.pos]) {
+			p.next()
+		}
+	}
+
+	s := p.src[start:p.pos]
+	if s == "" {
+		return nil, p.errorf("unexpected character %q", p.peek())
+	}
+
+	if isDate(s) || strings.Contains(s, ":") {
+		if !isDatetime(s) {
+			return nil, p.errorf("invalid datetime %q", s)
+		}
+		return &value{kind: kindDatetime, v: s}, nil
+	}
+
+	if v, ok := parseInteger(s); ok {
+		return &value{kind: kindInt, v: v}, nil
+	}
+	if v, ok := parseFloat(s); ok {
+		return &value{kind: kindFloat, v: v}, nil
+	}
+	return nil, p.errorf("invalid value %q", s)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDate(s string) bool {
+	return len(s) >= 10 && isDigit(s[0]) && isDigit(s[1]) && isDigit(s[2]) && isDigit(s[3]) && s[4] == '-' && s[7] == '-'
+}
+
+var datetimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+func isDatetime(s string) bool {
+	s = strings.ToUpper(s)
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + s[11:]
+	}
+	for _, layout := range datetimeLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnderscores removes the underscores from a number, each underscore
+// must be surrounded by digits
+func removeUnderscores(s string, isDigit func(byte) bool) (string, bool) {
+	if !strings.Contains(s, "_") {
+		return s, true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' {
+			continue
+		}
+		if i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1]) {
+			return "", false
+		}
+	}
+	return strings.Replace(s, "_", "", -1), true
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func parseInteger(s string) (int64, bool) {
+	if len(s) > 2 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base > 0 {
+			digits, ok := removeUnderscores(s[2:], isHexDigit)
+			if !ok {
+				return 0, false
+			}
+			v, err := strconv.ParseInt(digits, base, 64)
+			return v, err == nil
+		}
+	}
+
+	digits, ok := removeUnderscores(s, isDigit)
+	if !ok {
+		return 0, false
+	}
+	unsigned := strings.TrimLeft(digits, "+-")
+	if len(digits)-len(unsigned) > 1 || unsigned == "" {
+		return 0, false
+	}
+	if len(unsigned) > 1 && unsigned[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(unsigned); i++ {
+		if !isDigit(unsigned[i]) {
+			return 0, false
+		}
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	return v, err == nil
+}
+
+func parseFloat(s string) (float64, bool) {
+	unsigned := strings.TrimLeft(s, "+-")
+	if len(s)-len(unsigned) > 1 {
+		return 0, false
+	}
+	switch unsigned {
+	case "inf":
+		if s[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	case "nan":
+		return math.NaN(), true
+	}
+
+	digits, ok := removeUnderscores(unsigned, isDigit)
+	if !ok {
+		return 0, false
+	}
+
+	// the integer part is mandatory and can't have leading zeros, the
+	// fractional part (if any) must have at least one digit
+	mantissa := digits
+	if i := strings.IndexAny(digits, "eE"); i >= 0 {
+		mantissa = digits[:i]
+	}
+	intPart := mantissa
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		intPart = mantissa[:i]
+		if i == len(mantissa)-1 || !isDigit(mantissa[i+1]) {
+			return 0, false
+		}
+	}
+	if intPart == "" || len(intPart) > 1 && intPart[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(digits); i++ {
+		if c := digits[i]; !isDigit(c) && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			return 0, false
+		}
+	}
+
+	v, err := strconv.ParseFloat(s[:len(s)-len(unsigned)]+digits, 64)
+	return v, err == nil
+}
@@ -0,0 +1,463 @@
+// Package stoml converts TOML documents into S-expressions and S-expressions
+// into TOML documents.
+//
+// Tables become maps that keep the original order of their keys, arrays and
+// arrays of tables become lists and strings, integers, floats and booleans
+// keep their types.
+//
+// Some TOML constructs have no S-expression equivalent (comments, dates and
+// times, inf and nan) and some S-expression nodes have no TOML equivalent
+// (nil, expressions, atoms and arbitrary symbols). Converting them is not an
+// error, instead, every construct that could not be represented exactly is
+// reported as an Issue.
+package stoml
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Issue describes a construct that could not be represented exactly
+type Issue = ast.Issue
+
+// Options represents the settings of the converter
+type Options struct {
+	// AtomKeys makes the decoder convert table keys into atoms, as long as
+	// the key is a valid atom name. Keys that can't be atoms are kept as
+	// strings.
+	AtomKeys bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Decode converts a TOML document into a document root, see Options.Decode
+func Decode(data []byte) (*ast.Node, []Issue, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// Encode converts a document root into a TOML document, see Options.Encode
+func Encode(root *ast.Node) ([]byte, []Issue, error) {
+	return DefaultOptions.Encode(root)
+}
+
+// EncodeNode converts a map node into a TOML document, see
+// Options.EncodeNode
+func EncodeNode(n *ast.Node) ([]byte, []Issue, error) {
+	return DefaultOptions.EncodeNode(n)
+}
+
+type converter struct {
+	Options
+
+	issues []Issue
+	buf    bytes.Buffer
+}
+
+func (c *converter) reportValue(v *value, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Line: v.line, Column: v.column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *converter) reportNode(n *ast.Node, format string, args ...interface{}) {
+	issue := Issue{Message: fmt.Sprintf(format, args...)}
+	if tok := n.Token(); tok != nil {
+		issue.Line, issue.Column = tok.Pos().Line, tok.Pos().Column
+	}
+	c.issues = append(c.issues, issue)
+}
+
+// Decode converts a TOML document into a document root with a single map
+// child. Comments are dropped, each of them is reported as an Issue.
+func (o Options) Decode(data []byte) (*ast.Node, []Issue, error) {
+	t, comments, err := parse(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &converter{Options: o}
+	m, err := c.fromTable(t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, comment := range comments {
+		c.reportValue(comment, "comment dropped")
+	}
+	sort.SliceStable(c.issues, func(i, j int) bool {
+		a, b := c.issues[i], c.issues[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+
+	root := ast.NewList(nil)
+	if err := root.Push(m); err != nil {
+		return nil, nil, err
+	}
+	return root, c.issues, nil
+}
+
+func str(s string) *ast.Node {
+	return ast.NewNode(nil, ast.NewStringValue(s))
+}
+
+func (c *converter) fromTable(t *table) (*ast.Node, error) {
+	m := ast.NewMap(nil)
+	for _, k := range t.keys {
+		key := str(k)
+		if c.AtomKeys && ast.IsAtomName(k) {
+			key = ast.NewNode(nil, ast.NewAtomValue(":"+k))
+		}
+		v, err := c.fromValue(t.values[k])
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Push(key); err != nil {
+			return nil, err
+		}
+		if err := m.Push(v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (c *converter) fromValue(v *value) (*ast.Node, error) {
+	switch v.kind {
+	case kindString:
+		return str(v.v.(string)), nil
+
+	case kindInt:
+		return ast.NewNode(nil, ast.NewIntValue(v.v.(int64))), nil
+
+	case kindFloat:
+		f64 := v.v.(float64)
+		if math.IsInf(f64, 0) || math.IsNaN(f64) {
+			c.reportValue(v, "float %s can't be represented, converted to string", formatFloat(f64))
+			return str(formatFloat(f64)), nil
+		}
+		return ast.NewNode(nil, ast.NewFloatValue(f64)), nil
+
+	case kindBool:
+		return ast.NewNode(nil, ast.NewBoolValue(v.v.(bool))), nil
+
+	case kindDatetime:
+		c.reportValue(v, "datetime %s converted to string", v.v)
+		return str(v.v.(string)), nil
+
+	case kindArray:
+		list := ast.NewList(nil)
+		for _, item := range v.v.([]*value) {
+			node, err := c.fromValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := list.Push(node); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case kindTable:
+		return c.fromTable(v.v.(*table))
+	}
+
+	return nil, fmt.Errorf("stoml: unexpected value kind %v", v.kind)
+}
+
+// Encode converts a document root into a TOML document, the root must have a
+// single map child.
+func (o Options) Encode(root *ast.Node) ([]byte, []Issue, error) {
+	if root == nil || !root.IsVector() {
+		return nil, nil, fmt.Errorf("stoml: expecting a document root")
+	}
+	children := root.List()
+	if len(children) != 1 {
+		return nil, nil, fmt.Errorf("stoml: expecting a single map, got %d forms", len(children))
+	}
+	return o.EncodeNode(children[0])
+}
+
+// EncodeNode converts a map node into a TOML document. Values that are maps
+// become tables and lists made only of maps become arrays of tables, TOML
+// requires the keys of a table to be written before its sub-tables, so these
+// keys are moved when needed.
+func (o Options) EncodeNode(n *ast.Node) ([]byte, []Issue, error) {
+	if n == nil || n.Type() != ast.NodeTypeMap {
+		return nil, nil, fmt.Errorf("stoml: expecting a map")
+	}
+
+	c := &converter{Options: o}
+	if err := c.table(nil, n); err != nil {
+		return nil, nil, err
+	}
+	return c.buf.Bytes(), c.issues, nil
+}
+
+type pair struct {
+	key   string
+	value *ast.Node
+}
+
+func (c *converter) pairs(n *ast.Node) ([]pair, error) {
+	children := n.List()
+	if len(children)%2 != 0 {
+		return nil, fmt.Errorf("stoml: map with an odd number of children")
+	}
+
+	pairs := make([]pair, 0, len(children)/2)
+	for i := 0; i < len(children); i += 2 {
+		key, err := c.key(children[i])
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair{key: key, value: children[i+1]})
+	}
+	return pairs, nil
+}
+
+func (c *converter) key(n *ast.Node) (string, error) {
+	switch n.Type() {
+	case ast.NodeTypeAtom:
+		return strings.TrimPrefix(n.Value().(string), ":"), nil
+	case ast.NodeTypeSymbol, ast.NodeTypeString:
+		return n.Value().(string), nil
+	case ast.NodeTypeInt, ast.NodeTypeFloat:
+		text := string(ast.Encode(n))
+		c.reportNode(n, "key %s converted to string", text)
+		return text, nil
+	}
+	return "", fmt.Errorf("stoml: unsupported key type %v", n.Type())
+}
+
+func isNil(n *ast.Node) bool {
+	return n.IsNil() || n.Type() == ast.NodeTypeSymbol && n.Value().(string) == "nil"
+}
+
+func isTable(n *ast.Node) bool {
+	return n.Type() == ast.NodeTypeMap
+}
+
+func isArrayOfTables(n *ast.Node) bool {
+	if n.Type() != ast.NodeTypeList {
+		return false
+	}
+	children := n.List()
+	if len(children) == 0 {
+		return false
+	}
+	for _, child := range children {
+		if !isTable(child) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *converter) table(path []string, n *ast.Node) error {
+	pairs, err := c.pairs(n)
+	if err != nil {
+		return err
+	}
+
+	tables := false
+	for _, p := range pairs {
+		if isTable(p.value) || isArrayOfTables(p.value) {
+			tables = true
+			continue
+		}
+		if isNil(p.value) {
+			c.reportNode(p.value, "nil value of key %s dropped", p.key)
+			continue
+		}
+		if tables {
+			c.reportNode(p.value, "key %s moved before tables", p.key)
+		}
+		text, err := c.inline(p.value)
+		if err != nil {
+			return err
+		}
+		c.buf.WriteString(formatKey(p.key) + " = " + text + "\n")
+	}
+
+	for _, p := range pairs {
+		switch {
+		case isTable(p.value):
+			sub := append(append([]string{}, path...), p.key)
+			c.header("[" + formatPath(sub) + "]")
+			if err := c.table(sub, p.value); err != nil {
+				return err
+			}
+		case isArrayOfTables(p.value):
+			sub := append(append([]string{}, path...), p.key)
+			for _, item := range p.value.List() {
+				c.header("[[" + formatPath(sub) + "]]")
+				if err := c.table(sub, item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *converter) header(text string) {
+	if c.buf.Len() > 0 {
+		c.buf.WriteString("\n")
+	}
+	c.buf.WriteString(text + "\n")
+}
+
+// inline returns the text of a value that is written in a single line
+func (c *converter) inline(n *ast.Node) (string, error) {
+	switch n.Type() {
+	case ast.NodeTypeInt:
+		if _, ok := n.Int(); !ok {
+			c.reportNode(n, "integer %s out of range, converted to string", n.Encode())
+			return quote(n.Encode()), nil
+		}
+		return n.Encode(), nil
+
+	case ast.NodeTypeFloat:
+		return formatFloat(n.Value().(float64)), nil
+
+	case ast.NodeTypeString:
+		return quote(n.Value().(string)), nil
+
+	case ast.NodeTypeBool:
+		return n.Encode(), nil
+
+	case ast.NodeTypeSymbol:
+		switch name := n.Value().(string); name {
+		case "true", "false":
+			return name, nil
+		default:
+			c.reportNode(n, "symbol %s converted to string", name)
+			return quote(name), nil
+		}
+
+	case ast.NodeTypeAtom:
+		c.reportNode(n, "atom %s converted to string", n.Value())
+		return quote(n.Value().(string)), nil
+
+	case ast.NodeTypeList, ast.NodeTypeExpression:
+		if n.Type() == ast.NodeTypeExpression {
+			c.reportNode(n, "expression converted to array")
+		}
+		children := n.List()
+		if tail := n.Tail(); tail != nil {
+			c.reportNode(n, "dotted expression tail converted to array element")
+			children = append(children[:len(children):len(children)], tail)
+		}
+		values := []string{}
+		for _, child := range children {
+			if isNil(child) {
+				c.reportNode(child, "nil array element dropped")
+				continue
+			}
+			text, err := c.inline(child)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, text)
+		}
+		return "[" + strings.Join(values, ", ") + "]", nil
+
+	case ast.NodeTypeMap:
+		pairs, err := c.pairs(n)
+		if err != nil {
+			return "", err
+		}
+		values := []string{}
+		for _, p := range pairs {
+			if isNil(p.value) {
+				c.reportNode(p.value, "nil value of key %s dropped", p.key)
+				continue
+			}
+			text, err := c.inline(p.value)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, formatKey(p.key)+" = "+text)
+		}
+		if len(values) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(values, ", ") + " }", nil
+	}
+
+	return "", fmt.Errorf("stoml: unsupported node type %v", n.Type())
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s = s + ".0"
+	}
+	return s
+}
+
+// quote returns a TOML basic string
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if isControl(r) {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func formatKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for i := 0; i < len(key); i++ {
+		if !isBareKeyChar(key[i]) {
+			return quote(key)
+		}
+	}
+	return key
+}
+
+func formatPath(path []string) string {
+	keys := make([]string, len(path))
+	for i := range path {
+		keys[i] = formatKey(path[i])
+	}
+	return strings.Join(keys, ".")
+}
@@ -0,0 +1,222 @@
+package stoml
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func issueMessages(issues []Issue) []string {
+	messages := []string{}
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	return messages
+}
+
+func TestDecode(t *testing.T) {
+	in := `# service configuration
+title = "TOML \"example\"" # trailing comment
+port = 8_080
+hex = 0xDEAD_BEEF
+oct = 0o755
+bin = 0b1101
+ratio = 6.626e-34
+neg = -0.5
+enabled = true
+path = 'C:\Users\nodejs'
+lines = """
+one \
+  two"""
+raw = '''
+first
+second'''
+ports = [ 8000,
+  8001, # comment
+]
+point = { x = 1, y.z = 2 }
+site."google.com" = true
+
+[database]
+server = "192.168.1.1"
+
+[servers.alpha]
+ip = "10.0.0.1"
+
+[[products]]
+name = "Hammer"
+
+[[products]]
+
+[[products]]
+name = "Nail"
+[products.size]
+mm = 3
+`
+	root, issues, err := Decode([]byte(in))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"1:1: comment dropped",
+		"2:28: comment dropped",
+		"18:9: comment dropped",
+	}, issueMessages(issues))
+	assert.Equal(t, `{"title" "TOML \"example\"" "port" 8080 "hex" 3735928559 "oct" 493 "bin" 13 "ratio" 0.0000000000000000000000000000000006626 "neg" -0.5 "enabled" true "path" "C:\\Users\\nodejs" "lines" "one two" "raw" "first\nsecond" "ports" [8000 8001] "point" {"x" 1 "y" {"z" 2}} "site" {"google.com" true} "database" {"server" "192.168.1.1"} "servers" {"alpha" {"ip" "10.0.0.1"}} "products" [{"name" "Hammer"} {} {"name" "Nail" "size" {"mm" 3}}]}`, string(ast.EncodeDocument(root)))
+
+	enabled, ok := root.List()[0].List()[15].Bool()
+	assert.True(t, ok)
+	assert.True(t, enabled)
+}
+
+func TestDecodeAtomKeys(t *testing.T) {
+	opts := Options{AtomKeys: true}
+
+	root, _, err := opts.Decode([]byte("max-conns = 1\n\"with space\" = 2\n3 = 3\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{:max-conns 1 "with space" 2 "3" 3}`, string(ast.EncodeDocument(root)))
+}
+
+func TestDecodeIssues(t *testing.T) {
+	in := `when = 1979-05-27T07:32:00Z # utc
+day = 1979-05-27
+at = 07:32:00.999
+local = 1979-05-27 07:32:00
+top = inf
+bottom = -nan
+`
+	root, issues, err := Decode([]byte(in))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"when" "1979-05-27T07:32:00Z" "day" "1979-05-27" "at" "07:32:00.999" "local" "1979-05-27 07:32:00" "top" "inf" "bottom" "nan"}`, string(ast.EncodeDocument(root)))
+	assert.Equal(t, []string{
+		"1:8: datetime 1979-05-27T07:32:00Z converted to string",
+		"1:29: comment dropped",
+		"2:7: datetime 1979-05-27 converted to string",
+		"3:6: datetime 07:32:00.999 converted to string",
+		"4:9: datetime 1979-05-27 07:32:00 converted to string",
+		"5:7: float inf can't be represented, converted to string",
+		"6:10: float nan can't be represented, converted to string",
+	}, issueMessages(issues))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		in  string
+		err string
+	}{
+		{"a = 1\na = 2", `toml: line 2, column 1: duplicate key "a"`},
+		{"[a]\n[a]", `toml: line 2, column 1: table "a" redefined`},
+		{"a.b = 1\n[a]", `toml: line 2, column 1: table "a" redefined`},
+		{"a = 1\n[a.b]", `toml: line 2, column 1: key "a" is not a table`},
+		{"a = {}\n[a.b]", `toml: line 2, column 1: key "a" is not a table`},
+		{"a = { b = 1 }\na.c = 2", `toml: line 2, column 1: key "a" can't be extended`},
+		{"[[a]]\n[a]", `toml: line 2, column 1: key "a" is not a table`},
+		{"a = [1,", `toml: line 1, column 8: unexpected end of input`},
+		{"a = 01", `toml: line 1, column 7: invalid value "01"`},
+		{"a = 1__0", `toml: line 1, column 9: invalid value "1__0"`},
+		{"a = 1.", `toml: line 1, column 7: invalid value "1."`},
+		{"a = .5", `toml: line 1, column 7: invalid value ".5"`},
+		{"a = 1979-13-27", `toml: line 1, column 15: invalid datetime "1979-13-27"`},
+		{`a = "\x"`, `toml: line 1, column 8: invalid escape sequence \x`},
+		{`a = "open`, `toml: line 1, column 10: unterminated string`},
+		{"a = 1 b = 2", `toml: line 1, column 7: expecting end of line, got 'b'`},
+		{"a", `toml: line 1, column 2: expecting =`},
+	}
+
+	for _, tc := range testCases {
+		_, _, err := Decode([]byte(tc.in))
+		if assert.Error(t, err, tc.in) {
+			assert.Equal(t, tc.err, err.Error(), tc.in)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	root, err := parser.Parse([]byte(`{:name "service" :nested {:zeta 1 "alpha key" [1 2.5 "x"]} :port 8080 :enabled true :servers [{:ip "10.0.0.1"} {:ip "10.0.0.2" :tags {:a "b"}}] :point [{:x 1} 2] :empty {}}`))
+	assert.NoError(t, err)
+
+	out, issues, err := Encode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"1:66: key port moved before tables",
+		"1:80: key enabled moved before tables",
+		"1:152: key point moved before tables",
+	}, issueMessages(issues))
+	assert.Equal(t, `name = "service"
+port = 8080
+enabled = true
+point = [{ x = 1 }, 2]
+
+[nested]
+zeta = 1
+"alpha key" = [1, 2.5, "x"]
+
+[[servers]]
+ip = "10.0.0.1"
+
+[[servers]]
+ip = "10.0.0.2"
+
+[servers.tags]
+a = "b"
+
+[empty]
+`, string(out))
+
+	back, _, err := Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name" "service" "port" 8080 "enabled" true "point" [{"x" 1} 2] "nested" {"zeta" 1 "alpha key" [1 2.5 "x"]} "servers" [{"ip" "10.0.0.1"} {"ip" "10.0.0.2" "tags" {"a" "b"}}] "empty" {}}`, string(ast.EncodeDocument(back)))
+}
+
+func TestEncodeIssues(t *testing.T) {
+	root, err := parser.Parse([]byte(`{:a nil :b symbol :c :atom :d (1 nil) 1 "int key" :quote "a\"b\n\x01"}`))
+	assert.NoError(t, err)
+
+	out, issues, err := Encode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "b = \"symbol\"\nc = \":atom\"\nd = [1]\n1 = \"int key\"\nquote = \"a\\\"b\\n\\u0001\"\n", string(out))
+	assert.Equal(t, []string{
+		"1:39: key 1 converted to string",
+		"1:5: nil value of key a dropped",
+		"1:12: symbol symbol converted to string",
+		"1:22: atom :atom converted to string",
+		"1:31: expression converted to array",
+		"1:34: nil array element dropped",
+	}, issueMessages(issues))
+	root = ast.NewMap(nil)
+	_, _ = root.PushValue(nil, ast.NewAtomValue(":a"))
+	_ = root.Push(ast.NewPair(nil, ast.NewNode(nil, ast.NewIntValue(1)), ast.NewNode(nil, ast.NewIntValue(2))))
+	out, issues, err = EncodeNode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "a = [1, 2]\n", string(out))
+	assert.Equal(t, []string{
+		"expression converted to array",
+		"dotted expression tail converted to array element",
+	}, issueMessages(issues))
+	root = ast.NewMap(nil)
+	_, _ = root.PushValue(nil, ast.NewAtomValue(":big"))
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	_, _ = root.PushValue(nil, ast.NewBigIntValue(huge))
+	out, issues, err = EncodeNode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "big = \"123456789012345678901234567890\"\n", string(out))
+	assert.Equal(t, []string{
+		"integer 123456789012345678901234567890 out of range, converted to string",
+	}, issueMessages(issues))
+}
+
+func TestEncodeErrors(t *testing.T) {
+	root, err := parser.Parse([]byte(`{:a 1} {:b 2}`))
+	assert.NoError(t, err)
+	_, _, err = Encode(root)
+	assert.Error(t, err)
+
+	root, err = parser.Parse([]byte(`{:a}`))
+	assert.NoError(t, err)
+	_, _, err = Encode(root)
+	assert.Error(t, err)
+
+	_, _, err = EncodeNode(ast.NewList(nil))
+	assert.Error(t, err)
+}
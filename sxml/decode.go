@@ -0,0 +1,386 @@
+package sxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// rawTokenReader reads tokens without translating namespace prefixes, which
+// keeps names exactly as they were written
+type rawTokenReader struct {
+	d *xml.Decoder
+}
+
+func (r rawTokenReader) Token() (xml.Token, error) {
+	return r.d.RawToken()
+}
+
+// bareAttrReader is implemented by the token readers that know which
+// attributes of the last start element were written without a value
+type bareAttrReader interface {
+	bare(i int) bool
+}
+
+// htmlTokenReader reads raw tokens from an HTML document, the contents of
+// script and style elements are read as text up to their end tag and the
+// attributes written without a value are remembered.
+type htmlTokenReader struct {
+	data []byte
+
+	// d reads data from base
+	d    *xml.Decoder
+	base int
+
+	text      []byte
+	valueless []bool
+}
+
+func newHTMLDecoder(data []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+func (r *htmlTokenReader) Token() (xml.Token, error) {
+	if r.text != nil {
+		text := r.text
+		r.text = nil
+		return xml.CharData(text), nil
+	}
+
+	start := r.base + int(r.d.InputOffset())
+	tok, err := r.d.RawToken()
+	if err != nil {
+		return nil, err
+	}
+	end := r.base + int(r.d.InputOffset())
+
+	t, ok := tok.(xml.StartElement)
+	if !ok {
+		return tok, nil
+	}
+	t = t.Copy()
+
+	tag := r.data[start:end]
+	r.valueless = valuelessAttrs(tag)
+	if len(r.valueless) != len(t.Attr) {
+		r.valueless = nil
+	}
+
+	if rawTextElements[strings.ToLower(t.Name.Local)] && !bytes.HasSuffix(tag, []byte("/>")) {
+		n := rawTextEnd(r.data[end:], t.Name.Local)
+		if n > 0 {
+			r.text = r.data[end : end+n]
+		}
+		r.base = end + n
+		r.d = newHTMLDecoder(r.data[r.base:])
+	}
+	return t, nil
+}
+
+func (r *htmlTokenReader) bare(i int) bool {
+	return i < len(r.valueless) && r.valueless[i]
+}
+
+// valuelessAttrs tells which attributes of a start tag have no value
+func valuelessAttrs(tag []byte) []bool {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+	i := 1
+	for i < len(tag) && !isSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' {
+		i++
+	}
+
+	attrs := []bool{}
+	for {
+		for i < len(tag) && (isSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '>' {
+			return attrs
+		}
+		for i < len(tag) && !isSpace(tag[i]) && tag[i] != '=' && tag[i] != '/' && tag[i] != '>' {
+			i++
+		}
+		for i < len(tag) && isSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || tag[i] != '=' {
+			attrs = append(attrs, true)
+			continue
+		}
+		i++
+		for i < len(tag) && isSpace(tag[i]) {
+			i++
+		}
+		if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+			q := tag[i]
+			i++
+			for i < len(tag) && tag[i] != q {
+				i++
+			}
+			i++
+		} else {
+			for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' {
+				i++
+			}
+		}
+		attrs = append(attrs, false)
+	}
+}
+
+// rawTextEnd returns the position of the end tag of a raw text element, or
+// the length of data if the element is not closed
+func rawTextEnd(data []byte, name string) int {
+	lower := bytes.ToLower(data)
+	end := []byte("</" + strings.ToLower(name))
+	for i := 0; ; {
+		j := bytes.Index(lower[i:], end)
+		if j < 0 {
+			return len(data)
+		}
+		i += j
+		if k := i + len(end); k == len(data) || strings.IndexByte(" \t\n\r\f/>", data[k]) >= 0 {
+			return i
+		}
+		i += len(end)
+	}
+}
+
+// Decode reads a document and returns its SXML representation
+func (o Options) Decode(r io.Reader) (*ast.Node, error) {
+	if o.HTML {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return o.DecodeTokens(&htmlTokenReader{data: data, d: newHTMLDecoder(data)})
+	}
+	return o.DecodeTokens(rawTokenReader{xml.NewDecoder(r)})
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func pushSymbol(n *ast.Node, name string) (*ast.Node, error) {
+	return n.PushValue(nil, ast.NewSymbolValue(name))
+}
+
+func pushString(n *ast.Node, s string) (*ast.Node, error) {
+	return n.PushValue(nil, ast.NewStringValue(s))
+}
+
+type decoder struct {
+	Options
+
+	stack []*ast.Node
+	names []string
+
+	// bare tells which attributes have no value, it's nil unless the token
+	// reader knows it
+	bare bareAttrReader
+
+	text strings.Builder
+}
+
+func (d *decoder) top() *ast.Node {
+	return d.stack[len(d.stack)-1]
+}
+
+func (d *decoder) push(n *ast.Node, name string) {
+	d.stack = append(d.stack, n)
+	d.names = append(d.names, name)
+}
+
+func (d *decoder) pop(levels int) {
+	d.stack = d.stack[:len(d.stack)-levels]
+	d.names = d.names[:len(d.names)-levels]
+}
+
+// flush adds the text that was read since the last node as a string node,
+// consecutive text tokens are merged into a single string.
+func (d *decoder) flush() error {
+	s := d.text.String()
+	d.text.Reset()
+
+	if s == "" || d.SkipWhitespace && strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	_, err := pushString(d.top(), s)
+	return err
+}
+
+func (d *decoder) special(name string, values ...string) error {
+	if err := d.flush(); err != nil {
+		return err
+	}
+
+	node, err := d.top().PushExpression(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := pushSymbol(node, name); err != nil {
+		return err
+	}
+	for i, v := range values {
+		if name == ProcInst && i == 0 {
+			_, err = pushSymbol(node, v)
+		} else {
+			_, err = pushString(node, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) start(t xml.StartElement) error {
+	if err := d.flush(); err != nil {
+		return err
+	}
+
+	name := xmlName(t.Name)
+
+	if d.HTML {
+		// the open elements whose end tag can be omitted are closed by the
+		// start tags that can't be within them
+		lower := strings.ToLower(name)
+		for last := len(d.names) - 1; last > 0 && impliedEndTags[strings.ToLower(d.names[last])][lower]; last-- {
+			d.pop(1)
+		}
+	}
+
+	elem, err := d.top().PushExpression(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := pushSymbol(elem, name); err != nil {
+		return err
+	}
+
+	if len(t.Attr) > 0 {
+		attrs, err := elem.PushExpression(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := pushSymbol(attrs, Attributes); err != nil {
+			return err
+		}
+		for i, attr := range t.Attr {
+			pair, err := attrs.PushExpression(nil)
+			if err != nil {
+				return err
+			}
+			if _, err := pushSymbol(pair, xmlName(attr.Name)); err != nil {
+				return err
+			}
+			if d.bare != nil && d.bare.bare(i) {
+				continue
+			}
+			if _, err := pushString(pair, attr.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.HTML && voidElements[strings.ToLower(name)] {
+		return nil
+	}
+
+	d.push(elem, name)
+	return nil
+}
+
+func (d *decoder) end(t xml.EndElement) error {
+	if err := d.flush(); err != nil {
+		return err
+	}
+
+	name := xmlName(t.Name)
+
+	last := len(d.names) - 1
+	if last > 0 && d.names[last] == name {
+		d.pop(1)
+		return nil
+	}
+
+	if !d.HTML {
+		return fmt.Errorf("%w: </%s>", ErrUnmatchedEndTag, name)
+	}
+
+	// elements between the top of the stack and the matching element are
+	// closed implicitly and stray end tags are ignored
+	for i := last; i > 0; i-- {
+		if strings.EqualFold(d.names[i], name) {
+			d.pop(len(d.names) - i)
+			break
+		}
+	}
+	return nil
+}
+
+// DecodeTokens converts a stream of XML tokens into SXML, the stream is read
+// until io.EOF. Names that have a namespace are represented as
+// "namespace:local".
+func (o Options) DecodeTokens(tr xml.TokenReader) (*ast.Node, error) {
+	root := ast.NewExpression(nil)
+	if _, err := pushSymbol(root, Top); err != nil {
+		return nil, err
+	}
+
+	d := &decoder{Options: o}
+	d.bare, _ = tr.(bareAttrReader)
+	d.push(root, Top)
+
+	for {
+		tok, err := tr.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			err = d.start(t)
+		case xml.EndElement:
+			err = d.end(t)
+		case xml.CharData:
+			_, err = d.text.Write(t)
+		case xml.Comment:
+			err = d.special(Comment, string(t))
+		case xml.ProcInst:
+			err = d.special(ProcInst, t.Target, string(t.Inst))
+		case xml.Directive:
+			err = d.special(Directive, string(t))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.flush(); err != nil {
+		return nil, err
+	}
+
+	if len(d.stack) > 1 && !o.HTML {
+		return nil, fmt.Errorf("%w: <%s>", ErrUnclosedElement, d.names[len(d.names)-1])
+	}
+
+	return root, nil
+}
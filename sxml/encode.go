@@ -0,0 +1,421 @@
+package sxml
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Tokens walks a SXML node and calls fn with the XML tokens that represent
+// it, in document order. The tokens can be written with an xml.Encoder by
+// passing its EncodeToken method as fn.
+func Tokens(n *ast.Node, fn func(xml.Token) error) error {
+	if n == nil {
+		return nil
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeString:
+		return fn(xml.CharData(n.Value().(string)))
+	case ast.NodeTypeSymbol:
+		return fn(xml.CharData(n.Value().(string)))
+	case ast.NodeTypeInt, ast.NodeTypeFloat, ast.NodeTypeAtom, ast.NodeTypeBool:
+		return fn(xml.CharData(n.Encode()))
+	case ast.NodeTypeNil:
+		return nil
+	case ast.NodeTypeList:
+		return tokensList(n.List(), fn)
+	case ast.NodeTypeExpression:
+		if n.Tail() != nil {
+			return fmt.Errorf("%w: dotted %v", ErrUnexpectedNode, n.Type())
+		}
+		return tokensExpression(n, fn)
+	}
+
+	return fmt.Errorf("%w: %v", ErrUnexpectedNode, n.Type())
+}
+
+func tokensList(nodes []*ast.Node, fn func(xml.Token) error) error {
+	for _, child := range nodes {
+		if err := Tokens(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func headName(n *ast.Node) (string, bool) {
+	if n.Type() != ast.NodeTypeExpression {
+		return "", false
+	}
+	children := n.List()
+	if len(children) == 0 || children[0].Type() != ast.NodeTypeSymbol {
+		return "", false
+	}
+	return children[0].Value().(string), true
+}
+
+func textOf(nodes []*ast.Node) (string, error) {
+	var b strings.Builder
+	for _, node := range nodes {
+		if node.IsVector() {
+			return "", fmt.Errorf("%w: expecting text, got %v", ErrUnexpectedNode, node.Type())
+		}
+		if node.Type() == ast.NodeTypeString || node.Type() == ast.NodeTypeSymbol {
+			b.WriteString(node.Value().(string))
+			continue
+		}
+		b.WriteString(node.Encode())
+	}
+	return b.String(), nil
+}
+
+func tokensExpression(n *ast.Node, fn func(xml.Token) error) error {
+	name, ok := headName(n)
+	if !ok {
+		return fmt.Errorf("%w: expressions must begin with a name", ErrUnexpectedNode)
+	}
+	children := n.List()[1:]
+
+	switch name {
+	case Top:
+		return tokensList(children, fn)
+
+	case Comment:
+		text, err := textOf(children)
+		if err != nil {
+			return err
+		}
+		return fn(xml.Comment(text))
+
+	case ProcInst:
+		if len(children) == 0 {
+			return fmt.Errorf("%w: missing target", ErrUnexpectedNode)
+		}
+		target, err := textOf(children[:1])
+		if err != nil {
+			return err
+		}
+		inst, err := textOf(children[1:])
+		if err != nil {
+			return err
+		}
+		return fn(xml.ProcInst{Target: target, Inst: []byte(inst)})
+
+	case Directive:
+		text, err := textOf(children)
+		if err != nil {
+			return err
+		}
+		return fn(xml.Directive(text))
+
+	case Attributes:
+		return fmt.Errorf("%w: attributes outside of an element", ErrUnexpectedNode)
+	}
+
+	if !isName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if len(children) > 0 {
+		if attrName, ok := headName(children[0]); ok && attrName == Attributes {
+			attrs, err := attributes(children[0])
+			if err != nil {
+				return err
+			}
+			start.Attr = attrs
+			children = children[1:]
+		}
+	}
+
+	if err := fn(start); err != nil {
+		return err
+	}
+	if err := tokensList(children, fn); err != nil {
+		return err
+	}
+	return fn(start.End())
+}
+
+func attribute(name *ast.Node, value []*ast.Node) (xml.Attr, error) {
+	var key string
+	switch name.Type() {
+	case ast.NodeTypeSymbol, ast.NodeTypeString:
+		key = name.Value().(string)
+	case ast.NodeTypeAtom:
+		key = strings.TrimPrefix(name.Value().(string), ":")
+	default:
+		return xml.Attr{}, fmt.Errorf("%w: unexpected attribute name %v", ErrInvalidAttributes, name.Type())
+	}
+	if !isName(key) {
+		return xml.Attr{}, fmt.Errorf("%w: %q", ErrInvalidName, key)
+	}
+
+	text, err := textOf(value)
+	if err != nil {
+		return xml.Attr{}, err
+	}
+	return xml.Attr{Name: xml.Name{Local: key}, Value: text}, nil
+}
+
+// attributes reads the (@ (name "value") ...) and (@ {name "value" ...})
+// forms
+func attributes(n *ast.Node) ([]xml.Attr, error) {
+	attrs := []xml.Attr{}
+	for _, child := range n.List()[1:] {
+		switch child.Type() {
+		case ast.NodeTypeExpression:
+			pair := child.List()
+			if len(pair) == 0 || len(pair) > 2 {
+				return nil, fmt.Errorf("%w: expecting (name value)", ErrInvalidAttributes)
+			}
+			attr, err := attribute(pair[0], pair[1:])
+			if err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, attr)
+
+		case ast.NodeTypeMap:
+			values := child.List()
+			if len(values)%2 != 0 {
+				return nil, fmt.Errorf("%w: map with an odd number of children", ErrInvalidAttributes)
+			}
+			for i := 0; i < len(values); i += 2 {
+				attr, err := attribute(values[i], values[i+1:i+2])
+				if err != nil {
+					return nil, err
+				}
+				attrs = append(attrs, attr)
+			}
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected %v", ErrInvalidAttributes, child.Type())
+		}
+	}
+	return attrs, nil
+}
+
+func isNameStart(r rune) bool {
+	return r == ':' || r == '_' || unicode.IsLetter(r)
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if isNameStart(r) {
+			continue
+		}
+		if i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isChar implements the Char production of the XML specification
+func isChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+func checkChars(s string) error {
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return fmt.Errorf("%w: invalid UTF-8 at byte %d", ErrInvalidChar, i)
+			}
+		}
+		if !isChar(r) {
+			return fmt.Errorf("%w: %U", ErrInvalidChar, r)
+		}
+	}
+	return nil
+}
+
+type serializer struct {
+	Options
+
+	w *bufio.Writer
+
+	names   []string
+	pending bool
+}
+
+// Encode writes the document represented by the given SXML node
+func (o Options) Encode(w io.Writer, n *ast.Node) error {
+	s := &serializer{Options: o, w: bufio.NewWriter(w)}
+	if err := Tokens(n, s.token); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *serializer) top() string {
+	if len(s.names) == 0 {
+		return ""
+	}
+	return s.names[len(s.names)-1]
+}
+
+func (s *serializer) isVoid(name string) bool {
+	return s.HTML && voidElements[strings.ToLower(name)]
+}
+
+func (s *serializer) isRawText(name string) bool {
+	return s.HTML && rawTextElements[strings.ToLower(name)]
+}
+
+// content is called before writing any content inside of the current element
+func (s *serializer) content() error {
+	if s.isVoid(s.top()) {
+		return fmt.Errorf("%w: <%s>", ErrVoidElement, s.top())
+	}
+	if s.pending {
+		s.pending = false
+		return s.w.WriteByte('>')
+	}
+	return nil
+}
+
+func (s *serializer) escape(text string, attr bool) error {
+	if err := checkChars(text); err != nil {
+		return err
+	}
+	for _, r := range text {
+		var err error
+		switch {
+		case r == '&':
+			_, err = s.w.WriteString("&amp;")
+		case r == '<':
+			_, err = s.w.WriteString("&lt;")
+		case r == '>':
+			_, err = s.w.WriteString("&gt;")
+		case attr && r == '"':
+			_, err = s.w.WriteString("&quot;")
+		case attr && r == '\t':
+			_, err = s.w.WriteString("&#x9;")
+		case attr && r == '\n':
+			_, err = s.w.WriteString("&#xA;")
+		case r == '\r':
+			_, err = s.w.WriteString("&#xD;")
+		default:
+			_, err = s.w.WriteRune(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *serializer) token(tok xml.Token) error {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if err := s.content(); err != nil {
+			return err
+		}
+		name := t.Name.Local
+		s.w.WriteString("<" + name)
+		for _, attr := range t.Attr {
+			s.w.WriteString(" " + attr.Name.Local)
+			if s.HTML && attr.Value == "" {
+				continue
+			}
+			s.w.WriteString(`="`)
+			if err := s.escape(attr.Value, true); err != nil {
+				return err
+			}
+			s.w.WriteString(`"`)
+		}
+		s.names = append(s.names, name)
+		s.pending = true
+
+	case xml.EndElement:
+		name := s.top()
+		s.names = s.names[:len(s.names)-1]
+		switch {
+		case s.pending && s.isVoid(name):
+			s.w.WriteString(">")
+		case s.pending && !s.HTML:
+			s.w.WriteString("/>")
+		case s.pending:
+			s.w.WriteString("></" + name + ">")
+		default:
+			s.w.WriteString("</" + name + ">")
+		}
+		s.pending = false
+
+	case xml.CharData:
+		if err := s.content(); err != nil {
+			return err
+		}
+		if s.isRawText(s.top()) {
+			text := string(t)
+			if strings.Contains(strings.ToLower(text), "</"+strings.ToLower(s.top())) {
+				return fmt.Errorf("%w: <%s> can't contain its own end tag", ErrInvalidChar, s.top())
+			}
+			if err := checkChars(text); err != nil {
+				return err
+			}
+			_, err := s.w.WriteString(text)
+			return err
+		}
+		return s.escape(string(t), false)
+
+	case xml.Comment:
+		if err := s.content(); err != nil {
+			return err
+		}
+		text := string(t)
+		if strings.Contains(text, "--") || strings.HasSuffix(text, "-") {
+			return ErrInvalidComment
+		}
+		if err := checkChars(text); err != nil {
+			return err
+		}
+		s.w.WriteString("<!--" + text + "-->")
+
+	case xml.ProcInst:
+		if err := s.content(); err != nil {
+			return err
+		}
+		inst := string(t.Inst)
+		if strings.Contains(inst, "?>") {
+			return ErrInvalidProcInst
+		}
+		if !isName(t.Target) {
+			return fmt.Errorf("%w: %q", ErrInvalidName, t.Target)
+		}
+		if err := checkChars(inst); err != nil {
+			return err
+		}
+		s.w.WriteString("<?" + t.Target)
+		if inst != "" {
+			s.w.WriteString(" " + inst)
+		}
+		s.w.WriteString("?>")
+
+	case xml.Directive:
+		if err := s.content(); err != nil {
+			return err
+		}
+		if err := checkChars(string(t)); err != nil {
+			return err
+		}
+		s.w.WriteString("<!" + string(t) + ">")
+	}
+
+	return nil
+}
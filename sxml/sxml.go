@@ -0,0 +1,136 @@
+// Package sxml converts XML and HTML documents into S-expressions and
+// S-expressions into XML and HTML documents, following the SXML conventions:
+//
+//	<?xml version="1.0"?>
+//	<!-- links -->
+//	<a href="/home" class="nav">Go <b>home</b></a>
+//
+// is represented as:
+//
+//	(*TOP*
+//	  (*PI* xml "version=\"1.0\"")
+//	  (*COMMENT* " links ")
+//	  (a (@ (href "/home") (class "nav")) "Go " (b "home")))
+//
+// Elements are expressions with the element name as head, the attributes of
+// an element are grouped into an expression that begins with the @ symbol and
+// text is represented by strings. Comments, processing instructions and
+// directives are represented by the *COMMENT*, *PI* and *DECL* expressions and
+// the document itself is enclosed by a *TOP* expression.
+//
+// When converting S-expressions into XML, attributes can also be written as a
+// map (@ {:href "/home"}), text can also be written using ints, floats, atoms
+// or symbols and lists are spliced into their parent.
+package sxml
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+)
+
+// Special names used by SXML
+const (
+	Top        = "*TOP*"
+	Attributes = "@"
+	ProcInst   = "*PI*"
+	Comment    = "*COMMENT*"
+	Directive  = "*DECL*"
+)
+
+// Error messages
+var (
+	ErrUnexpectedNode    = errors.New("unexpected node")
+	ErrInvalidName       = errors.New("invalid name")
+	ErrInvalidChar       = errors.New("invalid XML character")
+	ErrInvalidComment    = errors.New(`comments can't contain "--"`)
+	ErrInvalidProcInst   = errors.New(`processing instructions can't contain "?>"`)
+	ErrVoidElement       = errors.New("void elements can't have children")
+	ErrUnmatchedEndTag   = errors.New("unmatched end tag")
+	ErrUnclosedElement   = errors.New("unclosed element")
+	ErrInvalidAttributes = errors.New("invalid attributes")
+)
+
+// Options represents the settings of the converter
+type Options struct {
+	// HTML enables HTML parsing and serialization rules: void elements (like
+	// br or img) have no end tag, unknown entities and unquoted attributes are
+	// accepted, unclosed elements are closed implicitly, the contents of
+	// script and style elements are raw text, which is not escaped, and
+	// attributes without value, like (@ (disabled)), are written as a bare
+	// name. The elements whose end tag can be omitted, like p, li, td, tr or
+	// option, are closed by the start tags that can't be within them, so
+	// <p>a<p>b becomes (p "a") (p "b").
+	HTML bool
+
+	// SkipWhitespace makes the decoder discard text nodes that only contain
+	// whitespace.
+	SkipWhitespace bool
+}
+
+// XMLOptions are the options used by Decode and Encode
+var XMLOptions = Options{}
+
+// HTMLOptions are the options used by DecodeHTML and EncodeHTML
+var HTMLOptions = Options{HTML: true}
+
+// Decode reads an XML document and returns its SXML representation
+func Decode(r io.Reader) (*ast.Node, error) {
+	return XMLOptions.Decode(r)
+}
+
+// DecodeHTML reads an HTML document and returns its SXML representation
+func DecodeHTML(r io.Reader) (*ast.Node, error) {
+	return HTMLOptions.Decode(r)
+}
+
+// Encode writes the XML representation of a SXML node
+func Encode(w io.Writer, n *ast.Node) error {
+	return XMLOptions.Encode(w, n)
+}
+
+// EncodeHTML writes the HTML representation of a SXML node
+func EncodeHTML(w io.Writer, n *ast.Node) error {
+	return HTMLOptions.Encode(w, n)
+}
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+var rawTextElements = map[string]bool{
+	"script": true,
+	"style":  true,
+}
+
+// impliedEndTags maps the elements whose end tag can be omitted to the start
+// tags that close them
+var impliedEndTags = map[string]map[string]bool{
+	"p": nameSet("address article aside blockquote dd details div dl dt fieldset figcaption " +
+		"figure footer form h1 h2 h3 h4 h5 h6 header hgroup hr li main menu nav ol p pre " +
+		"section table ul"),
+	"li":       nameSet("li"),
+	"dt":       nameSet("dd dt"),
+	"dd":       nameSet("dd dt"),
+	"rt":       nameSet("rp rt"),
+	"rp":       nameSet("rp rt"),
+	"option":   nameSet("optgroup option"),
+	"optgroup": nameSet("optgroup"),
+	"thead":    nameSet("tbody tfoot"),
+	"tbody":    nameSet("tbody tfoot"),
+	"tr":       nameSet("tbody tfoot thead tr"),
+	"td":       nameSet("tbody td tfoot th thead tr"),
+	"th":       nameSet("tbody td tfoot th thead tr"),
+}
+
+func nameSet(names string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range strings.Fields(names) {
+		set[name] = true
+	}
+	return set
+}
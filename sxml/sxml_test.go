@@ -0,0 +1,252 @@
+package sxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func encodeXML(t *testing.T, opts Options, in string) (string, error) {
+	root, err := parser.Parse([]byte(in))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = opts.Encode(&buf, root)
+	return buf.String(), err
+}
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			In:  `<a/>`,
+			Out: `(*TOP* (a))`,
+		},
+		{
+			In:  `<?xml version="1.0"?><!-- links --><a href="/home" class="nav">Go <b>home</b></a>`,
+			Out: `(*TOP* (*PI* xml "version=\"1.0\"") (*COMMENT* " links ") (a (@ (href "/home") (class "nav")) "Go " (b "home")))`,
+		},
+		{
+			In:  `<svg:rect xml:lang="en" data-x="1 &amp; 2">&lt;tag&gt; &#x41;<![CDATA[ <raw> ]]></svg:rect>`,
+			Out: `(*TOP* (svg:rect (@ (xml:lang "en") (data-x "1 & 2")) "<tag> A <raw> "))`,
+		},
+		{
+			In:  "<!DOCTYPE note><note>\n  <to>Tove</to>\n</note>",
+			Out: `(*TOP* (*DECL* "DOCTYPE note") (note "\n  " (to "Tove") "\n"))`,
+		},
+	}
+
+	for i := range testCases {
+		node, err := Decode(strings.NewReader(testCases[i].In))
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, string(ast.Encode(node)))
+	}
+
+	_, err := Decode(strings.NewReader(`<a><b></a>`))
+	assert.True(t, errors.Is(err, ErrUnmatchedEndTag))
+
+	_, err = Decode(strings.NewReader(`<a><b></b>`))
+	assert.True(t, errors.Is(err, ErrUnclosedElement))
+}
+
+func TestDecodeSkipWhitespace(t *testing.T) {
+	opts := Options{SkipWhitespace: true}
+
+	node, err := opts.Decode(strings.NewReader("<note>\n  <to>Tove</to>\n  <from> Jani </from>\n</note>\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `(*TOP* (note (to "Tove") (from " Jani ")))`, string(ast.Encode(node)))
+}
+
+func TestDecodeHTML(t *testing.T) {
+	node, err := DecodeHTML(strings.NewReader(`<p class=intro>Hello<br>world &copy; <img src="a.png"></p><ul><li>one<li>two</ul></div>`))
+	assert.NoError(t, err)
+	assert.Equal(t, `(*TOP* (p (@ (class "intro")) "Hello" (br) "world © " (img (@ (src "a.png")))) (ul (li "one") (li "two")))`, string(ast.Encode(node)))
+
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			// script and style elements hold raw text
+			In:  `<script>if (a<b && c) x("</p>")</script><STYLE>a > b { content: "&amp;" }</style >`,
+			Out: `(*TOP* (script "if (a<b && c) x(\"</p>\")") (STYLE "a > b { content: \"&amp;\" }"))`,
+		},
+		{
+			In:  `<script src="a.js"></script><script></scripts></script><p>x</p>`,
+			Out: `(*TOP* (script (@ (src "a.js"))) (script "</scripts>") (p "x"))`,
+		},
+		{
+			In:  `<script>unclosed <b>`,
+			Out: `(*TOP* (script "unclosed <b>"))`,
+		},
+		{
+			// elements whose end tag can be omitted are closed implicitly
+			In:  `<div><p>x<p>y</div><P>z<ul><li>a<LI>b<ul><li>c</ul><li>d</ul>`,
+			Out: `(*TOP* (div (p "x") (p "y")) (P "z") (ul (li "a") (LI "b" (ul (li "c"))) (li "d")))`,
+		},
+		{
+			In:  `<table><thead><tr><th>a<th>b<tbody><tr><td>1<td>2<tr><td>3</table>`,
+			Out: `(*TOP* (table (thead (tr (th "a") (th "b"))) (tbody (tr (td "1") (td "2")) (tr (td "3")))))`,
+		},
+		{
+			In:  `<select><optgroup><option>a<option>b<optgroup><option>c</select><dl><dt>t<dd>d<dt>u</dl>`,
+			Out: `(*TOP* (select (optgroup (option "a") (option "b")) (optgroup (option "c"))) (dl (dt "t") (dd "d") (dt "u")))`,
+		},
+		{
+			// a p is closed by block elements but not by inline ones, and
+			// only when it's the innermost open element
+			In:  `<p>a<b>b</b><hr>c<p><span>d<div>e</div>`,
+			Out: `(*TOP* (p "a" (b "b")) (hr) "c" (p (span "d" (div "e"))))`,
+		},
+		{
+			// attributes without value are kept without value
+			In:  `<input disabled value=x checked><option selected="selected" label='a b' hidden/>`,
+			Out: `(*TOP* (input (@ (disabled) (value "x") (checked))) (option (@ (selected "selected") (label "a b") (hidden))))`,
+		},
+	}
+
+	for _, tc := range testCases {
+		node, err := DecodeHTML(strings.NewReader(tc.In))
+		if assert.NoError(t, err, tc.In) {
+			assert.Equal(t, tc.Out, string(ast.Encode(node)), tc.In)
+		}
+	}
+
+	// decoded documents are encoded back
+	in := `<html><body><input disabled value="x"><script>if (a < b) {}</script></body></html>`
+	node, err = DecodeHTML(strings.NewReader(in))
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeHTML(&buf, node))
+	assert.Equal(t, in, buf.String())
+
+	node, err = DecodeHTML(strings.NewReader(`<div><p>x<p>y</div>`))
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, EncodeHTML(&buf, node))
+	assert.Equal(t, `<div><p>x</p><p>y</p></div>`, buf.String())
+}
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Out string
+	}{
+		{
+			In:  `(*TOP* (*PI* xml "version=\"1.0\"") (*COMMENT* " links ") (a (@ (href "/home") (class "nav")) "Go " (b "home")))`,
+			Out: `<?xml version="1.0"?><!-- links --><a href="/home" class="nav">Go <b>home</b></a>`,
+		},
+		{
+			In:  `(p (@ (title "a \"quoted\"\n<value>")) "1 < 2 & 3 > 2")`,
+			Out: `<p title="a &quot;quoted&quot;&#xA;&lt;value&gt;">1 &lt; 2 &amp; 3 &gt; 2</p>`,
+		},
+		{
+			In:  `(ul (@ {:class "list" id "main"}) [(li 1) (li 2.5) (li :three)] (empty))`,
+			Out: `<ul class="list" id="main"><li>1</li><li>2.5</li><li>:three</li><empty/></ul>`,
+		},
+		{
+			In:  `(*DECL* "DOCTYPE html") (html (body (br) (script "if (a < b) {}")))`,
+			Out: `<!DOCTYPE html><html><body><br/><script>if (a &lt; b) {}</script></body></html>`,
+		},
+	}
+
+	for i := range testCases {
+		out, err := encodeXML(t, XMLOptions, testCases[i].In)
+		assert.NoError(t, err)
+		assert.Equal(t, testCases[i].Out, out)
+	}
+}
+
+func TestEncodeLiterals(t *testing.T) {
+	p := parser.NewParser(strings.NewReader(`(input (@ (checked true)) nil false)`))
+	p.SetOptions(parser.ParserOptions{Literals: true})
+	assert.NoError(t, p.Parse())
+
+	var buf bytes.Buffer
+	assert.NoError(t, XMLOptions.Encode(&buf, p.RootNode()))
+	assert.Equal(t, `<input checked="true">false</input>`, buf.String())
+}
+
+func TestEncodeHTML(t *testing.T) {
+	out, err := encodeXML(t, HTMLOptions, `(*DECL* "DOCTYPE html") (html (body (br) (input (@ (disabled) (value "x"))) (p) (script "if (a < b) {}")))`)
+	assert.NoError(t, err)
+	assert.Equal(t, `<!DOCTYPE html><html><body><br><input disabled value="x"><p></p><script>if (a < b) {}</script></body></html>`, out)
+
+	_, err = encodeXML(t, HTMLOptions, `(br "text")`)
+	assert.True(t, errors.Is(err, ErrVoidElement))
+
+	_, err = encodeXML(t, HTMLOptions, `(script "</script>")`)
+	assert.Error(t, err)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	testCases := []struct {
+		In  string
+		Err error
+	}{
+		{`(*COMMENT* "a -- b")`, ErrInvalidComment},
+		{`(*PI* php "echo ?> x")`, ErrInvalidProcInst},
+		{`(a "\u0000")`, ErrInvalidChar},
+		{`("a")`, ErrUnexpectedNode},
+		{`(a (@ (1 "x")))`, ErrInvalidAttributes},
+		{`(a (@ {:x}))`, ErrInvalidAttributes},
+		{`(a<b)`, ErrInvalidName},
+		{`(a {:b 1})`, ErrUnexpectedNode},
+		{`(@ (a "b"))`, ErrUnexpectedNode},
+	}
+
+	for i := range testCases {
+		_, err := encodeXML(t, XMLOptions, testCases[i].In)
+		assert.True(t, errors.Is(err, testCases[i].Err), "%q: %v", testCases[i].In, err)
+	}
+
+	pair := ast.NewPair(nil, ast.NewNode(nil, ast.NewSymbolValue("a")), ast.NewNode(nil, ast.NewStringValue("b")))
+	err := Tokens(pair, func(xml.Token) error { return nil })
+	assert.True(t, errors.Is(err, ErrUnexpectedNode))
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">` + "\n" +
+		`  <title type="text">Tom &amp; Jerry&apos;s "feed"</title>` + "\n" +
+		`  <!-- entries -->` + "\n" +
+		`  <entry><media:thumbnail url="a.png"/><summary>1 &lt; 2</summary></entry>` + "\n" +
+		`</feed>`
+
+	node, err := Decode(strings.NewReader(in))
+	assert.NoError(t, err)
+
+	// the SXML tree survives being encoded as text and parsed again
+	root, err := parser.Parse(ast.Encode(node))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, root))
+
+	again, err := Decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ast.Encode(node)), string(ast.Encode(again)))
+}
+
+func TestTokens(t *testing.T) {
+	root, err := parser.Parse([]byte(`(a (@ (href "x")) "text" (b))`))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	assert.NoError(t, Tokens(root, enc.EncodeToken))
+	assert.NoError(t, enc.Flush())
+	assert.Equal(t, `<a href="x">text<b></b></a>`, buf.String())
+
+	node, err := XMLOptions.DecodeTokens(xml.NewDecoder(&buf))
+	assert.NoError(t, err)
+	assert.Equal(t, `(*TOP* (a (@ (href "x")) "text" (b)))`, string(ast.Encode(node)))
+}
@@ -0,0 +1,385 @@
+// Package syaml converts YAML documents into S-expressions and S-expressions
+// into YAML documents.
+//
+// YAML mappings become maps that keep the original order of their keys,
+// sequences become lists, strings, ints, floats and booleans keep their types
+// and null becomes nil. Each document of a YAML stream is a top-level form of
+// the resulting document.
+//
+// Some YAML constructs have no S-expression equivalent (comments, anchors,
+// tags, timestamps, etc.) and some S-expression nodes have no YAML equivalent
+// (expressions, atoms and arbitrary symbols). Converting them is not an error,
+// instead, every construct that could not be represented exactly is reported
+// as an Issue.
+package syaml
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xiam/s-expr/ast"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue describes a construct that could not be represented exactly
+type Issue = ast.Issue
+
+// Options represents the settings of the converter
+type Options struct {
+	// AtomKeys makes the decoder convert mapping keys into atoms, as long as
+	// the key is a valid atom name. Keys that can't be atoms are kept as
+	// strings.
+	AtomKeys bool
+}
+
+// DefaultOptions are the options used by the package level functions
+var DefaultOptions = Options{}
+
+// Decode converts a YAML stream into a document root, see Options.Decode
+func Decode(data []byte) (*ast.Node, []Issue, error) {
+	return DefaultOptions.Decode(data)
+}
+
+// Encode converts a document root into a YAML stream, see Options.Encode
+func Encode(root *ast.Node) ([]byte, []Issue, error) {
+	return DefaultOptions.Encode(root)
+}
+
+// EncodeNode converts a single node into a YAML document, see
+// Options.EncodeNode
+func EncodeNode(n *ast.Node) ([]byte, []Issue, error) {
+	return DefaultOptions.EncodeNode(n)
+}
+
+type converter struct {
+	Options
+
+	issues  []Issue
+	anchors map[string]bool
+}
+
+func (c *converter) reportYAML(n *yaml.Node, format string, args ...interface{}) {
+	c.issues = append(c.issues, Issue{Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *converter) reportNode(n *ast.Node, format string, args ...interface{}) {
+	issue := Issue{Message: fmt.Sprintf(format, args...)}
+	if tok := n.Token(); tok != nil {
+		issue.Line, issue.Column = tok.Pos().Line, tok.Pos().Column
+	}
+	c.issues = append(c.issues, issue)
+}
+
+// Decode converts a YAML stream into a document root, each YAML document
+// becomes a child of the root.
+func (o Options) Decode(data []byte) (*ast.Node, []Issue, error) {
+	c := &converter{Options: o, anchors: map[string]bool{}}
+
+	root := ast.NewList(nil)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		node, err := c.fromYAML(&doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := root.Push(node); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return root, c.issues, nil
+}
+
+func null() *ast.Node {
+	return ast.NewNode(nil, ast.NewNilValue())
+}
+
+func str(s string) *ast.Node {
+	return ast.NewNode(nil, ast.NewStringValue(s))
+}
+
+func (c *converter) fromYAML(n *yaml.Node) (*ast.Node, error) {
+	if n.HeadComment != "" || n.LineComment != "" || n.FootComment != "" {
+		c.reportYAML(n, "comment dropped")
+	}
+	if n.Anchor != "" && !c.anchors[n.Anchor] {
+		c.anchors[n.Anchor] = true
+		c.reportYAML(n, "anchor &%s dropped, its aliases are expanded", n.Anchor)
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return null(), nil
+		}
+		return c.fromYAML(n.Content[0])
+
+	case yaml.AliasNode:
+		return c.fromYAML(n.Alias)
+
+	case yaml.SequenceNode:
+		list := ast.NewList(nil)
+		for _, child := range n.Content {
+			node, err := c.fromYAML(child)
+			if err != nil {
+				return nil, err
+			}
+			if err := list.Push(node); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case yaml.MappingNode:
+		m := ast.NewMap(nil)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, err := c.fromYAMLKey(n.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.fromYAML(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if err := m.Push(key); err != nil {
+				return nil, err
+			}
+			if err := m.Push(value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case yaml.ScalarNode:
+		return c.fromYAMLScalar(n)
+	}
+
+	return nil, fmt.Errorf("syaml: unexpected node kind %v", n.Kind)
+}
+
+func (c *converter) fromYAMLKey(n *yaml.Node) (*ast.Node, error) {
+	if c.AtomKeys && n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str" && ast.IsAtomName(n.Value) {
+		return ast.NewNode(nil, ast.NewAtomValue(":"+n.Value)), nil
+	}
+	return c.fromYAML(n)
+}
+
+func (c *converter) fromYAMLScalar(n *yaml.Node) (*ast.Node, error) {
+	switch tag := n.ShortTag(); tag {
+	case "!!null":
+		return null(), nil
+
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, err
+		}
+		return ast.NewNode(nil, ast.NewBoolValue(b)), nil
+
+	case "!!int":
+		var i64 int64
+		if err := n.Decode(&i64); err != nil {
+			c.reportYAML(n, "integer %s out of range, converted to string", n.Value)
+			return str(n.Value), nil
+		}
+		return ast.NewNode(nil, ast.NewIntValue(i64)), nil
+
+	case "!!float":
+		var f64 float64
+		if err := n.Decode(&f64); err != nil {
+			return nil, err
+		}
+		if math.IsInf(f64, 0) || math.IsNaN(f64) {
+			c.reportYAML(n, "float %s can't be represented, converted to string", n.Value)
+			return str(n.Value), nil
+		}
+		if isInteger(n.Value) {
+			c.reportYAML(n, "integer %s out of range, converted to float", n.Value)
+		}
+		return ast.NewNode(nil, ast.NewFloatValue(f64)), nil
+
+	case "!!str":
+		return str(n.Value), nil
+
+	case "!!timestamp", "!!binary":
+		c.reportYAML(n, "%s value converted to string", tag)
+		return str(n.Value), nil
+
+	case "!!merge":
+		c.reportYAML(n, "merge key not expanded")
+		return str(n.Value), nil
+
+	default:
+		c.reportYAML(n, "tag %s dropped", tag)
+		return str(n.Value), nil
+	}
+}
+
+// Encode converts a document root into a YAML stream, each child of the root
+// becomes a YAML document.
+func (o Options) Encode(root *ast.Node) ([]byte, []Issue, error) {
+	if root == nil || !root.IsVector() {
+		return nil, nil, fmt.Errorf("syaml: expecting a document root")
+	}
+	return o.encode(root.List())
+}
+
+// EncodeNode converts a single node into a YAML document
+func (o Options) EncodeNode(n *ast.Node) ([]byte, []Issue, error) {
+	return o.encode([]*ast.Node{n})
+}
+
+func (o Options) encode(docs []*ast.Node) ([]byte, []Issue, error) {
+	c := &converter{Options: o}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		node, err := c.toYAML(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := enc.Encode(node); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), c.issues, nil
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	case math.IsNaN(f):
+		return ".nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s = s + ".0"
+	}
+	return s
+}
+
+func (c *converter) toYAML(n *ast.Node) (*yaml.Node, error) {
+	if n == nil {
+		return scalar("!!null", "null"), nil
+	}
+
+	switch n.Type() {
+	case ast.NodeTypeInt:
+		return scalar("!!int", n.Encode()), nil
+
+	case ast.NodeTypeFloat:
+		return scalar("!!float", formatFloat(n.Value().(float64))), nil
+
+	case ast.NodeTypeString:
+		return scalar("!!str", n.Value().(string)), nil
+
+	case ast.NodeTypeBool:
+		return scalar("!!bool", n.Encode()), nil
+
+	case ast.NodeTypeNil:
+		return scalar("!!null", "null"), nil
+
+	case ast.NodeTypeSymbol:
+		switch name := n.Value().(string); name {
+		case "true", "false":
+			return scalar("!!bool", name), nil
+		case "nil":
+			return scalar("!!null", "null"), nil
+		default:
+			c.reportNode(n, "symbol %s converted to string", name)
+			return scalar("!!str", name), nil
+		}
+
+	case ast.NodeTypeAtom:
+		c.reportNode(n, "atom %s converted to string", n.Value())
+		return scalar("!!str", n.Value().(string)), nil
+
+	case ast.NodeTypeList, ast.NodeTypeExpression:
+		if n.Type() == ast.NodeTypeExpression {
+			c.reportNode(n, "expression converted to sequence")
+		}
+		children := n.List()
+		if tail := n.Tail(); tail != nil {
+			c.reportNode(n, "dotted expression tail converted to sequence element")
+			children = append(children[:len(children):len(children)], tail)
+		}
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, child := range children {
+			node, err := c.toYAML(child)
+			if err != nil {
+				return nil, err
+			}
+			seq.Content = append(seq.Content, node)
+		}
+		return seq, nil
+
+	case ast.NodeTypeMap:
+		children := n.List()
+		if len(children)%2 != 0 {
+			return nil, fmt.Errorf("syaml: map with an odd number of children")
+		}
+		m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for i := 0; i < len(children); i += 2 {
+			var key *yaml.Node
+			var err error
+			switch children[i].Type() {
+			case ast.NodeTypeAtom:
+				key = scalar("!!str", strings.TrimPrefix(children[i].Value().(string), ":"))
+			case ast.NodeTypeSymbol:
+				key = scalar("!!str", children[i].Value().(string))
+			default:
+				key, err = c.toYAML(children[i])
+			}
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.toYAML(children[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m.Content = append(m.Content, key, value)
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("syaml: unsupported node type %v", n.Type())
+}
+
+func isInteger(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
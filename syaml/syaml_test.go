@@ -0,0 +1,148 @@
+package syaml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xiam/s-expr/ast"
+	"github.com/xiam/s-expr/parser"
+)
+
+func issueMessages(issues []Issue) []string {
+	messages := []string{}
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	return messages
+}
+
+func TestDecode(t *testing.T) {
+	in := `
+name: service
+port: 8080
+ratio: 0.75
+enabled: true
+debug: ~
+hex: 0x1F
+tags: [a, "b c"]
+nested:
+  zeta: 1
+  alpha: "2"
+`
+	root, issues, err := Decode([]byte(in))
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Equal(t, `{"name" "service" "port" 8080 "ratio" 0.75 "enabled" true "debug" nil "hex" 31 "tags" ["a" "b c"] "nested" {"zeta" 1 "alpha" "2"}}`, string(ast.EncodeDocument(root)))
+
+	values := root.List()[0].List()
+	assert.Equal(t, ast.NodeTypeBool, values[7].Type())
+	assert.True(t, values[9].IsNil())
+}
+
+func TestDecodeAtomKeys(t *testing.T) {
+	opts := Options{AtomKeys: true}
+
+	root, _, err := opts.Decode([]byte("max-conns: 1\n\"with space\": 2\n3: 3\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{:max-conns 1 "with space" 2 3 3}`, string(ast.EncodeDocument(root)))
+}
+
+func TestDecodeIssues(t *testing.T) {
+	in := `# header comment
+base: &base
+  a: 1
+copy: *base
+when: 2001-12-14
+big: 123456789012345678901234567890
+inf: .inf
+custom: !thing value
+`
+	root, issues, err := Decode([]byte(in))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"base" {"a" 1} "copy" {"a" 1} "when" "2001-12-14" "big" 123456789012345680000000000000.0 "inf" ".inf" "custom" "value"}`, string(ast.EncodeDocument(root)))
+	assert.Equal(t, []string{
+		"2:1: comment dropped",
+		"2:7: anchor &base dropped, its aliases are expanded",
+		"5:7: !!timestamp value converted to string",
+		"6:6: integer 123456789012345678901234567890 out of range, converted to float",
+		"7:6: float .inf can't be represented, converted to string",
+		"8:9: tag !thing dropped",
+	}, issueMessages(issues))
+}
+
+func TestDecodeMultipleDocuments(t *testing.T) {
+	root, _, err := Decode([]byte("a: 1\n---\n- 2\n- 3\n---\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a" 1} [2 3] nil`, string(ast.EncodeDocument(root)))
+
+	_, _, err = Decode([]byte("a: [1"))
+	assert.Error(t, err)
+
+	// CVE-2022-28948
+	assert.NotPanics(t, func() {
+		_, _, err = Decode([]byte("0: [:!00 \xef"))
+	})
+	assert.Error(t, err)
+}
+
+func TestEncode(t *testing.T) {
+	root, err := parser.Parse([]byte(`{:name "service" :port 8080 :ratio 2.0 :enabled true :debug nil :tags ["a" "true" "1"] :nested {:zeta 1 "alpha key" [{:x 1}]}}`))
+	assert.NoError(t, err)
+
+	out, issues, err := Encode(root)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Equal(t, strings.Join([]string{
+		`name: service`,
+		`port: 8080`,
+		`ratio: 2.0`,
+		`enabled: true`,
+		`debug: null`,
+		`tags:`,
+		`  - a`,
+		`  - "true"`,
+		`  - "1"`,
+		`nested:`,
+		`  zeta: 1`,
+		`  alpha key:`,
+		`    - x: 1`,
+		``,
+	}, "\n"), string(out))
+
+	back, _, err := Options{AtomKeys: true}.Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `{:name "service" :port 8080 :ratio 2.0 :enabled true :debug nil :tags ["a" "true" "1"] :nested {:zeta 1 "alpha key" [{:x 1}]}}`, string(ast.EncodeDocument(back)))
+}
+
+func TestEncodeIssues(t *testing.T) {
+	root, err := parser.Parse([]byte("{:a (f 1)\n :b :atom :c sym}\n[1]"))
+	assert.NoError(t, err)
+
+	out, issues, err := Encode(root)
+	assert.NoError(t, err)
+	assert.Equal(t, "a:\n  - f\n  - 1\nb: :atom\nc: sym\n---\n- 1\n", string(out))
+	assert.Equal(t, []string{
+		"1:5: expression converted to sequence",
+		"1:6: symbol f converted to string",
+		"2:5: atom :atom converted to string",
+		"2:14: symbol sym converted to string",
+	}, issueMessages(issues))
+
+	root, err = parser.Parse([]byte(`{:a}`))
+	assert.NoError(t, err)
+	_, _, err = Encode(root)
+	assert.Error(t, err)
+
+	out, _, err = EncodeNode(ast.NewNode(nil, ast.NewIntValue(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(out))
+	pair := ast.NewPair(nil, ast.NewNode(nil, ast.NewIntValue(1)), ast.NewNode(nil, ast.NewIntValue(2)))
+	out, issues, err = EncodeNode(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, "- 1\n- 2\n", string(out))
+	assert.Equal(t, []string{
+		"expression converted to sequence",
+		"dotted expression tail converted to sequence element",
+	}, issueMessages(issues))
+}